@@ -0,0 +1,10 @@
+-- Notification channels per user, and the channel of each queued delivery
+-- notification_channels is a comma-separated subset of fcm, email, sms and
+-- webhook. Email is on by default so that users without the app still hear
+-- about payments and reminders; SMS and webhooks are opt-in.
+ALTER TABLE user
+    ADD COLUMN notification_channels VARCHAR(64) NOT NULL DEFAULT 'fcm,email',
+    ADD COLUMN webhook_url VARCHAR(500) NULL;
+
+ALTER TABLE push_outbox
+    ADD COLUMN channel VARCHAR(16) NOT NULL DEFAULT 'fcm' AFTER receiver;
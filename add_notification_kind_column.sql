@@ -0,0 +1,17 @@
+-- Notification kind, used to filter the notification list
+-- New rows get it from the message when they are created (see notificationKind
+-- in handlers/notification.go); this classifies existing rows the same way
+ALTER TABLE notification ADD COLUMN kind VARCHAR(32) NOT NULL DEFAULT 'notification';
+
+UPDATE notification SET kind = CASE
+    WHEN message LIKE '%Monthly rent reminder%' THEN 'monthly_reminder'
+    WHEN message LIKE '%Payment amount%' THEN 'payment'
+    WHEN message LIKE '%Advance payment%' THEN 'advance_payment'
+    WHEN message LIKE '%Tenant request%' THEN 'tenant_request'
+    ELSE 'notification'
+END;
+
+-- Keyset pagination walks a user's notifications by (created_at, id), and the
+-- unread count polls (receiver, is_read)
+CREATE INDEX idx_notification_receiver_created ON notification (receiver, created_at, id);
+CREATE INDEX idx_notification_receiver_read ON notification (receiver, is_read);
@@ -0,0 +1,24 @@
+-- Notification preferences per user, and notifications held for the daily
+-- digest
+-- notification_preferences holds the JSON managed through
+-- /user/notification-preferences: channels and digest per notification
+-- kind, quiet hours, timezone and digest time. NULL means every kind goes
+-- straight out on the user's notification_channels.
+ALTER TABLE user
+    ADD COLUMN notification_preferences JSON NULL;
+
+-- One row per held notification until the user's digest time (due_at), when
+-- the scheduler sends them as one message on the listed channels
+CREATE TABLE IF NOT EXISTS notification_digest (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    notification_id BIGINT NULL,
+    kind VARCHAR(32) NOT NULL,
+    channels VARCHAR(64) NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    body TEXT NOT NULL,
+    due_at DATETIME NOT NULL,
+    created_at DATETIME NOT NULL,
+    INDEX idx_notification_digest_due (due_at),
+    INDEX idx_notification_digest_user (user_id, due_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,27 @@
+-- Running balances for payment history
+-- Each payment stores its position in the tenant's ledger for that floor (seq,
+-- from 1 with no gaps) and the rent and electricity outstanding after it, so
+-- history pages and totals no longer sum every earlier payment.
+-- Requires MySQL 8 for the window functions used by the backfill.
+ALTER TABLE payment ADD COLUMN seq BIGINT NULL;
+ALTER TABLE payment ADD COLUMN balance_rent DECIMAL(12,2) NOT NULL DEFAULT 0;
+ALTER TABLE payment ADD COLUMN balance_electricity DECIMAL(12,2) NOT NULL DEFAULT 0;
+
+-- Number existing payments in creation order and accumulate their balances.
+-- Payments created in the same second are ordered by id.
+UPDATE payment p
+JOIN (
+    SELECT
+        id,
+        ROW_NUMBER() OVER w AS seq,
+        SUM(COALESCE(rent, 0) - COALESCE(recieved_money, 0)) OVER w AS balance_rent,
+        SUM(COALESCE(electricity_bill, 0) - COALESCE(paid_bill, 0)) OVER w AS balance_electricity
+    FROM payment
+    WINDOW w AS (PARTITION BY fid, uid ORDER BY created_at, id ROWS UNBOUNDED PRECEDING)
+) ledger ON ledger.id = p.id
+SET p.seq = ledger.seq,
+    p.balance_rent = ledger.balance_rent,
+    p.balance_electricity = ledger.balance_electricity;
+
+ALTER TABLE payment MODIFY COLUMN seq BIGINT NOT NULL;
+CREATE UNIQUE INDEX idx_payment_ledger ON payment (fid, uid, seq);
@@ -0,0 +1,11 @@
+-- Proof of payment sent with a tenant's payment notification: an upload
+-- with purpose payment_proof (a screenshot or photo of the transfer) and
+-- the transaction reference, such as a bKash TrxID. Both are copied to the
+-- payment recorded when the manager accepts the notification.
+ALTER TABLE notification ADD COLUMN proof_upload_id BIGINT NULL;
+ALTER TABLE notification ADD COLUMN transaction_reference VARCHAR(64) NULL;
+ALTER TABLE notification ADD INDEX idx_notification_proof_upload (proof_upload_id);
+
+ALTER TABLE payment ADD COLUMN proof_upload_id BIGINT NULL;
+ALTER TABLE payment ADD COLUMN transaction_reference VARCHAR(64) NULL;
+ALTER TABLE payment ADD INDEX idx_payment_proof_upload (proof_upload_id);
@@ -0,0 +1,6 @@
+-- Property broadcasts push once to the property's FCM topic (property-<id>)
+-- instead of to each tenant's device, so their outbox row names a topic and
+-- has no receiver
+ALTER TABLE push_outbox
+    MODIFY COLUMN receiver BIGINT NULL,
+    ADD COLUMN topic VARCHAR(64) NULL AFTER channel;
@@ -0,0 +1,20 @@
+-- TOTP two-factor authentication
+-- totp_secret is set during enrollment and only takes effect once totp_enabled is true;
+-- totp_last_step stores the last accepted time step so a code can't be replayed
+ALTER TABLE user ADD COLUMN totp_secret VARCHAR(64) NULL;
+ALTER TABLE user ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE user ADD COLUMN totp_last_step BIGINT NULL;
+
+-- Properties can require 2FA for everyone who manages them
+ALTER TABLE property ADD COLUMN require_2fa BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Single-use recovery codes, stored as bcrypt hashes
+CREATE TABLE IF NOT EXISTS recovery_code (
+    id BIGINT PRIMARY KEY,
+    uid BIGINT NOT NULL,
+    code_hash VARCHAR(255) NOT NULL,
+    used_at DATETIME NULL,
+    created_at DATETIME NOT NULL,
+    INDEX idx_recovery_code_uid (uid),
+    FOREIGN KEY (uid) REFERENCES user(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
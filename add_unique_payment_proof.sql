@@ -0,0 +1,18 @@
+-- An upload is the proof of at most one payment notification. Run after
+-- add_payment_proof.sql. Should a proof already have been sent twice, the
+-- later notifications lose it first; the payments recorded from them keep
+-- their own copy.
+UPDATE notification n
+JOIN (
+    SELECT proof_upload_id, MIN(id) AS first_id
+    FROM notification
+    WHERE proof_upload_id IS NOT NULL
+    GROUP BY proof_upload_id
+    HAVING COUNT(*) > 1
+) d ON d.proof_upload_id = n.proof_upload_id
+SET n.proof_upload_id = NULL
+WHERE n.id > d.first_id;
+
+ALTER TABLE notification
+    DROP INDEX idx_notification_proof_upload,
+    ADD UNIQUE KEY uq_notification_proof_upload (proof_upload_id);
@@ -0,0 +1,116 @@
+// Package apidocs serves the OpenAPI description of the API and a browsable
+// documentation page generated from it.
+//
+// openapi.json is maintained by hand alongside the routes in router.go. Check
+// compares the two, and `go run . check-openapi` fails when a registered
+// route is missing from the spec, so run it before merging route changes.
+package apidocs
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+//go:embed openapi.json
+var spec []byte
+
+// docsPage renders the spec with Swagger UI
+const docsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>GoRent API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.onload = function () {
+      SwaggerUIBundle({ url: "/openapi.json", dom_id: "#swagger-ui", withCredentials: true });
+    };
+  </script>
+</body>
+</html>
+`
+
+// SpecHandler serves the OpenAPI 3 document
+func SpecHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(spec)
+}
+
+// DocsHandler serves the interactive documentation page
+func DocsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(docsPage))
+}
+
+// pathVariable matches a mux path variable with a pattern, e.g. {id:[0-9]+}
+var pathVariable = regexp.MustCompile(`\{([^{}:]+):[^{}]+\}`)
+
+// Check reports every method and path registered on router that the spec
+// does not document, and every operation in the spec that no longer has a
+// route. Route patterns are compared in OpenAPI form, so {id:[0-9]+} in a
+// route matches {id} in the spec.
+func Check(router *mux.Router) error {
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return fmt.Errorf("openapi.json is not valid JSON: %v", err)
+	}
+	documented := make(map[string]bool)
+	for path, operations := range doc.Paths {
+		for method := range operations {
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+
+	registered := make(map[string]bool)
+	err := router.Walk(func(route *mux.Route, r *mux.Router, ancestors []*mux.Route) error {
+		if route.GetHandler() == nil {
+			// Subrouter prefixes only group routes
+			return nil
+		}
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			methods = []string{"GET"}
+		}
+		for _, method := range methods {
+			registered[method+" "+pathVariable.ReplaceAllString(template, "{$1}")] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	for route := range registered {
+		if !documented[route] {
+			problems = append(problems, "missing from openapi.json: "+route)
+		}
+	}
+	for operation := range documented {
+		if !registered[operation] {
+			problems = append(problems, "documented but not registered: "+operation)
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("OpenAPI spec is out of date:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"go-rent/apidocs"
+)
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	if err := apidocs.Check(newRouter()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenAPICheckReportsUndocumentedRoute(t *testing.T) {
+	router := newRouter()
+	router.HandleFunc("/undocumented/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {}).Methods(http.MethodPatch)
+
+	err := apidocs.Check(router)
+	if err == nil {
+		t.Fatal("Check passed with an undocumented route")
+	}
+	if want := "missing from openapi.json: PATCH /undocumented/{id}"; !strings.Contains(err.Error(), want) {
+		t.Errorf("error does not report the route:\n%v\nwant it to contain %q", err, want)
+	}
+}
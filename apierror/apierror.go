@@ -0,0 +1,123 @@
+// Package apierror writes the JSON envelope used by every error response:
+//
+//	{"success": false, "code": "not_found", "message": "Floor not found",
+//	 "fields": {"rent": "Rent must be positive"}, "request_id": "..."}
+//
+// code is stable and meant for programs; message is for people and may
+// change. fields is present only for validation errors and maps request
+// body fields to what is wrong with them. request_id matches the
+// X-Request-ID header and the server logs.
+package apierror
+
+import (
+	"encoding/json"
+	"go-rent/logger"
+	"net/http"
+)
+
+// Error codes. Most responses use the code for their status (see
+// CodeForStatus); the more specific ones let clients react to a particular
+// failure without matching on the message.
+const (
+	CodeInvalidRequest     = "invalid_request"
+	CodeValidationFailed   = "validation_failed"
+	CodeUnauthorized       = "unauthorized"
+	CodeInvalidCredentials = "invalid_credentials"
+	CodeForbidden          = "forbidden"
+	CodeCSRFTokenInvalid   = "csrf_token_invalid"
+	CodeInsufficientScope  = "insufficient_scope"
+	CodeTwoFactorRequired  = "two_factor_required"
+	CodeNotFound           = "not_found"
+	CodeMethodNotAllowed   = "method_not_allowed"
+	CodeConflict           = "conflict"
+	CodeGone               = "gone"
+	CodeTooLarge           = "too_large"
+	CodeUnsupportedMedia   = "unsupported_media_type"
+	CodeRateLimited        = "rate_limited"
+	CodeInternal           = "internal_error"
+	CodeUnavailable        = "unavailable"
+)
+
+// Response is the error envelope
+type Response struct {
+	Success   bool              `json:"success"`
+	Code      string            `json:"code"`
+	Message   string            `json:"message"`
+	Fields    map[string]string `json:"fields,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+}
+
+// CodeForStatus returns the default code for an HTTP status
+func CodeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeInvalidRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusGone:
+		return CodeGone
+	case http.StatusRequestEntityTooLarge:
+		return CodeTooLarge
+	case http.StatusUnsupportedMediaType:
+		return CodeUnsupportedMedia
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	}
+	if status >= 500 {
+		return CodeInternal
+	}
+	return CodeInvalidRequest
+}
+
+// Write sends an error with the default code for status
+func Write(w http.ResponseWriter, r *http.Request, status int, message string) {
+	WriteCode(w, r, status, CodeForStatus(status), message)
+}
+
+// WriteCode sends an error with a specific code
+func WriteCode(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	write(w, r, status, Response{Code: code, Message: message})
+}
+
+// WriteFields sends a 400 validation error. fields maps each invalid
+// request field to a description of the problem.
+func WriteFields(w http.ResponseWriter, r *http.Request, message string, fields map[string]string) {
+	write(w, r, http.StatusBadRequest, Response{Code: CodeValidationFailed, Message: message, Fields: fields})
+}
+
+// Field is a shorthand for a validation error on a single field, using the
+// problem as the message too
+func Field(w http.ResponseWriter, r *http.Request, field, problem string) {
+	WriteFields(w, r, problem, map[string]string{field: problem})
+}
+
+func write(w http.ResponseWriter, r *http.Request, status int, response Response) {
+	response.Success = false
+	response.RequestID = logger.RequestID(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// NotFoundHandler answers requests that match no route
+func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	Write(w, r, http.StatusNotFound, "Endpoint not found")
+}
+
+// MethodNotAllowedHandler answers requests whose path matches a route but
+// not its method
+func MethodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+}
@@ -0,0 +1,39 @@
+// Command fakefcm serves a fake FCM server for local development. Point
+// the API at it with FCM_EMULATOR_HOST, and pushes are recorded instead of
+// reaching devices:
+//
+//	go run ./cmd/fakefcm -addr localhost:9290
+//	FCM_EMULATOR_HOST=localhost:9290 go run .
+//	curl localhost:9290/messages
+//
+// Device tokens starting with "unregistered" or "invalid" are rejected the
+// way FCM rejects them. See package notify/fakefcm for the routes.
+package main
+
+import (
+	"context"
+	"flag"
+	"go-rent/logger"
+	"go-rent/notify/fakefcm"
+	"net/http"
+	"os"
+	"time"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:9290", "address to listen on")
+	flag.Parse()
+
+	logger.Init("info", "text", os.Stderr)
+	ctx := context.Background()
+
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           fakefcm.New(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	logger.Info(ctx, "Fake FCM server listening", "addr", *addr)
+	if err := server.ListenAndServe(); err != nil {
+		logger.Fatal(ctx, "Fake FCM server stopped", "error", err)
+	}
+}
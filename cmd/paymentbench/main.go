@@ -0,0 +1,267 @@
+// Command paymentbench measures payment history latency as a tenant's ledger
+// grows. It creates a throwaway user, property and floor, appends -rows
+// payments through handlers.RecordPayment, and times history pages near the
+// newest payment, in the middle and at the oldest one. Page latency should be
+// the same at every depth; -legacy also times the previous correlated
+// subquery with OFFSET for comparison.
+//
+// It uses the server's configuration and database, so point it at a
+// development database that has add_payment_balances.sql applied:
+//
+//	go run ./cmd/paymentbench -rows 10000
+//
+// Everything it creates is deleted when it finishes.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"go-rent/config"
+	"go-rent/handlers"
+	"go-rent/logger"
+	"go-rent/utils"
+	"os"
+	"sort"
+	"time"
+)
+
+// legacyHistoryQuery is the history query used before running balances were
+// stored, kept here only to compare against
+const legacyHistoryQuery = `
+	SELECT
+		p.id,
+		COALESCE((SELECT SUM(p2.rent - p2.recieved_money) FROM payment p2
+			WHERE p2.fid = p.fid AND p2.uid = p.uid AND p2.created_at < p.created_at), 0),
+		COALESCE((SELECT SUM(p3.rent - p3.recieved_money) FROM payment p3
+			WHERE p3.fid = p.fid AND p3.uid = p.uid AND p3.created_at <= p.created_at), 0),
+		COALESCE((SELECT SUM(COALESCE(p4.electricity_bill, 0) - COALESCE(p4.paid_bill, 0)) FROM payment p4
+			WHERE p4.fid = p.fid AND p4.uid = p.uid AND p4.created_at <= p.created_at), 0),
+		COALESCE((SELECT SUM(COALESCE(p5.electricity_bill, 0) - COALESCE(p5.paid_bill, 0)) FROM payment p5
+			WHERE p5.fid = p.fid AND p5.uid = p.uid AND p5.created_at < p.created_at), 0)
+	FROM payment p
+	WHERE p.fid = ? AND p.uid = ?
+	ORDER BY p.created_at DESC
+	LIMIT ? OFFSET ?`
+
+// fixture is the user, property and floor the payments belong to
+type fixture struct {
+	userID, propertyID, floorID int64
+}
+
+func main() {
+	rows := flag.Int("rows", 10000, "payments to create for the tenant")
+	limit := flag.Int("limit", 25, "page size")
+	samples := flag.Int("samples", 50, "timed requests per page depth")
+	batch := flag.Int("batch", 500, "payments inserted per transaction while seeding")
+	legacy := flag.Bool("legacy", false, "also time the pre-ledger OFFSET query")
+	flag.Parse()
+
+	ctx := context.Background()
+	cfg, err := config.Load()
+	if err != nil {
+		fail(err)
+	}
+	logger.Init("warn", cfg.Log.Format, os.Stderr)
+	if err := config.InitDB(); err != nil {
+		fail(err)
+	}
+	defer config.CloseDB()
+	db := config.CurrentDB()
+
+	f, err := createFixture(db)
+	if err != nil {
+		fail(fmt.Errorf("creating fixture: %v", err))
+	}
+	defer f.remove(db)
+	abort := func(err error) {
+		f.remove(db)
+		fail(err)
+	}
+
+	fmt.Printf("Seeding %d payments (batches of %d)\n", *rows, *batch)
+	fmt.Printf("%10s %14s\n", "ledger", "insert")
+	start := time.Now()
+	for seeded := 0; seeded < *rows; {
+		n := *batch
+		if remaining := *rows - seeded; remaining < n {
+			n = remaining
+		}
+		batchStart := time.Now()
+		if err := f.seed(ctx, db, n); err != nil {
+			abort(fmt.Errorf("seeding: %v", err))
+		}
+		seeded += n
+		fmt.Printf("%10d %14s\n", seeded, (time.Since(batchStart) / time.Duration(n)).Round(time.Microsecond))
+	}
+	fmt.Printf("Seeded in %s\n\n", time.Since(start).Round(time.Millisecond))
+
+	total := int64(*rows)
+	depths := []struct {
+		name       string
+		throughSeq int64
+	}{
+		{"newest", total},
+		{"middle", total / 2},
+		{"oldest", int64(*limit)},
+	}
+
+	fmt.Printf("History page of %d, %d samples each\n", *limit, *samples)
+	fmt.Printf("%-8s %8s %12s %12s %12s\n", "page", "offset", "p50", "p95", "max")
+	for _, depth := range depths {
+		offset := total - depth.throughSeq
+		latencies, err := measure(*samples, func() error {
+			_, err := handlers.PaymentHistoryPage(ctx, db, f.floorID, f.userID, depth.throughSeq, *limit)
+			return err
+		})
+		if err != nil {
+			abort(err)
+		}
+		report(depth.name, offset, latencies)
+	}
+
+	if *legacy {
+		fmt.Printf("\nLegacy OFFSET query\n")
+		for _, depth := range depths {
+			offset := total - depth.throughSeq
+			latencies, err := measure(*samples, func() error {
+				return drain(db.QueryContext(ctx, legacyHistoryQuery, f.floorID, f.userID, *limit, offset))
+			})
+			if err != nil {
+				abort(err)
+			}
+			report(depth.name, offset, latencies)
+		}
+	}
+}
+
+func createFixture(db *sql.DB) (*fixture, error) {
+	f := &fixture{}
+	var err error
+	if f.userID, err = utils.GenerateRandomID(); err != nil {
+		return nil, err
+	}
+	if f.propertyID, err = utils.GenerateRandomID(); err != nil {
+		return nil, err
+	}
+	if f.floorID, err = utils.GenerateRandomID(); err != nil {
+		return nil, err
+	}
+	now := time.Now().In(config.App.Location).Format("2006-01-02 15:04:05")
+
+	_, err = db.Exec(`
+		INSERT INTO user (id, name, phone_number, email, NID, password, manager, created_at, created_by, updated_at, updated_by)
+		VALUES (?, 'paymentbench', ?, NULL, NULL, '', false, ?, ?, ?, ?)`,
+		f.userID, fmt.Sprintf("+880bench%d", f.userID), now, f.userID, now, f.userID)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`
+		INSERT INTO property (id, name, address, photo, created_at, created_by, updated_at, updated_by)
+		VALUES (?, 'paymentbench', 'paymentbench', NULL, ?, ?, ?, ?)`,
+		f.propertyID, now, f.userID, now, f.userID)
+	if err != nil {
+		f.remove(db)
+		return nil, err
+	}
+	_, err = db.Exec(`
+		INSERT INTO floor (id, name, rent, tenant, created_at, created_by, updated_at, updated_by, pid)
+		VALUES (?, 'paymentbench', 1000, ?, ?, ?, ?, ?, ?)`,
+		f.floorID, f.userID, now, f.userID, now, f.userID, f.propertyID)
+	if err != nil {
+		f.remove(db)
+		return nil, err
+	}
+	return f, nil
+}
+
+// seed appends n payments in one transaction, alternating rent charges and
+// partial payments so the balances move
+func (f *fixture) seed(ctx context.Context, db *sql.DB, n int) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i := 0; i < n; i++ {
+		entry := handlers.LedgerEntry{FloorID: f.floorID, TenantID: f.userID, CreatedBy: f.userID}
+		if i%2 == 0 {
+			entry.Rent = 1000
+		} else {
+			entry.ReceivedMoney = 900
+		}
+
+		// Payment IDs are random, so retry the rare collision with a new one.
+		// MySQL rolls back only the failed INSERT, so the transaction goes on.
+		for attempt := 0; ; attempt++ {
+			if entry.ID, err = utils.GenerateRandomID(); err != nil {
+				return err
+			}
+			if err = handlers.RecordPayment(tx, entry); err == nil {
+				break
+			}
+			if !config.IsDuplicateKey(err) || attempt == 4 {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+func (f *fixture) remove(db *sql.DB) {
+	for _, stmt := range []struct {
+		query string
+		id    int64
+	}{
+		{"DELETE FROM payment WHERE fid = ?", f.floorID},
+		{"DELETE FROM floor WHERE id = ?", f.floorID},
+		{"DELETE FROM property WHERE id = ?", f.propertyID},
+		{"DELETE FROM user WHERE id = ?", f.userID},
+	} {
+		if _, err := db.Exec(stmt.query, stmt.id); err != nil {
+			fmt.Fprintf(os.Stderr, "cleanup: %s: %v\n", stmt.query, err)
+		}
+	}
+}
+
+// measure runs fn samples times after one warm-up run and returns the
+// latencies sorted ascending
+func measure(samples int, fn func() error) ([]time.Duration, error) {
+	if err := fn(); err != nil {
+		return nil, err
+	}
+	latencies := make([]time.Duration, 0, samples)
+	for i := 0; i < samples; i++ {
+		start := time.Now()
+		if err := fn(); err != nil {
+			return nil, err
+		}
+		latencies = append(latencies, time.Since(start))
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return latencies, nil
+}
+
+func report(name string, offset int64, latencies []time.Duration) {
+	percentile := func(p float64) time.Duration {
+		return latencies[int(p*float64(len(latencies)-1))].Round(time.Microsecond)
+	}
+	fmt.Printf("%-8s %8d %12s %12s %12s\n", name, offset, percentile(0.5), percentile(0.95), percentile(1))
+}
+
+func drain(rows *sql.Rows, err error) error {
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+	}
+	return rows.Err()
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "paymentbench:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"go-rent/config"
+	"go-rent/handlers"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// benchmarkRows is the ledger length the history pages are timed against
+const benchmarkRows = 10000
+
+// historyLimit is the page size of the history pages
+const historyLimit = 25
+
+// historyPages are the newest and the oldest page of the ledger
+var historyPages = []struct {
+	name       string
+	throughSeq int64
+}{
+	{"first", benchmarkRows},
+	{"last", historyLimit},
+}
+
+// openLedger connects to the development database named by PAYMENTBENCH_DSN
+// and seeds a throwaway tenant with benchmarkRows payments, which are
+// deleted when tb finishes. It skips tb when PAYMENTBENCH_DSN is not set.
+func openLedger(tb testing.TB) (*sql.DB, *fixture) {
+	raw := os.Getenv("PAYMENTBENCH_DSN")
+	if raw == "" {
+		tb.Skip("PAYMENTBENCH_DSN is not set")
+	}
+	dsn, err := mysql.ParseDSN(raw)
+	if err != nil {
+		tb.Fatalf("PAYMENTBENCH_DSN: %v", err)
+	}
+	dsn.ParseTime = true
+	db, err := sql.Open("mysql", dsn.FormatDSN())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { db.Close() })
+	saved := config.App
+	config.App = &config.Config{Location: time.UTC}
+	tb.Cleanup(func() { config.App = saved })
+
+	f, err := createFixture(db)
+	if err != nil {
+		tb.Fatalf("creating fixture: %v", err)
+	}
+	tb.Cleanup(func() { f.remove(db) })
+	for seeded := 0; seeded < benchmarkRows; seeded += 500 {
+		if err := f.seed(context.Background(), db, 500); err != nil {
+			tb.Fatalf("seeding: %v", err)
+		}
+	}
+	return db, f
+}
+
+// BenchmarkPaymentHistoryPage times the newest and the oldest history page
+// of a tenant with 10,000 payments. It needs a development database with the
+// payment migrations applied, given as a DSN:
+//
+//	PAYMENTBENCH_DSN='user:pass@tcp(localhost:3306)/rent' go test ./cmd/paymentbench -run '^$' -bench PaymentHistoryPage
+//
+// and is skipped without one. Both pages should take about the same time.
+func BenchmarkPaymentHistoryPage(b *testing.B) {
+	db, f := openLedger(b)
+	ctx := context.Background()
+	for _, page := range historyPages {
+		b.Run(page.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := handlers.PaymentHistoryPage(ctx, db, f.floorID, f.userID, page.throughSeq, historyLimit); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// TestPaymentHistoryPageDepth checks that the first and the last history
+// page of a 10,000-payment ledger each read about one page of index entries,
+// as counted by MySQL's Handler_read_* session counters, so that their cost
+// does not depend on depth. The OFFSET query this replaced is counted too,
+// to show that the counters see every row a query walks past. Like the
+// benchmark it is skipped unless PAYMENTBENCH_DSN is set:
+//
+//	PAYMENTBENCH_DSN='user:pass@tcp(localhost:3306)/rent' go test ./cmd/paymentbench -run PaymentHistoryPageDepth -v
+func TestPaymentHistoryPageDepth(t *testing.T) {
+	db, f := openLedger(t)
+	// Session counters belong to a connection, so keep to one
+	db.SetMaxOpenConns(1)
+	ctx := context.Background()
+
+	rowsRead := func(query func() error) int64 {
+		t.Helper()
+		before := handlerReads(t, db)
+		if err := query(); err != nil {
+			t.Fatal(err)
+		}
+		return handlerReads(t, db) - before
+	}
+
+	// The page reads its own rows through idx_payment_ledger, plus one
+	// upload lookup each at most
+	const maxReads = 3*historyLimit + 10
+	for _, page := range historyPages {
+		reads := rowsRead(func() error {
+			_, err := handlers.PaymentHistoryPage(ctx, db, f.floorID, f.userID, page.throughSeq, historyLimit)
+			return err
+		})
+		t.Logf("%s page: %d rows read", page.name, reads)
+		if reads > maxReads {
+			t.Errorf("%s page read %d rows, want at most %d", page.name, reads, maxReads)
+		}
+	}
+
+	legacy := rowsRead(func() error {
+		return drain(db.QueryContext(ctx, legacyHistoryQuery, f.floorID, f.userID, historyLimit, benchmarkRows-historyLimit))
+	})
+	t.Logf("last page with the OFFSET query: %d rows read", legacy)
+	if legacy < benchmarkRows {
+		t.Errorf("OFFSET query read %d rows, want at least %d; the counters are not measuring reads", legacy, benchmarkRows)
+	}
+}
+
+// handlerReads returns the index and table rows this connection has read
+// so far. SHOW STATUS itself walks a temporary table, which only moves
+// Handler_read_rnd_next, so that counter is left out.
+func handlerReads(t *testing.T, db *sql.DB) int64 {
+	t.Helper()
+	rows, err := db.Query(`SHOW SESSION STATUS LIKE 'Handler_read%'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var total int64
+	for rows.Next() {
+		var name string
+		var value int64
+		if err := rows.Scan(&name, &value); err != nil {
+			t.Fatal(err)
+		}
+		if name != "Handler_read_rnd_next" {
+			total += value
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return total
+}
@@ -0,0 +1,635 @@
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Config is the complete server configuration. It is read once at startup
+// by Load from the environment and an optional env file; see .env.example
+// for every setting.
+type Config struct {
+	Server    ServerConfig
+	DB        DBConfig
+	JWT       JWTConfig
+	FCM       FCMConfig
+	Outbox    OutboxConfig
+	Events    EventsConfig
+	SMTP      SMTPConfig
+	SMS       SMSConfig
+	Webhook   WebhookConfig
+	Storage   StorageConfig
+	CORS      CORSConfig
+	Cookie    CookieConfig
+	RateLimit RateLimitConfig
+	Log       LogConfig
+
+	// Timezone is the IANA zone used for stored timestamps and schedules
+	Timezone string
+	Location *time.Location
+
+	// MetricsToken, when set, must be sent as "Authorization: Bearer <token>"
+	// to scrape /metrics. Leave it empty when /metrics is only reachable from
+	// the internal network.
+	MetricsToken string
+
+	// AdminToken must be sent as "Authorization: Bearer <token>" to use the
+	// /admin routes. They are disabled while it is empty.
+	AdminToken string
+}
+
+// ServerConfig holds the HTTP listener settings
+type ServerConfig struct {
+	Addr string
+	// ShutdownTimeout bounds how long the server waits on SIGINT/SIGTERM for
+	// in-flight requests and running scheduled jobs before exiting anyway
+	ShutdownTimeout time.Duration
+}
+
+// DBConfig holds the MySQL connection and pool settings
+type DBConfig struct {
+	DSN             string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// JWTConfig holds the session signing keys. Tokens are signed with Secret
+// and verified against Secret and then each of PreviousSecrets, so a key can
+// be rotated without logging everyone out.
+type JWTConfig struct {
+	Secret          []byte
+	PreviousSecrets [][]byte
+}
+
+// FCMConfig identifies the Firebase project used for push notifications.
+// BaseURL and IIDBaseURL are the roots of the FCM and Instance ID APIs.
+// Emulator is set by FCM_EMULATOR_HOST, which points both at a fake server
+// such as cmd/fakefcm and sends no service account credentials.
+//
+// TopicQuietStart and TopicQuietEnd are HH:MM in the server's timezone. A
+// topic push, which cannot honour each subscriber's own quiet hours, queued
+// between them is held until TopicQuietEnd. Equal times send them at once.
+type FCMConfig struct {
+	ProjectID       string
+	CredentialsFile string
+	BaseURL         string
+	IIDBaseURL      string
+	Emulator        bool
+	TopicQuietStart string
+	TopicQuietEnd   string
+}
+
+// OutboxConfig controls delivery of queued push notifications. A failed
+// send is retried after BaseDelay, doubling each time up to MaxDelay, and
+// moves to the dead-letter state after MaxAttempts sends or a permanent
+// failure. Delivered rows are deleted after Retention.
+type OutboxConfig struct {
+	PollInterval time.Duration
+	BatchSize    int
+	MaxAttempts  int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	Retention    time.Duration
+}
+
+// EventsConfig controls the /events stream. New events are looked for
+// every PollInterval (and straight away when this server records one), a
+// comment is sent every Heartbeat to keep idle connections open, and events
+// are kept for Retention so that a client can resume after reconnecting.
+// A user can have MaxStreams streams open at once.
+type EventsConfig struct {
+	PollInterval time.Duration
+	Heartbeat    time.Duration
+	Retention    time.Duration
+	MaxStreams   int
+}
+
+// SMTPConfig is the mail server for email notifications. Email is disabled
+// while Host is empty. TLS is starttls, tls (implicit, usually port 465) or
+// none.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	TLS      string
+}
+
+// SMSConfig is the Twilio-compatible SMS gateway. SMS is disabled while
+// AccountSID is empty.
+type SMSConfig struct {
+	BaseURL    string
+	AccountSID string
+	AuthToken  string
+	From       string
+}
+
+// WebhookConfig enables webhook notifications when SigningSecret is set.
+// AllowPrivateNetworks lets webhooks reach loopback and private addresses,
+// for local development only.
+type WebhookConfig struct {
+	SigningSecret        string
+	AllowPrivateNetworks bool
+}
+
+// StorageConfig selects where uploaded files are kept: Backend is local
+// (files under LocalDir, served by the API at /files) or s3. Files are
+// handed out as signed URLs that expire after URLTTL; local URLs are signed
+// with SigningSecret and start with PublicURL when it is set. Uploads may be
+// at most MaxBytes, and images get a thumbnail at most ThumbnailSize pixels
+// on their longer side. A user's uploads may add up to at most QuotaBytes,
+// and uploads that nothing refers to are deleted once they are older than
+// OrphanTTL.
+type StorageConfig struct {
+	Backend       string
+	LocalDir      string
+	PublicURL     string
+	SigningSecret []byte
+	URLTTL        time.Duration
+	MaxBytes      int64
+	QuotaBytes    int64
+	OrphanTTL     time.Duration
+	ThumbnailSize int
+	S3            S3Config
+}
+
+// S3Config is the bucket used when STORAGE_BACKEND is s3. PublicEndpoint
+// replaces Endpoint in presigned URLs, for when clients reach the service
+// at a different address than the API does. PathStyle puts the bucket in
+// the path, as MinIO needs.
+type S3Config struct {
+	Endpoint        string
+	PublicEndpoint  string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	PathStyle       bool
+}
+
+// CORSConfig is the CORS policy. Origins may end in ":*" to allow any port
+// on that host, which keeps `flutter run -d chrome` working without pinning
+// its port.
+type CORSConfig struct {
+	AllowedOrigins []string
+	AllowedMethods []string
+	AllowedHeaders []string
+	ExposedHeaders []string
+	MaxAge         int
+}
+
+// CookieConfig holds the session cookie attributes. An empty domain makes
+// the cookies host-only, which works for whichever host the API is served
+// from.
+type CookieConfig struct {
+	Domain   string
+	Secure   bool
+	SameSite string
+}
+
+// RateLimitConfig holds the token-bucket limits for each class of route
+type RateLimitConfig struct {
+	Auth  RateLimit
+	Read  RateLimit
+	Write RateLimit
+}
+
+// RateLimit allows Burst requests at once, refilled at PerMinute per minute
+type RateLimit struct {
+	PerMinute float64
+	Burst     int
+}
+
+// LogConfig selects the log level (debug, info, warn or error) and format
+// (json, or text for local development)
+type LogConfig struct {
+	Level  string
+	Format string
+}
+
+// App is the configuration loaded at startup
+var App *Config
+
+// minJWTSecretLength is the shortest accepted HMAC key, in bytes
+const minJWTSecretLength = 32
+
+// Load reads the configuration, validates it and stores it in App. Settings
+// come from the environment, falling back to the file named by ENV_FILE
+// (default ".env", which may be absent). The returned error lists every
+// invalid or missing setting.
+func Load() (*Config, error) {
+	l := &loader{}
+
+	envFile, explicit := os.LookupEnv("ENV_FILE")
+	if !explicit {
+		envFile = ".env"
+	}
+	if envFile != "" {
+		values, err := readEnvFile(envFile)
+		switch {
+		case err == nil:
+			l.file = values
+		case errors.Is(err, os.ErrNotExist) && !explicit:
+		default:
+			return nil, fmt.Errorf("reading env file: %v", err)
+		}
+	}
+
+	cfg := &Config{}
+	cfg.loadServer(l)
+	cfg.loadDB(l)
+	cfg.loadJWT(l)
+	cfg.loadFCM(l)
+	cfg.loadOutbox(l)
+	cfg.loadEvents(l)
+	cfg.loadNotifiers(l)
+	cfg.loadStorage(l)
+	cfg.loadCORS(l)
+	cfg.loadCookie(l)
+	cfg.loadRateLimits(l)
+
+	cfg.Log = LogConfig{
+		Level:  strings.ToLower(l.string("LOG_LEVEL", "info")),
+		Format: strings.ToLower(l.string("LOG_FORMAT", "json")),
+	}
+	if !oneOf(cfg.Log.Level, "debug", "info", "warn", "warning", "error") {
+		l.errorf("LOG_LEVEL must be debug, info, warn or error, got %q", cfg.Log.Level)
+	}
+	if !oneOf(cfg.Log.Format, "json", "text") {
+		l.errorf("LOG_FORMAT must be json or text, got %q", cfg.Log.Format)
+	}
+
+	cfg.Timezone = l.string("TIMEZONE", "Asia/Dhaka")
+	location, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		l.errorf("TIMEZONE %q is not a known time zone: %v", cfg.Timezone, err)
+		location = time.UTC
+	}
+	cfg.Location = location
+
+	cfg.MetricsToken = l.string("METRICS_TOKEN", "")
+	cfg.AdminToken = l.string("ADMIN_TOKEN", "")
+	if cfg.AdminToken != "" && len(cfg.AdminToken) < minJWTSecretLength {
+		l.errorf("ADMIN_TOKEN must be at least %d bytes", minJWTSecretLength)
+	}
+
+	if len(l.errors) > 0 {
+		return nil, fmt.Errorf("invalid configuration:\n  - %s", strings.Join(l.errors, "\n  - "))
+	}
+	App = cfg
+	return cfg, nil
+}
+
+func (cfg *Config) loadServer(l *loader) {
+	port := l.string("PORT", l.string("SERVER_PORT", "8080"))
+	cfg.Server.Addr = ":" + port
+	if _, err := net.LookupPort("tcp", port); err != nil {
+		l.errorf("PORT must be a port number, got %q", port)
+	}
+
+	cfg.Server.ShutdownTimeout = time.Duration(l.int("SHUTDOWN_TIMEOUT_SECONDS", 30)) * time.Second
+	if cfg.Server.ShutdownTimeout <= 0 {
+		l.errorf("SHUTDOWN_TIMEOUT_SECONDS must be positive")
+	}
+}
+
+// loadDB builds the DSN from DB_DSN, or else from the DB_HOST, DB_PORT,
+// DB_USER, DB_PASSWORD and DB_NAME parts. parseTime is always enabled since
+// the handlers scan DATETIME columns into time.Time.
+func (cfg *Config) loadDB(l *loader) {
+	var dsn *mysql.Config
+	if raw := l.lookup("DB_DSN"); raw != "" {
+		parsed, err := mysql.ParseDSN(raw)
+		if err != nil {
+			l.errorf("DB_DSN is not a valid MySQL DSN: %v", err)
+		} else {
+			dsn = parsed
+		}
+	} else {
+		dsn = mysql.NewConfig()
+		dsn.Net = "tcp"
+		dsn.Addr = net.JoinHostPort(l.string("DB_HOST", "localhost"), l.string("DB_PORT", "3306"))
+		dsn.User = l.required("DB_USER")
+		dsn.Passwd = l.lookup("DB_PASSWORD")
+		dsn.DBName = l.required("DB_NAME")
+	}
+	if dsn != nil {
+		dsn.ParseTime = true
+		cfg.DB.DSN = dsn.FormatDSN()
+	}
+
+	cfg.DB.MaxOpenConns = l.int("DB_MAX_OPEN_CONNS", 50)
+	cfg.DB.MaxIdleConns = l.int("DB_MAX_IDLE_CONNS", 25)
+	cfg.DB.ConnMaxLifetime = l.duration("DB_CONN_MAX_LIFETIME", time.Hour)
+	cfg.DB.ConnMaxIdleTime = l.duration("DB_CONN_MAX_IDLE_TIME", 30*time.Minute)
+	if cfg.DB.MaxOpenConns < 1 {
+		l.errorf("DB_MAX_OPEN_CONNS must be at least 1")
+	}
+	if cfg.DB.MaxIdleConns < 0 || cfg.DB.MaxIdleConns > cfg.DB.MaxOpenConns {
+		l.errorf("DB_MAX_IDLE_CONNS must be between 0 and DB_MAX_OPEN_CONNS (%d)", cfg.DB.MaxOpenConns)
+	}
+}
+
+func (cfg *Config) loadJWT(l *loader) {
+	if secret := l.required("JWT_SECRET"); secret != "" {
+		if len(secret) < minJWTSecretLength {
+			l.errorf("JWT_SECRET must be at least %d bytes", minJWTSecretLength)
+		}
+		cfg.JWT.Secret = []byte(secret)
+	}
+	for _, previous := range l.list("JWT_PREVIOUS_SECRETS", "") {
+		cfg.JWT.PreviousSecrets = append(cfg.JWT.PreviousSecrets, []byte(previous))
+	}
+}
+
+// loadFCM reads the project ID from FCM_PROJECT_ID, or else from the
+// project_id field of the service account file. With FCM_EMULATOR_HOST set
+// no credentials are needed and the project defaults to demo-gorent.
+func (cfg *Config) loadFCM(l *loader) {
+	cfg.FCM.TopicQuietStart = l.string("FCM_TOPIC_QUIET_HOURS_START", "22:00")
+	cfg.FCM.TopicQuietEnd = l.string("FCM_TOPIC_QUIET_HOURS_END", "07:00")
+	for _, setting := range [][2]string{{"FCM_TOPIC_QUIET_HOURS_START", cfg.FCM.TopicQuietStart}, {"FCM_TOPIC_QUIET_HOURS_END", cfg.FCM.TopicQuietEnd}} {
+		if _, err := time.Parse("15:04", setting[1]); err != nil {
+			l.errorf("%s must be HH:MM, got %q", setting[0], setting[1])
+		}
+	}
+
+	cfg.FCM.CredentialsFile = l.string("FCM_CREDENTIALS_FILE", "config/firebase-service-account.json")
+	if host := l.lookup("FCM_EMULATOR_HOST"); host != "" {
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			l.errorf("FCM_EMULATOR_HOST must be host:port, got %q", host)
+		}
+		cfg.FCM.Emulator = true
+		cfg.FCM.BaseURL = "http://" + host
+		cfg.FCM.IIDBaseURL = "http://" + host
+		cfg.FCM.ProjectID = l.string("FCM_PROJECT_ID", "demo-gorent")
+		return
+	}
+
+	cfg.FCM.BaseURL = strings.TrimRight(l.string("FCM_API_BASE_URL", "https://fcm.googleapis.com"), "/")
+	cfg.FCM.IIDBaseURL = strings.TrimRight(l.string("FCM_IID_BASE_URL", "https://iid.googleapis.com"), "/")
+	for _, setting := range [][2]string{{"FCM_API_BASE_URL", cfg.FCM.BaseURL}, {"FCM_IID_BASE_URL", cfg.FCM.IIDBaseURL}} {
+		if u, err := url.Parse(setting[1]); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			l.errorf("%s must be an http(s) URL, got %q", setting[0], setting[1])
+		}
+	}
+
+	cfg.FCM.ProjectID = l.lookup("FCM_PROJECT_ID")
+	if cfg.FCM.ProjectID != "" {
+		return
+	}
+
+	creds, err := os.ReadFile(cfg.FCM.CredentialsFile)
+	if err != nil {
+		l.errorf("FCM_PROJECT_ID is not set and the credentials file could not be read: %v", err)
+		return
+	}
+	var account struct {
+		ProjectID string `json:"project_id"`
+	}
+	if err := json.Unmarshal(creds, &account); err != nil || account.ProjectID == "" {
+		l.errorf("FCM_PROJECT_ID is not set and %s has no project_id", cfg.FCM.CredentialsFile)
+		return
+	}
+	cfg.FCM.ProjectID = account.ProjectID
+}
+
+func (cfg *Config) loadOutbox(l *loader) {
+	cfg.Outbox = OutboxConfig{
+		PollInterval: l.duration("PUSH_OUTBOX_POLL_INTERVAL", 5*time.Second),
+		BatchSize:    l.int("PUSH_OUTBOX_BATCH_SIZE", 50),
+		MaxAttempts:  l.int("PUSH_OUTBOX_MAX_ATTEMPTS", 8),
+		BaseDelay:    l.duration("PUSH_OUTBOX_RETRY_BASE_DELAY", 30*time.Second),
+		MaxDelay:     l.duration("PUSH_OUTBOX_RETRY_MAX_DELAY", time.Hour),
+		Retention:    l.duration("PUSH_OUTBOX_RETENTION", 7*24*time.Hour),
+	}
+	if cfg.Outbox.PollInterval <= 0 {
+		l.errorf("PUSH_OUTBOX_POLL_INTERVAL must be positive")
+	}
+	if cfg.Outbox.BatchSize < 1 {
+		l.errorf("PUSH_OUTBOX_BATCH_SIZE must be at least 1")
+	}
+	if cfg.Outbox.MaxAttempts < 1 {
+		l.errorf("PUSH_OUTBOX_MAX_ATTEMPTS must be at least 1")
+	}
+	if cfg.Outbox.BaseDelay <= 0 || cfg.Outbox.MaxDelay < cfg.Outbox.BaseDelay {
+		l.errorf("PUSH_OUTBOX_RETRY_BASE_DELAY must be positive and no more than PUSH_OUTBOX_RETRY_MAX_DELAY")
+	}
+	if cfg.Outbox.Retention <= 0 {
+		l.errorf("PUSH_OUTBOX_RETENTION must be positive")
+	}
+}
+
+func (cfg *Config) loadEvents(l *loader) {
+	cfg.Events = EventsConfig{
+		PollInterval: l.duration("EVENT_STREAM_POLL_INTERVAL", time.Second),
+		Heartbeat:    l.duration("EVENT_STREAM_HEARTBEAT", 15*time.Second),
+		Retention:    l.duration("EVENT_STREAM_RETENTION", 72*time.Hour),
+		MaxStreams:   l.int("EVENT_STREAM_MAX_PER_USER", 5),
+	}
+	if cfg.Events.PollInterval <= 0 {
+		l.errorf("EVENT_STREAM_POLL_INTERVAL must be positive")
+	}
+	if cfg.Events.Heartbeat <= 0 {
+		l.errorf("EVENT_STREAM_HEARTBEAT must be positive")
+	}
+	if cfg.Events.Retention <= 0 {
+		l.errorf("EVENT_STREAM_RETENTION must be positive")
+	}
+	if cfg.Events.MaxStreams < 1 {
+		l.errorf("EVENT_STREAM_MAX_PER_USER must be at least 1")
+	}
+}
+
+// loadNotifiers reads the optional email, SMS and webhook channels. Each is
+// enabled by its first setting and then needs the rest.
+func (cfg *Config) loadNotifiers(l *loader) {
+	cfg.SMTP = SMTPConfig{
+		Host:     l.string("SMTP_HOST", ""),
+		Port:     l.int("SMTP_PORT", 587),
+		Username: l.string("SMTP_USERNAME", ""),
+		Password: l.lookup("SMTP_PASSWORD"),
+		TLS:      strings.ToLower(l.string("SMTP_TLS", "starttls")),
+	}
+	if cfg.SMTP.Host != "" {
+		cfg.SMTP.From = l.required("SMTP_FROM")
+		if _, err := mail.ParseAddress(cfg.SMTP.From); cfg.SMTP.From != "" && err != nil {
+			l.errorf("SMTP_FROM must be an email address such as GoRent <no-reply@example.com>: %v", err)
+		}
+		if !oneOf(cfg.SMTP.TLS, "starttls", "tls", "none") {
+			l.errorf("SMTP_TLS must be starttls, tls or none, got %q", cfg.SMTP.TLS)
+		}
+		if cfg.SMTP.Port < 1 || cfg.SMTP.Port > 65535 {
+			l.errorf("SMTP_PORT must be a port number")
+		}
+	}
+
+	cfg.SMS = SMSConfig{
+		BaseURL:    l.string("SMS_API_BASE_URL", "https://api.twilio.com"),
+		AccountSID: l.string("SMS_ACCOUNT_SID", ""),
+	}
+	if cfg.SMS.AccountSID != "" {
+		cfg.SMS.AuthToken = l.required("SMS_AUTH_TOKEN")
+		cfg.SMS.From = l.required("SMS_FROM")
+		if u, err := url.Parse(cfg.SMS.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			l.errorf("SMS_API_BASE_URL must be an http(s) URL, got %q", cfg.SMS.BaseURL)
+		}
+	}
+
+	cfg.Webhook = WebhookConfig{
+		SigningSecret:        l.lookup("WEBHOOK_SIGNING_SECRET"),
+		AllowPrivateNetworks: l.bool("WEBHOOK_ALLOW_PRIVATE_NETWORKS", false),
+	}
+	if cfg.Webhook.SigningSecret != "" && len(cfg.Webhook.SigningSecret) < minJWTSecretLength {
+		l.errorf("WEBHOOK_SIGNING_SECRET must be at least %d bytes", minJWTSecretLength)
+	}
+}
+
+// loadStorage reads the upload settings. Without STORAGE_SIGNING_SECRET,
+// local URLs are signed with a key derived from JWT_SECRET.
+func (cfg *Config) loadStorage(l *loader) {
+	cfg.Storage = StorageConfig{
+		Backend:       strings.ToLower(l.string("STORAGE_BACKEND", "local")),
+		LocalDir:      l.string("STORAGE_LOCAL_DIR", "uploads"),
+		PublicURL:     strings.TrimRight(l.string("STORAGE_PUBLIC_URL", ""), "/"),
+		URLTTL:        l.duration("STORAGE_URL_TTL", 15*time.Minute),
+		MaxBytes:      int64(l.int("UPLOAD_MAX_BYTES", 10<<20)),
+		QuotaBytes:    int64(l.int("UPLOAD_USER_QUOTA_BYTES", 500<<20)),
+		OrphanTTL:     l.duration("UPLOAD_ORPHAN_TTL", 24*time.Hour),
+		ThumbnailSize: l.int("UPLOAD_THUMBNAIL_SIZE", 320),
+	}
+	if secret := l.lookup("STORAGE_SIGNING_SECRET"); secret != "" {
+		if len(secret) < minJWTSecretLength {
+			l.errorf("STORAGE_SIGNING_SECRET must be at least %d bytes", minJWTSecretLength)
+		}
+		cfg.Storage.SigningSecret = []byte(secret)
+	} else if len(cfg.JWT.Secret) > 0 {
+		mac := hmac.New(sha256.New, cfg.JWT.Secret)
+		mac.Write([]byte("go-rent storage URLs"))
+		cfg.Storage.SigningSecret = mac.Sum(nil)
+	}
+	if cfg.Storage.PublicURL != "" {
+		if u, err := url.Parse(cfg.Storage.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			l.errorf("STORAGE_PUBLIC_URL must be an http(s) URL, got %q", cfg.Storage.PublicURL)
+		}
+	}
+	if cfg.Storage.URLTTL < time.Minute || cfg.Storage.URLTTL > 7*24*time.Hour {
+		l.errorf("STORAGE_URL_TTL must be between 1m and 168h")
+	}
+	if cfg.Storage.MaxBytes < 1 {
+		l.errorf("UPLOAD_MAX_BYTES must be positive")
+	}
+	if cfg.Storage.QuotaBytes < cfg.Storage.MaxBytes {
+		l.errorf("UPLOAD_USER_QUOTA_BYTES must be at least UPLOAD_MAX_BYTES")
+	}
+	if cfg.Storage.OrphanTTL < time.Hour {
+		l.errorf("UPLOAD_ORPHAN_TTL must be at least 1h")
+	}
+	if cfg.Storage.ThumbnailSize < 16 {
+		l.errorf("UPLOAD_THUMBNAIL_SIZE must be at least 16")
+	}
+
+	switch cfg.Storage.Backend {
+	case "local":
+		if cfg.Storage.LocalDir == "" {
+			l.errorf("STORAGE_LOCAL_DIR must not be empty")
+		}
+	case "s3":
+		cfg.Storage.S3 = S3Config{
+			Endpoint:        l.required("S3_ENDPOINT"),
+			PublicEndpoint:  l.string("S3_PUBLIC_ENDPOINT", ""),
+			Region:          l.string("S3_REGION", "us-east-1"),
+			Bucket:          l.required("S3_BUCKET"),
+			AccessKeyID:     l.required("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: l.required("S3_SECRET_ACCESS_KEY"),
+			PathStyle:       l.bool("S3_FORCE_PATH_STYLE", true),
+		}
+		for _, setting := range [][2]string{{"S3_ENDPOINT", cfg.Storage.S3.Endpoint}, {"S3_PUBLIC_ENDPOINT", cfg.Storage.S3.PublicEndpoint}} {
+			if setting[1] == "" {
+				continue
+			}
+			if u, err := url.Parse(setting[1]); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				l.errorf("%s must be an http(s) URL, got %q", setting[0], setting[1])
+			}
+		}
+	default:
+		l.errorf("STORAGE_BACKEND must be local or s3, got %q", cfg.Storage.Backend)
+	}
+}
+
+func (cfg *Config) loadCORS(l *loader) {
+	cfg.CORS = CORSConfig{
+		AllowedOrigins: l.list("CORS_ALLOWED_ORIGINS", "http://localhost:*,http://127.0.0.1:*"),
+		AllowedMethods: l.list("CORS_ALLOWED_METHODS", "GET,POST,PUT,DELETE,OPTIONS"),
+		AllowedHeaders: l.list("CORS_ALLOWED_HEADERS", "Content-Type,Authorization,X-CSRF-Token,X-Request-ID"),
+		ExposedHeaders: l.list("CORS_EXPOSED_HEADERS", "Retry-After,X-RateLimit-Limit,X-RateLimit-Remaining,X-RateLimit-Reset,X-Request-ID"),
+		MaxAge:         l.int("CORS_MAX_AGE", 600),
+	}
+	for _, origin := range cfg.CORS.AllowedOrigins {
+		if origin == "*" {
+			l.errorf("CORS_ALLOWED_ORIGINS cannot be *: credentialed requests need explicit origins")
+			continue
+		}
+		u, err := url.Parse(strings.TrimSuffix(origin, ":*"))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" {
+			l.errorf("CORS_ALLOWED_ORIGINS entry %q must look like https://host or http://host:*", origin)
+		}
+	}
+}
+
+func (cfg *Config) loadCookie(l *loader) {
+	cfg.Cookie = CookieConfig{
+		Domain:   l.string("COOKIE_DOMAIN", ""),
+		Secure:   l.bool("COOKIE_SECURE", false),
+		SameSite: strings.ToLower(l.string("COOKIE_SAMESITE", "lax")),
+	}
+	if !oneOf(cfg.Cookie.SameSite, "lax", "strict", "none") {
+		l.errorf("COOKIE_SAMESITE must be lax, strict or none, got %q", cfg.Cookie.SameSite)
+	}
+	if cfg.Cookie.SameSite == "none" && !cfg.Cookie.Secure {
+		l.errorf("COOKIE_SAMESITE=none requires COOKIE_SECURE=true")
+	}
+}
+
+func (cfg *Config) loadRateLimits(l *loader) {
+	limit := func(prefix string, perMinute float64, burst int) RateLimit {
+		rl := RateLimit{
+			PerMinute: l.float(prefix+"_PER_MINUTE", perMinute),
+			Burst:     l.int(prefix+"_BURST", burst),
+		}
+		if rl.PerMinute <= 0 || rl.Burst < 1 {
+			l.errorf("%s_PER_MINUTE must be positive and %s_BURST at least 1", prefix, prefix)
+		}
+		return rl
+	}
+
+	// Strict for credential endpoints, generous for reads
+	cfg.RateLimit = RateLimitConfig{
+		Auth:  limit("RATE_LIMIT_AUTH", 5, 5),
+		Read:  limit("RATE_LIMIT_READ", 1200, 60),
+		Write: limit("RATE_LIMIT_WRITE", 120, 10),
+	}
+}
+
+func oneOf(value string, options ...string) bool {
+	for _, option := range options {
+		if value == option {
+			return true
+		}
+	}
+	return false
+}
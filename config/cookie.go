@@ -0,0 +1,17 @@
+package config
+
+import (
+	"net/http"
+)
+
+// CookieSameSiteMode converts App.Cookie.SameSite into an http.SameSite value
+func CookieSameSiteMode() http.SameSite {
+	switch App.Cookie.SameSite {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
@@ -2,36 +2,17 @@ package config
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
-	"os"
-	_ "github.com/go-sql-driver/mysql"
-	"time"
+	"github.com/go-sql-driver/mysql"
 )
 
-var (
-	DBUser     = getEnv("DB_USER", "suma")
-	DBPassword = getEnv("DB_PASSWORD", "tMyc6mApj]wgzHl7")
-	DBHost     = getEnv("DB_HOST", "localhost")
-	DBPort     = getEnv("DB_PORT", "3306")
-	DBName     = getEnv("DB_NAME", "rent")
-)
-
-// getEnv gets an environment variable or returns a default value
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
-}
-
 var db *sql.DB
 
 // InitDB initializes the database connection
 func InitDB() error {
 	var err error
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true", DBUser, DBPassword, DBHost, DBPort, DBName)
-	
-	db, err = sql.Open("mysql", dsn)
+	db, err = sql.Open("mysql", App.DB.DSN)
 	if err != nil {
 		return fmt.Errorf("error opening database: %v", err)
 	}
@@ -43,10 +24,10 @@ func InitDB() error {
 	}
 
 	// Set connection pool settings
-	db.SetMaxOpenConns(50)                // Increased from 25
-	db.SetMaxIdleConns(25)               // Keep idle connections
-	db.SetConnMaxLifetime(time.Hour)     // Maximum lifetime of a connection
-	db.SetConnMaxIdleTime(30 * time.Minute) // Maximum idle time of a connection
+	db.SetMaxOpenConns(App.DB.MaxOpenConns)
+	db.SetMaxIdleConns(App.DB.MaxIdleConns)
+	db.SetConnMaxLifetime(App.DB.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(App.DB.ConnMaxIdleTime)
 
 	return nil
 }
@@ -66,4 +47,29 @@ func GetDBConnection() (*sql.DB, error) {
 	}
 	
 	return db, nil
-} 
\ No newline at end of file
+} 
+
+// CurrentDB returns the shared pool without checking it, for callers such as
+// the metrics collector that must not open connections themselves
+func CurrentDB() *sql.DB {
+	return db
+}
+
+// CloseDB closes the shared pool. It is called once, on shutdown, after the
+// HTTP server and scheduler have stopped using it.
+func CloseDB() error {
+	if db == nil {
+		return nil
+	}
+	return db.Close()
+}
+
+// erDupEntry is MySQL's error number for a duplicate value in a unique key
+const erDupEntry = 1062
+
+// IsDuplicateKey reports whether err, or an error it wraps, is MySQL
+// rejecting a row because it would duplicate a primary or unique key
+func IsDuplicateKey(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == erDupEntry
+}
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// readEnvFile parses a file of KEY=VALUE lines. Blank lines and lines
+// starting with # are skipped, an optional "export " prefix is allowed, and
+// values may be wrapped in single or double quotes.
+func readEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, lineNo)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return values, nil
+}
+
+// loader reads settings from the environment, falling back to the env file,
+// and collects every problem so they can all be reported at once
+type loader struct {
+	file   map[string]string
+	errors []string
+}
+
+func (l *loader) errorf(format string, args ...interface{}) {
+	l.errors = append(l.errors, fmt.Sprintf(format, args...))
+}
+
+// lookup returns the value of key from the environment or the env file.
+// Variables set in the environment take precedence.
+func (l *loader) lookup(key string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return strings.TrimSpace(value)
+	}
+	return l.file[key]
+}
+
+func (l *loader) string(key, defaultValue string) string {
+	if value := l.lookup(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func (l *loader) required(key string) string {
+	value := l.lookup(key)
+	if value == "" {
+		l.errorf("%s is required", key)
+	}
+	return value
+}
+
+func (l *loader) int(key string, defaultValue int) int {
+	value := l.lookup(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		l.errorf("%s must be an integer, got %q", key, value)
+		return defaultValue
+	}
+	return n
+}
+
+func (l *loader) float(key string, defaultValue float64) float64 {
+	value := l.lookup(key)
+	if value == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		l.errorf("%s must be a number, got %q", key, value)
+		return defaultValue
+	}
+	return f
+}
+
+func (l *loader) bool(key string, defaultValue bool) bool {
+	value := l.lookup(key)
+	if value == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		l.errorf("%s must be true or false, got %q", key, value)
+		return defaultValue
+	}
+	return b
+}
+
+// duration accepts Go durations ("90s", "1h30m")
+func (l *loader) duration(key string, defaultValue time.Duration) time.Duration {
+	value := l.lookup(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		l.errorf("%s must be a duration such as 30s or 1h, got %q", key, value)
+		return defaultValue
+	}
+	return d
+}
+
+// list splits a comma-separated value into trimmed, non-empty items
+func (l *loader) list(key, defaultValue string) []string {
+	var list []string
+	for _, item := range strings.Split(l.string(key, defaultValue), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,18 @@
+-- Personal API tokens for scripts and integrations
+-- Only the SHA-256 hash of a token is stored; the token itself is shown once on creation
+CREATE TABLE IF NOT EXISTS api_token (
+    id BIGINT PRIMARY KEY,
+    uid BIGINT NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    scopes VARCHAR(255) NOT NULL,
+    last_used_at DATETIME NULL,
+    expires_at DATETIME NULL,
+    revoked_at DATETIME NULL,
+    created_at DATETIME NOT NULL,
+    created_by BIGINT NOT NULL,
+    updated_at DATETIME NOT NULL,
+    updated_by BIGINT NOT NULL,
+    INDEX idx_api_token_uid (uid),
+    FOREIGN KEY (uid) REFERENCES user(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,28 @@
+-- Devices registered for push notifications, several per user
+-- The token is unique: a device that someone else logs in on moves to them.
+-- It is case-sensitive ASCII, so it uses a binary collation.
+-- A user's devices beyond the ten seen most recently are removed on
+-- registration, and FCM pushes are queued once per device (push_outbox.device_id)
+CREATE TABLE IF NOT EXISTS device_token (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    token VARCHAR(512) CHARACTER SET ascii COLLATE ascii_bin NOT NULL,
+    platform VARCHAR(16) NOT NULL,
+    app_version VARCHAR(32) NULL,
+    last_seen_at DATETIME NOT NULL,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    UNIQUE KEY uq_device_token_token (token),
+    INDEX idx_device_token_user (user_id, last_seen_at),
+    FOREIGN KEY (user_id) REFERENCES user(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Carry over the single token each user had in user.fcm_token; the column
+-- is no longer read and can be dropped once every server runs this version
+INSERT IGNORE INTO device_token (user_id, token, platform, last_seen_at, created_at, updated_at)
+SELECT id, fcm_token, 'unknown', NOW(), NOW(), NOW()
+FROM user
+WHERE fcm_token IS NOT NULL AND fcm_token <> '' AND CHAR_LENGTH(fcm_token) <= 512;
+
+ALTER TABLE push_outbox
+    ADD COLUMN device_id BIGINT NULL AFTER topic;
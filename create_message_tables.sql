@@ -0,0 +1,137 @@
+-- Threaded messages between a floor's tenant and the property's managers
+-- There is one thread per floor and tenant (a tenancy); every manager of the
+-- property takes part in it. Message ids are AUTO_INCREMENT so that they
+-- order a thread and can be compared with each participant's
+-- message_read.last_read_message_id for unread counts and read receipts.
+CREATE TABLE IF NOT EXISTS message_thread (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    pid BIGINT NOT NULL,
+    fid BIGINT NOT NULL,
+    tenant_id BIGINT NOT NULL,
+    last_message_id BIGINT NULL,
+    last_activity_at DATETIME NOT NULL,
+    created_at DATETIME NOT NULL,
+    UNIQUE KEY uq_message_thread_tenancy (fid, tenant_id),
+    INDEX idx_message_thread_tenant (tenant_id, last_activity_at),
+    INDEX idx_message_thread_property (pid, last_activity_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- notification_id is the notification a comment replied to.
+-- source_notification_id is the comment notification a message was copied
+-- from, by POST /notifications/send-comment or by this script; it is unique
+-- so that running the script again copies nothing twice.
+CREATE TABLE IF NOT EXISTS message (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    thread_id BIGINT NOT NULL,
+    sender_id BIGINT NOT NULL,
+    body TEXT NOT NULL,
+    notification_id BIGINT NULL,
+    source_notification_id BIGINT NULL,
+    created_at DATETIME NOT NULL,
+    INDEX idx_message_thread (thread_id, id),
+    UNIQUE KEY uq_message_source (source_notification_id),
+    FOREIGN KEY (thread_id) REFERENCES message_thread(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS message_attachment (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    message_id BIGINT NOT NULL,
+    url VARCHAR(2048) NOT NULL,
+    name VARCHAR(255) NULL,
+    content_type VARCHAR(100) NULL,
+    size_bytes BIGINT NULL,
+    INDEX idx_message_attachment_message (message_id),
+    FOREIGN KEY (message_id) REFERENCES message(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- How far each participant has read a thread
+CREATE TABLE IF NOT EXISTS message_read (
+    thread_id BIGINT NOT NULL,
+    user_id BIGINT NOT NULL,
+    last_read_message_id BIGINT NOT NULL,
+    read_at DATETIME NOT NULL,
+    PRIMARY KEY (thread_id, user_id),
+    INDEX idx_message_read_user (user_id),
+    FOREIGN KEY (thread_id) REFERENCES message_thread(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Copy the comments sent before threads existed. Each comment was stored as
+-- a notification from the commenter to the other party. They are found
+-- two ways: replies to pending notifications have status 'comment', and
+-- the latest reply to any other notification is still in that
+-- notification's comment column. Earlier replies to accepted or rejected
+-- notifications cannot be told apart from other notifications and stay
+-- where they are. Replies without text ("Response sent") are skipped.
+-- The tenant of each conversation is whichever party is not a manager.
+CREATE TEMPORARY TABLE comment_reply AS
+SELECT
+    reply.id,
+    reply.pid,
+    reply.fid,
+    reply.sender,
+    reply.message,
+    reply.created_at,
+    (
+        SELECT MAX(original.id)
+        FROM notification original
+        WHERE original.fid = reply.fid
+          AND original.id <> reply.id
+          AND original.created_at <= reply.created_at
+          AND original.comment = reply.message
+          AND ((original.sender = reply.sender AND original.receiver = reply.receiver)
+            OR (original.sender = reply.receiver AND original.receiver = reply.sender))
+    ) AS original_id,
+    CASE
+        WHEN EXISTS (SELECT 1 FROM takes_care_of c WHERE c.pid = reply.pid AND c.uid = reply.sender)
+        THEN reply.receiver
+        ELSE reply.sender
+    END AS tenant_id
+FROM notification reply
+WHERE reply.message <> 'Response sent'
+  AND (
+    reply.status = 'comment'
+    OR EXISTS (
+        SELECT 1
+        FROM notification original
+        WHERE original.fid = reply.fid
+          AND original.id <> reply.id
+          AND original.created_at <= reply.created_at
+          AND original.comment = reply.message
+          AND ((original.sender = reply.sender AND original.receiver = reply.receiver)
+            OR (original.sender = reply.receiver AND original.receiver = reply.sender))
+    )
+  );
+
+INSERT IGNORE INTO message_thread (pid, fid, tenant_id, last_activity_at, created_at)
+SELECT MIN(pid), fid, tenant_id, MAX(created_at), MIN(created_at)
+FROM comment_reply
+GROUP BY fid, tenant_id;
+
+INSERT IGNORE INTO message (thread_id, sender_id, body, notification_id, source_notification_id, created_at)
+SELECT t.id, cr.sender, cr.message, cr.original_id, cr.id, cr.created_at
+FROM comment_reply cr
+JOIN message_thread t ON t.fid = cr.fid AND t.tenant_id = cr.tenant_id
+ORDER BY cr.created_at, cr.id;
+
+UPDATE message_thread t
+JOIN (
+    SELECT thread_id, MAX(id) AS last_id, MAX(created_at) AS last_at
+    FROM message
+    GROUP BY thread_id
+) latest ON latest.thread_id = t.id
+SET t.last_message_id = latest.last_id,
+    t.last_activity_at = GREATEST(t.last_activity_at, latest.last_at);
+
+-- Old comments were shown in the app already, so they start out read
+INSERT IGNORE INTO message_read (thread_id, user_id, last_read_message_id, read_at)
+SELECT t.id, t.tenant_id, t.last_message_id, NOW()
+FROM message_thread t
+WHERE t.last_message_id IS NOT NULL;
+
+INSERT IGNORE INTO message_read (thread_id, user_id, last_read_message_id, read_at)
+SELECT t.id, c.uid, t.last_message_id, NOW()
+FROM message_thread t
+JOIN takes_care_of c ON c.pid = t.pid
+WHERE t.last_message_id IS NOT NULL;
+
+DROP TEMPORARY TABLE comment_reply;
@@ -0,0 +1,25 @@
+-- Push notifications waiting to be sent, written in the same transaction as
+-- their notification and delivered by the background dispatcher
+-- status: pending (waiting for next_attempt_at), sent, or dead (given up;
+-- can be replayed through POST /admin/push-outbox/{id}/replay)
+-- The id is AUTO_INCREMENT rather than a random ID because rows are written
+-- for every notification and a collision would fail the notification itself
+CREATE TABLE IF NOT EXISTS push_outbox (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    notification_id BIGINT NULL,
+    receiver BIGINT NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    body TEXT NOT NULL,
+    data JSON NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME NOT NULL,
+    last_error TEXT NULL,
+    last_reason VARCHAR(32) NULL,
+    sent_at DATETIME NULL,
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    INDEX idx_push_outbox_due (status, next_attempt_at),
+    INDEX idx_push_outbox_receiver (receiver),
+    INDEX idx_push_outbox_notification (notification_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,30 @@
+-- Files uploaded through POST /uploads and PUT /property/{id}/photo
+-- The files themselves are kept by the storage backend (STORAGE_BACKEND)
+-- under storage_key; images also have a JPEG thumbnail under thumbnail_key.
+-- purpose is what the file was uploaded for: attachment, property_photo or
+-- payment_proof. Uploads that nothing refers to are deleted after
+-- UPLOAD_ORPHAN_TTL.
+CREATE TABLE IF NOT EXISTS upload (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    purpose VARCHAR(32) NOT NULL,
+    storage_key VARCHAR(255) NOT NULL,
+    thumbnail_key VARCHAR(255) NULL,
+    name VARCHAR(255) NULL,
+    content_type VARCHAR(100) NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    width INT NULL,
+    height INT NULL,
+    created_at DATETIME NOT NULL,
+    UNIQUE KEY uq_upload_key (storage_key),
+    INDEX idx_upload_user (user_id, created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- An uploaded property photo replaces the photo URL column
+ALTER TABLE property ADD COLUMN photo_upload_id BIGINT NULL;
+ALTER TABLE property ADD INDEX idx_property_photo_upload (photo_upload_id);
+
+-- Attachments are either uploads or links; links keep their url
+ALTER TABLE message_attachment ADD COLUMN upload_id BIGINT NULL AFTER message_id;
+ALTER TABLE message_attachment MODIFY url VARCHAR(2048) NULL;
+ALTER TABLE message_attachment ADD INDEX idx_message_attachment_upload (upload_id);
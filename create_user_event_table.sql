@@ -0,0 +1,14 @@
+-- Events streamed to users over GET /events, written in the same
+-- transaction as the change they describe. Each server instance polls for
+-- rows past the last id it has read, and clients resume with the id of the
+-- last event they received, so ids must increase: hence AUTO_INCREMENT.
+-- Rows older than EVENT_STREAM_RETENTION are deleted by the dispatcher.
+CREATE TABLE IF NOT EXISTS user_event (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    type VARCHAR(32) NOT NULL,
+    data JSON NOT NULL,
+    created_at DATETIME NOT NULL,
+    INDEX idx_user_event_user (user_id, id),
+    INDEX idx_user_event_created (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
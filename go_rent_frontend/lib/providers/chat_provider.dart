@@ -104,16 +104,16 @@ class ChatProvider with ChangeNotifier {
       final requestBody = {
         'message': text,
         'tenant_id': tenantId ?? _selectedTenantId ?? '',
-        'user_id': 'flutter_user',
       };
       final body = jsonEncode(requestBody);
       
       print('Chatbot: Sending request to $url');
       print('Chatbot: Request body: $body');
 
+      // The chatbot only answers about the signed-in manager's tenants
       final response = await http.post(
         url,
-        headers: {'Content-Type': 'application/json'},
+        headers: ApiService().headers,
         body: body,
       ).timeout(const Duration(seconds: 30));
       
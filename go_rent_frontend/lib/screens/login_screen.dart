@@ -39,11 +39,22 @@ class _LoginScreenState extends State<LoginScreen> {
       // Format phone number as +880 XXXX-XXXXXX
       final phoneNumber = '+880 ${_phoneController.text.substring(0, 4)}-${_phoneController.text.substring(4)}';
       
-      final loginResult = await _apiService.login(
+      var loginResult = await _apiService.login(
         phoneNumber,
         _passwordController.text,
       );
 
+      if (loginResult != null && loginResult['two_factor_required'] == true && mounted) {
+        final code = await _promptTwoFactorCode();
+        if (code == null || code.isEmpty) {
+          return;
+        }
+        loginResult = await _apiService.verifyTwoFactorLogin(
+          loginResult['challenge_token'],
+          code,
+        );
+      }
+
       if (loginResult != null && loginResult['success'] == true && mounted) {
         // Set the current user ID after login using the actual userId from backend
         final userId = loginResult['user_id'];
@@ -89,6 +100,35 @@ class _LoginScreenState extends State<LoginScreen> {
     }
   }
 
+  Future<String?> _promptTwoFactorCode() {
+    final codeController = TextEditingController();
+    return showDialog<String>(
+      context: context,
+      barrierDismissible: false,
+      builder: (context) => AlertDialog(
+        title: const Text('Two-factor authentication'),
+        content: TextField(
+          controller: codeController,
+          autofocus: true,
+          decoration: const InputDecoration(
+            labelText: 'Authenticator or recovery code',
+            border: OutlineInputBorder(),
+          ),
+        ),
+        actions: [
+          TextButton(
+            onPressed: () => Navigator.pop(context),
+            child: const Text('Cancel'),
+          ),
+          ElevatedButton(
+            onPressed: () => Navigator.pop(context, codeController.text),
+            child: const Text('Verify'),
+          ),
+        ],
+      ),
+    );
+  }
+
   @override
   Widget build(BuildContext context) {
     return Scaffold(
@@ -74,14 +74,18 @@ class ApiService {
       'Content-Type': 'application/json',
     };
     
+    // Bearer tokens are exempt from the cookie CSRF check
     if (_sessionToken != null) {
-      headers['Cookie'] = 'sessiontoken=$_sessionToken';
+      headers['Authorization'] = 'Bearer $_sessionToken';
       print('Adding session token to headers: $_sessionToken');
     }
     
     return headers;
   }
 
+  // Headers for requests made outside this service, such as the chatbot's
+  Map<String, String> get headers => _headers;
+
   // LOGIN
   Future<Map<String, dynamic>?> login(String phoneNumber, String password) async {
     try {
@@ -92,6 +96,7 @@ class ApiService {
         body: json.encode({
           'phone_number': phoneNumber,
           'password': password,
+          'return_token': true,
         }),
       );
       
@@ -106,21 +111,25 @@ class ApiService {
         final userName = responseData['name'];
         
         print('Extracted user ID: $userId, name: $userName');
+
+        // Accounts with two-factor authentication need a second step
+        if (responseData['two_factor_required'] == true) {
+          return {
+            'success': false,
+            'two_factor_required': true,
+            'challenge_token': responseData['challenge_token'],
+          };
+        }
         
-        // Extract session cookie
-        final setCookie = response.headers['set-cookie'];
-        print('Set-Cookie header: $setCookie');
-        
-        if (setCookie != null) {
-          final sessionMatch = RegExp(r'sessiontoken=([^;]+)').firstMatch(setCookie);
-          if (sessionMatch != null) {
-            await setSessionToken(sessionMatch.group(1)!);
-            return {
-              'success': true,
-              'user_id': userId,
-              'name': userName,
-            };
-          }
+        // Session token is returned in the body when return_token is set
+        final token = responseData['token'];
+        if (token != null) {
+          await setSessionToken(token);
+          return {
+            'success': true,
+            'user_id': userId,
+            'name': userName,
+          };
         }
         print('No session token found in response');
       }
@@ -131,6 +140,42 @@ class ApiService {
     }
   }
 
+  // LOGIN - second step for accounts with two-factor authentication
+  Future<Map<String, dynamic>?> verifyTwoFactorLogin(String challengeToken, String code) async {
+    try {
+      // Authenticator codes are 6 digits; anything else is treated as a recovery code
+      final isTotp = RegExp(r'^\d{6}$').hasMatch(code.trim());
+      final response = await _client.post(
+        Uri.parse('$baseUrl/login/2fa'),
+        headers: {'Content-Type': 'application/json'},
+        body: json.encode({
+          'challenge_token': challengeToken,
+          if (isTotp) 'code': code.trim() else 'recovery_code': code.trim(),
+          'return_token': true,
+        }),
+      );
+
+      print('Two-factor login response status: ${response.statusCode}');
+
+      if (response.statusCode == 200) {
+        final responseData = json.decode(response.body);
+        final token = responseData['token'];
+        if (token != null) {
+          await setSessionToken(token);
+          return {
+            'success': true,
+            'user_id': responseData['user_id'],
+            'name': responseData['name'],
+          };
+        }
+      }
+      return null;
+    } catch (e) {
+      print('Two-factor login error: $e');
+      throw Exception('Two-factor login error: $e');
+    }
+  }
+
   // REGISTRATION
   Future<bool> register(String phoneNumber, String password, {required String name}) async {
     try {
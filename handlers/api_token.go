@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"go-rent/apierror"
+	"go-rent/config"
+	"go-rent/logger"
+	"go-rent/utils"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type CreateAPITokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days,omitempty"`
+}
+
+type APIToken struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type APITokenResponse struct {
+	Success  bool       `json:"success"`
+	Message  string     `json:"message"`
+	Token    string     `json:"token,omitempty"`
+	APIToken *APIToken  `json:"api_token,omitempty"`
+	Tokens   []APIToken `json:"api_tokens,omitempty"`
+}
+
+// CreateAPITokenHandler issues a personal API token for the current user.
+// The plaintext token is only returned in this response.
+func CreateAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := getUserIDFromContext(r)
+	if userID == 0 {
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req CreateAPITokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		apierror.Field(w, r, "name", "Token name is required (max 100 characters)")
+		return
+	}
+	if len(req.Scopes) == 0 {
+		apierror.Field(w, r, "scopes", "At least one scope is required")
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !utils.ValidAPITokenScope(scope) {
+			apierror.Field(w, r, "scopes", fmt.Sprintf("Unknown scope %q. Valid scopes: %s", scope, strings.Join(utils.APITokenScopes, ", ")))
+			return
+		}
+	}
+	if req.ExpiresInDays < 0 {
+		apierror.Field(w, r, "expires_in_days", "expires_in_days must not be negative")
+		return
+	}
+
+	db, err := config.GetDBConnection()
+	if err != nil {
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
+		return
+	}
+
+	token, err := utils.GenerateAPIToken()
+	if err != nil {
+		logger.Error(r.Context(), "Error generating API token", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error generating API token")
+		return
+	}
+
+	tokenID, err := utils.GenerateRandomID()
+	if err != nil {
+		logger.Error(r.Context(), "Error generating API token ID", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error generating API token")
+		return
+	}
+
+	// Expiry is computed by the database so it compares consistently with
+	// the NOW() check in AuthMiddleware
+	var expiresInDays interface{}
+	if req.ExpiresInDays > 0 {
+		expiresInDays = req.ExpiresInDays
+	}
+	_, err = db.Exec(`
+		INSERT INTO api_token (id, uid, name, token_hash, scopes, expires_at, created_at, created_by, updated_at, updated_by)
+		VALUES (?, ?, ?, ?, ?, IF(? IS NULL, NULL, DATE_ADD(NOW(), INTERVAL ? DAY)), NOW(), ?, NOW(), ?)`,
+		tokenID, userID, req.Name, utils.HashAPIToken(token), strings.Join(req.Scopes, ","),
+		expiresInDays, expiresInDays, userID, userID)
+	if err != nil {
+		logger.Error(r.Context(), "Error creating API token", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error creating API token")
+		return
+	}
+
+	apiToken, err := getAPIToken(db, tokenID, userID)
+	if err != nil {
+		logger.Error(r.Context(), "Error reading created API token", "error", err)
+	}
+
+	logger.Info(r.Context(), "API token created", "token_id", tokenID, "scopes", req.Scopes)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(APITokenResponse{
+		Success:  true,
+		Message:  "API token created. Store it now, it will not be shown again.",
+		Token:    token,
+		APIToken: apiToken,
+	})
+}
+
+// GetAPITokensHandler lists the current user's personal API tokens
+func GetAPITokensHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := getUserIDFromContext(r)
+	if userID == 0 {
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	db, err := config.GetDBConnection()
+	if err != nil {
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT id, name, scopes, last_used_at, expires_at, revoked_at, created_at
+		FROM api_token
+		WHERE uid = ?
+		ORDER BY created_at DESC`, userID)
+	if err != nil {
+		logger.Error(r.Context(), "Error querying API tokens", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error fetching API tokens")
+		return
+	}
+	defer rows.Close()
+
+	tokens := []APIToken{}
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			logger.Error(r.Context(), "Error scanning API token", "error", err)
+			continue
+		}
+		tokens = append(tokens, *token)
+	}
+
+	json.NewEncoder(w).Encode(APITokenResponse{
+		Success: true,
+		Message: "API tokens retrieved successfully",
+		Tokens:  tokens,
+	})
+}
+
+// RevokeAPITokenHandler revokes one of the current user's personal API tokens
+func RevokeAPITokenHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := getUserIDFromContext(r)
+	if userID == 0 {
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	tokenID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid token ID")
+		return
+	}
+
+	db, err := config.GetDBConnection()
+	if err != nil {
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
+		return
+	}
+
+	result, err := db.Exec(`
+		UPDATE api_token
+		SET revoked_at = NOW(), updated_at = NOW(), updated_by = ?
+		WHERE id = ? AND uid = ? AND revoked_at IS NULL`, userID, tokenID, userID)
+	if err != nil {
+		logger.Error(r.Context(), "Error revoking API token", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error revoking API token")
+		return
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		apierror.Write(w, r, http.StatusNotFound, "API token not found or already revoked")
+		return
+	}
+
+	logger.Info(r.Context(), "API token revoked", "token_id", tokenID)
+
+	json.NewEncoder(w).Encode(APITokenResponse{Success: true, Message: "API token revoked"})
+}
+
+// getAPIToken loads a single API token owned by the user
+func getAPIToken(db *sql.DB, tokenID, userID int64) (*APIToken, error) {
+	row := db.QueryRow(`
+		SELECT id, name, scopes, last_used_at, expires_at, revoked_at, created_at
+		FROM api_token
+		WHERE id = ? AND uid = ?`, tokenID, userID)
+	return scanAPIToken(row)
+}
+
+// scanAPIToken reads an api_token row selected in the column order used above
+func scanAPIToken(row interface{ Scan(...interface{}) error }) (*APIToken, error) {
+	var token APIToken
+	var scopes string
+	var lastUsedAt, expiresAt, revokedAt sql.NullTime
+	if err := row.Scan(&token.ID, &token.Name, &scopes, &lastUsedAt, &expiresAt, &revokedAt, &token.CreatedAt); err != nil {
+		return nil, err
+	}
+	token.Scopes = strings.Split(scopes, ",")
+	if lastUsedAt.Valid {
+		token.LastUsedAt = &lastUsedAt.Time
+	}
+	if expiresAt.Valid {
+		token.ExpiresAt = &expiresAt.Time
+	}
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+	return &token, nil
+}
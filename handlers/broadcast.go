@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-rent/apierror"
+	"go-rent/config"
+	"go-rent/logger"
+	"go-rent/notify"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// Broadcast length limits, in characters
+const (
+	maxBroadcastTitle   = 100
+	maxBroadcastMessage = 1000
+)
+
+// PropertyBroadcastRequest is an announcement to every tenant of a
+// property. Title heads the push and email; it defaults to "Announcement -
+// <property name>".
+type PropertyBroadcastRequest struct {
+	Title   string `json:"title"`
+	Message string `json:"message"`
+}
+
+type PropertyBroadcastResponse struct {
+	Success    bool   `json:"success"`
+	Message    string `json:"message"`
+	Recipients int    `json:"recipients"`
+	Topic      string `json:"topic"`
+}
+
+// BroadcastPropertyHandler sends an announcement, such as a water shutdown
+// or lift maintenance, to every tenant of the property. Each tenant gets it
+// as a notification and on their email, SMS and webhook channels, and one
+// push is sent to the property's FCM topic, which their devices are
+// subscribed to.
+func BroadcastPropertyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := getUserIDFromContext(r)
+	if userID == 0 {
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	propertyID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid property ID")
+		return
+	}
+
+	var req PropertyBroadcastRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	req.Title = strings.TrimSpace(req.Title)
+	req.Message = strings.TrimSpace(req.Message)
+
+	problems := make(map[string]string)
+	if req.Message == "" {
+		problems["message"] = "Message is required"
+	} else if utf8.RuneCountInString(req.Message) > maxBroadcastMessage {
+		problems["message"] = fmt.Sprintf("Message must be at most %d characters", maxBroadcastMessage)
+	}
+	if utf8.RuneCountInString(req.Title) > maxBroadcastTitle {
+		problems["title"] = fmt.Sprintf("Title must be at most %d characters", maxBroadcastTitle)
+	}
+	if len(problems) > 0 {
+		apierror.WriteFields(w, r, "Invalid broadcast", problems)
+		return
+	}
+
+	db, err := config.GetDBConnection()
+	if err != nil {
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Error(r.Context(), "Transaction start error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	var propertyName string
+	if err := tx.QueryRow(`SELECT name FROM property WHERE id = ?`, propertyID).Scan(&propertyName); err != nil {
+		logger.Error(r.Context(), "Error getting property", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error getting property")
+		return
+	}
+	if req.Title == "" {
+		req.Title = fmt.Sprintf("Announcement - %s", propertyName)
+	}
+
+	// One notification per tenant, on the first floor they rent here
+	rows, err := tx.Query(`
+		SELECT tenant, MIN(id)
+		FROM floor
+		WHERE pid = ? AND tenant IS NOT NULL
+		GROUP BY tenant`, propertyID)
+	if err != nil {
+		logger.Error(r.Context(), "Error getting tenants", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error getting tenants")
+		return
+	}
+	type tenantFloor struct{ TenantID, FloorID int64 }
+	var tenants []tenantFloor
+	for rows.Next() {
+		var t tenantFloor
+		if err := rows.Scan(&t.TenantID, &t.FloorID); err != nil {
+			rows.Close()
+			logger.Error(r.Context(), "Error scanning tenant", "error", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "Error getting tenants")
+			return
+		}
+		tenants = append(tenants, t)
+	}
+	rows.Close()
+	if len(tenants) == 0 {
+		apierror.Write(w, r, http.StatusConflict, "This property has no tenants to notify")
+		return
+	}
+
+	timestamp := fmt.Sprintf("%d", time.Now().Unix())
+	for _, t := range tenants {
+		notificationID, err := insertNotification(tx, NotificationKindAnnouncement, userID, t.TenantID, propertyID, t.FloorID, req.Message, "", nil)
+		if err != nil {
+			logger.Error(r.Context(), "Error creating announcement", "tenant_id", t.TenantID, "error", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "Error creating announcement")
+			return
+		}
+
+		// Push goes to the topic below rather than to each device
+		data := map[string]interface{}{
+			"notification_id": fmt.Sprintf("%d", notificationID),
+			"type":            NotificationKindAnnouncement,
+			"property_id":     fmt.Sprintf("%d", propertyID),
+			"floor_id":        fmt.Sprintf("%d", t.FloorID),
+			"timestamp":       timestamp,
+		}
+		if _, err := enqueueNotification(tx, notificationID, t.TenantID, NotificationKindAnnouncement, req.Title, req.Message, data, notify.ChannelFCM); err != nil {
+			logger.Error(r.Context(), "Error queueing announcement", "tenant_id", t.TenantID, "error", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "Error creating announcement")
+			return
+		}
+	}
+
+	topic := PropertyTopic(propertyID)
+	data := map[string]interface{}{
+		"type":        NotificationKindAnnouncement,
+		"property_id": fmt.Sprintf("%d", propertyID),
+		"timestamp":   timestamp,
+	}
+	if err := enqueueTopicPush(tx, topic, req.Title, req.Message, data); err != nil {
+		logger.Error(r.Context(), "Error queueing announcement push", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error creating announcement")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.Error(r.Context(), "Error committing transaction", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to commit transaction")
+		return
+	}
+	WakePushDispatcher()
+	WakeEventDispatcher()
+
+	logger.Info(r.Context(), "Property broadcast queued", "recipients", len(tenants), "topic", topic)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(PropertyBroadcastResponse{
+		Success:    true,
+		Message:    fmt.Sprintf("Announcement sent to %d tenants", len(tenants)),
+		Recipients: len(tenants),
+		Topic:      topic,
+	})
+}
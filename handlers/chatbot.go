@@ -1,12 +1,14 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"go-rent/apierror"
 	"go-rent/config"
-	"log"
-	"math/rand"
+	"go-rent/logger"
 	"net/http"
 	"regexp"
 	"sort"
@@ -38,11 +40,11 @@ type ChatbotTenant struct {
 	PaymentTrend        float64 `json:"payment_trend,omitempty"`
 }
 
-// ChatRequest represents a chat message from the user
+// ChatRequest represents a chat message from the user. TenantID is the
+// phone number of a tenant to ask about, when the message has none.
 type ChatRequest struct {
 	Message  string `json:"message"`
 	TenantID string `json:"tenant_id,omitempty"`
-	UserID   string `json:"user_id,omitempty"`
 }
 
 // ChatResponse represents the bot's response
@@ -193,8 +195,6 @@ func (id *IntentDetector) ExtractPhoneNumbers(message string) []string {
 	allMatches := append(matches1, matches2...)
 	allMatches = append(allMatches, matches3...)
 	
-	log.Printf("ExtractPhoneNumbers: raw matches1=%v, matches2=%v, matches3=%v", matches1, matches2, matches3)
-	
 	// Deduplicate and normalize
 	unique := make(map[string]bool)
 	result := []string{}
@@ -227,136 +227,28 @@ func (id *IntentDetector) ExtractPhoneNumbers(message string) []string {
 		}
 	}
 	
-	log.Printf("ExtractPhoneNumbers: message=%s, found=%v", message, result)
 	return result
 }
 
-// ResponseGenerator generates responses based on intent
-type ResponseGenerator struct {
-	tenants map[string]*ChatbotTenant
-	mu      sync.RWMutex
-}
+// ResponseGenerator generates responses based on intent. Every lookup is
+// made for a manager and only finds tenants of floors on the properties
+// they manage; nothing is cached between requests, so one manager's
+// tenants never show up in another's answers.
+type ResponseGenerator struct{}
+
+// errChatTenantNotFound is returned for a phone number that is not a
+// tenant's on any of the manager's properties
+var errChatTenantNotFound = errors.New("tenant not found")
 
 // NewResponseGenerator creates a new response generator
 func NewResponseGenerator() *ResponseGenerator {
-	rg := &ResponseGenerator{
-		tenants: make(map[string]*ChatbotTenant),
-	}
-	rg.initializeSampleData()
-	return rg
+	return &ResponseGenerator{}
 }
 
-// Initialize sample tenant data
-func (rg *ResponseGenerator) initializeSampleData() {
-	rg.mu.Lock()
-	defer rg.mu.Unlock()
-
-	// Create sample tenants with 4 risk bands
-	sampleTenants := []*ChatbotTenant{
-		{
-			ID:                  "01712345679",
-			RiskProbability:     0.78,
-			RiskLevel:           "High",
-			PreviousLateCount:   3,
-			AvgDelayDays:        6.2,
-			LastPaymentDate:     time.Now().AddDate(0, -1, 0).Format("2006-01-02"),
-			CurrentRentAmount:   1200,
-			RentToIncomeRatio:   0.42,
-			TenancyMonths:       12,
-			EmploymentStatus:    "Full-time",
-			CreditScore:         580,
-			ComplaintCount:      1,
-			PropertyDamageCount: 0,
-			PartialPaymentRatio: 0.10,
-			PaymentTrend:        2.0,
-			Notes:               "3 late payments in last 6 months",
-			CreatedAt:           time.Now().Format(time.RFC3339),
-			UpdatedAt:           time.Now().Format(time.RFC3339),
-		},
-		{
-			ID:                  "01712345678",
-			RiskProbability:     0.50,
-			RiskLevel:           "Medium",
-			PreviousLateCount:   1,
-			AvgDelayDays:        2.0,
-			LastPaymentDate:     time.Now().AddDate(0, 0, -5).Format("2006-01-02"),
-			CurrentRentAmount:   850,
-			RentToIncomeRatio:   0.28,
-			TenancyMonths:       24,
-			EmploymentStatus:    "Full-time",
-			CreditScore:         720,
-			ComplaintCount:      0,
-			PropertyDamageCount: 0,
-			PartialPaymentRatio: 0.05,
-			PaymentTrend:        0.0,
-			Notes:               "1 late payment in last 12 months",
-			CreatedAt:           time.Now().Format(time.RFC3339),
-			UpdatedAt:           time.Now().Format(time.RFC3339),
-		},
-		{
-			ID:                  "01987654321",
-			RiskProbability:     0.92,
-			RiskLevel:           "Critical",
-			PreviousLateCount:   5,
-			AvgDelayDays:        12.5,
-			LastPaymentDate:     time.Now().AddDate(0, -2, 0).Format("2006-01-02"),
-			CurrentRentAmount:   1500,
-			RentToIncomeRatio:   0.55,
-			TenancyMonths:       6,
-			EmploymentStatus:    "Part-time",
-			CreditScore:         520,
-			ComplaintCount:      3,
-			PropertyDamageCount: 2,
-			PartialPaymentRatio: 0.30,
-			PaymentTrend:        3.0,
-			Notes:               "Multiple late payments and complaints",
-			CreatedAt:           time.Now().Format(time.RFC3339),
-			UpdatedAt:           time.Now().Format(time.RFC3339),
-		},
-		{
-			ID:                  "01712345675",
-			RiskProbability:     0.20,
-			RiskLevel:           "Low",
-			PreviousLateCount:   0,
-			AvgDelayDays:        0.0,
-			LastPaymentDate:     time.Now().AddDate(0, 0, -2).Format("2006-01-02"),
-			CurrentRentAmount:   950,
-			RentToIncomeRatio:   0.25,
-			TenancyMonths:       36,
-			EmploymentStatus:    "Full-time",
-			CreditScore:         780,
-			ComplaintCount:      0,
-			PropertyDamageCount: 0,
-			PartialPaymentRatio: 0.0,
-			PaymentTrend:        0.0,
-			Notes:               "Excellent payment history",
-			CreatedAt:           time.Now().Format(time.RFC3339),
-			UpdatedAt:           time.Now().Format(time.RFC3339),
-		},
-	}
-
-	for _, tenant := range sampleTenants {
-		rg.tenants[tenant.ID] = tenant
-	}
-}
-
-// Get tenant by ID
-func (rg *ResponseGenerator) GetTenant(id string) (*ChatbotTenant, bool) {
-	rg.mu.RLock()
-	defer rg.mu.RUnlock()
-
-	tenant, exists := rg.tenants[strings.ToUpper(id)]
-	if !exists {
-		// Generate synthetic tenant
-		tenant = rg.generateSyntheticTenant(id)
-		rg.tenants[tenant.ID] = tenant
-	}
-
-	return tenant, exists
-}
-
-// Get tenant by phone number from database - ENHANCED VERSION
-func (rg *ResponseGenerator) GetTenantByPhone(phoneNumber string) (*ChatbotTenant, error) {
+// GetTenantByPhone returns the risk profile of the tenant with phoneNumber,
+// or errChatTenantNotFound unless they rent a floor on a property managerID
+// manages
+func (rg *ResponseGenerator) GetTenantByPhone(managerID int64, phoneNumber string) (*ChatbotTenant, error) {
 	db, err := config.GetDBConnection()
 	if err != nil {
 		return nil, fmt.Errorf("database connection error: %v", err)
@@ -371,31 +263,43 @@ func (rg *ResponseGenerator) GetTenantByPhone(phoneNumber string) (*ChatbotTenan
 		normalizedPhone = normalizedPhone[1:]
 	}
 
-	// Get user ID by phone number
+	// Get user ID by phone number, among the manager's tenants
 	var userID int64
 	var userName sql.NullString
 	err = db.QueryRow(`
-		SELECT id, name 
-		FROM user 
-		WHERE phone_number = ?`, normalizedPhone).Scan(&userID, &userName)
+		SELECT u.id, u.name
+		FROM user u
+		WHERE u.phone_number = ? AND EXISTS (
+			SELECT 1
+			FROM floor f
+			JOIN takes_care_of c ON c.pid = f.pid
+			WHERE f.tenant = u.id AND c.uid = ?
+		)`, normalizedPhone, managerID).Scan(&userID, &userName)
 	
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("tenant not found with phone number: %s", phoneNumber)
+			return nil, errChatTenantNotFound
 		}
 		return nil, fmt.Errorf("error querying user: %v", err)
 	}
 
+	return rg.tenantProfile(db, managerID, userID, normalizedPhone, userName)
+}
+
+// tenantProfile works out the risk profile of userID from their payments
+// for the latest floor they rent from managerID
+func (rg *ResponseGenerator) tenantProfile(db *sql.DB, managerID, userID int64, phone string, userName sql.NullString) (*ChatbotTenant, error) {
 	// Get tenant's floor and payment data
 	var floorID sql.NullInt64
 	var rent sql.NullInt64
 	var floorCreatedAt sql.NullString
-	err = db.QueryRow(`
+	err := db.QueryRow(`
 		SELECT f.id, f.rent, f.created_at
 		FROM floor f
-		WHERE f.tenant = ?
+		JOIN takes_care_of c ON c.pid = f.pid
+		WHERE f.tenant = ? AND c.uid = ?
 		ORDER BY f.created_at DESC
-		LIMIT 1`, userID).Scan(&floorID, &rent, &floorCreatedAt)
+		LIMIT 1`, userID, managerID).Scan(&floorID, &rent, &floorCreatedAt)
 	
 	if err != nil && err != sql.ErrNoRows {
 		return nil, fmt.Errorf("error querying floor: %v", err)
@@ -485,12 +389,12 @@ func (rg *ResponseGenerator) GetTenantByPhone(phoneNumber string) (*ChatbotTenan
 					}
 				}
 			} else {
-				log.Printf("Warning: Could not calculate payment trend: %v (using fallback)", err)
+				logger.Warn(context.Background(), "Could not calculate payment trend, using fallback", "error", err)
 			}
 
 		} else if err != sql.ErrNoRows {
 			// ⚠️ FALLBACK: Use simple metrics if enhanced query fails
-			log.Printf("Warning: Enhanced metrics query failed, using simple fallback: %v", err)
+			logger.Warn(context.Background(), "Enhanced metrics query failed, using simple fallback", "error", err)
 			
 			err = db.QueryRow(`
 				SELECT 
@@ -512,7 +416,7 @@ func (rg *ResponseGenerator) GetTenantByPhone(phoneNumber string) (*ChatbotTenan
 					lastPaymentDate = lastPayment
 				}
 			} else if err != sql.ErrNoRows {
-				log.Printf("Error calculating even simple risk metrics: %v", err)
+				logger.Error(context.Background(), "Error calculating simple risk metrics", "error", err)
 			}
 		}
 	}
@@ -538,13 +442,13 @@ func (rg *ResponseGenerator) GetTenantByPhone(phoneNumber string) (*ChatbotTenan
 		riskLevel = "Medium"
 	}
 
-	tenantName := normalizedPhone
+	tenantName := phone
 	if userName.Valid && userName.String != "" {
 		tenantName = userName.String
 	}
 
 	tenant := &ChatbotTenant{
-		ID:                normalizedPhone,
+		ID:                phone,
 		RiskProbability:   riskProbability,
 		RiskLevel:         riskLevel,
 		PreviousLateCount: previousLateCount,
@@ -569,14 +473,61 @@ func (rg *ResponseGenerator) GetTenantByPhone(phoneNumber string) (*ChatbotTenan
 		UpdatedAt:           time.Now().Format(time.RFC3339),
 	}
 
-	// Cache the tenant
-	rg.mu.Lock()
-	rg.tenants[normalizedPhone] = tenant
-	rg.mu.Unlock()
-
 	return tenant, nil
 }
 
+// managedTenants returns the risk profiles of the tenants on managerID's
+// properties, riskiest first
+func (rg *ResponseGenerator) managedTenants(managerID int64) ([]*ChatbotTenant, error) {
+	db, err := config.GetDBConnection()
+	if err != nil {
+		return nil, fmt.Errorf("database connection error: %v", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT DISTINCT u.id, u.phone_number, u.name
+		FROM floor f
+		JOIN takes_care_of c ON c.pid = f.pid
+		JOIN user u ON u.id = f.tenant
+		WHERE c.uid = ?`, managerID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying tenants: %v", err)
+	}
+	type tenantUser struct {
+		id    int64
+		phone string
+		name  sql.NullString
+	}
+	var users []tenantUser
+	for rows.Next() {
+		var u tenantUser
+		if err := rows.Scan(&u.id, &u.phone, &u.name); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error reading tenants: %v", err)
+		}
+		users = append(users, u)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading tenants: %v", err)
+	}
+
+	tenants := make([]*ChatbotTenant, 0, len(users))
+	for _, u := range users {
+		tenant, err := rg.tenantProfile(db, managerID, u.id, u.phone, u.name)
+		if err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, tenant)
+	}
+
+	sort.Slice(tenants, func(i, j int) bool {
+		return tenants[i].RiskProbability > tenants[j].RiskProbability
+	})
+
+	return tenants, nil
+}
+
 // ✅ ENHANCED RISK CALCULATION - MATCHES FIGURE 2
 func (rg *ResponseGenerator) calculateEnhancedRiskProbability(
 	lateCount int, 
@@ -686,61 +637,6 @@ func (rg *ResponseGenerator) calculateRiskProbability(lateCount int, avgDelay fl
 	return rg.calculateEnhancedRiskProbability(lateCount, avgDelay, tenancyMonths, 0, 0, 0, 0)
 }
 
-// Generate synthetic tenant for unknown IDs
-func (rg *ResponseGenerator) generateSyntheticTenant(id string) *ChatbotTenant {
-	rand.Seed(time.Now().UnixNano())
-
-	riskProb := rand.Float64()
-	
-	// 4 risk bands
-	riskLevel := "Low"
-	if riskProb >= 0.85 {
-		riskLevel = "Critical"
-	} else if riskProb >= 0.65 {
-		riskLevel = "High"
-	} else if riskProb >= 0.35 {
-		riskLevel = "Medium"
-	}
-
-	return &ChatbotTenant{
-		ID:                  strings.ToUpper(id),
-		RiskProbability:     riskProb,
-		RiskLevel:           riskLevel,
-		PreviousLateCount:   rand.Intn(5),
-		AvgDelayDays:        rand.Float64() * 10,
-		LastPaymentDate:     time.Now().AddDate(0, 0, -rand.Intn(30)).Format("2006-01-02"),
-		CurrentRentAmount:   500 + rand.Float64()*2000,
-		RentToIncomeRatio:   0.2 + rand.Float64()*0.5,
-		TenancyMonths:       rand.Intn(60),
-		EmploymentStatus:    []string{"Full-time", "Part-time", "Self-employed", "Unemployed"}[rand.Intn(4)],
-		CreditScore:         300 + rand.Intn(550),
-		ComplaintCount:      rand.Intn(3),
-		PropertyDamageCount: rand.Intn(2),
-		PartialPaymentRatio: rand.Float64() * 0.3,
-		PaymentTrend:        (rand.Float64() - 0.5) * 4,
-		Notes:               "Synthetic tenant data",
-		CreatedAt:           time.Now().Format(time.RFC3339),
-		UpdatedAt:           time.Now().Format(time.RFC3339),
-	}
-}
-
-// Get all tenants
-func (rg *ResponseGenerator) GetAllTenants() []*ChatbotTenant {
-	rg.mu.RLock()
-	defer rg.mu.RUnlock()
-
-	tenants := make([]*ChatbotTenant, 0, len(rg.tenants))
-	for _, tenant := range rg.tenants {
-		tenants = append(tenants, tenant)
-	}
-
-	sort.Slice(tenants, func(i, j int) bool {
-		return tenants[i].RiskProbability > tenants[j].RiskProbability
-	})
-
-	return tenants
-}
-
 // FormatPhoneNumberForDisplay adds leading zero to 10-digit phone numbers
 func FormatPhoneNumberForDisplay(phoneNumber string) string {
 	if len(phoneNumber) == 10 && strings.HasPrefix(phoneNumber, "1") {
@@ -814,12 +710,6 @@ func (rg *ResponseGenerator) ExplainRiskForTenant(tenant *ChatbotTenant) string
 	return riskText
 }
 
-// Explain risk response (backward compatibility)
-func (rg *ResponseGenerator) ExplainRisk(tenantID string) string {
-	tenant, _ := rg.GetTenant(tenantID)
-	return rg.ExplainRiskForTenant(tenant)
-}
-
 // Recommend action response (using tenant object)
 func (rg *ResponseGenerator) RecommendActionForTenant(tenant *ChatbotTenant) string {
 
@@ -871,15 +761,9 @@ func (rg *ResponseGenerator) RecommendActionForTenant(tenant *ChatbotTenant) str
 	return actionText
 }
 
-// Recommend action response (backward compatibility)
-func (rg *ResponseGenerator) RecommendAction(tenantID string) string {
-	tenant, _ := rg.GetTenant(tenantID)
-	return rg.RecommendActionForTenant(tenant)
-}
-
-// Monthly summary
-func (rg *ResponseGenerator) MonthlySummary() string {
-	allTenants := rg.GetAllTenants()
+// MonthlySummary summarizes the risk of tenants, as returned by
+// managedTenants
+func (rg *ResponseGenerator) MonthlySummary(allTenants []*ChatbotTenant) string {
 
 	// Count all 4 risk bands
 	criticalRisk := 0
@@ -934,9 +818,9 @@ func (rg *ResponseGenerator) MonthlySummary() string {
 	return summary
 }
 
-// List high-risk tenants
-func (rg *ResponseGenerator) ListHighRisk() string {
-	allTenants := rg.GetAllTenants()
+// ListHighRisk lists the high and critical risk tenants among tenants, as
+// returned by managedTenants
+func (rg *ResponseGenerator) ListHighRisk(allTenants []*ChatbotTenant) string {
 
 	criticalTenants := []string{}
 	highRiskTenants := []string{}
@@ -967,41 +851,36 @@ func (rg *ResponseGenerator) ListHighRisk() string {
 	return result
 }
 
-// Compare tenants
-func (rg *ResponseGenerator) CompareTenants(tenantIDs []string) string {
-	log.Printf("CompareTenants called with: %v", tenantIDs)
-	
+// CompareTenants compares managerID's tenants with the given phone numbers
+func (rg *ResponseGenerator) CompareTenants(managerID int64, tenantIDs []string) (string, error) {
 	if len(tenantIDs) < 2 {
-		log.Printf("CompareTenants: Not enough tenant IDs provided: %d", len(tenantIDs))
-		return "Please specify at least two tenants to compare."
+		return "Please specify at least two tenants to compare.", nil
 	}
 
 	tenants := []*ChatbotTenant{}
-	errors := []string{}
+	missing := []string{}
 	
 	for _, id := range tenantIDs {
-		log.Printf("CompareTenants: Looking up tenant with ID/phone: %s", id)
-		if tenant, err := rg.GetTenantByPhone(id); err == nil {
-			log.Printf("CompareTenants: Found tenant by phone: %s", id)
-			tenants = append(tenants, tenant)
-		} else if tenant, exists := rg.GetTenant(id); exists {
-			log.Printf("CompareTenants: Found tenant by ID: %s", id)
-			tenants = append(tenants, tenant)
-		} else {
-			log.Printf("CompareTenants: Could not find tenant: %s, error: %v", id, err)
-			errors = append(errors, id)
+		tenant, err := rg.GetTenantByPhone(managerID, id)
+		if errors.Is(err, errChatTenantNotFound) {
+			missing = append(missing, id)
+			continue
+		}
+		if err != nil {
+			return "", err
 		}
+		tenants = append(tenants, tenant)
 	}
 
 	if len(tenants) < 2 {
-		if len(errors) > 0 {
-			formattedErrors := make([]string, len(errors))
-			for i, err := range errors {
-				formattedErrors[i] = FormatPhoneNumberForDisplay(err)
+		if len(missing) > 0 {
+			formattedMissing := make([]string, len(missing))
+			for i, id := range missing {
+				formattedMissing[i] = FormatPhoneNumberForDisplay(id)
 			}
-			return fmt.Sprintf("Could not find enough tenants to compare. Could not find tenants with phone numbers: %s. Please check the phone numbers and try again.", strings.Join(formattedErrors, ", "))
+			return fmt.Sprintf("Could not find enough tenants to compare. Could not find tenants on your properties with phone numbers: %s. Please check the phone numbers and try again.", strings.Join(formattedMissing, ", ")), nil
 		}
-		return "Could not find enough tenants to compare. Please provide valid phone numbers."
+		return "Could not find enough tenants to compare. Please provide valid phone numbers.", nil
 	}
 
 	comparison := "**Tenant Comparison**\n\n"
@@ -1033,8 +912,7 @@ func (rg *ResponseGenerator) CompareTenants(tenantIDs []string) string {
 		}
 	}
 
-	log.Printf("CompareTenants: Returning comparison with %d tenants", len(tenants))
-	return comparison
+	return comparison, nil
 }
 
 // Unknown intent response
@@ -1065,8 +943,9 @@ func (rg *ResponseGenerator) UnknownIntent() string {
 Please rephrase your question using one of these topics.`
 }
 
-// Process a chat message
-func (rg *ResponseGenerator) ProcessMessage(message string, tenantID string) *ChatResponse {
+// ProcessMessage answers a chat message from managerID, about the tenants
+// on the properties they manage
+func (rg *ResponseGenerator) ProcessMessage(managerID int64, message string, tenantID string) (*ChatResponse, error) {
 	startTime := time.Now()
 
 	detector := NewIntentDetector()
@@ -1090,15 +969,13 @@ func (rg *ResponseGenerator) ProcessMessage(message string, tenantID string) *Ch
 	switch intent {
 	case "EXPLAIN_RISK":
 		if tenantID != "" {
-			var tenant *ChatbotTenant
-			var err error
-			if tenant, err = rg.GetTenantByPhone(tenantID); err != nil {
-				var exists bool
-				tenant, exists = rg.GetTenant(tenantID)
-				if !exists {
-					responseText = fmt.Sprintf("Could not find tenant with phone number %s. Please check the phone number and try again.", tenantID)
-					break
-				}
+			tenant, err := rg.GetTenantByPhone(managerID, tenantID)
+			if errors.Is(err, errChatTenantNotFound) {
+				responseText = fmt.Sprintf("Could not find a tenant with phone number %s on your properties. Please check the phone number and try again.", tenantID)
+				break
+			}
+			if err != nil {
+				return nil, err
 			}
 			responseText = rg.ExplainRiskForTenant(tenant)
 			suggestedFollowups = []string{
@@ -1113,15 +990,13 @@ func (rg *ResponseGenerator) ProcessMessage(message string, tenantID string) *Ch
 
 	case "RECOMMEND_ACTION":
 		if tenantID != "" {
-			var tenant *ChatbotTenant
-			var err error
-			if tenant, err = rg.GetTenantByPhone(tenantID); err != nil {
-				var exists bool
-				tenant, exists = rg.GetTenant(tenantID)
-				if !exists {
-					responseText = fmt.Sprintf("Could not find tenant with phone number %s. Please check the phone number and try again.", tenantID)
-					break
-				}
+			tenant, err := rg.GetTenantByPhone(managerID, tenantID)
+			if errors.Is(err, errChatTenantNotFound) {
+				responseText = fmt.Sprintf("Could not find a tenant with phone number %s on your properties. Please check the phone number and try again.", tenantID)
+				break
+			}
+			if err != nil {
+				return nil, err
 			}
 			responseText = rg.RecommendActionForTenant(tenant)
 			suggestedFollowups = []string{
@@ -1135,7 +1010,11 @@ func (rg *ResponseGenerator) ProcessMessage(message string, tenantID string) *Ch
 		}
 
 	case "LIST_HIGH_RISK":
-		responseText = rg.ListHighRisk()
+		tenants, err := rg.managedTenants(managerID)
+		if err != nil {
+			return nil, err
+		}
+		responseText = rg.ListHighRisk(tenants)
 		suggestedFollowups = []string{
 			"Show monthly summary",
 			"Compare the top 2 high-risk tenants",
@@ -1143,11 +1022,15 @@ func (rg *ResponseGenerator) ProcessMessage(message string, tenantID string) *Ch
 		}
 
 	case "MONTHLY_SUMMARY":
-		responseText = rg.MonthlySummary()
+		tenants, err := rg.managedTenants(managerID)
+		if err != nil {
+			return nil, err
+		}
+		responseText = rg.MonthlySummary(tenants)
 		suggestedFollowups = []string{
 			"List high risk tenants",
 			"List critical risk tenants",
-			"Compare T100 and T087",
+			"Compare the top 2 high-risk tenants",
 			"Show payment trends",
 		}
 
@@ -1159,9 +1042,10 @@ func (rg *ResponseGenerator) ProcessMessage(message string, tenantID string) *Ch
 		if len(ids) == 0 && tenantID != "" {
 			ids = append(ids, tenantID)
 		}
-		log.Printf("COMPARE_TENANTS: extractedPhones=%v, extractedIDs=%v, tenantID=%s, final ids=%v", 
-			extractedPhones, extractedIDs, tenantID, ids)
-		responseText = rg.CompareTenants(ids)
+		var err error
+		if responseText, err = rg.CompareTenants(managerID, ids); err != nil {
+			return nil, err
+		}
 
 	case "UNKNOWN":
 		responseText = rg.UnknownIntent()
@@ -1180,7 +1064,7 @@ func (rg *ResponseGenerator) ProcessMessage(message string, tenantID string) *Ch
 		ProcessingTimeMS:   processingTime,
 		Timestamp:          time.Now().Format(time.RFC3339),
 		Data:               data,
-	}
+	}, nil
 }
 
 // Global response generator instance
@@ -1197,38 +1081,41 @@ func getChatbotResponseGenerator() *ResponseGenerator {
 // ChatHandler handles POST requests to process chat messages
 func ChatHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 
-	if r.Method == "OPTIONS" {
-		w.WriteHeader(http.StatusOK)
+	if r.Method != "POST" {
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	if r.Method != "POST" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	userID := getUserIDFromContext(r)
+	if userID == 0 {
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
 	var req ChatRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Printf("Error decoding chat request: %v", err)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		logger.Warn(r.Context(), "Error decoding chat request", "error", err)
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
 	if req.Message == "" {
-		http.Error(w, "Message is required", http.StatusBadRequest)
+		apierror.Field(w, r, "message", "Message is required")
 		return
 	}
 
 	responseGen := getChatbotResponseGenerator()
-	response := responseGen.ProcessMessage(req.Message, req.TenantID)
+	response, err := responseGen.ProcessMessage(userID, req.Message, req.TenantID)
+	if err != nil {
+		logger.Error(r.Context(), "Error processing chat message", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error generating response")
+		return
+	}
 
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("Error encoding chat response: %v", err)
-		http.Error(w, "Error generating response", http.StatusInternalServerError)
+		logger.Error(r.Context(), "Error encoding chat response", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error generating response")
 		return
 	}
 }
@@ -1240,7 +1127,6 @@ func ChatHealthHandler(w http.ResponseWriter, r *http.Request) {
 		"status":      "healthy",
 		"timestamp":   time.Now().Format(time.RFC3339),
 		"version":     "1.0.0-enhanced",
-		"tenants":     len(getChatbotResponseGenerator().GetAllTenants()),
 		"risk_bands":  "4 (Low < 0.35, Medium 0.35-0.65, High 0.65-0.85, Critical ≥ 0.85)",
 		"features":    "Enhanced risk calculation with 6 factors matching Figure 2 (DR, D, SF, PP, TR, IC)",
 	}
@@ -0,0 +1,342 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"go-rent/apierror"
+	"go-rent/config"
+	"go-rent/logger"
+	"go-rent/metrics"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// A user receives pushes on every device they register, up to
+// maxDevicesPerUser; registering another forgets the one seen least
+// recently. Devices are also forgotten when FCM reports their token as
+// unregistered or invalid.
+
+// Device limits
+const (
+	maxDevicesPerUser     = 10
+	maxDeviceTokenLength  = 512
+	maxAppVersionLength   = 32
+	deviceTokenSuffixSize = 8
+)
+
+// Device platforms. platformUnknown is recorded for devices registered
+// through the older /user/fcm-token route, which does not say.
+const (
+	platformAndroid = "android"
+	platformIOS     = "ios"
+	platformWeb     = "web"
+	platformUnknown = "unknown"
+)
+
+// devicePlatforms lists the platforms a device can be registered with
+var devicePlatforms = []string{platformAndroid, platformIOS, platformWeb}
+
+// Device is a registered device as shown to its owner. The token itself is
+// never returned; TokenSuffix is enough to tell devices apart.
+type Device struct {
+	ID          int64     `json:"id"`
+	Platform    string    `json:"platform"`
+	AppVersion  *string   `json:"app_version,omitempty"`
+	TokenSuffix string    `json:"token_suffix"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type DeviceRequest struct {
+	Token      string `json:"token"`
+	Platform   string `json:"platform"`
+	AppVersion string `json:"app_version"`
+}
+
+type DeviceResponse struct {
+	Success bool     `json:"success"`
+	Message string   `json:"message"`
+	Device  *Device  `json:"device,omitempty"`
+	Devices []Device `json:"devices,omitempty"`
+}
+
+// deviceToken is a device's ID and FCM token
+type deviceToken struct {
+	ID    int64
+	Token string
+}
+
+// RegisterDeviceHandler registers the current user's device for push
+// notifications, or refreshes its platform, app version and last-seen time
+// if it is already registered. Apps should call it on every launch.
+func RegisterDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := getUserIDFromContext(r)
+	if userID == 0 {
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req DeviceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	req.Token = strings.TrimSpace(req.Token)
+	req.Platform = strings.ToLower(strings.TrimSpace(req.Platform))
+	req.AppVersion = strings.TrimSpace(req.AppVersion)
+
+	problems := make(map[string]string)
+	if req.Token == "" {
+		problems["token"] = "Token is required"
+	} else if len(req.Token) > maxDeviceTokenLength {
+		problems["token"] = fmt.Sprintf("Token must be at most %d characters", maxDeviceTokenLength)
+	}
+	if !contains(devicePlatforms, req.Platform) {
+		problems["platform"] = "platform must be one of " + strings.Join(devicePlatforms, ", ")
+	}
+	if len(req.AppVersion) > maxAppVersionLength {
+		problems["app_version"] = fmt.Sprintf("app_version must be at most %d characters", maxAppVersionLength)
+	}
+	if len(problems) > 0 {
+		apierror.WriteFields(w, r, "Invalid device", problems)
+		return
+	}
+
+	device, err := registerDevice(r.Context(), userID, req.Token, req.Platform, req.AppVersion)
+	if err != nil {
+		logger.Error(r.Context(), "Error registering device", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to register device")
+		return
+	}
+
+	logger.Info(r.Context(), "Device registered", "device_id", device.ID, "platform", device.Platform)
+	json.NewEncoder(w).Encode(DeviceResponse{
+		Success: true,
+		Message: "Device registered successfully",
+		Device:  device,
+	})
+}
+
+// GetDevicesHandler lists the current user's devices, most recently seen
+// first
+func GetDevicesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := getUserIDFromContext(r)
+	if userID == 0 {
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	db, err := config.GetDBConnection()
+	if err != nil {
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT `+deviceColumns+`
+		FROM device_token
+		WHERE user_id = ?
+		ORDER BY last_seen_at DESC, id DESC`, userID)
+	if err != nil {
+		logger.Error(r.Context(), "Error querying devices", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error fetching devices")
+		return
+	}
+	defer rows.Close()
+
+	devices := []Device{}
+	for rows.Next() {
+		device, err := scanDevice(rows)
+		if err != nil {
+			logger.Error(r.Context(), "Error scanning device", "error", err)
+			continue
+		}
+		devices = append(devices, *device)
+	}
+
+	json.NewEncoder(w).Encode(DeviceResponse{
+		Success: true,
+		Message: "Devices retrieved successfully",
+		Devices: devices,
+	})
+}
+
+// UnregisterDeviceHandler stops pushes to one of the current user's
+// devices, for example when they log out on it
+func UnregisterDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := getUserIDFromContext(r)
+	if userID == 0 {
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	deviceID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid device ID")
+		return
+	}
+
+	db, err := config.GetDBConnection()
+	if err != nil {
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
+		return
+	}
+
+	var token string
+	err = db.QueryRow(`SELECT token FROM device_token WHERE id = ? AND user_id = ?`, deviceID, userID).Scan(&token)
+	if err == sql.ErrNoRows {
+		apierror.Write(w, r, http.StatusNotFound, "Device not found")
+		return
+	}
+	if err != nil {
+		logger.Error(r.Context(), "Error getting device", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to unregister device")
+		return
+	}
+
+	if _, err := db.Exec(`DELETE FROM device_token WHERE id = ? AND user_id = ?`, deviceID, userID); err != nil {
+		logger.Error(r.Context(), "Error deleting device", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to unregister device")
+		return
+	}
+	userTopicsChanged(r.Context(), userID, token)
+
+	logger.Info(r.Context(), "Device unregistered", "device_id", deviceID)
+	json.NewEncoder(w).Encode(DeviceResponse{
+		Success: true,
+		Message: "Device unregistered successfully",
+	})
+}
+
+// registerDevice adds or refreshes a device for userID. A token registered
+// to another user moves to this one, as happens when someone else logs in
+// on the device.
+func registerDevice(ctx context.Context, userID int64, token, platform, appVersion string) (*Device, error) {
+	db, err := config.GetDBConnection()
+	if err != nil {
+		return nil, fmt.Errorf("database connection failed: %v", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var previousOwner int64
+	err = tx.QueryRow(`SELECT user_id FROM device_token WHERE token = ? FOR UPDATE`, token).Scan(&previousOwner)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to look up device: %v", err)
+	}
+
+	var version interface{}
+	if appVersion != "" {
+		version = appVersion
+	}
+	now := time.Now().In(config.App.Location).Format("2006-01-02 15:04:05")
+	_, err = tx.Exec(`
+		INSERT INTO device_token (user_id, token, platform, app_version, last_seen_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			user_id = VALUES(user_id), platform = VALUES(platform), app_version = VALUES(app_version),
+			last_seen_at = VALUES(last_seen_at), updated_at = VALUES(updated_at)`,
+		userID, token, platform, version, now, now, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save device: %v", err)
+	}
+
+	// Forget the devices seen least recently beyond the limit
+	devices, err := userDevices(tx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load devices: %v", err)
+	}
+	var evicted []string
+	for _, device := range devices[min(len(devices), maxDevicesPerUser):] {
+		if _, err := tx.Exec(`DELETE FROM device_token WHERE id = ?`, device.ID); err != nil {
+			return nil, fmt.Errorf("failed to remove old device: %v", err)
+		}
+		evicted = append(evicted, device.Token)
+	}
+
+	device, err := scanDevice(tx.QueryRow(`SELECT `+deviceColumns+` FROM device_token WHERE token = ?`, token))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load device: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit device: %v", err)
+	}
+
+	if previousOwner != 0 && previousOwner != userID {
+		logger.Info(ctx, "Device moved to another user", "device_id", device.ID, "previous_user_id", previousOwner)
+		userTopicsChanged(ctx, previousOwner, token)
+	}
+	userTopicsChanged(ctx, userID, evicted...)
+	return device, nil
+}
+
+// userDevices returns a user's devices, most recently seen first
+func userDevices(q interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}, userID int64) ([]deviceToken, error) {
+	rows, err := q.Query(`
+		SELECT id, token
+		FROM device_token
+		WHERE user_id = ?
+		ORDER BY last_seen_at DESC, id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var devices []deviceToken
+	for rows.Next() {
+		var device deviceToken
+		if err := rows.Scan(&device.ID, &device.Token); err != nil {
+			return nil, err
+		}
+		devices = append(devices, device)
+	}
+	return devices, rows.Err()
+}
+
+// pruneDevice forgets a device whose token FCM rejected for reason
+func pruneDevice(ctx context.Context, db *sql.DB, userID, deviceID int64, reason string) {
+	if _, err := db.Exec(`DELETE FROM device_token WHERE id = ?`, deviceID); err != nil {
+		logger.Error(ctx, "Failed to remove rejected device", "device_id", deviceID, "error", err)
+		return
+	}
+	metrics.DeviceTokensPruned.Inc(reason)
+	logger.Info(ctx, "Device removed after FCM rejected its token", "user_id", userID, "device_id", deviceID, "reason", reason)
+}
+
+// deviceColumns is the column list read by scanDevice
+const deviceColumns = `id, platform, app_version, token, last_seen_at, created_at`
+
+// scanDevice reads a row selected with deviceColumns
+func scanDevice(row interface{ Scan(...interface{}) error }) (*Device, error) {
+	var device Device
+	var appVersion sql.NullString
+	var token string
+	if err := row.Scan(&device.ID, &device.Platform, &appVersion, &token, &device.LastSeenAt, &device.CreatedAt); err != nil {
+		return nil, err
+	}
+	if appVersion.Valid {
+		device.AppVersion = &appVersion.String
+	}
+	device.TokenSuffix = token[max(0, len(token)-deviceTokenSuffixSize):]
+	return &device, nil
+}
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"go-rent/apierror"
+	"go-rent/config"
+	"go-rent/logger"
+	"go-rent/metrics"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stream limits
+const (
+	maxReplayEvents   = 1000             // events replayed on resume before the client is told to reload instead
+	streamWriteGrace  = 10 * time.Second // time allowed for one write to the client
+	streamRetryMillis = 3000             // reconnect delay suggested to clients
+)
+
+// EventStreamReset tells a resuming client that events it missed are no
+// longer available, so it should reload its notifications and
+// conversations before relying on the stream again
+const EventStreamReset = "stream.reset"
+
+// EventStreamHandler streams the current user's events as Server-Sent
+// Events until they disconnect. Each event has the user_event ID as its id,
+// the event type as its name and JSON data. A client resumes by sending the
+// last ID it received in the Last-Event-ID header, which EventSource does
+// by itself, or in the last_event_id query parameter. A comment is sent
+// every heartbeat interval to keep proxies from closing an idle stream.
+func EventStreamHandler(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromContext(r)
+	if userID == 0 {
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var lastID int64
+	if raw := r.Header.Get("Last-Event-ID"); raw != "" || r.URL.Query().Get("last_event_id") != "" {
+		if raw == "" {
+			raw = r.URL.Query().Get("last_event_id")
+		}
+		id, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+		if err != nil || id < 0 {
+			apierror.Field(w, r, "last_event_id", "last_event_id must be an event ID")
+			return
+		}
+		lastID = id
+	}
+
+	db, err := config.GetDBConnection()
+	if err != nil {
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
+		return
+	}
+
+	// Subscribe before replaying so that nothing recorded in between is lost
+	sub, err := events.subscribe(userID)
+	switch {
+	case errors.Is(err, errTooManyStreams):
+		apierror.Write(w, r, http.StatusTooManyRequests,
+			fmt.Sprintf("At most %d event streams can be open at once", config.App.Events.MaxStreams))
+		return
+	case err != nil:
+		apierror.Write(w, r, http.StatusServiceUnavailable, "The server is shutting down; reconnect to continue")
+		return
+	}
+	defer events.unsubscribe(sub, metrics.EventStreamClient)
+
+	// The server's read and write timeouts are meant for ordinary requests;
+	// the stream extends the write deadline before each write instead
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(time.Time{}); err != nil {
+		logger.Warn(r.Context(), "Could not clear the read deadline of an event stream", "error", err)
+	}
+	stream := &eventWriter{w: w, rc: rc}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := stream.write(fmt.Sprintf("retry: %d\n\n", streamRetryMillis)); err != nil {
+		return
+	}
+
+	replayed := make(map[int64]bool)
+	if lastID > 0 {
+		replayed, err = replayEvents(r, db, stream, userID, lastID)
+		if err != nil {
+			logger.Warn(r.Context(), "Event stream replay failed", "last_event_id", lastID, "error", err)
+			return
+		}
+	}
+	logger.Debug(r.Context(), "Event stream opened", "last_event_id", lastID, "replayed", len(replayed))
+
+	heartbeat := time.NewTicker(config.App.Events.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event, ok := <-sub.events:
+			if !ok {
+				return
+			}
+			if replayed[event.ID] {
+				continue
+			}
+			if err := stream.event(event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if err := stream.write(": ping\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// replayEvents sends the user's events after lastID and returns their IDs.
+// When they are no longer all available it sends EventStreamReset instead.
+func replayEvents(r *http.Request, db *sql.DB, stream *eventWriter, userID, lastID int64) (map[int64]bool, error) {
+	replayed := make(map[int64]bool)
+
+	// Rows before the oldest one left have been pruned, and the client may
+	// have missed some of them
+	var oldest, newest sql.NullInt64
+	err := db.QueryRowContext(r.Context(), `SELECT MIN(id), MAX(id) FROM user_event`).Scan(&oldest, &newest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read event range: %v", err)
+	}
+	if oldest.Valid && lastID+1 < oldest.Int64 {
+		return replayed, stream.reset(newest.Int64)
+	}
+
+	rows, err := queryEvents(r.Context(), db, `
+		SELECT id, user_id, type, data
+		FROM user_event
+		WHERE user_id = ? AND id > ?
+		ORDER BY id
+		LIMIT ?`, userID, lastID, maxReplayEvents+1)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) > maxReplayEvents {
+		return replayed, stream.reset(newest.Int64)
+	}
+	for _, event := range rows {
+		if err := stream.event(event); err != nil {
+			return nil, err
+		}
+		replayed[event.ID] = true
+	}
+	return replayed, nil
+}
+
+// eventWriter writes Server-Sent Events and flushes each one
+type eventWriter struct {
+	w      http.ResponseWriter
+	rc     *http.ResponseController
+	lastID int64
+}
+
+// event writes one event. An event older than one already sent, which
+// committed late, is sent without an id so that the client's Last-Event-ID
+// does not move backwards.
+func (s *eventWriter) event(event streamEvent) error {
+	var b strings.Builder
+	if event.ID > s.lastID {
+		fmt.Fprintf(&b, "id: %d\n", event.ID)
+		s.lastID = event.ID
+	}
+	fmt.Fprintf(&b, "event: %s\ndata: %s\n\n", event.Type, event.Data)
+	if err := s.write(b.String()); err != nil {
+		return err
+	}
+	metrics.EventsStreamed.Inc(event.Type)
+	return nil
+}
+
+// reset writes EventStreamReset with the newest event ID as its id, so that
+// the client resumes from there after reloading
+func (s *eventWriter) reset(newestID int64) error {
+	var b strings.Builder
+	if newestID > s.lastID {
+		fmt.Fprintf(&b, "id: %d\n", newestID)
+		s.lastID = newestID
+	}
+	fmt.Fprintf(&b, "event: %s\ndata: {}\n\n", EventStreamReset)
+	if err := s.write(b.String()); err != nil {
+		return err
+	}
+	metrics.EventsStreamed.Inc(EventStreamReset)
+	return nil
+}
+
+// write sends text to the client straight away
+func (s *eventWriter) write(text string) error {
+	if err := s.rc.SetWriteDeadline(time.Now().Add(streamWriteGrace)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	if _, err := s.w.Write([]byte(text)); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
@@ -0,0 +1,323 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-rent/config"
+	"go-rent/metrics"
+	"sync"
+	"time"
+)
+
+// Changes a user should see straight away (new notifications, status
+// changes, replies, thread messages and read receipts) are recorded in the user_event table in the same
+// transaction as the change, and streamed to the user's open /events
+// connections. The table is the only channel between server instances: each
+// one polls it with DispatchEvents, run in a loop by the scheduler, and
+// fans the new rows out to the streams connected to it. Clients resume
+// after a reconnect from the last event ID they saw.
+
+// Event types, sent as the SSE event name
+const (
+	EventNotificationCreated = "notification.created"
+	EventNotificationUpdated = "notification.updated"
+	EventNotificationDeleted = "notification.deleted"
+	EventNotificationsRead   = "notifications.read"
+	EventConversationMessage = "conversation.message"
+	EventMessageCreated      = "message.created"
+	EventThreadRead          = "thread.read"
+)
+
+// EventBatchSize is the most rows one DispatchEvents call reads
+const EventBatchSize = 500
+
+// Event fan-out limits
+const (
+	eventBufferSize   = 64               // events a stream may fall behind by before it is closed
+	eventSettleWindow = 30 * time.Second // how long a skipped ID is waited for
+	maxEventGaps      = 1000             // skipped IDs waited for at once
+)
+
+// NotificationEvent is the data of the notification and conversation
+// events. Fields that do not apply are left out; a deleted notification
+// carries only its ID.
+type NotificationEvent struct {
+	NotificationID int64   `json:"notification_id"`
+	Kind           string  `json:"kind,omitempty"`
+	Status         string  `json:"status,omitempty"`
+	Message        string  `json:"message,omitempty"`
+	Comment        *string `json:"comment,omitempty"`
+	SenderID       int64   `json:"sender_id,omitempty"`
+	ReceiverID     int64   `json:"receiver_id,omitempty"`
+	PropertyID     int64   `json:"property_id,omitempty"`
+	FloorID        int64   `json:"floor_id,omitempty"`
+	InReplyTo      int64   `json:"in_reply_to,omitempty"`
+	CreatedAt      string  `json:"created_at,omitempty"`
+}
+
+// recordEvent stores an event for each of userIDs within q, normally the
+// transaction making the change, so that the event exists exactly when the
+// change does. Repeated and zero user IDs are skipped. Call
+// WakeEventDispatcher after committing.
+func recordEvent(q interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}, eventType string, data interface{}, userIDs ...int64) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %v", eventType, err)
+	}
+	now := time.Now().In(config.App.Location).Format("2006-01-02 15:04:05")
+	seen := make(map[int64]bool, len(userIDs))
+	for _, userID := range userIDs {
+		if userID == 0 || seen[userID] {
+			continue
+		}
+		seen[userID] = true
+		_, err := q.Exec(`
+			INSERT INTO user_event (user_id, type, data, created_at)
+			VALUES (?, ?, ?, ?)`, userID, eventType, payload, now)
+		if err != nil {
+			return fmt.Errorf("failed to record %s event: %v", eventType, err)
+		}
+	}
+	return nil
+}
+
+// eventDispatcherWake nudges the dispatcher when an event is recorded, so
+// that local streams do not wait for the next poll
+var eventDispatcherWake = make(chan struct{}, 1)
+
+// WakeEventDispatcher asks the dispatcher to look for new events now
+func WakeEventDispatcher() {
+	select {
+	case eventDispatcherWake <- struct{}{}:
+	default:
+	}
+}
+
+// EventDispatcherWakeups receives a value after WakeEventDispatcher is
+// called
+func EventDispatcherWakeups() <-chan struct{} {
+	return eventDispatcherWake
+}
+
+// streamEvent is a user_event row on its way to a stream
+type streamEvent struct {
+	ID     int64
+	UserID int64
+	Type   string
+	Data   json.RawMessage
+}
+
+// eventSubscriber is one open stream. Events is closed when the stream
+// falls eventBufferSize events behind or the server shuts down.
+type eventSubscriber struct {
+	userID int64
+	events chan streamEvent
+	reason string
+}
+
+// errTooManyStreams is returned by subscribe when the user already has the
+// most streams allowed
+var errTooManyStreams = errors.New("too many open event streams")
+
+// errStreamsClosed is returned by subscribe once the server is shutting down
+var errStreamsClosed = errors.New("event streams are closed")
+
+// eventHub tracks the open streams and how far the event table has been
+// read. AUTO_INCREMENT IDs are handed out before commit, so a transaction
+// can commit after one holding a later ID: IDs skipped over are kept in
+// gaps and looked for again until eventSettleWindow has passed.
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[int64]map[*eventSubscriber]bool
+	open        int
+	closed      bool
+
+	started bool
+	lastID  int64
+	gaps    map[int64]time.Time
+}
+
+var events = &eventHub{
+	subscribers: make(map[int64]map[*eventSubscriber]bool),
+	gaps:        make(map[int64]time.Time),
+}
+
+// subscribe opens a stream for userID
+func (h *eventHub) subscribe(userID int64) (*eventSubscriber, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, errStreamsClosed
+	}
+	if len(h.subscribers[userID]) >= config.App.Events.MaxStreams {
+		return nil, errTooManyStreams
+	}
+	sub := &eventSubscriber{userID: userID, events: make(chan streamEvent, eventBufferSize)}
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = make(map[*eventSubscriber]bool)
+	}
+	h.subscribers[userID][sub] = true
+	h.open++
+	metrics.EventStreamsOpen.Set(float64(h.open))
+	return sub, nil
+}
+
+// unsubscribe closes a stream that ended for reason, unless the hub has
+// already closed it with a reason of its own
+func (h *eventHub) unsubscribe(sub *eventSubscriber, reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subscribers[sub.userID][sub] {
+		h.remove(sub, reason)
+	}
+	metrics.EventStreamsClosed.Inc(sub.reason)
+}
+
+// remove drops sub and closes its channel; h.mu must be held
+func (h *eventHub) remove(sub *eventSubscriber, reason string) {
+	delete(h.subscribers[sub.userID], sub)
+	if len(h.subscribers[sub.userID]) == 0 {
+		delete(h.subscribers, sub.userID)
+	}
+	sub.reason = reason
+	close(sub.events)
+	h.open--
+	metrics.EventStreamsOpen.Set(float64(h.open))
+}
+
+// publish hands an event to its user's streams, closing any that are full
+func (h *eventHub) publish(event streamEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subscribers[event.UserID] {
+		select {
+		case sub.events <- event:
+		default:
+			h.remove(sub, metrics.EventStreamSlow)
+		}
+	}
+}
+
+// CloseEventStreams ends every open stream and refuses new ones, so that
+// shutdown does not wait for clients to disconnect. Clients reconnect to
+// another instance and resume from their last event.
+func CloseEventStreams() {
+	events.mu.Lock()
+	defer events.mu.Unlock()
+	events.closed = true
+	for _, subs := range events.subscribers {
+		for sub := range subs {
+			events.remove(sub, metrics.EventStreamShutdown)
+		}
+	}
+}
+
+// DispatchEvents reads the events recorded since the last call, including
+// late commits into earlier gaps, and hands them to the open streams. It
+// returns how many new rows it read; a full batch means there may be more.
+func DispatchEvents(ctx context.Context) (int, error) {
+	db, err := config.GetDBConnection()
+	if err != nil {
+		return 0, fmt.Errorf("database connection failed: %v", err)
+	}
+
+	events.mu.Lock()
+	started, lastID := events.started, events.lastID
+	gapIDs := make([]interface{}, 0, len(events.gaps))
+	now := time.Now()
+	for id, since := range events.gaps {
+		if now.Sub(since) > eventSettleWindow {
+			delete(events.gaps, id)
+			continue
+		}
+		gapIDs = append(gapIDs, id)
+	}
+	events.mu.Unlock()
+
+	// Streams opened before the first read only get events from then on
+	if !started {
+		err := db.QueryRowContext(ctx, `SELECT COALESCE(MAX(id), 0) FROM user_event`).Scan(&lastID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read event position: %v", err)
+		}
+		events.mu.Lock()
+		events.started, events.lastID = true, lastID
+		events.mu.Unlock()
+		return 0, nil
+	}
+
+	if len(gapIDs) > 0 {
+		late, err := queryEvents(ctx, db, `
+			SELECT id, user_id, type, data
+			FROM user_event
+			WHERE id IN (`+placeholders(len(gapIDs))+`)`, gapIDs...)
+		if err != nil {
+			return 0, err
+		}
+		for _, event := range late {
+			events.mu.Lock()
+			delete(events.gaps, event.ID)
+			events.mu.Unlock()
+			events.publish(event)
+		}
+	}
+
+	fresh, err := queryEvents(ctx, db, `
+		SELECT id, user_id, type, data
+		FROM user_event
+		WHERE id > ?
+		ORDER BY id
+		LIMIT ?`, lastID, EventBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	for _, event := range fresh {
+		events.mu.Lock()
+		for id := events.lastID + 1; id < event.ID && len(events.gaps) < maxEventGaps; id++ {
+			events.gaps[id] = now
+		}
+		events.lastID = event.ID
+		events.mu.Unlock()
+		events.publish(event)
+	}
+	return len(fresh), nil
+}
+
+// queryEvents reads user_event rows selected as id, user_id, type, data
+func queryEvents(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]streamEvent, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read events: %v", err)
+	}
+	defer rows.Close()
+
+	var result []streamEvent
+	for rows.Next() {
+		var event streamEvent
+		if err := rows.Scan(&event.ID, &event.UserID, &event.Type, &event.Data); err != nil {
+			return nil, fmt.Errorf("failed to read events: %v", err)
+		}
+		result = append(result, event)
+	}
+	return result, rows.Err()
+}
+
+// PruneEvents deletes events older than the retention period and returns
+// how many it removed
+func PruneEvents(ctx context.Context) (int64, error) {
+	db, err := config.GetDBConnection()
+	if err != nil {
+		return 0, fmt.Errorf("database connection failed: %v", err)
+	}
+	cutoff := time.Now().In(config.App.Location).Add(-config.App.Events.Retention)
+	result, err := db.ExecContext(ctx, `DELETE FROM user_event WHERE created_at < ?`, cutoff.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune events: %v", err)
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDB is an in-memory stand-in for the few queries that queue and
+// deliver notifications, served through database/sql so that the handlers
+// run their real code paths. Queries it does not know fail the test.
+type fakeDB struct {
+	t *testing.T
+
+	mu     sync.Mutex
+	users  map[int64]*fakeUser
+	floors map[int64]*fakeFloor
+	outbox []fakeOutboxRow
+}
+
+// fakeUser is a user row with their registered devices, newest first
+type fakeUser struct {
+	name, phone, email, webhookURL string
+	channels                       string
+	preferences                    string
+	devices                        []deviceToken
+	uploadBytes                    int64
+}
+
+// fakeFloor is a floor row with the managers of its property
+type fakeFloor struct {
+	tenant   int64
+	managers []int64
+}
+
+// fakeOutboxRow is an INSERT INTO push_outbox
+type fakeOutboxRow struct {
+	receiver int64
+	channel  string
+	device   int64
+	title    string
+}
+
+var (
+	fakeDBsMu sync.Mutex
+	fakeDBs   = make(map[string]*fakeDB)
+)
+
+func init() {
+	sql.Register("handlers-fake", fakeDriver{})
+}
+
+// newFakeDB returns a database backed by a fakeDB with the given users
+func newFakeDB(t *testing.T, users map[int64]*fakeUser) (*sql.DB, *fakeDB) {
+	f := &fakeDB{t: t, users: users}
+	fakeDBsMu.Lock()
+	fakeDBs[t.Name()] = f
+	fakeDBsMu.Unlock()
+	db, err := sql.Open("handlers-fake", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		fakeDBsMu.Lock()
+		delete(fakeDBs, t.Name())
+		fakeDBsMu.Unlock()
+	})
+	return db, f
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeDBsMu.Lock()
+	defer fakeDBsMu.Unlock()
+	f, ok := fakeDBs[name]
+	if !ok {
+		return nil, fmt.Errorf("no fake database %q", name)
+	}
+	return &fakeConn{db: f}, nil
+}
+
+type fakeConn struct{ db *fakeDB }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{db: c.db, query: strings.Join(strings.Fields(query), " ")}, nil
+}
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	f := s.db
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if strings.HasPrefix(s.query, "INSERT INTO push_outbox") {
+		// notification_id, receiver, channel, topic, device_id, title, ...
+		row := fakeOutboxRow{channel: args[2].(string), title: args[5].(string)}
+		if receiver, ok := args[1].(int64); ok {
+			row.receiver = receiver
+		}
+		if device, ok := args[4].(int64); ok {
+			row.device = device
+		}
+		f.outbox = append(f.outbox, row)
+		return driver.RowsAffected(1), nil
+	}
+	f.t.Errorf("fake database: unexpected statement %q", s.query)
+	return nil, fmt.Errorf("unexpected statement")
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	f := s.db
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	user := func(arg driver.Value) *fakeUser {
+		id, _ := arg.(int64)
+		return f.users[id]
+	}
+	nullable := func(s string) driver.Value {
+		if s == "" {
+			return nil
+		}
+		return s
+	}
+
+	switch {
+	case strings.Contains(s.query, "u.webhook_url, u.notification_channels FROM user u"):
+		u := user(args[0])
+		if u == nil {
+			return &fakeRows{columns: 6}, nil
+		}
+		var token driver.Value
+		if len(u.devices) > 0 {
+			token = u.devices[0].Token
+		}
+		return &fakeRows{columns: 6, rows: [][]driver.Value{{
+			u.name, u.phone, nullable(u.email), token, nullable(u.webhookURL), u.channels,
+		}}}, nil
+
+	case strings.HasPrefix(s.query, "SELECT notification_preferences FROM user WHERE id = ?"):
+		u := user(args[0])
+		if u == nil {
+			return &fakeRows{columns: 1}, nil
+		}
+		var prefs driver.Value
+		if u.preferences != "" {
+			prefs = []byte(u.preferences)
+		}
+		return &fakeRows{columns: 1, rows: [][]driver.Value{{prefs}}}, nil
+
+	case strings.HasPrefix(s.query, "SELECT id, token FROM device_token WHERE user_id = ?"):
+		rows := &fakeRows{columns: 2}
+		if u := user(args[0]); u != nil {
+			for _, d := range u.devices {
+				rows.rows = append(rows.rows, []driver.Value{d.ID, d.Token})
+			}
+		}
+		return rows, nil
+
+	case strings.HasPrefix(s.query, "SELECT f.tenant, f.tenant <=> ? OR EXISTS (SELECT 1 FROM takes_care_of c"):
+		floor := f.floors[args[2].(int64)]
+		if floor == nil {
+			return &fakeRows{columns: 2}, nil
+		}
+		userID := args[0].(int64)
+		allowed := floor.tenant != 0 && floor.tenant == userID
+		for _, manager := range floor.managers {
+			allowed = allowed || manager == userID
+		}
+		var tenant driver.Value
+		if floor.tenant != 0 {
+			tenant = floor.tenant
+		}
+		return &fakeRows{columns: 2, rows: [][]driver.Value{{tenant, allowed}}}, nil
+
+	case strings.HasPrefix(s.query, "SELECT COALESCE(SUM(size_bytes), 0) FROM upload WHERE user_id = ?"):
+		var used int64
+		if u := user(args[0]); u != nil {
+			used = u.uploadBytes
+		}
+		return &fakeRows{columns: 1, rows: [][]driver.Value{{used}}}, nil
+
+	case strings.HasPrefix(s.query, "SELECT token FROM device_token WHERE id = ? AND user_id = ?"):
+		rows := &fakeRows{columns: 1}
+		if u := user(args[1]); u != nil {
+			for _, d := range u.devices {
+				if d.ID == args[0].(int64) {
+					rows.rows = append(rows.rows, []driver.Value{d.Token})
+				}
+			}
+		}
+		return rows, nil
+	}
+	f.t.Errorf("fake database: unexpected query %q", s.query)
+	return nil, fmt.Errorf("unexpected query")
+}
+
+type fakeRows struct {
+	columns int
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return make([]string, r.columns) }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// outboxRows returns the pushes queued so far
+func (f *fakeDB) outboxRows() []fakeOutboxRow {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]fakeOutboxRow(nil), f.outbox...)
+}
+
+// begin starts a transaction on db, failing the test if it cannot
+func begin(t *testing.T, db *sql.DB) *sql.Tx {
+	t.Helper()
+	tx, err := db.BeginTx(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
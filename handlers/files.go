@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-rent/apierror"
+	"go-rent/config"
+	"go-rent/logger"
+	"go-rent/storage"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Files keeps uploaded files. ConfigureStorage sets it from the
+// configuration; tests can swap in a storage.Local in a temporary directory.
+var Files storage.Store
+
+// ConfigureStorage sets up the local or S3 store named by STORAGE_BACKEND
+func ConfigureStorage(cfg *config.Config) error {
+	switch cfg.Storage.Backend {
+	case storage.BackendS3:
+		s3 := cfg.Storage.S3
+		store, err := storage.NewS3(s3.Endpoint, s3.PublicEndpoint, s3.Region, s3.Bucket, s3.AccessKeyID, s3.SecretAccessKey, s3.PathStyle)
+		if err != nil {
+			return err
+		}
+		Files = store
+	default:
+		store, err := storage.NewLocal(cfg.Storage.LocalDir, cfg.Storage.PublicURL, cfg.Storage.SigningSecret)
+		if err != nil {
+			return err
+		}
+		Files = store
+	}
+	return nil
+}
+
+// CheckStorage reports whether the file store can be reached, for the
+// readiness probe
+func CheckStorage(ctx context.Context) error {
+	if Files == nil {
+		return fmt.Errorf("storage not configured")
+	}
+	return Files.Check(ctx)
+}
+
+// fileURL signs a URL for key that expires at expires, or returns "" for
+// an empty key. A key that cannot be signed is logged and left out rather
+// than failing the whole response.
+func fileURL(key string, expires time.Time) string {
+	if key == "" || Files == nil {
+		return ""
+	}
+	u, err := Files.URL(key, expires)
+	if err != nil {
+		logger.Error(context.Background(), "Error signing file URL", "key", key, "error", err)
+		return ""
+	}
+	return u
+}
+
+// fileURLExpiry is when URLs signed now stop working
+func fileURLExpiry() time.Time {
+	return time.Now().Add(config.App.Storage.URLTTL).Truncate(time.Second)
+}
+
+// ServeFileHandler serves a file from the local store to anyone holding a
+// URL signed by storage.Local.URL. The signature takes the place of
+// authentication, so the route is public; with the S3 backend clients
+// download from the bucket instead and this route finds nothing.
+func ServeFileHandler(w http.ResponseWriter, r *http.Request) {
+	local, ok := Files.(*storage.Local)
+	key := mux.Vars(r)["key"]
+	if !ok || !storage.ValidKey(key) {
+		apierror.Write(w, r, http.StatusNotFound, "File not found")
+		return
+	}
+
+	query := r.URL.Query()
+	switch err := local.Verify(key, query.Get("expires"), query.Get("signature"), time.Now()); {
+	case errors.Is(err, storage.ErrURLExpired):
+		apierror.Write(w, r, http.StatusForbidden, "This link has expired; request the file again for a new one")
+		return
+	case err != nil:
+		apierror.Write(w, r, http.StatusForbidden, "Invalid file link")
+		return
+	}
+
+	body, object, err := local.Open(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		apierror.Write(w, r, http.StatusNotFound, "File not found")
+		return
+	}
+	if err != nil {
+		logger.Error(r.Context(), "Error opening file", "key", key, "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error reading file")
+		return
+	}
+	defer body.Close()
+
+	// Uploads are sniffed before they are stored, but the browser must not
+	// guess again, and only images are shown inline
+	w.Header().Set("Content-Type", object.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	if !strings.HasPrefix(object.ContentType, "image/") {
+		w.Header().Set("Content-Disposition", "attachment")
+	}
+	if seeker, ok := body.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", object.ModTime, seeker)
+		return
+	}
+	io.Copy(w, body)
+}
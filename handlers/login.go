@@ -1,14 +1,13 @@
 package handlers
 
 import (
-	"bytes"
 	"database/sql"
 	"encoding/json"
-	"fmt"
+	"go-rent/apierror"
 	"go-rent/config"
+	"go-rent/logger"
 	"go-rent/utils"
 	"golang.org/x/crypto/bcrypt"
-	"io"
 	"net/http"
 	"regexp"
 	"strings"
@@ -18,48 +17,43 @@ import (
 type LoginRequest struct {
 	PhoneNumber string `json:"phone_number"`
 	Password    string `json:"password"`
+	// ReturnToken asks for the session token in the response body so native
+	// clients can send it as "Authorization: Bearer" instead of a cookie
+	ReturnToken bool `json:"return_token,omitempty"`
 }
 
 type LoginResponse struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
-	UserID  int64  `json:"user_id,omitempty"`
-	Name    string `json:"name,omitempty"`
+	Success   bool       `json:"success"`
+	Message   string     `json:"message"`
+	UserID    int64      `json:"user_id,omitempty"`
+	Name      string     `json:"name,omitempty"`
+	Token     string     `json:"token,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// TwoFactorRequired means the password was accepted and the login must be
+	// completed at /login/2fa with ChallengeToken and an authenticator code
+	TwoFactorRequired bool   `json:"two_factor_required,omitempty"`
+	ChallengeToken    string `json:"challenge_token,omitempty"`
+	// TwoFactorSetupRequired means a property the user manages requires 2FA
+	// and the user has not enrolled yet
+	TwoFactorSetupRequired bool `json:"two_factor_setup_required,omitempty"`
 }
 
 func LoginHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("\n=== New Login Request ===")
-	fmt.Printf("Method: %s\n", r.Method)
-	fmt.Printf("URL: %s\n", r.URL)
-
-	// Read and log request body
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		fmt.Printf("Error reading request body: %v\n", err)
-	}
-	fmt.Printf("Request Body: %s\n", string(body))
-
-	// Restore body for decoding
-	r.Body = io.NopCloser(bytes.NewBuffer(body))
-
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(LoginResponse{false, "Method not allowed", 0, ""})
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	var req LoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(LoginResponse{false, "Invalid request body", 0, ""})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
 	// Validate phone number format
 	phoneRegex := regexp.MustCompile(`^\+880 \d{4}-\d{6}$`)
 	if !phoneRegex.MatchString(req.PhoneNumber) {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(LoginResponse{false, "Invalid phone number format. Use format: +880 XXXX-XXXXXX", 0, ""})
+		apierror.Field(w, r, "phone_number", "Invalid phone number format. Use format: +880 XXXX-XXXXXX")
 		return
 	}
 
@@ -68,97 +62,128 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	phoneNumber = strings.ReplaceAll(phoneNumber, "-", "")
 
 	if req.Password == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(LoginResponse{false, "Password is required", 0, ""})
+		apierror.Field(w, r, "password", "Password is required")
 		return
 	}
 
 	db, err := config.GetDBConnection()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(LoginResponse{false, "Database connection error", 0, ""})
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
 		return
 	}
-	defer db.Close()
 
 	// Check if user exists and get their details
 	var (
-		userID   int64
-		name     string
-		password string
+		userID      int64
+		name        string
+		password    string
+		totpEnabled bool
 	)
-	err = db.QueryRow("SELECT id, name, password FROM user WHERE phone_number = ?", phoneNumber).Scan(&userID, &name, &password)
+	err = db.QueryRow("SELECT id, name, password, totp_enabled FROM user WHERE phone_number = ?", phoneNumber).Scan(&userID, &name, &password, &totpEnabled)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			w.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(w).Encode(LoginResponse{false, "Invalid phone number or password", 0, ""})
+			apierror.WriteCode(w, r, http.StatusUnauthorized, apierror.CodeInvalidCredentials, "Invalid phone number or password")
 			return
 		}
-		fmt.Printf("Database error: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(LoginResponse{false, "Database error", 0, ""})
+		logger.Error(r.Context(), "Database error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database error")
 		return
 	}
 
 	// Compare password
 	err = bcrypt.CompareHashAndPassword([]byte(password), []byte(req.Password))
 	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(LoginResponse{false, "Invalid phone number or password", 0, ""})
+		apierror.WriteCode(w, r, http.StatusUnauthorized, apierror.CodeInvalidCredentials, "Invalid phone number or password")
+		return
+	}
+
+	if totpEnabled {
+		// Password is correct but a second factor is needed before a session is issued
+		challenge, err := utils.GenerateTwoFactorChallenge(userID)
+		if err != nil {
+			logger.Error(r.Context(), "Error generating two-factor challenge", "error", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "Error generating authentication token")
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(LoginResponse{
+			Success:           false,
+			Message:           "Two-factor authentication code required",
+			TwoFactorRequired: true,
+			ChallengeToken:    challenge,
+		})
 		return
 	}
 
+	issueSession(w, r, db, userID, name, req.ReturnToken)
+}
+
+// issueSession sets the session and CSRF cookies for a fully authenticated
+// user and writes the login response
+func issueSession(w http.ResponseWriter, r *http.Request, db *sql.DB, userID int64, name string, returnToken bool) {
 	// Generate JWT token
 	token, err := utils.GenerateToken(userID)
 	if err != nil {
-		fmt.Printf("Error generating token: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(LoginResponse{false, "Error generating authentication token", 0, ""})
+		logger.Error(r.Context(), "Error generating token", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error generating authentication token")
 		return
 	}
 
 	// Generate CSRF token
 	csrfToken, err := utils.GenerateCSRFToken()
 	if err != nil {
-		fmt.Printf("Error generating CSRF token: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(LoginResponse{false, "Error generating CSRF token", 0, ""})
+		logger.Error(r.Context(), "Error generating CSRF token", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error generating CSRF token")
 		return
 	}
 
-	fmt.Printf("Generated CSRF token: %s\n", csrfToken) // Debug log
+	expiresAt := time.Now().Add(24 * time.Hour)
 
 	// Set session cookie with JWT token
 	http.SetCookie(w, &http.Cookie{
 		Name:     "sessiontoken",
 		Value:    token,
-		Expires:  time.Now().Add(24 * time.Hour),
+		Expires:  expiresAt,
 		Path:     "/",
+		Domain:   config.App.Cookie.Domain,
 		HttpOnly: true,
-		Secure:   false, // Set to true in production with HTTPS
-		SameSite: http.SameSiteStrictMode,
+		Secure:   config.App.Cookie.Secure,
+		SameSite: config.CookieSameSiteMode(),
 	})
 
 	// Set CSRF token cookie
 	csrfCookie := &http.Cookie{
 		Name:     "csrf_token",
 		Value:    csrfToken,
-		Expires:  time.Now().Add(24 * time.Hour),
+		Expires:  expiresAt,
 		Path:     "/",
-		Domain:   "localhost",
+		Domain:   config.App.Cookie.Domain,
 		HttpOnly: false, // Must be accessible via JavaScript
-		Secure:   false, // Set to true in production with HTTPS
-		SameSite: http.SameSiteLaxMode, // Changed from Strict to Lax for better compatibility
+		Secure:   config.App.Cookie.Secure,
+		SameSite: config.CookieSameSiteMode(),
 	}
 	http.SetCookie(w, csrfCookie)
 
-	fmt.Printf("Set CSRF cookie: %s\n", csrfCookie.String()) // Debug log
-
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(LoginResponse{
+	resp := LoginResponse{
 		Success: true,
 		Message: "Login successful",
 		UserID:  userID,
 		Name:    name,
-	})
-} 
\ No newline at end of file
+	}
+	if returnToken {
+		resp.Token = token
+		resp.ExpiresAt = &expiresAt
+	}
+
+	// Managers of a property that requires 2FA are told to enroll; manager
+	// routes for that property stay closed until they do
+	if setupRequired, err := twoFactorMissing(db, userID, 0); err != nil {
+		logger.Error(r.Context(), "Error checking property two-factor requirement", "error", err)
+	} else if setupRequired {
+		resp.TwoFactorSetupRequired = true
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+} 
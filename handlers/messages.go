@@ -0,0 +1,1119 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-rent/apierror"
+	"go-rent/config"
+	"go-rent/logger"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// Managers and tenants write to each other in threads, one for each floor
+// and tenant: the tenant on one side and every manager of the property on
+// the other. Each participant's position in a thread is kept in
+// message_read, which gives both their unread count and the read receipts
+// the others see. POST /notifications/send-comment, which older apps still
+// use, appends to the same thread, and create_message_tables.sql copied the
+// comments sent before threads existed into them.
+
+// Message and thread limits
+const (
+	defaultMessageLimit     = 50
+	maxMessageLimit         = 200
+	defaultThreadLimit      = 50
+	maxThreadLimit          = 200
+	maxMessageLength        = 4000
+	maxMessageAttachments   = 10
+	maxAttachmentURLLength  = 2048
+	maxAttachmentNameLength = 255
+	maxAttachmentTypeLength = 100
+	messagePreviewLength    = 140
+)
+
+// threadParticipant is the SQL condition for the current user taking part
+// in thread t; it takes the user ID twice
+const threadParticipant = `(t.tenant_id = ? OR EXISTS (
+	SELECT 1 FROM takes_care_of c WHERE c.pid = t.pid AND c.uid = ?))`
+
+// MessageAttachment is a file attached to a message: an upload with
+// purpose attachment, named by UploadID when sending, or a link to a file
+// kept elsewhere. The URLs of uploads are signed and expire after
+// STORAGE_URL_TTL.
+type MessageAttachment struct {
+	UploadID     int64  `json:"upload_id,omitempty"`
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+	Name         string `json:"name,omitempty"`
+	ContentType  string `json:"content_type,omitempty"`
+	Size         int64  `json:"size,omitempty"`
+}
+
+// Message is a message in a thread. NotificationID is the notification a
+// comment replied to, for messages sent through send-comment.
+type Message struct {
+	ID             int64               `json:"id"`
+	ThreadID       int64               `json:"thread_id"`
+	SenderID       int64               `json:"sender_id"`
+	SenderName     string              `json:"sender_name"`
+	Body           string              `json:"body"`
+	Attachments    []MessageAttachment `json:"attachments"`
+	NotificationID *int64              `json:"notification_id,omitempty"`
+	CreatedAt      time.Time           `json:"created_at"`
+	IsFromMe       bool                `json:"is_from_me,omitempty"`
+}
+
+// Thread is the conversation between a floor's tenant and the property's
+// managers, as seen by one participant
+type Thread struct {
+	ID             int64     `json:"id"`
+	PropertyID     int64     `json:"property_id"`
+	PropertyName   string    `json:"property_name"`
+	FloorID        int64     `json:"floor_id"`
+	FloorName      string    `json:"floor_name"`
+	TenantID       int64     `json:"tenant_id"`
+	TenantName     string    `json:"tenant_name"`
+	LastMessage    *Message  `json:"last_message,omitempty"`
+	UnreadCount    int       `json:"unread_count"`
+	LastActivityAt time.Time `json:"last_activity_at"`
+
+	lastMessageID int64
+}
+
+// ReadReceipt is how far a participant has read a thread
+type ReadReceipt struct {
+	ThreadID          int64     `json:"thread_id"`
+	UserID            int64     `json:"user_id"`
+	LastReadMessageID int64     `json:"last_read_message_id"`
+	ReadAt            time.Time `json:"read_at"`
+}
+
+type ThreadRequest struct {
+	FloorID  int64 `json:"floor_id"`
+	TenantID int64 `json:"tenant_id"`
+}
+
+type MessageRequest struct {
+	Body        string              `json:"body"`
+	Attachments []MessageAttachment `json:"attachments"`
+}
+
+type ThreadReadRequest struct {
+	MessageID int64 `json:"message_id"`
+}
+
+type ThreadResponse struct {
+	Success bool    `json:"success"`
+	Message string  `json:"message"`
+	Thread  *Thread `json:"thread"`
+}
+
+type ThreadsResponse struct {
+	Success    bool     `json:"success"`
+	Message    string   `json:"message"`
+	Threads    []Thread `json:"threads"`
+	HasMore    bool     `json:"has_more"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+}
+
+type MessagesResponse struct {
+	Success      bool          `json:"success"`
+	Message      string        `json:"message"`
+	Messages     []Message     `json:"messages"`
+	ReadReceipts []ReadReceipt `json:"read_receipts,omitempty"`
+	HasMore      bool          `json:"has_more"`
+	NextBefore   int64         `json:"next_before,omitempty"`
+}
+
+type ThreadReadResponse struct {
+	Success           bool   `json:"success"`
+	Message           string `json:"message"`
+	LastReadMessageID int64  `json:"last_read_message_id"`
+	UnreadCount       int    `json:"unread_count"`
+}
+
+// GetThreadsHandler lists the current user's threads, most recently active
+// first, with their last message and unread count. property_id and
+// floor_id narrow the list; limit and cursor page through it.
+func GetThreadsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := getUserIDFromContext(r)
+	if userID == 0 {
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	values := r.URL.Query()
+	problems := make(map[string]string)
+	conditions := []string{threadParticipant}
+	args := []interface{}{userID, userID}
+	filterID := func(name, condition string) {
+		if raw := values.Get(name); raw != "" {
+			id, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil || id <= 0 {
+				problems[name] = name + " must be a positive integer"
+				return
+			}
+			conditions = append(conditions, condition)
+			args = append(args, id)
+		}
+	}
+	filterID("property_id", "t.pid = ?")
+	filterID("floor_id", "t.fid = ?")
+	limit := defaultThreadLimit
+	if raw := values.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxThreadLimit {
+			problems["limit"] = fmt.Sprintf("limit must be between 1 and %d", maxThreadLimit)
+		} else {
+			limit = n
+		}
+	}
+	// Threads page by last activity like notifications page by creation
+	if raw := values.Get("cursor"); raw != "" {
+		cursor, err := decodeNotificationCursor(raw)
+		if err != nil {
+			problems["cursor"] = "cursor is invalid; pass next_cursor from the previous page unchanged"
+		} else {
+			conditions = append(conditions, "(t.last_activity_at < ? OR (t.last_activity_at = ? AND t.id < ?))")
+			args = append(args, cursor.CreatedAt, cursor.CreatedAt, cursor.ID)
+		}
+	}
+	if len(problems) > 0 {
+		apierror.WriteFields(w, r, "Invalid thread filters", problems)
+		return
+	}
+
+	db, err := config.GetDBConnection()
+	if err != nil {
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
+		return
+	}
+
+	threads, err := queryThreads(db, userID, strings.Join(conditions, " AND "), "t.last_activity_at DESC, t.id DESC", limit+1, args...)
+	if err != nil {
+		logger.Error(r.Context(), "Error querying threads", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error fetching threads")
+		return
+	}
+
+	response := ThreadsResponse{Success: true, Message: "Threads retrieved successfully", Threads: threads}
+	if len(threads) > limit {
+		response.Threads = threads[:limit]
+		last := threads[limit-1]
+		response.HasMore = true
+		response.NextCursor = notificationCursor{CreatedAt: last.LastActivityAt, ID: last.ID}.encode()
+	}
+	if response.Threads == nil {
+		response.Threads = []Thread{}
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// OpenThreadHandler returns the thread for a floor and tenant, creating it
+// if it does not exist yet. A manager of the property names the tenant, or
+// gets the floor's current tenant; a tenant opens their own thread.
+// Anyone who has been the floor's tenant or had a request about it counts
+// as a tenant of it.
+func OpenThreadHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := getUserIDFromContext(r)
+	if userID == 0 {
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req ThreadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.FloorID <= 0 {
+		apierror.Field(w, r, "floor_id", "floor_id is required")
+		return
+	}
+
+	db, err := config.GetDBConnection()
+	if err != nil {
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
+		return
+	}
+
+	var propertyID int64
+	var currentTenant sql.NullInt64
+	var isManager bool
+	err = db.QueryRow(`
+		SELECT f.pid, f.tenant,
+			EXISTS (SELECT 1 FROM takes_care_of c WHERE c.pid = f.pid AND c.uid = ?)
+		FROM floor f
+		WHERE f.id = ?`, userID, req.FloorID).Scan(&propertyID, &currentTenant, &isManager)
+	if err == sql.ErrNoRows {
+		apierror.Write(w, r, http.StatusNotFound, "Floor not found")
+		return
+	}
+	if err != nil {
+		logger.Error(r.Context(), "Error getting floor", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to open thread")
+		return
+	}
+
+	tenantID := req.TenantID
+	switch {
+	case isManager && tenantID == 0:
+		if !currentTenant.Valid {
+			apierror.Field(w, r, "tenant_id", "The floor has no tenant; pass tenant_id")
+			return
+		}
+		tenantID = currentTenant.Int64
+	case !isManager && tenantID == 0:
+		tenantID = userID
+	case !isManager && tenantID != userID:
+		apierror.Write(w, r, http.StatusForbidden, "You can only open your own thread for a floor")
+		return
+	}
+
+	if tenantID != currentTenant.Int64 {
+		var known bool
+		err = db.QueryRow(`
+			SELECT EXISTS (
+				SELECT 1 FROM notification
+				WHERE fid = ? AND (sender = ? OR receiver = ?)
+			)`, req.FloorID, tenantID, tenantID).Scan(&known)
+		if err != nil {
+			logger.Error(r.Context(), "Error checking tenancy", "error", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "Failed to open thread")
+			return
+		}
+		if !known {
+			if isManager {
+				apierror.Field(w, r, "tenant_id", "That user has never been a tenant of this floor")
+			} else {
+				apierror.Write(w, r, http.StatusForbidden, "You are not a tenant of this floor")
+			}
+			return
+		}
+	}
+
+	threadID, err := openThread(db, propertyID, req.FloorID, tenantID)
+	if err != nil {
+		logger.Error(r.Context(), "Error opening thread", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to open thread")
+		return
+	}
+	thread, err := loadThread(db, threadID, userID)
+	if err != nil {
+		logger.Error(r.Context(), "Error loading thread", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to open thread")
+		return
+	}
+
+	json.NewEncoder(w).Encode(ThreadResponse{
+		Success: true,
+		Message: "Thread opened successfully",
+		Thread:  thread,
+	})
+}
+
+// GetThreadMessagesHandler returns a page of a thread's messages, newest
+// first, with the participants' read receipts. Pass next_before as before
+// to get older messages.
+func GetThreadMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := getUserIDFromContext(r)
+	if userID == 0 {
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	values := r.URL.Query()
+	problems := make(map[string]string)
+	limit := defaultMessageLimit
+	if raw := values.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxMessageLimit {
+			problems["limit"] = fmt.Sprintf("limit must be between 1 and %d", maxMessageLimit)
+		} else {
+			limit = n
+		}
+	}
+	var before int64
+	if raw := values.Get("before"); raw != "" {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || id <= 0 {
+			problems["before"] = "before must be a message ID"
+		} else {
+			before = id
+		}
+	}
+	if len(problems) > 0 {
+		apierror.WriteFields(w, r, "Invalid message filters", problems)
+		return
+	}
+
+	db, thread, ok := threadFromRequest(w, r, userID)
+	if !ok {
+		return
+	}
+
+	conditions := "m.thread_id = ?"
+	args := []interface{}{thread.ID}
+	if before != 0 {
+		conditions += " AND m.id < ?"
+		args = append(args, before)
+	}
+	args = append(args, limit+1)
+	messages, err := queryMessages(db, userID, conditions+" ORDER BY m.id DESC LIMIT ?", args...)
+	if err != nil {
+		logger.Error(r.Context(), "Error querying messages", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error fetching messages")
+		return
+	}
+	receipts, err := threadReceipts(db, thread.ID, userID)
+	if err != nil {
+		logger.Error(r.Context(), "Error querying read receipts", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error fetching messages")
+		return
+	}
+
+	response := MessagesResponse{
+		Success:      true,
+		Message:      "Messages retrieved successfully",
+		Messages:     messages,
+		ReadReceipts: receipts,
+	}
+	if len(messages) > limit {
+		response.Messages = messages[:limit]
+		response.HasMore = true
+		response.NextBefore = messages[limit-1].ID
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// SendMessageHandler adds a message to a thread and notifies the other
+// participants on their channels for the message kind
+func SendMessageHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := getUserIDFromContext(r)
+	if userID == 0 {
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req MessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	req.Body = strings.TrimSpace(req.Body)
+	if problems := req.validate(); len(problems) > 0 {
+		apierror.WriteFields(w, r, "Invalid message", problems)
+		return
+	}
+
+	db, thread, ok := threadFromRequest(w, r, userID)
+	if !ok {
+		return
+	}
+	problems, err := resolveAttachments(db, userID, req.Attachments)
+	if err != nil {
+		logger.Error(r.Context(), "Error loading attachment uploads", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to send message")
+		return
+	}
+	if len(problems) > 0 {
+		apierror.WriteFields(w, r, "Invalid message", problems)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Error(r.Context(), "Transaction start error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to send message")
+		return
+	}
+	defer tx.Rollback()
+
+	message, err := appendMessage(tx, thread, userID, req.Body, req.Attachments, 0, 0)
+	if err != nil {
+		logger.Error(r.Context(), "Error saving message", "thread_id", thread.ID, "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to send message")
+		return
+	}
+	if err := queueMessagePushes(r.Context(), tx, thread, message); err != nil {
+		logger.Error(r.Context(), "Error queueing message notifications", "thread_id", thread.ID, "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to send message")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		logger.Error(r.Context(), "Error committing transaction", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to commit transaction")
+		return
+	}
+	WakePushDispatcher()
+	WakeEventDispatcher()
+
+	logger.Info(r.Context(), "Message sent", "thread_id", thread.ID, "message_id", message.ID)
+	message.IsFromMe = true
+	json.NewEncoder(w).Encode(MessagesResponse{
+		Success:  true,
+		Message:  "Message sent successfully",
+		Messages: []Message{*message},
+	})
+}
+
+// MarkThreadReadHandler records that the current user has read a thread up
+// to message_id, or to its last message when message_id is left out. A
+// position earlier than one already recorded is ignored.
+func MarkThreadReadHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := getUserIDFromContext(r)
+	if userID == 0 {
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	// The body is optional
+	var req ThreadReadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	db, thread, ok := threadFromRequest(w, r, userID)
+	if !ok {
+		return
+	}
+
+	messageID := thread.lastMessageID
+	if req.MessageID != 0 {
+		var found int64
+		err := db.QueryRow(`SELECT id FROM message WHERE id = ? AND thread_id = ?`, req.MessageID, thread.ID).Scan(&found)
+		if err == sql.ErrNoRows {
+			apierror.Field(w, r, "message_id", "message_id is not a message in this thread")
+			return
+		}
+		if err != nil {
+			logger.Error(r.Context(), "Error getting message", "error", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "Failed to mark thread as read")
+			return
+		}
+		messageID = found
+	}
+
+	if messageID != 0 {
+		tx, err := db.Begin()
+		if err != nil {
+			logger.Error(r.Context(), "Transaction start error", "error", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "Failed to mark thread as read")
+			return
+		}
+		defer tx.Rollback()
+
+		moved, err := markThreadRead(tx, thread, userID, messageID)
+		if err != nil {
+			logger.Error(r.Context(), "Error marking thread as read", "thread_id", thread.ID, "error", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "Failed to mark thread as read")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			logger.Error(r.Context(), "Error committing transaction", "error", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "Failed to commit transaction")
+			return
+		}
+		if moved {
+			WakeEventDispatcher()
+		}
+	}
+
+	response := ThreadReadResponse{Success: true, Message: "Thread marked as read"}
+	err := db.QueryRow(`
+		SELECT COALESCE(r.last_read_message_id, 0),
+			(SELECT COUNT(*) FROM message m
+			 WHERE m.thread_id = t.id AND m.sender_id <> ? AND m.id > COALESCE(r.last_read_message_id, 0))
+		FROM message_thread t
+		LEFT JOIN message_read r ON r.thread_id = t.id AND r.user_id = ?
+		WHERE t.id = ?`, userID, userID, thread.ID).Scan(&response.LastReadMessageID, &response.UnreadCount)
+	if err != nil {
+		logger.Error(r.Context(), "Error counting unread messages", "thread_id", thread.ID, "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to mark thread as read")
+		return
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetUnreadMessageCountHandler returns how many messages from others the
+// current user has not read, across all their threads
+func GetUnreadMessageCountHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := getUserIDFromContext(r)
+	if userID == 0 {
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	db, err := config.GetDBConnection()
+	if err != nil {
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
+		return
+	}
+
+	var count int
+	err = db.QueryRow(`
+		SELECT COUNT(*)
+		FROM message_thread t
+		JOIN message m ON m.thread_id = t.id
+		LEFT JOIN message_read r ON r.thread_id = t.id AND r.user_id = ?
+		WHERE `+threadParticipant+`
+			AND m.sender_id <> ? AND m.id > COALESCE(r.last_read_message_id, 0)`,
+		userID, userID, userID, userID).Scan(&count)
+	if err != nil {
+		logger.Error(r.Context(), "Error counting unread messages", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error counting unread messages")
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":      true,
+		"message":      "Unread message count retrieved successfully",
+		"unread_count": count,
+	})
+}
+
+// validate returns the problems with a message, by field
+func (req MessageRequest) validate() map[string]string {
+	problems := make(map[string]string)
+	if req.Body == "" && len(req.Attachments) == 0 {
+		problems["body"] = "A message needs a body or an attachment"
+	} else if utf8.RuneCountInString(req.Body) > maxMessageLength {
+		problems["body"] = fmt.Sprintf("body must be at most %d characters", maxMessageLength)
+	}
+	if len(req.Attachments) > maxMessageAttachments {
+		problems["attachments"] = fmt.Sprintf("At most %d attachments can be sent at once", maxMessageAttachments)
+	}
+	for i, attachment := range req.Attachments {
+		field := fmt.Sprintf("attachments[%d]", i)
+		if attachment.UploadID != 0 {
+			if attachment.URL != "" {
+				problems[field+".url"] = "Give either upload_id or url, not both"
+			}
+		} else if u, err := url.Parse(attachment.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			problems[field+".url"] = "url must be an http or https URL"
+		} else if len(attachment.URL) > maxAttachmentURLLength {
+			problems[field+".url"] = fmt.Sprintf("url must be at most %d characters", maxAttachmentURLLength)
+		}
+		if utf8.RuneCountInString(attachment.Name) > maxAttachmentNameLength {
+			problems[field+".name"] = fmt.Sprintf("name must be at most %d characters", maxAttachmentNameLength)
+		}
+		if len(attachment.ContentType) > maxAttachmentTypeLength {
+			problems[field+".content_type"] = fmt.Sprintf("content_type must be at most %d characters", maxAttachmentTypeLength)
+		}
+		if attachment.Size < 0 {
+			problems[field+".size"] = "size must not be negative"
+		}
+	}
+	return problems
+}
+
+// resolveAttachments fills in the attachments sent as uploads from the
+// upload table, with signed URLs, and returns the problems with them by
+// field. Each upload must be one of userID's with purpose attachment.
+func resolveAttachments(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, userID int64, attachments []MessageAttachment) (map[string]string, error) {
+	problems := make(map[string]string)
+	expires := fileURLExpiry()
+	for i := range attachments {
+		a := &attachments[i]
+		if a.UploadID == 0 {
+			continue
+		}
+		upload, err := ownUpload(q, userID, a.UploadID, uploadPurposeAttachment)
+		if errors.Is(err, sql.ErrNoRows) {
+			problems[fmt.Sprintf("attachments[%d].upload_id", i)] = "upload_id must be an attachment upload of yours"
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if a.Name == "" {
+			a.Name = upload.Name
+		}
+		a.ContentType, a.Size = upload.ContentType, upload.Size
+		a.URL, a.ThumbnailURL = fileURL(upload.key, expires), fileURL(upload.thumbnailKey, expires)
+	}
+	return problems, nil
+}
+
+// threadFromRequest loads the thread named in the route for userID, writing
+// the error response when it cannot
+func threadFromRequest(w http.ResponseWriter, r *http.Request, userID int64) (*sql.DB, *Thread, bool) {
+	threadID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid thread ID")
+		return nil, nil, false
+	}
+
+	db, err := config.GetDBConnection()
+	if err != nil {
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
+		return nil, nil, false
+	}
+
+	thread, err := loadThread(db, threadID, userID)
+	if err == sql.ErrNoRows {
+		apierror.Write(w, r, http.StatusNotFound, "Thread not found")
+		return nil, nil, false
+	}
+	if err != nil {
+		logger.Error(r.Context(), "Error loading thread", "thread_id", threadID, "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to load thread")
+		return nil, nil, false
+	}
+	return db, thread, true
+}
+
+// openThread returns the ID of the thread for a floor and tenant, creating
+// it if needed
+func openThread(q interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, propertyID, floorID, tenantID int64) (int64, error) {
+	now := time.Now().In(config.App.Location).Format("2006-01-02 15:04:05")
+	_, err := q.Exec(`
+		INSERT IGNORE INTO message_thread (pid, fid, tenant_id, last_activity_at, created_at)
+		VALUES (?, ?, ?, ?, ?)`, propertyID, floorID, tenantID, now, now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create thread: %v", err)
+	}
+	var threadID int64
+	err = q.QueryRow(`SELECT id FROM message_thread WHERE fid = ? AND tenant_id = ?`, floorID, tenantID).Scan(&threadID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load thread: %v", err)
+	}
+	return threadID, nil
+}
+
+// loadThread returns a thread as seen by userID, or sql.ErrNoRows when it
+// does not exist or they do not take part in it
+func loadThread(q interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}, threadID, userID int64) (*Thread, error) {
+	threads, err := queryThreads(q, userID, "t.id = ? AND "+threadParticipant, "t.id", 1, threadID, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(threads) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &threads[0], nil
+}
+
+// queryThreads returns the threads matching where, with each one's last
+// message and unread count for userID. The thread table is aliased as t.
+func queryThreads(q interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}, userID int64, where, orderBy string, limit int, args ...interface{}) ([]Thread, error) {
+	queryArgs := append([]interface{}{userID, userID}, args...)
+	queryArgs = append(queryArgs, limit)
+	rows, err := q.Query(`
+		SELECT t.id, t.pid, p.name, t.fid, f.name, t.tenant_id, u.name,
+			COALESCE(t.last_message_id, 0), t.last_activity_at,
+			(SELECT COUNT(*) FROM message m
+			 WHERE m.thread_id = t.id AND m.sender_id <> ? AND m.id > COALESCE(r.last_read_message_id, 0))
+		FROM message_thread t
+		JOIN property p ON p.id = t.pid
+		JOIN floor f ON f.id = t.fid
+		JOIN user u ON u.id = t.tenant_id
+		LEFT JOIN message_read r ON r.thread_id = t.id AND r.user_id = ?
+		WHERE `+where+`
+		ORDER BY `+orderBy+`
+		LIMIT ?`, queryArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query threads: %v", err)
+	}
+	defer rows.Close()
+
+	var threads []Thread
+	var lastIDs []interface{}
+	for rows.Next() {
+		var t Thread
+		err := rows.Scan(&t.ID, &t.PropertyID, &t.PropertyName, &t.FloorID, &t.FloorName, &t.TenantID, &t.TenantName,
+			&t.lastMessageID, &t.LastActivityAt, &t.UnreadCount)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read thread: %v", err)
+		}
+		threads = append(threads, t)
+		if t.lastMessageID != 0 {
+			lastIDs = append(lastIDs, t.lastMessageID)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read threads: %v", err)
+	}
+	rows.Close()
+
+	if len(lastIDs) == 0 {
+		return threads, nil
+	}
+	messages, err := queryMessages(q, userID, "m.id IN ("+placeholders(len(lastIDs))+")", lastIDs...)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int64]*Message, len(messages))
+	for i := range messages {
+		byID[messages[i].ID] = &messages[i]
+	}
+	for i := range threads {
+		threads[i].LastMessage = byID[threads[i].lastMessageID]
+	}
+	return threads, nil
+}
+
+// queryMessages returns the messages matching where, which may end with an
+// ORDER BY and LIMIT, with their attachments. The message table is aliased
+// as m.
+func queryMessages(q interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}, userID int64, where string, args ...interface{}) ([]Message, error) {
+	rows, err := q.Query(`
+		SELECT m.id, m.thread_id, m.sender_id, u.name, m.body, m.notification_id, m.created_at
+		FROM message m
+		JOIN user u ON u.id = m.sender_id
+		WHERE `+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query messages: %v", err)
+	}
+	defer rows.Close()
+
+	messages := []Message{}
+	var ids []interface{}
+	for rows.Next() {
+		var m Message
+		var notificationID sql.NullInt64
+		if err := rows.Scan(&m.ID, &m.ThreadID, &m.SenderID, &m.SenderName, &m.Body, &notificationID, &m.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to read message: %v", err)
+		}
+		if notificationID.Valid {
+			m.NotificationID = &notificationID.Int64
+		}
+		m.Attachments = []MessageAttachment{}
+		m.IsFromMe = m.SenderID == userID
+		messages = append(messages, m)
+		ids = append(ids, m.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read messages: %v", err)
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		return messages, nil
+	}
+
+	rows, err = q.Query(`
+		SELECT a.message_id, a.upload_id, a.url, up.storage_key, up.thumbnail_key, a.name, a.content_type, a.size_bytes
+		FROM message_attachment a
+		LEFT JOIN upload up ON up.id = a.upload_id
+		WHERE a.message_id IN (`+placeholders(len(ids))+`)
+		ORDER BY a.id`, ids...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query attachments: %v", err)
+	}
+	defer rows.Close()
+
+	index := make(map[int64]int, len(messages))
+	for i, m := range messages {
+		index[m.ID] = i
+	}
+	expires := fileURLExpiry()
+	for rows.Next() {
+		var messageID int64
+		var a MessageAttachment
+		var uploadID, size sql.NullInt64
+		var link, key, thumbnailKey, name, contentType sql.NullString
+		if err := rows.Scan(&messageID, &uploadID, &link, &key, &thumbnailKey, &name, &contentType, &size); err != nil {
+			return nil, fmt.Errorf("failed to read attachment: %v", err)
+		}
+		a.UploadID, a.URL = uploadID.Int64, link.String
+		if key.Valid {
+			a.URL, a.ThumbnailURL = fileURL(key.String, expires), fileURL(thumbnailKey.String, expires)
+		}
+		a.Name, a.ContentType, a.Size = name.String, contentType.String, size.Int64
+		i := index[messageID]
+		messages[i].Attachments = append(messages[i].Attachments, a)
+	}
+	return messages, rows.Err()
+}
+
+// threadReceipts returns the read positions of a thread's participants
+// other than userID
+func threadReceipts(q interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}, threadID, userID int64) ([]ReadReceipt, error) {
+	rows, err := q.Query(`
+		SELECT thread_id, user_id, last_read_message_id, read_at
+		FROM message_read
+		WHERE thread_id = ? AND user_id <> ?
+		ORDER BY user_id`, threadID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query read receipts: %v", err)
+	}
+	defer rows.Close()
+
+	var receipts []ReadReceipt
+	for rows.Next() {
+		var receipt ReadReceipt
+		if err := rows.Scan(&receipt.ThreadID, &receipt.UserID, &receipt.LastReadMessageID, &receipt.ReadAt); err != nil {
+			return nil, fmt.Errorf("failed to read receipt: %v", err)
+		}
+		receipts = append(receipts, receipt)
+	}
+	return receipts, rows.Err()
+}
+
+// threadParticipants returns the thread's tenant and the property's
+// managers
+func threadParticipants(q interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}, thread *Thread) ([]int64, error) {
+	rows, err := q.Query(`SELECT uid FROM takes_care_of WHERE pid = ?`, thread.PropertyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query managers: %v", err)
+	}
+	defer rows.Close()
+
+	participants := []int64{thread.TenantID}
+	for rows.Next() {
+		var managerID int64
+		if err := rows.Scan(&managerID); err != nil {
+			return nil, fmt.Errorf("failed to read manager: %v", err)
+		}
+		if managerID != thread.TenantID {
+			participants = append(participants, managerID)
+		}
+	}
+	return participants, rows.Err()
+}
+
+// appendMessage stores a message from senderID in thread within tx, moves
+// the sender's read position to it and records a message.created event for
+// every participant. notificationID and sourceNotificationID are set for
+// comments sent through send-comment, and are otherwise 0.
+func appendMessage(tx *sql.Tx, thread *Thread, senderID int64, body string, attachments []MessageAttachment, notificationID, sourceNotificationID int64) (*Message, error) {
+	createdAt := time.Now().In(config.App.Location)
+	now := createdAt.Format("2006-01-02 15:04:05")
+
+	var notification, source interface{}
+	if notificationID != 0 {
+		notification = notificationID
+	}
+	if sourceNotificationID != 0 {
+		source = sourceNotificationID
+	}
+	result, err := tx.Exec(`
+		INSERT INTO message (thread_id, sender_id, body, notification_id, source_notification_id, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`, thread.ID, senderID, body, notification, source, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save message: %v", err)
+	}
+	messageID, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message ID: %v", err)
+	}
+
+	for _, a := range attachments {
+		var upload, link, name, contentType, size interface{}
+		if a.UploadID != 0 {
+			upload = a.UploadID
+		} else {
+			link = a.URL
+		}
+		if a.Name != "" {
+			name = a.Name
+		}
+		if a.ContentType != "" {
+			contentType = a.ContentType
+		}
+		if a.Size != 0 {
+			size = a.Size
+		}
+		_, err := tx.Exec(`
+			INSERT INTO message_attachment (message_id, upload_id, url, name, content_type, size_bytes)
+			VALUES (?, ?, ?, ?, ?, ?)`, messageID, upload, link, name, contentType, size)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save attachment: %v", err)
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE message_thread
+		SET last_message_id = ?, last_activity_at = ?
+		WHERE id = ?`, messageID, now, thread.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update thread: %v", err)
+	}
+	thread.lastMessageID = messageID
+
+	var senderName string
+	if err := tx.QueryRow(`SELECT name FROM user WHERE id = ?`, senderID).Scan(&senderName); err != nil {
+		return nil, fmt.Errorf("failed to load sender: %v", err)
+	}
+	message := &Message{
+		ID:          messageID,
+		ThreadID:    thread.ID,
+		SenderID:    senderID,
+		SenderName:  senderName,
+		Body:        body,
+		Attachments: attachments,
+		CreatedAt:   createdAt,
+	}
+	if message.Attachments == nil {
+		message.Attachments = []MessageAttachment{}
+	}
+	if notificationID != 0 {
+		message.NotificationID = &notificationID
+	}
+
+	participants, err := threadParticipants(tx, thread)
+	if err != nil {
+		return nil, err
+	}
+	if err := recordEvent(tx, EventMessageCreated, message, participants...); err != nil {
+		return nil, err
+	}
+	// The sender has read their own message; their other devices learn of it
+	// from the message itself
+	if _, err := saveReadPosition(tx, thread.ID, senderID, messageID); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// appendComment adds a comment sent through send-comment to the thread of
+// the floor and whichever of sender and receiver is not a manager, within
+// tx. notificationID is the notification commented on and
+// commentNotificationID the notification the comment was sent as.
+func appendComment(tx *sql.Tx, propertyID, floorID, senderID, receiverID int64, comment string, notificationID, commentNotificationID int64) (*Message, error) {
+	var senderManages bool
+	err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM takes_care_of WHERE pid = ? AND uid = ?)`, propertyID, senderID).Scan(&senderManages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check manager: %v", err)
+	}
+	tenantID := senderID
+	if senderManages {
+		tenantID = receiverID
+	}
+
+	threadID, err := openThread(tx, propertyID, floorID, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	thread, err := loadThread(tx, threadID, senderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load thread: %v", err)
+	}
+	return appendMessage(tx, thread, senderID, comment, nil, notificationID, commentNotificationID)
+}
+
+// markThreadRead moves userID's read position in thread forward to
+// messageID within tx and records a thread.read event for every
+// participant. It reports false when the position was already there or
+// further on.
+func markThreadRead(tx *sql.Tx, thread *Thread, userID, messageID int64) (bool, error) {
+	readAt := time.Now().In(config.App.Location)
+	moved, err := saveReadPosition(tx, thread.ID, userID, messageID)
+	if err != nil || !moved {
+		return false, err
+	}
+
+	participants, err := threadParticipants(tx, thread)
+	if err != nil {
+		return false, err
+	}
+	receipt := ReadReceipt{ThreadID: thread.ID, UserID: userID, LastReadMessageID: messageID, ReadAt: readAt}
+	if err := recordEvent(tx, EventThreadRead, receipt, participants...); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// saveReadPosition moves userID's read position in a thread forward to
+// messageID, and reports whether it moved
+func saveReadPosition(tx *sql.Tx, threadID, userID, messageID int64) (bool, error) {
+	now := time.Now().In(config.App.Location).Format("2006-01-02 15:04:05")
+	// read_at is assigned first so that it still sees the old position
+	result, err := tx.Exec(`
+		INSERT INTO message_read (thread_id, user_id, last_read_message_id, read_at)
+		VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			read_at = IF(VALUES(last_read_message_id) > last_read_message_id, VALUES(read_at), read_at),
+			last_read_message_id = GREATEST(last_read_message_id, VALUES(last_read_message_id))`,
+		threadID, userID, messageID, now)
+	if err != nil {
+		return false, fmt.Errorf("failed to save read position: %v", err)
+	}
+	changed, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to save read position: %v", err)
+	}
+	return changed > 0, nil
+}
+
+// queueMessagePushes notifies every participant but the sender of a new
+// message within tx
+func queueMessagePushes(ctx context.Context, tx *sql.Tx, thread *Thread, message *Message) error {
+	participants, err := threadParticipants(tx, thread)
+	if err != nil {
+		return err
+	}
+
+	title := fmt.Sprintf("New Message - %s %s", thread.PropertyName, thread.FloorName)
+	preview := message.Body
+	if preview == "" {
+		preview = "Sent an attachment"
+	}
+	if runes := []rune(preview); len(runes) > messagePreviewLength {
+		preview = string(runes[:messagePreviewLength-1]) + "…"
+	}
+	body := message.SenderName + ": " + preview
+	data := map[string]interface{}{
+		"type":        NotificationKindMessage,
+		"thread_id":   fmt.Sprintf("%d", thread.ID),
+		"message_id":  fmt.Sprintf("%d", message.ID),
+		"property_id": fmt.Sprintf("%d", thread.PropertyID),
+		"floor_id":    fmt.Sprintf("%d", thread.FloorID),
+		"timestamp":   fmt.Sprintf("%d", message.CreatedAt.Unix()),
+	}
+
+	for _, receiverID := range participants {
+		if receiverID == message.SenderID {
+			continue
+		}
+		channels, err := enqueueNotification(tx, 0, receiverID, NotificationKindMessage, title, body, data)
+		if err != nil {
+			return err
+		}
+		logger.Debug(ctx, "Message notification queued", "receiver_id", receiverID, "message_id", message.ID, "channels", channels)
+	}
+	return nil
+}
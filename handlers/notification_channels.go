@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"encoding/json"
+	"go-rent/apierror"
+	"go-rent/config"
+	"go-rent/logger"
+	"go-rent/notify"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// NotificationChannel describes one channel in the user's settings.
+// Available is false when the server has no provider for the channel, and
+// HasAddress when the user has no device token, email, phone number or
+// webhook URL for it; messages are only sent when all three are true.
+type NotificationChannel struct {
+	Channel    string `json:"channel"`
+	Enabled    bool   `json:"enabled"`
+	Available  bool   `json:"available"`
+	HasAddress bool   `json:"has_address"`
+}
+
+type NotificationChannelsRequest struct {
+	Channels   []string `json:"channels"`
+	WebhookURL *string  `json:"webhook_url,omitempty"`
+}
+
+type NotificationChannelsResponse struct {
+	Success    bool                  `json:"success"`
+	Message    string                `json:"message"`
+	Channels   []NotificationChannel `json:"channels"`
+	WebhookURL string                `json:"webhook_url,omitempty"`
+}
+
+// GetNotificationChannelsHandler shows which channels the current user
+// receives notifications on
+func GetNotificationChannelsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := getUserIDFromContext(r)
+	if userID == 0 {
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	writeNotificationChannels(w, r, userID, "Notification channels retrieved successfully")
+}
+
+// UpdateNotificationChannelsHandler replaces the current user's enabled
+// channels and, when webhook_url is present, their webhook URL (an empty
+// string removes it)
+func UpdateNotificationChannelsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := getUserIDFromContext(r)
+	if userID == 0 {
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req NotificationChannelsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	db, err := config.GetDBConnection()
+	if err != nil {
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
+		return
+	}
+
+	recipient, previous, err := loadRecipient(db, userID)
+	if err != nil {
+		logger.Error(r.Context(), "Error loading notification channels", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error loading notification channels")
+		return
+	}
+
+	problems := make(map[string]string)
+	if req.WebhookURL != nil {
+		recipient.WebhookURL = strings.TrimSpace(*req.WebhookURL)
+		if recipient.WebhookURL != "" {
+			if err := notify.ValidateWebhookURL(recipient.WebhookURL); err != nil {
+				problems["webhook_url"] = "webhook_url " + err.Error()
+			}
+		}
+	}
+
+	var channels []string
+	for _, channel := range req.Channels {
+		channel = strings.ToLower(strings.TrimSpace(channel))
+		switch {
+		case !contains(notify.Channels, channel):
+			problems["channels"] = "channels must be chosen from " + strings.Join(notify.Channels, ", ")
+		case channel == notify.ChannelWebhook && recipient.WebhookURL == "":
+			problems["webhook_url"] = "webhook_url is required to enable the webhook channel"
+		case !contains(channels, channel):
+			channels = append(channels, channel)
+		}
+	}
+
+	if len(problems) > 0 {
+		apierror.WriteFields(w, r, "Invalid notification channels", problems)
+		return
+	}
+
+	var webhookURL interface{}
+	if recipient.WebhookURL != "" {
+		webhookURL = recipient.WebhookURL
+	}
+	now := time.Now().In(config.App.Location).Format("2006-01-02 15:04:05")
+	_, err = db.Exec(`
+		UPDATE user
+		SET notification_channels = ?, webhook_url = ?, updated_at = ?, updated_by = ?
+		WHERE id = ?`, strings.Join(channels, ","), webhookURL, now, userID, userID)
+	if err != nil {
+		logger.Error(r.Context(), "Error updating notification channels", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error updating notification channels")
+		return
+	}
+
+	logger.Info(r.Context(), "Notification channels updated", "channels", channels)
+
+	// Property broadcasts go to FCM topics, so turning push on or off
+	// subscribes or unsubscribes the user's devices
+	if contains(previous, notify.ChannelFCM) != contains(channels, notify.ChannelFCM) {
+		userTopicsChanged(r.Context(), userID)
+	}
+	writeNotificationChannels(w, r, userID, "Notification channels updated")
+}
+
+// writeNotificationChannels responds with the user's channel settings
+func writeNotificationChannels(w http.ResponseWriter, r *http.Request, userID int64, message string) {
+	db, err := config.GetDBConnection()
+	if err != nil {
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
+		return
+	}
+
+	recipient, enabled, err := loadRecipient(db, userID)
+	if err != nil {
+		logger.Error(r.Context(), "Error loading notification channels", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error loading notification channels")
+		return
+	}
+
+	channels := make([]NotificationChannel, 0, len(notify.Channels))
+	for _, channel := range notify.Channels {
+		channels = append(channels, NotificationChannel{
+			Channel:    channel,
+			Enabled:    contains(enabled, channel),
+			Available:  Notifiers.Get(channel) != nil,
+			HasAddress: recipient.Address(channel) != "",
+		})
+	}
+
+	json.NewEncoder(w).Encode(NotificationChannelsResponse{
+		Success:    true,
+		Message:    message,
+		Channels:   channels,
+		WebhookURL: recipient.WebhookURL,
+	})
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"go-rent/config"
+	"go-rent/logger"
+	"go-rent/metrics"
+	"strings"
+	"time"
+)
+
+// Notifications of the kinds a user gets as a daily digest are held in the
+// notification_digest table, each with the user's next digest time, and
+// SendNotificationDigests, run every minute by the scheduler, turns each
+// user's due items into one message on the channels they were meant for.
+// The message goes through the outbox, so quiet hours and retries apply to
+// it like any other.
+
+// Digest limits
+const (
+	maxDigestLines = 20  // items listed in one digest; the rest are counted
+	maxDigestUsers = 500 // users handled in one run
+)
+
+// enqueueDigestItem holds a message for the receiver's digest due at dueAt
+func enqueueDigestItem(tx *sql.Tx, receiverID, notificationID int64, kind string, channels []string, title, body string, dueAt time.Time) error {
+	var notification interface{}
+	if notificationID != 0 {
+		notification = notificationID
+	}
+	now := time.Now().In(config.App.Location).Format("2006-01-02 15:04:05")
+	_, err := tx.Exec(`
+		INSERT INTO notification_digest (
+			user_id, notification_id, kind, channels, title, body, due_at, created_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		receiverID, notification, kind, strings.Join(channels, ","), title, body,
+		dueAt.In(config.App.Location).Format("2006-01-02 15:04:05"), now,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to hold notification for digest: %v", err)
+	}
+	return nil
+}
+
+// SendNotificationDigests queues the digest of every user whose digest time
+// has come, and records the run in the scheduler job metrics
+func SendNotificationDigests() {
+	start := time.Now()
+	outcome := sendNotificationDigests(context.Background())
+	metrics.ObserveJob("notification_digests", outcome, start)
+}
+
+// sendNotificationDigests does the work of SendNotificationDigests and
+// returns the job outcome
+func sendNotificationDigests(ctx context.Context) string {
+	db, err := config.GetDBConnection()
+	if err != nil {
+		logger.Error(ctx, "Database connection error", "error", err)
+		return metrics.JobError
+	}
+
+	now := time.Now()
+	rows, err := db.QueryContext(ctx, `
+		SELECT DISTINCT user_id
+		FROM notification_digest
+		WHERE due_at <= ?
+		LIMIT ?`, now.In(config.App.Location).Format("2006-01-02 15:04:05"), maxDigestUsers)
+	if err != nil {
+		logger.Error(ctx, "Error reading notification digests", "error", err)
+		return metrics.JobError
+	}
+	var userIDs []int64
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			logger.Error(ctx, "Error reading notification digests", "error", err)
+			return metrics.JobError
+		}
+		userIDs = append(userIDs, userID)
+	}
+	rows.Close()
+	if len(userIDs) == 0 {
+		return metrics.JobSkipped
+	}
+
+	failed := 0
+	for _, userID := range userIDs {
+		if err := queueDigest(ctx, db, userID, now); err != nil {
+			failed++
+			logger.Error(ctx, "Failed to queue notification digest", "user_id", userID, "error", err)
+		}
+	}
+	WakePushDispatcher()
+
+	logger.Info(ctx, "Notification digests queued", "users", len(userIDs)-failed, "failed", failed)
+	if failed > 0 {
+		return metrics.JobError
+	}
+	return metrics.JobSuccess
+}
+
+// queueDigest turns the user's due digest items into one outbox message per
+// channel and removes them, in one transaction
+func queueDigest(ctx context.Context, db *sql.DB, userID int64, now time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Lock the items so that another instance running the same job skips
+	// them rather than sending them twice
+	rows, err := tx.Query(`
+		SELECT id, channels, title, body
+		FROM notification_digest
+		WHERE user_id = ? AND due_at <= ?
+		ORDER BY created_at, id
+		FOR UPDATE SKIP LOCKED`, userID, now.In(config.App.Location).Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return fmt.Errorf("failed to read digest: %v", err)
+	}
+	var ids []interface{}
+	var channels, lines []string
+	for rows.Next() {
+		var id int64
+		var itemChannels, title, body string
+		if err := rows.Scan(&id, &itemChannels, &title, &body); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read digest: %v", err)
+		}
+		ids = append(ids, id)
+		for _, channel := range splitList(itemChannels) {
+			if !contains(channels, channel) {
+				channels = append(channels, channel)
+			}
+		}
+		if len(lines) < maxDigestLines {
+			lines = append(lines, fmt.Sprintf("• %s: %s", title, body))
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read digest: %v", err)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	if more := len(ids) - len(lines); more > 0 {
+		lines = append(lines, fmt.Sprintf("…and %d more", more))
+	}
+
+	recipient, _, err := loadRecipient(tx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to load recipient: %v", err)
+	}
+	prefs, err := loadPreferences(tx, userID)
+	if err != nil {
+		return err
+	}
+
+	title := fmt.Sprintf("Your GoRent digest: %d updates", len(ids))
+	if len(ids) == 1 {
+		title = "Your GoRent digest: 1 update"
+	}
+	body := strings.Join(lines, "\n")
+	data := map[string]interface{}{
+		"type":      "digest",
+		"count":     fmt.Sprintf("%d", len(ids)),
+		"timestamp": fmt.Sprintf("%d", now.Unix()),
+	}
+	for _, channel := range channels {
+		// Channels switched off or left without an address since the items
+		// were held are skipped
+		if Notifiers.Get(channel) == nil || recipient.Address(channel) == "" {
+			continue
+		}
+		if err := enqueuePush(tx, channel, 0, userID, title, body, data, prefs.sendAfter(channel, now)); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`DELETE FROM notification_digest WHERE id IN (`+placeholders(len(ids))+`)`, ids...)
+	if err != nil {
+		return fmt.Errorf("failed to clear digest: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit digest: %v", err)
+	}
+	logger.Debug(ctx, "Notification digest queued", "user_id", userID, "items", len(ids), "channels", channels)
+	return nil
+}
@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"go-rent/apierror"
+	"go-rent/config"
+	"go-rent/logger"
+	"go-rent/notify"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultDigestTime is when the daily digest goes out if the user has not
+// chosen a time
+const defaultDigestTime = "08:00"
+
+// KindPreference chooses how one kind of notification is delivered.
+// Channels replaces the user's default channels for the kind; an empty list
+// keeps it in the app only. Digest holds it for the daily digest instead of
+// sending it straight away.
+type KindPreference struct {
+	Channels []string `json:"channels"`
+	Digest   bool     `json:"digest"`
+}
+
+// QuietHours is a daily window, as HH:MM in the user's timezone, in which
+// nothing is sent. It may wrap past midnight, as 22:00 to 07:00 does.
+type QuietHours struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// NotificationPreferences is stored as JSON in user.notification_preferences.
+// Timezone is an IANA name and defaults to the server's; kinds without an
+// entry in Kinds use the channels from /user/notification-channels.
+type NotificationPreferences struct {
+	Timezone   string                    `json:"timezone,omitempty"`
+	QuietHours *QuietHours               `json:"quiet_hours,omitempty"`
+	DigestTime string                    `json:"digest_time,omitempty"`
+	Kinds      map[string]KindPreference `json:"kinds,omitempty"`
+}
+
+type NotificationPreferencesResponse struct {
+	Success         bool                    `json:"success"`
+	Message         string                  `json:"message"`
+	Preferences     NotificationPreferences `json:"preferences"`
+	DefaultChannels []string                `json:"default_channels"`
+}
+
+// loadPreferences reads a user's notification preferences; users who have
+// never set them get the zero value
+func loadPreferences(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, userID int64) (NotificationPreferences, error) {
+	var prefs NotificationPreferences
+	var raw []byte
+	err := q.QueryRow(`SELECT notification_preferences FROM user WHERE id = ?`, userID).Scan(&raw)
+	if err != nil || len(raw) == 0 {
+		return prefs, err
+	}
+	if err := json.Unmarshal(raw, &prefs); err != nil {
+		return prefs, fmt.Errorf("user %d has invalid notification preferences: %v", userID, err)
+	}
+	return prefs, nil
+}
+
+// location returns the user's timezone, or the server's when it is unset or
+// unknown
+func (p NotificationPreferences) location() *time.Location {
+	if p.Timezone != "" {
+		if loc, err := time.LoadLocation(p.Timezone); err == nil {
+			return loc
+		}
+	}
+	return config.App.Location
+}
+
+// channelsFor returns the channels for kind, falling back to defaults
+func (p NotificationPreferences) channelsFor(kind string, defaults []string) []string {
+	if pref, ok := p.Kinds[kind]; ok {
+		return pref.Channels
+	}
+	return defaults
+}
+
+// digestFor reports whether kind is held for the daily digest
+func (p NotificationPreferences) digestFor(kind string) bool {
+	return p.Kinds[kind].Digest
+}
+
+// sendAfter returns when a message queued at now on channel may be sent:
+// the end of the quiet hours if now falls inside them, otherwise now.
+// Webhooks are for other systems rather than people and are never held.
+func (p NotificationPreferences) sendAfter(channel string, now time.Time) time.Time {
+	if p.QuietHours == nil || channel == notify.ChannelWebhook {
+		return now
+	}
+	start, errStart := parseClock(p.QuietHours.Start)
+	end, errEnd := parseClock(p.QuietHours.End)
+	if errStart != nil || errEnd != nil || start == end {
+		return now
+	}
+
+	local := now.In(p.location())
+	minute := local.Hour()*60 + local.Minute()
+	quiet := minute >= start && minute < end
+	if start > end {
+		quiet = minute >= start || minute < end
+	}
+	if !quiet {
+		return now
+	}
+	return nextClock(local, end)
+}
+
+// nextDigest returns the first digest time after now
+func (p NotificationPreferences) nextDigest(now time.Time) time.Time {
+	at, err := parseClock(p.DigestTime)
+	if err != nil {
+		at, _ = parseClock(defaultDigestTime)
+	}
+	return nextClock(now.In(p.location()), at)
+}
+
+// validate checks the preferences and returns a message per invalid field.
+// webhookURL is the user's webhook address, which the webhook channel needs.
+func (p NotificationPreferences) validate(webhookURL string) map[string]string {
+	problems := make(map[string]string)
+	if p.Timezone != "" {
+		if _, err := time.LoadLocation(p.Timezone); err != nil {
+			problems["timezone"] = "timezone must be an IANA name such as Asia/Dhaka"
+		}
+	}
+	if p.QuietHours != nil {
+		_, errStart := parseClock(p.QuietHours.Start)
+		_, errEnd := parseClock(p.QuietHours.End)
+		switch {
+		case errStart != nil || errEnd != nil:
+			problems["quiet_hours"] = "quiet_hours start and end must be HH:MM"
+		case p.QuietHours.Start == p.QuietHours.End:
+			problems["quiet_hours"] = "quiet_hours start and end must differ"
+		}
+	}
+	if p.DigestTime != "" {
+		if _, err := parseClock(p.DigestTime); err != nil {
+			problems["digest_time"] = "digest_time must be HH:MM"
+		}
+	}
+	for kind, pref := range p.Kinds {
+		field := "kinds." + kind
+		if !contains(NotificationKinds, kind) {
+			problems[field] = "kind must be one of " + strings.Join(NotificationKinds, ", ")
+			continue
+		}
+		for _, channel := range pref.Channels {
+			if !contains(notify.Channels, channel) {
+				problems[field] = "channels must be chosen from " + strings.Join(notify.Channels, ", ")
+			} else if channel == notify.ChannelWebhook && webhookURL == "" {
+				problems[field] = "set a webhook_url in /user/notification-channels to use the webhook channel"
+			}
+		}
+	}
+	return problems
+}
+
+// parseClock parses HH:MM into minutes after midnight
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// nextClock returns the first time after now, in now's location, at minute
+// minutes after midnight
+func nextClock(now time.Time, minute int) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), minute/60, minute%60, 0, 0, now.Location())
+	if !next.After(now) {
+		next = time.Date(now.Year(), now.Month(), now.Day()+1, minute/60, minute%60, 0, 0, now.Location())
+	}
+	return next
+}
+
+// GetNotificationPreferencesHandler shows the current user's per-kind
+// channels, quiet hours and digest time
+func GetNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := getUserIDFromContext(r)
+	if userID == 0 {
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	writeNotificationPreferences(w, r, userID, "Notification preferences retrieved successfully")
+}
+
+// UpdateNotificationPreferencesHandler replaces the current user's
+// notification preferences
+func UpdateNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := getUserIDFromContext(r)
+	if userID == 0 {
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var prefs NotificationPreferences
+	if err := json.NewDecoder(r.Body).Decode(&prefs); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	prefs.Timezone = strings.TrimSpace(prefs.Timezone)
+	for kind, pref := range prefs.Kinds {
+		channels := []string{}
+		for _, channel := range pref.Channels {
+			channel = strings.ToLower(strings.TrimSpace(channel))
+			if !contains(channels, channel) {
+				channels = append(channels, channel)
+			}
+		}
+		pref.Channels = channels
+		prefs.Kinds[kind] = pref
+	}
+
+	db, err := config.GetDBConnection()
+	if err != nil {
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
+		return
+	}
+
+	recipient, _, err := loadRecipient(db, userID)
+	if err != nil {
+		logger.Error(r.Context(), "Error loading notification channels", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error loading notification preferences")
+		return
+	}
+	if problems := prefs.validate(recipient.WebhookURL); len(problems) > 0 {
+		apierror.WriteFields(w, r, "Invalid notification preferences", problems)
+		return
+	}
+
+	payload, err := json.Marshal(prefs)
+	if err != nil {
+		logger.Error(r.Context(), "Error encoding notification preferences", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error updating notification preferences")
+		return
+	}
+	now := time.Now().In(config.App.Location).Format("2006-01-02 15:04:05")
+	_, err = db.Exec(`
+		UPDATE user
+		SET notification_preferences = ?, updated_at = ?, updated_by = ?
+		WHERE id = ?`, payload, now, userID, userID)
+	if err != nil {
+		logger.Error(r.Context(), "Error updating notification preferences", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error updating notification preferences")
+		return
+	}
+
+	logger.Info(r.Context(), "Notification preferences updated")
+	writeNotificationPreferences(w, r, userID, "Notification preferences updated")
+}
+
+// writeNotificationPreferences responds with the user's preferences, with
+// the timezone and digest time they fall back to filled in
+func writeNotificationPreferences(w http.ResponseWriter, r *http.Request, userID int64, message string) {
+	db, err := config.GetDBConnection()
+	if err != nil {
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
+		return
+	}
+
+	_, channels, err := loadRecipient(db, userID)
+	if err != nil {
+		logger.Error(r.Context(), "Error loading notification channels", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error loading notification preferences")
+		return
+	}
+	prefs, err := loadPreferences(db, userID)
+	if err != nil {
+		logger.Error(r.Context(), "Error loading notification preferences", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error loading notification preferences")
+		return
+	}
+
+	prefs.Timezone = prefs.location().String()
+	if prefs.DigestTime == "" {
+		prefs.DigestTime = defaultDigestTime
+	}
+	if channels == nil {
+		channels = []string{}
+	}
+	json.NewEncoder(w).Encode(NotificationPreferencesResponse{
+		Success:         true,
+		Message:         message,
+		Preferences:     prefs,
+		DefaultChannels: channels,
+	})
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"go-rent/config"
+	"go-rent/notify"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+// useServerLocation sets the server's timezone to Asia/Dhaka, which has no
+// daylight saving time, until the test ends
+func useServerLocation(t *testing.T) *time.Location {
+	dhaka, err := time.LoadLocation("Asia/Dhaka")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := config.App
+	t.Cleanup(func() { config.App = saved })
+	config.App = &config.Config{Location: dhaka, FCM: config.FCMConfig{TopicQuietStart: "22:00", TopicQuietEnd: "07:00"}}
+	return dhaka
+}
+
+func TestSendAfter(t *testing.T) {
+	dhaka := useServerLocation(t)
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(loc *time.Location, year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, loc)
+	}
+	overnight := &QuietHours{Start: "22:00", End: "07:00"}
+	afternoon := &QuietHours{Start: "13:00", End: "15:00"}
+
+	tests := []struct {
+		name    string
+		prefs   NotificationPreferences
+		channel string
+		now     time.Time
+		want    time.Time
+	}{
+		{"no quiet hours", NotificationPreferences{}, notify.ChannelFCM,
+			at(dhaka, 2025, 6, 1, 23, 0), at(dhaka, 2025, 6, 1, 23, 0)},
+		{"before an overnight window", NotificationPreferences{QuietHours: overnight}, notify.ChannelFCM,
+			at(dhaka, 2025, 6, 1, 21, 59), at(dhaka, 2025, 6, 1, 21, 59)},
+		{"window start is quiet", NotificationPreferences{QuietHours: overnight}, notify.ChannelEmail,
+			at(dhaka, 2025, 6, 1, 22, 0), at(dhaka, 2025, 6, 2, 7, 0)},
+		{"before midnight waits for tomorrow", NotificationPreferences{QuietHours: overnight}, notify.ChannelSMS,
+			at(dhaka, 2025, 6, 1, 23, 30), at(dhaka, 2025, 6, 2, 7, 0)},
+		{"after midnight waits for today", NotificationPreferences{QuietHours: overnight}, notify.ChannelFCM,
+			at(dhaka, 2025, 6, 2, 3, 0), at(dhaka, 2025, 6, 2, 7, 0)},
+		{"window end is not quiet", NotificationPreferences{QuietHours: overnight}, notify.ChannelFCM,
+			at(dhaka, 2025, 6, 2, 7, 0), at(dhaka, 2025, 6, 2, 7, 0)},
+		{"overnight window across a month end", NotificationPreferences{QuietHours: overnight}, notify.ChannelFCM,
+			at(dhaka, 2025, 12, 31, 23, 0), at(dhaka, 2026, 1, 1, 7, 0)},
+		{"inside a same-day window", NotificationPreferences{QuietHours: afternoon}, notify.ChannelFCM,
+			at(dhaka, 2025, 6, 1, 14, 0), at(dhaka, 2025, 6, 1, 15, 0)},
+		{"after a same-day window", NotificationPreferences{QuietHours: afternoon}, notify.ChannelFCM,
+			at(dhaka, 2025, 6, 1, 23, 0), at(dhaka, 2025, 6, 1, 23, 0)},
+		{"webhooks are never held", NotificationPreferences{QuietHours: overnight}, notify.ChannelWebhook,
+			at(dhaka, 2025, 6, 1, 23, 30), at(dhaka, 2025, 6, 1, 23, 30)},
+		{"empty window", NotificationPreferences{QuietHours: &QuietHours{Start: "22:00", End: "22:00"}}, notify.ChannelFCM,
+			at(dhaka, 2025, 6, 1, 22, 30), at(dhaka, 2025, 6, 1, 22, 30)},
+		{"invalid clock", NotificationPreferences{QuietHours: &QuietHours{Start: "10pm", End: "07:00"}}, notify.ChannelFCM,
+			at(dhaka, 2025, 6, 1, 23, 0), at(dhaka, 2025, 6, 1, 23, 0)},
+		{"unknown timezone uses the server's", NotificationPreferences{Timezone: "Mars/Olympus", QuietHours: overnight}, notify.ChannelFCM,
+			at(dhaka, 2025, 6, 1, 23, 0), at(dhaka, 2025, 6, 2, 7, 0)},
+
+		// The user's clock decides, not the server's: 23:00 in New York is
+		// 09:00 the next morning in Dhaka
+		{"user's timezone", NotificationPreferences{Timezone: "America/New_York", QuietHours: overnight}, notify.ChannelFCM,
+			at(newYork, 2025, 6, 1, 23, 0), at(newYork, 2025, 6, 2, 7, 0)},
+		{"server's night is the user's day", NotificationPreferences{Timezone: "America/New_York", QuietHours: overnight}, notify.ChannelFCM,
+			at(dhaka, 2025, 6, 1, 23, 0), at(dhaka, 2025, 6, 1, 23, 0)},
+		// Clocks go forward at 02:00 on 9 March 2025: 01:30 EST to 07:00 EDT
+		// is five and a half hours on the wall clock but four and a half hours
+		{"spring forward", NotificationPreferences{Timezone: "America/New_York", QuietHours: overnight}, notify.ChannelFCM,
+			at(newYork, 2025, 3, 9, 1, 30), time.Date(2025, 3, 9, 11, 0, 0, 0, time.UTC)},
+		// Clocks go back at 02:00 on 2 November 2025: 23:00 EDT to 07:00 EST
+		// is nine hours
+		{"fall back", NotificationPreferences{Timezone: "America/New_York", QuietHours: overnight}, notify.ChannelFCM,
+			at(newYork, 2025, 11, 1, 23, 0), time.Date(2025, 11, 2, 12, 0, 0, 0, time.UTC)},
+		{"repeated hour after fall back", NotificationPreferences{Timezone: "America/New_York", QuietHours: overnight}, notify.ChannelFCM,
+			time.Date(2025, 11, 2, 6, 30, 0, 0, time.UTC), time.Date(2025, 11, 2, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got := tt.prefs.sendAfter(tt.channel, tt.now)
+		if !got.Equal(tt.want) {
+			t.Errorf("%s: sendAfter(%v) = %v, want %v", tt.name, tt.now, got, tt.want)
+		}
+	}
+}
+
+func TestNextClock(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		now    time.Time
+		minute int
+		want   time.Time
+	}{
+		{"later today", time.Date(2025, 6, 1, 6, 0, 0, 0, newYork), 7 * 60, time.Date(2025, 6, 1, 7, 0, 0, 0, newYork)},
+		{"exactly now is tomorrow", time.Date(2025, 6, 1, 7, 0, 0, 0, newYork), 7 * 60, time.Date(2025, 6, 2, 7, 0, 0, 0, newYork)},
+		{"midnight", time.Date(2025, 6, 1, 23, 59, 0, 0, newYork), 0, time.Date(2025, 6, 2, 0, 0, 0, 0, newYork)},
+		{"year end", time.Date(2025, 12, 31, 9, 0, 0, 0, newYork), 8 * 60, time.Date(2026, 1, 1, 8, 0, 0, 0, newYork)},
+		// Across the spring-forward night the same wall clock is 23 hours on
+		{"across spring forward", time.Date(2025, 3, 8, 12, 0, 0, 0, newYork), 12 * 60, time.Date(2025, 3, 9, 16, 0, 0, 0, time.UTC)},
+		// and across the fall-back night 25 hours on
+		{"across fall back", time.Date(2025, 11, 1, 12, 0, 0, 0, newYork), 12 * 60, time.Date(2025, 11, 2, 17, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got := nextClock(tt.now, tt.minute)
+		if !got.Equal(tt.want) || got.Location() != tt.now.Location() {
+			t.Errorf("%s: nextClock = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNextDigest(t *testing.T) {
+	dhaka := useServerLocation(t)
+	now := time.Date(2025, 6, 1, 9, 0, 0, 0, dhaka)
+	tests := []struct {
+		prefs NotificationPreferences
+		want  time.Time
+	}{
+		{NotificationPreferences{}, time.Date(2025, 6, 2, 8, 0, 0, 0, dhaka)},
+		{NotificationPreferences{DigestTime: "18:30"}, time.Date(2025, 6, 1, 18, 30, 0, 0, dhaka)},
+		{NotificationPreferences{DigestTime: "bad"}, time.Date(2025, 6, 2, 8, 0, 0, 0, dhaka)},
+		// 09:00 in Dhaka is 03:00 UTC
+		{NotificationPreferences{DigestTime: "08:00", Timezone: "UTC"}, time.Date(2025, 6, 1, 8, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := tt.prefs.nextDigest(now); !got.Equal(tt.want) {
+			t.Errorf("%+v: nextDigest = %v, want %v", tt.prefs, got, tt.want)
+		}
+	}
+}
+
+func TestTopicSendAfter(t *testing.T) {
+	dhaka := useServerLocation(t)
+	tests := []struct {
+		now, want time.Time
+	}{
+		{time.Date(2025, 6, 1, 21, 0, 0, 0, dhaka), time.Date(2025, 6, 1, 21, 0, 0, 0, dhaka)},
+		{time.Date(2025, 6, 1, 23, 0, 0, 0, dhaka), time.Date(2025, 6, 2, 7, 0, 0, 0, dhaka)},
+		{time.Date(2025, 6, 1, 17, 30, 0, 0, time.UTC), time.Date(2025, 6, 2, 7, 0, 0, 0, dhaka)},
+	}
+	for _, tt := range tests {
+		if got := topicSendAfter(tt.now); !got.Equal(tt.want) {
+			t.Errorf("topicSendAfter(%v) = %v, want %v", tt.now, got, tt.want)
+		}
+	}
+
+	config.App.FCM.TopicQuietStart, config.App.FCM.TopicQuietEnd = "00:00", "00:00"
+	now := time.Date(2025, 6, 1, 23, 0, 0, 0, dhaka)
+	if got := topicSendAfter(now); !got.Equal(now) {
+		t.Errorf("with an empty window, topicSendAfter = %v, want now", got)
+	}
+}
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"go-rent/config"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Page sizes for GET /notifications
+const (
+	defaultNotificationLimit = 50
+	maxNotificationLimit     = 200
+)
+
+// notificationStatuses are the values of notification.status a client can
+// filter on. Informational notifications have no status.
+var notificationStatuses = []string{"pending", "accepted", "rejected", "comment"}
+
+// notificationListQuery holds the filters, order and page of a
+// GET /notifications request
+type notificationListQuery struct {
+	Statuses   []string
+	Kinds      []string
+	PropertyID int64
+	FloorID    int64
+	Read       *bool
+	From       string // inclusive, as stored in created_at
+	To         string // exclusive, as stored in created_at
+	Ascending  bool
+	Limit      int
+	After      *notificationCursor
+}
+
+// notificationCursor is the position of the last notification on a page
+type notificationCursor struct {
+	CreatedAt time.Time
+	ID        int64
+}
+
+// parseNotificationListQuery reads the query parameters:
+//
+//	status       comma-separated: pending, accepted, rejected, comment
+//	kind         comma-separated: see NotificationKinds
+//	property_id  only this property
+//	floor_id     only this floor
+//	read         true or false
+//	from, to     RFC 3339 timestamps or YYYY-MM-DD dates; a date for "to"
+//	             includes that whole day
+//	sort         -created_at (newest first, the default) or created_at
+//	limit        page size, 1 to 200 (default 50)
+//	cursor       next_cursor from the previous page
+//
+// Invalid parameters are returned as field errors.
+func parseNotificationListQuery(values url.Values) (notificationListQuery, map[string]string) {
+	q := notificationListQuery{Limit: defaultNotificationLimit}
+	problems := make(map[string]string)
+
+	if raw := values.Get("status"); raw != "" {
+		q.Statuses = splitList(raw)
+		for _, status := range q.Statuses {
+			if !contains(notificationStatuses, status) {
+				problems["status"] = "status must be one of " + strings.Join(notificationStatuses, ", ")
+			}
+		}
+	}
+	if raw := values.Get("kind"); raw != "" {
+		q.Kinds = splitList(raw)
+		for _, kind := range q.Kinds {
+			if !contains(NotificationKinds, kind) {
+				problems["kind"] = "kind must be one of " + strings.Join(NotificationKinds, ", ")
+			}
+		}
+	}
+
+	parseID := func(name string, dest *int64) {
+		if raw := values.Get(name); raw != "" {
+			id, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil || id <= 0 {
+				problems[name] = name + " must be a positive integer"
+				return
+			}
+			*dest = id
+		}
+	}
+	parseID("property_id", &q.PropertyID)
+	parseID("floor_id", &q.FloorID)
+
+	if raw := values.Get("read"); raw != "" {
+		read, err := strconv.ParseBool(raw)
+		if err != nil {
+			problems["read"] = "read must be true or false"
+		} else {
+			q.Read = &read
+		}
+	}
+
+	if raw := values.Get("from"); raw != "" {
+		from, _, err := parseNotificationTime(raw)
+		if err != nil {
+			problems["from"] = err.Error()
+		} else {
+			q.From = from.Format("2006-01-02 15:04:05")
+		}
+	}
+	if raw := values.Get("to"); raw != "" {
+		to, dateOnly, err := parseNotificationTime(raw)
+		if err != nil {
+			problems["to"] = err.Error()
+		} else {
+			if dateOnly {
+				to = to.AddDate(0, 0, 1)
+			}
+			q.To = to.Format("2006-01-02 15:04:05")
+		}
+	}
+
+	switch values.Get("sort") {
+	case "", "-created_at":
+	case "created_at":
+		q.Ascending = true
+	default:
+		problems["sort"] = "sort must be -created_at or created_at"
+	}
+
+	if raw := values.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxNotificationLimit {
+			problems["limit"] = fmt.Sprintf("limit must be between 1 and %d", maxNotificationLimit)
+		} else {
+			q.Limit = limit
+		}
+	}
+
+	if raw := values.Get("cursor"); raw != "" {
+		cursor, err := decodeNotificationCursor(raw)
+		if err != nil {
+			problems["cursor"] = "cursor is invalid; pass next_cursor from the previous page unchanged"
+		} else {
+			q.After = cursor
+		}
+	}
+
+	return q, problems
+}
+
+// parseNotificationTime accepts an RFC 3339 timestamp or a YYYY-MM-DD date
+// in the configured time zone, and returns it in that zone, which is how
+// created_at is stored
+func parseNotificationTime(raw string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t.In(config.App.Location), false, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", raw, config.App.Location); err == nil {
+		return t, true, nil
+	}
+	return time.Time{}, false, fmt.Errorf("must be an RFC 3339 timestamp or a YYYY-MM-DD date")
+}
+
+// where returns the SQL conditions and arguments for the filters and cursor.
+// The notification table is aliased as n.
+func (q notificationListQuery) where(userID int64) (string, []interface{}) {
+	conditions := []string{"n.receiver = ?"}
+	args := []interface{}{userID}
+
+	if len(q.Statuses) > 0 {
+		conditions = append(conditions, "n.status IN ("+placeholders(len(q.Statuses))+")")
+		for _, status := range q.Statuses {
+			args = append(args, status)
+		}
+	}
+	if len(q.Kinds) > 0 {
+		conditions = append(conditions, "n.kind IN ("+placeholders(len(q.Kinds))+")")
+		for _, kind := range q.Kinds {
+			args = append(args, kind)
+		}
+	}
+	if q.PropertyID != 0 {
+		conditions = append(conditions, "n.pid = ?")
+		args = append(args, q.PropertyID)
+	}
+	if q.FloorID != 0 {
+		conditions = append(conditions, "n.fid = ?")
+		args = append(args, q.FloorID)
+	}
+	if q.Read != nil {
+		conditions = append(conditions, "COALESCE(n.is_read, false) = ?")
+		args = append(args, *q.Read)
+	}
+	if q.From != "" {
+		conditions = append(conditions, "n.created_at >= ?")
+		args = append(args, q.From)
+	}
+	if q.To != "" {
+		conditions = append(conditions, "n.created_at < ?")
+		args = append(args, q.To)
+	}
+	if q.After != nil {
+		op := "<"
+		if q.Ascending {
+			op = ">"
+		}
+		conditions = append(conditions, fmt.Sprintf("(n.created_at %s ? OR (n.created_at = ? AND n.id %s ?))", op, op))
+		args = append(args, q.After.CreatedAt, q.After.CreatedAt, q.After.ID)
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+// orderBy returns the ORDER BY clause. id breaks ties between notifications
+// created in the same second so that pages never overlap or skip rows.
+func (q notificationListQuery) orderBy() string {
+	if q.Ascending {
+		return "n.created_at ASC, n.id ASC"
+	}
+	return "n.created_at DESC, n.id DESC"
+}
+
+func (c notificationCursor) encode() string {
+	raw := c.CreatedAt.Format(time.RFC3339Nano) + "|" + strconv.FormatInt(c.ID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeNotificationCursor(s string) (*notificationCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	createdAt, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, fmt.Errorf("malformed cursor")
+	}
+	cursor := &notificationCursor{}
+	if cursor.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt); err != nil {
+		return nil, err
+	}
+	if cursor.ID, err = strconv.ParseInt(id, 10, 64); err != nil {
+		return nil, err
+	}
+	return cursor, nil
+}
+
+// splitList splits a comma-separated parameter into trimmed, non-empty items
+func splitList(raw string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// placeholders returns n comma-separated SQL placeholders
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
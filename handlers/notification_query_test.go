@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotificationCursorRoundTrip(t *testing.T) {
+	dhaka := time.FixedZone("Asia/Dhaka", 6*60*60)
+	cursors := []notificationCursor{
+		{CreatedAt: time.Date(2025, 3, 5, 9, 0, 0, 0, time.UTC), ID: 1},
+		{CreatedAt: time.Date(2025, 12, 31, 23, 59, 59, 999999999, dhaka), ID: 9223372036854775807},
+		{CreatedAt: time.Date(2024, 2, 29, 0, 0, 0, 1000, dhaka), ID: 42},
+	}
+	for _, want := range cursors {
+		encoded := want.encode()
+		if strings.ContainsAny(encoded, "+/=") {
+			t.Errorf("cursor %q is not URL-safe", encoded)
+		}
+		got, err := decodeNotificationCursor(encoded)
+		if err != nil {
+			t.Fatalf("decode(%q): %v", encoded, err)
+		}
+		if !got.CreatedAt.Equal(want.CreatedAt) || got.ID != want.ID {
+			t.Errorf("round trip of %v/%d gave %v/%d", want.CreatedAt, want.ID, got.CreatedAt, got.ID)
+		}
+	}
+}
+
+func TestDecodeNotificationCursorRejects(t *testing.T) {
+	encode := func(raw string) string { return base64.RawURLEncoding.EncodeToString([]byte(raw)) }
+	tests := map[string]string{
+		"empty":           "",
+		"not base64":      "not a cursor!",
+		"padded base64":   base64.URLEncoding.EncodeToString([]byte("2025-03-05T09:00:00Z|1")),
+		"no separator":    encode("2025-03-05T09:00:00Z"),
+		"bad time":        encode("yesterday|1"),
+		"date only":       encode("2025-03-05|1"),
+		"bad id":          encode("2025-03-05T09:00:00Z|one"),
+		"missing id":      encode("2025-03-05T09:00:00Z|"),
+		"id out of range": encode("2025-03-05T09:00:00Z|9223372036854775808"),
+		"extra separator": encode("2025-03-05T09:00:00Z|1|2"),
+	}
+	for name, s := range tests {
+		if cursor, err := decodeNotificationCursor(s); err == nil {
+			t.Errorf("%s: decoded %q to %+v, want an error", name, s, cursor)
+		}
+	}
+}
+
+func TestParseNotificationListQueryCursor(t *testing.T) {
+	cursor := notificationCursor{CreatedAt: time.Date(2025, 3, 5, 9, 0, 0, 0, time.UTC), ID: 7}
+
+	q, problems := parseNotificationListQuery(url.Values{"cursor": {cursor.encode()}, "sort": {"created_at"}})
+	if len(problems) > 0 {
+		t.Fatalf("problems: %v", problems)
+	}
+	if q.After == nil || q.After.ID != 7 || !q.After.CreatedAt.Equal(cursor.CreatedAt) {
+		t.Fatalf("After = %+v", q.After)
+	}
+	where, args := q.where(3)
+	if !strings.Contains(where, "(n.created_at > ? OR (n.created_at = ? AND n.id > ?))") || len(args) != 4 {
+		t.Errorf("ascending page condition %q with %d args", where, len(args))
+	}
+
+	q.Ascending = false
+	if where, _ := q.where(3); !strings.Contains(where, "(n.created_at < ? OR (n.created_at = ? AND n.id < ?))") {
+		t.Errorf("descending page condition %q", where)
+	}
+
+	_, problems = parseNotificationListQuery(url.Values{"cursor": {"garbage"}})
+	if problems["cursor"] == "" {
+		t.Error("invalid cursor was not reported as a field error")
+	}
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"fmt"
+	"go-rent/config"
+	"go-rent/notify"
+)
+
+// Notifiers holds the notifier for each configured channel. Queued
+// notifications are delivered through it, so tests can swap in
+// notify.Memory notifiers.
+var Notifiers = notify.NewRegistry()
+
+// fcmNotifier is the FCM notifier, also used by the FCM test endpoints and
+// the readiness check
+var fcmNotifier *notify.FCM
+
+// ConfigureNotifiers sets up FCM and whichever of email, SMS and webhooks
+// are configured
+func ConfigureNotifiers(cfg *config.Config) error {
+	fcmNotifier = notify.NewFCM(cfg.FCM.ProjectID, cfg.FCM.CredentialsFile)
+	fcmNotifier.BaseURL = cfg.FCM.BaseURL
+	fcmNotifier.IIDBaseURL = cfg.FCM.IIDBaseURL
+	fcmNotifier.Emulator = cfg.FCM.Emulator
+	notifiers := []notify.Notifier{fcmNotifier}
+
+	if cfg.SMTP.Host != "" {
+		email, err := notify.NewEmail(cfg.SMTP.Host, cfg.SMTP.Port, cfg.SMTP.Username, cfg.SMTP.Password, cfg.SMTP.From, cfg.SMTP.TLS)
+		if err != nil {
+			return fmt.Errorf("email notifier: %v", err)
+		}
+		notifiers = append(notifiers, email)
+	}
+	if cfg.SMS.AccountSID != "" {
+		notifiers = append(notifiers, notify.NewSMS(cfg.SMS.BaseURL, cfg.SMS.AccountSID, cfg.SMS.AuthToken, cfg.SMS.From))
+	}
+	if cfg.Webhook.SigningSecret != "" {
+		notifiers = append(notifiers, notify.NewWebhook(cfg.Webhook.SigningSecret, cfg.Webhook.AllowPrivateNetworks))
+	}
+
+	Notifiers = notify.NewRegistry(notifiers...)
+	return nil
+}
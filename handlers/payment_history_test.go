@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPaymentHistoryAccess(t *testing.T) {
+	db, f := newFakeDB(t, nil)
+	f.floors = map[int64]*fakeFloor{
+		3: {tenant: 7, managers: []int64{2}},
+		4: {managers: []int64{2}},
+	}
+
+	tests := []struct {
+		name    string
+		userID  int64
+		floorID int64
+		status  int
+	}{
+		// Reading the ledger is not faked, so an allowed request to an
+		// occupied floor would fail the test
+		{"stranger", 9, 3, http.StatusForbidden},
+		{"stranger to an empty floor", 9, 4, http.StatusForbidden},
+		{"manager", 2, 4, http.StatusOK},
+		{"missing floor", 2, 5, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/floor/3/payment-history", nil)
+			r = r.WithContext(context.WithValue(r.Context(), "userID", tt.userID))
+			w := httptest.NewRecorder()
+			writePaymentHistory(w, r, db, tt.userID, tt.floorID, 1, 25, 0)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if body := w.Body.String(); strings.Contains(body, "proof") || strings.Contains(body, "transaction_reference") {
+				t.Errorf("response shows payment proofs: %s", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"go-rent/config"
+	"time"
+)
+
+// Payments form a ledger per floor and tenant. Each row stores its position
+// in the ledger (seq, counting from 1 with no gaps) and the running rent and
+// electricity balances through that row, so history pages and outstanding
+// totals are read without summing earlier rows. add_payment_balances.sql
+// backfills these columns for existing rows.
+
+// LedgerEntry is a payment to append to a tenant's ledger
+type LedgerEntry struct {
+	ID              int64
+	FloorID         int64
+	TenantID        int64
+	Rent            int
+	ReceivedMoney   int
+	FullPayment     bool
+	ElectricityBill *int
+	PaidBill        *int
+	// ProofUploadID and TransactionReference are the tenant's proof of
+	// payment, from an accepted payment notification
+	ProofUploadID        sql.NullInt64
+	TransactionReference sql.NullString
+	CreatedBy            int64
+}
+
+// RecordPayment appends a payment to the tenant's ledger within tx. It locks
+// the floor row so that concurrent payments for the same floor are numbered
+// and balanced one after another.
+func RecordPayment(tx *sql.Tx, e LedgerEntry) error {
+	var lockedFloor int64
+	if err := tx.QueryRow(`SELECT id FROM floor WHERE id = ? FOR UPDATE`, e.FloorID).Scan(&lockedFloor); err != nil {
+		return fmt.Errorf("failed to lock floor %d: %v", e.FloorID, err)
+	}
+
+	var seq int64
+	var rentBalance, electricityBalance float64
+	err := tx.QueryRow(`
+		SELECT seq, balance_rent, balance_electricity
+		FROM payment
+		WHERE fid = ? AND uid = ?
+		ORDER BY seq DESC
+		LIMIT 1`, e.FloorID, e.TenantID).Scan(&seq, &rentBalance, &electricityBalance)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to read ledger balance: %v", err)
+	}
+
+	rentBalance += float64(e.Rent - e.ReceivedMoney)
+	electricityBalance += float64(intValue(e.ElectricityBill) - intValue(e.PaidBill))
+
+	now := time.Now().In(config.App.Location).Format("2006-01-02 15:04:05")
+	_, err = tx.Exec(`
+		INSERT INTO payment (
+			id, rent, recieved_money, full_payment,
+			created_at, created_by, updated_at, updated_by,
+			fid, uid, electricity_bill, paid_bill,
+			seq, balance_rent, balance_electricity,
+			proof_upload_id, transaction_reference
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.ID, e.Rent, e.ReceivedMoney, e.FullPayment,
+		now, e.CreatedBy, now, e.CreatedBy,
+		e.FloorID, e.TenantID, e.ElectricityBill, e.PaidBill,
+		seq+1, rentBalance, electricityBalance,
+		e.ProofUploadID, e.TransactionReference,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert payment: %w", err)
+	}
+	return nil
+}
+
+// recordPaymentTx runs RecordPayment in its own transaction
+func recordPaymentTx(ctx context.Context, db *sql.DB, e LedgerEntry) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := RecordPayment(tx, e); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ledgerLength returns the number of payments in the tenant's ledger, which
+// is the seq of the latest one
+func ledgerLength(ctx context.Context, db *sql.DB, floorID, tenantID int64) (int64, error) {
+	var length int64
+	err := db.QueryRowContext(ctx, `
+		SELECT COALESCE(MAX(seq), 0)
+		FROM payment
+		WHERE fid = ? AND uid = ?`, floorID, tenantID).Scan(&length)
+	return length, err
+}
+
+// PaymentHistoryPage returns up to limit payments from the tenant's ledger,
+// newest first, starting at seq throughSeq and going back. It reads only the
+// rows it returns, so every page costs the same however deep it is.
+func PaymentHistoryPage(ctx context.Context, db *sql.DB, floorID, tenantID, throughSeq int64, limit int) ([]PaymentHistory, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT
+			pay.id, pay.seq, pay.rent, pay.recieved_money, pay.full_payment, pay.created_at,
+			pay.electricity_bill, pay.paid_bill,
+			pay.balance_rent, pay.balance_electricity,
+			pay.transaction_reference, `+paymentProofColumns+`
+		FROM payment pay
+		LEFT JOIN upload pr ON pr.id = pay.proof_upload_id
+		WHERE pay.fid = ? AND pay.uid = ? AND pay.seq <= ?
+		ORDER BY pay.seq DESC
+		LIMIT ?`, floorID, tenantID, throughSeq, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []PaymentHistory{}
+	expires := fileURLExpiry()
+	for rows.Next() {
+		var payment PaymentHistory
+		var createdAt time.Time
+		var rent, receivedMoney sql.NullInt64
+		var newAddedElectricityBill, paidElectricityBill sql.NullFloat64
+		var rentBalance, electricityBalance float64
+		var proof paymentProofRow
+		if err := rows.Scan(append([]interface{}{
+			&payment.ID, &payment.Seq, &rent, &receivedMoney, &payment.FullPayment, &createdAt,
+			&newAddedElectricityBill, &paidElectricityBill,
+			&rentBalance, &electricityBalance,
+		}, proof.dest()...)...); err != nil {
+			return nil, err
+		}
+
+		// new_added_rent is the rent charged by this row, rent the amount
+		// outstanding before it and due_rent the amount outstanding after it
+		payment.NewAddedRent = float64(rent.Int64)
+		payment.ReceivedMoney = float64(receivedMoney.Int64)
+		payment.DueRent = rentBalance
+		payment.Rent = rentBalance - float64(rent.Int64-receivedMoney.Int64)
+
+		// Electricity follows the same pattern
+		if newAddedElectricityBill.Valid {
+			payment.NewAddedElectricityBill = &newAddedElectricityBill.Float64
+		}
+		if paidElectricityBill.Valid {
+			payment.PaidElectricityBill = &paidElectricityBill.Float64
+		}
+		dueElectricityBill := electricityBalance
+		previousElectricityBill := electricityBalance - (newAddedElectricityBill.Float64 - paidElectricityBill.Float64)
+		payment.DueElectricityBill = &dueElectricityBill
+		payment.ElectricityBill = &previousElectricityBill
+
+		payment.TransactionReference = proof.transactionReference()
+		payment.PaymentProof = proof.upload(expires)
+		payment.CreatedAt = createdAt.Format("2006-01-02T15:04:05Z")
+		payments = append(payments, payment)
+	}
+	return payments, rows.Err()
+}
+
+func intValue(p *int) int {
+	if p == nil {
+		return 0
+	}
+	return *p
+}
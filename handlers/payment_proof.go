@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// A tenant's payment notification can carry proof of the transfer: an
+// upload with purpose payment_proof, such as a screenshot of the bKash
+// confirmation or a photo of a bank slip, and the transaction reference
+// (the TrxID). Managers see both on the pending notification, and they are
+// copied to the payment recorded when it is accepted. add_payment_proof.sql
+// adds the columns.
+
+// maxTransactionReferenceLength fits the transaction_reference columns
+const maxTransactionReferenceLength = 64
+
+// paymentProofColumns selects the proof upload joined as pr, after the
+// transaction_reference of the notification or payment. Scan them with
+// paymentProofRow.dest.
+const paymentProofColumns = `pr.id, pr.purpose, pr.storage_key, pr.thumbnail_key, pr.name,
+			pr.content_type, pr.size_bytes, pr.width, pr.height, pr.created_at`
+
+// paymentProofRow scans a transaction reference and paymentProofColumns
+type paymentProofRow struct {
+	reference    sql.NullString
+	id           sql.NullInt64
+	purpose      sql.NullString
+	key          sql.NullString
+	thumbnailKey sql.NullString
+	name         sql.NullString
+	contentType  sql.NullString
+	size         sql.NullInt64
+	width        sql.NullInt64
+	height       sql.NullInt64
+	createdAt    sql.NullTime
+}
+
+// dest returns the Scan destinations, in the order of
+// "transaction_reference, " + paymentProofColumns
+func (p *paymentProofRow) dest() []interface{} {
+	return []interface{}{&p.reference, &p.id, &p.purpose, &p.key, &p.thumbnailKey, &p.name,
+		&p.contentType, &p.size, &p.width, &p.height, &p.createdAt}
+}
+
+// transactionReference returns the transaction reference, or nil when
+// there is none
+func (p *paymentProofRow) transactionReference() *string {
+	if !p.reference.Valid || p.reference.String == "" {
+		return nil
+	}
+	return &p.reference.String
+}
+
+// upload returns the proof with URLs that expire at expires, or nil when
+// there is none
+func (p *paymentProofRow) upload(expires time.Time) *Upload {
+	if !p.id.Valid {
+		return nil
+	}
+	upload := &Upload{
+		ID:           p.id.Int64,
+		Purpose:      p.purpose.String,
+		Name:         p.name.String,
+		ContentType:  p.contentType.String,
+		Size:         p.size.Int64,
+		Width:        int(p.width.Int64),
+		Height:       int(p.height.Int64),
+		CreatedAt:    p.createdAt.Time,
+		key:          p.key.String,
+		thumbnailKey: p.thumbnailKey.String,
+	}
+	upload.sign(expires)
+	return upload
+}
+
+// cleanTransactionReference trims a transaction reference, returning the
+// problem with it when it is too long or has control characters
+func cleanTransactionReference(reference string) (string, string) {
+	reference = strings.TrimSpace(reference)
+	if len([]rune(reference)) > maxTransactionReferenceLength {
+		return "", fmt.Sprintf("transaction_reference must be at most %d characters", maxTransactionReferenceLength)
+	}
+	if strings.IndexFunc(reference, unicode.IsControl) >= 0 {
+		return "", "transaction_reference must not contain control characters"
+	}
+	return reference, ""
+}
@@ -1,9 +1,14 @@
 package handlers
 
 import (
+	"context"
+	"go-rent/apierror"
+	"go-rent/logger"
+	"go-rent/metrics"
 	
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"go-rent/config"
 	"go-rent/utils"
@@ -13,7 +18,6 @@ import (
 	"strings"
 	"time"
 	"github.com/gorilla/mux"
-	"log"
 	"regexp"
 )
 
@@ -25,10 +29,13 @@ func getUserIDFromContext(r *http.Request) int64 {
 	return 0
 }
 
+// PropertyRequest names the photo by URL in Photo, or as an upload with
+// purpose property_photo in PhotoUploadID
 type PropertyRequest struct {
-	Name    string  `json:"name"`
-	Address string  `json:"address"`
-	Photo   *string `json:"photo,omitempty"`
+	Name          string  `json:"name"`
+	Address       string  `json:"address"`
+	Photo         *string `json:"photo,omitempty"`
+	PhotoUploadID *int64  `json:"photo_upload_id,omitempty"`
 }
 
 type PropertyResponse struct {
@@ -37,12 +44,15 @@ type PropertyResponse struct {
 	PropertyID int64 `json:"property_id,omitempty"`
 }
 
+// Property is a property as listed to its managers and tenants. An
+// uploaded photo is given as signed URLs that expire after STORAGE_URL_TTL.
 type Property struct {
-	ID        int64   `json:"id"`
-	Name      string  `json:"name"`
-	Address   string  `json:"address"`
-	Photo     *string `json:"photo,omitempty"`
-	CreatedAt string  `json:"created_at"`
+	ID             int64   `json:"id"`
+	Name           string  `json:"name"`
+	Address        string  `json:"address"`
+	Photo          *string `json:"photo,omitempty"`
+	PhotoThumbnail *string `json:"photo_thumbnail,omitempty"`
+	CreatedAt      string  `json:"created_at"`
 }
 
 type UserPropertiesResponse struct {
@@ -122,6 +132,7 @@ type TenantRequestResponse struct {
 type Notification struct {
 	ID        int64  `json:"id"`
 	Message   string `json:"message"`
+	Kind      string `json:"kind"`
 	Status    string `json:"status"`
 	CreatedAt string `json:"created_at"`
 	Property  struct {
@@ -139,18 +150,33 @@ type Notification struct {
 	ReceiverID  *int64  `json:"receiver_id,omitempty"`
 	SenderName  *string `json:"sender_name,omitempty"`
 	ReceiverName *string `json:"receiver_name,omitempty"`
+	// Payment notifications may carry proof of the transfer
+	TransactionReference *string `json:"transaction_reference,omitempty"`
+	PaymentProof         *Upload `json:"payment_proof,omitempty"`
 }
 
 type NotificationsResponse struct {
 	Success       bool          `json:"success"`
 	Message       string        `json:"message"`
 	Notifications []Notification `json:"notifications"`
+	NextCursor    string         `json:"next_cursor,omitempty"`
+	HasMore       bool           `json:"has_more"`
+}
+
+type UnreadCountResponse struct {
+	Success     bool   `json:"success"`
+	Message     string `json:"message"`
+	UnreadCount int    `json:"unread_count"`
 }
 
 type PaymentNotificationRequest struct {
 	Amount int     `json:"amount"`
 	Month  *int    `json:"month"`
 	PaidElectricityBill *int `json:"paid_electricity_bill,omitempty"`
+	// ProofUploadID is an unused payment_proof upload of the tenant's, and
+	// TransactionReference the transfer's ID, such as a bKash TrxID
+	ProofUploadID        *int64 `json:"proof_upload_id,omitempty"`
+	TransactionReference string `json:"transaction_reference,omitempty"`
 }
 
 type AdvancePaymentRequest struct {
@@ -192,6 +218,7 @@ type PaginationInfo struct {
 	Limit       int `json:"limit"`
 	HasNextPage bool `json:"has_next_page"`
 	HasPrevPage bool `json:"has_prev_page"`
+	NextCursor  string `json:"next_cursor,omitempty"`
 }
 
 type PaymentHistoryResponse struct {
@@ -203,6 +230,7 @@ type PaymentHistoryResponse struct {
 
 type PaymentHistory struct {
 	ID           int64   `json:"id"`
+	Seq          int64   `json:"seq"`
 	NewAddedRent float64 `json:"new_added_rent"`
 	Rent         float64 `json:"rent"`
 	ReceivedMoney float64 `json:"received_money"`
@@ -213,83 +241,99 @@ type PaymentHistory struct {
 	PaidElectricityBill     *float64 `json:"paid_electricity_bill,omitempty"`
 	DueElectricityBill      *float64 `json:"due_electricity_bill,omitempty"`
 	ElectricityBill         *float64 `json:"electricity_bill,omitempty"`
+	TransactionReference    *string  `json:"transaction_reference,omitempty"`
+	PaymentProof            *Upload  `json:"payment_proof,omitempty"`
 }
 
 func AddPropertyHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("\n=== New Add Property Request ===")
-	fmt.Printf("Method: %s\n", r.Method)
-	fmt.Printf("URL: %s\n", r.URL)
-
 	// Set response header to JSON
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(PropertyResponse{false, "Method not allowed", 0})
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	// Get user ID from session
 	userID := getUserIDFromContext(r)
 	if userID == 0 {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(PropertyResponse{false, "User not authenticated", 0})
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
 	var req PropertyRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(PropertyResponse{false, "Invalid request body", 0})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
 	if req.Name == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(PropertyResponse{false, "Property name is required", 0})
+		apierror.Field(w, r, "name", "Property name is required")
 		return
 	}
 
 	db, err := config.GetDBConnection()
 	if err != nil {
-		fmt.Printf("Database connection error: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(PropertyResponse{false, "Database connection error", 0})
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
 		return
 	}
 
+	// An uploaded photo must be the manager's own and not already another
+	// property's
+	var photoUploadID interface{}
+	if req.PhotoUploadID != nil {
+		_, err := ownUpload(db, userID, *req.PhotoUploadID, uploadPurposePropertyPhoto)
+		if err == nil {
+			var used bool
+			err = db.QueryRow(`SELECT EXISTS (SELECT 1 FROM property WHERE photo_upload_id = ?)`, *req.PhotoUploadID).Scan(&used)
+			if err == nil && used {
+				err = sql.ErrNoRows
+			}
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			apierror.Field(w, r, "photo_upload_id", "photo_upload_id must be an unused property_photo upload of yours")
+			return
+		}
+		if err != nil {
+			logger.Error(r.Context(), "Error loading photo upload", "error", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "Error adding property")
+			return
+		}
+		photoUploadID = *req.PhotoUploadID
+		req.Photo = nil
+	}
+
 	// Generate random ID for property
 	randomID, err := utils.GenerateRandomID()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(PropertyResponse{false, "Error generating property ID", 0})
+		apierror.Write(w, r, http.StatusInternalServerError, "Error generating property ID")
 		return
 	}
 
 	// Insert property into database
 	_, err = db.Exec(
-		`INSERT INTO property (id, name, address, photo, created_at, created_by, updated_at, updated_by)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		`INSERT INTO property (id, name, address, photo, photo_upload_id, created_at, created_by, updated_at, updated_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		randomID,
 		req.Name,
 		req.Address,
 		req.Photo,
-		time.Now().In(time.FixedZone("BDT", 6*60*60)).Format("2006-01-02 15:04:05"),
+		photoUploadID,
+		time.Now().In(config.App.Location).Format("2006-01-02 15:04:05"),
 		userID,
-		time.Now().In(time.FixedZone("BDT", 6*60*60)).Format("2006-01-02 15:04:05"),
+		time.Now().In(config.App.Location).Format("2006-01-02 15:04:05"),
 		userID,
 	)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(PropertyResponse{false, "Error adding property", 0})
+		apierror.Write(w, r, http.StatusInternalServerError, "Error adding property")
 		return
 	}
 
 	// Insert into takes_care_of table
 	takesCareID, err := utils.GenerateRandomID()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(PropertyResponse{false, "Error generating care ID", 0})
+		apierror.Write(w, r, http.StatusInternalServerError, "Error generating care ID")
 		return
 	}
 
@@ -299,14 +343,13 @@ func AddPropertyHandler(w http.ResponseWriter, r *http.Request) {
 		takesCareID,
 		userID,
 		randomID,
-		time.Now().In(time.FixedZone("BDT", 6*60*60)).Format("2006-01-02 15:04:05"),
+		time.Now().In(config.App.Location).Format("2006-01-02 15:04:05"),
 		userID,
-		time.Now().In(time.FixedZone("BDT", 6*60*60)).Format("2006-01-02 15:04:05"),
+		time.Now().In(config.App.Location).Format("2006-01-02 15:04:05"),
 		userID,
 	)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(PropertyResponse{false, "Error saving property care details", 0})
+		apierror.Write(w, r, http.StatusInternalServerError, "Error saving property care details")
 		return
 	}
 
@@ -319,80 +362,65 @@ func AddPropertyHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func GetUserPropertiesHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("\n=== New Get User Properties Request ===")
-	fmt.Printf("Method: %s\n", r.Method)
-	fmt.Printf("URL: %s\n", r.URL)
-
 	// Set response header to JSON
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(UserPropertiesResponse{false, "Method not allowed", nil})
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	// Get user ID from session
 	userID := getUserIDFromContext(r)
 	if userID == 0 {
-		fmt.Println("No user ID found in session")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(UserPropertiesResponse{false, "User not authenticated", nil})
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
-	fmt.Printf("Fetching properties for user ID: %d\n", userID)
-
 	db, err := config.GetDBConnection()
 	if err != nil {
-		fmt.Printf("Database connection error: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(UserPropertiesResponse{false, "Database connection error", nil})
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
 		return
 	}
 
 	// Query to get all properties for the user
 	query := `
-		SELECT p.id, p.name, p.address, p.photo, p.created_at 
+		SELECT p.id, p.name, p.address, p.photo, pu.storage_key, pu.thumbnail_key, p.created_at 
 		FROM property p
+		LEFT JOIN upload pu ON pu.id = p.photo_upload_id
 		INNER JOIN takes_care_of t ON p.id = t.pid
 		WHERE t.uid = ?
 		ORDER BY p.created_at DESC`
-	
-	fmt.Printf("Executing query: %s with userID: %d\n", query, userID)
-	
+
 	rows, err := db.Query(query, userID)
 	if err != nil {
-		fmt.Printf("Error querying properties: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(UserPropertiesResponse{false, "Error fetching properties", nil})
+		logger.Error(r.Context(), "Error querying properties", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error fetching properties")
 		return
 	}
 	defer rows.Close()
 
 	var properties []Property
+	expires := fileURLExpiry()
 	for rows.Next() {
 		var prop Property
-		var photo sql.NullString
-		if err := rows.Scan(&prop.ID, &prop.Name, &prop.Address, &photo, &prop.CreatedAt); err != nil {
-			fmt.Printf("Error scanning property row: %v\n", err)
+		var photo, photoKey, thumbnailKey sql.NullString
+		if err := rows.Scan(&prop.ID, &prop.Name, &prop.Address, &photo, &photoKey, &thumbnailKey, &prop.CreatedAt); err != nil {
+			logger.Error(r.Context(), "Error scanning property row", "error", err)
 			continue
 		}
-		if photo.Valid {
-			prop.Photo = &photo.String
-		}
+		setPropertyPhoto(&prop, photo, photoKey, thumbnailKey, expires)
 		properties = append(properties, prop)
-		fmt.Printf("Found property: ID=%d, Name=%s\n", prop.ID, prop.Name)
 	}
 
 	if err = rows.Err(); err != nil {
-		fmt.Printf("Error iterating property rows: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(UserPropertiesResponse{false, "Error processing properties", nil})
+		logger.Error(r.Context(), "Error iterating property rows", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error processing properties")
 		return
 	}
 
-	fmt.Printf("Found %d properties\n", len(properties))
+	logger.Debug(r.Context(), "Properties retrieved", "count", len(properties))
 
 	response := UserPropertiesResponse{
 		Success: true,
@@ -400,66 +428,51 @@ func GetUserPropertiesHandler(w http.ResponseWriter, r *http.Request) {
 		Properties: properties,
 	}
 
-	// Log the response
-	responseJSON, _ := json.MarshalIndent(response, "", "  ")
-	fmt.Printf("Sending response: %s\n", string(responseJSON))
-
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
 }
 
 func GetPropertyByIDHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("\n=== New Get Property By ID Request ===")
-	fmt.Printf("Method: %s\n", r.Method)
-	fmt.Printf("URL: %s\n", r.URL)
-
 	// Set response header to JSON
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(SinglePropertyResponse{false, "Method not allowed", Property{}, nil, false})
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	// Get user ID from session
 	userID := getUserIDFromContext(r)
 	if userID == 0 {
-		fmt.Println("No user ID found in session")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(SinglePropertyResponse{false, "User not authenticated", Property{}, nil, false})
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
 	// Extract property ID from URL
 	pathParts := strings.Split(r.URL.Path, "/")
 	if len(pathParts) != 3 {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(SinglePropertyResponse{false, "Invalid property ID format", Property{}, nil, false})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid property ID format")
 		return
 	}
 
 	propertyID, err := strconv.ParseInt(pathParts[2], 10, 64)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(SinglePropertyResponse{false, "Invalid property ID", Property{}, nil, false})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid property ID")
 		return
 	}
 
-	fmt.Printf("Fetching property ID: %d for user ID: %d\n", propertyID, userID)
-
 	db, err := config.GetDBConnection()
 	if err != nil {
-		fmt.Printf("Database connection error: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(SinglePropertyResponse{false, "Database connection error", Property{}, nil, false})
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
 		return
 	}
 
 	// Query to get the specific property and verify user has access
 	query := `
-		SELECT p.id, p.name, p.address, p.photo, p.created_at 
+		SELECT p.id, p.name, p.address, p.photo, pu.storage_key, pu.thumbnail_key, p.created_at 
 		FROM property p
+		LEFT JOIN upload pu ON pu.id = p.photo_upload_id
 		WHERE p.id = ? AND (
 			EXISTS (
 				SELECT 1 FROM takes_care_of t 
@@ -469,21 +482,16 @@ func GetPropertyByIDHandler(w http.ResponseWriter, r *http.Request) {
 				WHERE f.pid = p.id AND f.tenant = ?
 			)
 		)`
-	
-	fmt.Printf("Executing query: %s with propertyID: %d, userID: %d\n", query, propertyID, userID, userID)
-	
+
 	var prop Property
-	var photo sql.NullString
-	err = db.QueryRow(query, propertyID, userID, userID).Scan(&prop.ID, &prop.Name, &prop.Address, &photo, &prop.CreatedAt)
+	var photo, photoKey, thumbnailKey sql.NullString
+	err = db.QueryRow(query, propertyID, userID, userID).Scan(&prop.ID, &prop.Name, &prop.Address, &photo, &photoKey, &thumbnailKey, &prop.CreatedAt)
 	if err != nil {
-		fmt.Printf("Error querying property: %v\n", err)
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(SinglePropertyResponse{false, "Property not found or access denied", Property{}, nil, false})
+		logger.Error(r.Context(), "Error querying property", "error", err)
+		apierror.Write(w, r, http.StatusNotFound, "Property not found or access denied")
 		return
 	}
-	if photo.Valid {
-		prop.Photo = &photo.String
-	}
+	setPropertyPhoto(&prop, photo, photoKey, thumbnailKey, fileURLExpiry())
 
 	// Get all floors for this property with tenant names
 	floorsQuery := `
@@ -515,9 +523,8 @@ func GetPropertyByIDHandler(w http.ResponseWriter, r *http.Request) {
 	
 	rows, err := db.Query(floorsQuery, propertyID)
 	if err != nil {
-		fmt.Printf("Error querying floors: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(SinglePropertyResponse{false, "Error fetching floors", prop, nil, false})
+		logger.Error(r.Context(), "Error querying floors", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error fetching floors")
 		return
 	}
 	defer rows.Close()
@@ -531,7 +538,7 @@ func GetPropertyByIDHandler(w http.ResponseWriter, r *http.Request) {
 		var notificationID sql.NullInt64
 		var hasPendingAdvancePayment bool
 		if err := rows.Scan(&floor.ID, &floor.Name, &floor.Rent, &floor.CreatedAt, &tenant, &tenantName, &hasPendingRequest, &notificationID, &hasPendingAdvancePayment); err != nil {
-			fmt.Printf("Error scanning floor row: %v\n", err)
+			logger.Error(r.Context(), "Error scanning floor row", "error", err)
 			continue
 		}
 		if tenant.Valid {
@@ -550,8 +557,6 @@ func GetPropertyByIDHandler(w http.ResponseWriter, r *http.Request) {
 		floors = append(floors, floor)
 	}
 
-	fmt.Printf("Found property: ID=%d, Name=%s with %d floors\n", prop.ID, prop.Name, len(floors))
-
 	// Check if user is a manager of this property
 	var isManager bool
 	err = db.QueryRow(`
@@ -561,12 +566,10 @@ func GetPropertyByIDHandler(w http.ResponseWriter, r *http.Request) {
 		)`, userID, propertyID).Scan(&isManager)
 	
 	if err != nil {
-		fmt.Printf("Error checking manager status: %v\n", err)
+		logger.Error(r.Context(), "Error checking manager status", "error", err)
 		isManager = false
 	}
 
-	fmt.Printf("User %d is manager of property %d: %v\n", userID, propertyID, isManager)
-
 	response := SinglePropertyResponse{
 		Success: true,
 		Message: "Property retrieved successfully",
@@ -575,10 +578,6 @@ func GetPropertyByIDHandler(w http.ResponseWriter, r *http.Request) {
 		IsManager: isManager,
 	}
 
-	// Log the response
-	responseJSON, _ := json.MarshalIndent(response, "", "  ")
-	fmt.Printf("Sending response: %s\n", string(responseJSON))
-
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
 }
@@ -616,8 +615,7 @@ func HandlePropertyRoutes(w http.ResponseWriter, r *http.Request) {
 		case http.MethodPost:
 			AddFloorHandler(w, r)
 		default:
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			json.NewEncoder(w).Encode(FloorResponse{false, "Method not allowed", 0})
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		}
 	case len(cleanParts) == 4 && cleanParts[0] == "property" && cleanParts[2] == "floor":
 		// /property/{id}/floor/{floor_id}
@@ -629,8 +627,7 @@ func HandlePropertyRoutes(w http.ResponseWriter, r *http.Request) {
 		case http.MethodPost:
 			SendTenantRequestHandler(w, r)
 		default:
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			json.NewEncoder(w).Encode(FloorResponse{false, "Method not allowed", 0})
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		}
 	case len(cleanParts) == 5 && cleanParts[0] == "property" && cleanParts[2] == "floor" && cleanParts[4] == "payment":
 		// /property/{id}/floor/{floor_id}/payment
@@ -638,8 +635,7 @@ func HandlePropertyRoutes(w http.ResponseWriter, r *http.Request) {
 		case http.MethodPost:
 			CreatePaymentHandler(w, r)
 		default:
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			json.NewEncoder(w).Encode(PaymentResponse{false, "Method not allowed", 0})
+			apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		}
 	default:
 		http.NotFound(w, r)
@@ -647,25 +643,18 @@ func HandlePropertyRoutes(w http.ResponseWriter, r *http.Request) {
 }
 
 func AddFloorHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("\n=== New Add Floor Request ===")
-	fmt.Printf("Method: %s\n", r.Method)
-	fmt.Printf("URL: %s\n", r.URL)
-
 	// Set response header to JSON
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(FloorResponse{false, "Method not allowed", 0})
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	// Get user ID from session
 	userID := getUserIDFromContext(r)
 	if userID == 0 {
-		fmt.Println("No user ID found in session")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(FloorResponse{false, "User not authenticated", 0})
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
@@ -679,36 +668,31 @@ func AddFloorHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if len(cleanParts) != 3 {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(FloorResponse{false, "Invalid URL format", 0})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid URL format")
 		return
 	}
 
 	propertyID, err := strconv.ParseInt(cleanParts[1], 10, 64)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(FloorResponse{false, "Invalid property ID", 0})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid property ID")
 		return
 	}
 
 	var req FloorRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(FloorResponse{false, "Invalid request body", 0})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
 	if req.Name == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(FloorResponse{false, "Floor name is required", 0})
+		apierror.Field(w, r, "name", "Floor name is required")
 		return
 	}
 
 	db, err := config.GetDBConnection()
 	if err != nil {
-		fmt.Printf("Database connection error: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(FloorResponse{false, "Database connection error", 0})
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
 		return
 	}
 
@@ -721,17 +705,15 @@ func AddFloorHandler(w http.ResponseWriter, r *http.Request) {
 		)`, propertyID, userID).Scan(&exists)
 	
 	if err != nil || !exists {
-		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(FloorResponse{false, "Access denied to property", 0})
+		apierror.Write(w, r, http.StatusForbidden, "Access denied to property")
 		return
 	}
 
 	// Generate random ID for floor
 	floorID, err := utils.GenerateRandomID()
 	if err != nil {
-		fmt.Printf("Error generating random ID: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(FloorResponse{false, "Error generating floor ID", 0})
+		logger.Error(r.Context(), "Error generating random ID", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error generating floor ID")
 		return
 	}
 
@@ -742,20 +724,19 @@ func AddFloorHandler(w http.ResponseWriter, r *http.Request) {
 		floorID,
 		req.Name,
 		req.Rent,
-		time.Now().In(time.FixedZone("BDT", 6*60*60)).Format("2006-01-02 15:04:05"),
+		time.Now().In(config.App.Location).Format("2006-01-02 15:04:05"),
 		userID,
-		time.Now().In(time.FixedZone("BDT", 6*60*60)).Format("2006-01-02 15:04:05"),
+		time.Now().In(config.App.Location).Format("2006-01-02 15:04:05"),
 		userID,
 		propertyID,
 	)
 	if err != nil {
-		fmt.Printf("Error inserting floor: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(FloorResponse{false, "Error adding floor", 0})
+		logger.Error(r.Context(), "Error inserting floor", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error adding floor")
 		return
 	}
 
-	fmt.Printf("Successfully added floor ID: %d to property ID: %d\n", floorID, propertyID)
+	logger.Info(r.Context(), "Floor added", "floor_id", floorID)
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(FloorResponse{
@@ -767,19 +748,13 @@ func AddFloorHandler(w http.ResponseWriter, r *http.Request) {
 
 // GetFloorsHandler handles GET requests for floors of a property
 func GetFloorsHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("\n=== New Get Floors Request ===")
-	fmt.Printf("Method: %s\n", r.Method)
-	fmt.Printf("URL: %s\n", r.URL)
-
 	// Set response header to JSON
 	w.Header().Set("Content-Type", "application/json")
 
 	// Get user ID from session
 	userID := getUserIDFromContext(r)
 	if userID == 0 {
-		fmt.Println("No user ID found in session")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(FloorResponse{false, "User not authenticated", 0})
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
@@ -793,23 +768,20 @@ func GetFloorsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if len(cleanParts) != 3 {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(FloorResponse{false, "Invalid URL format", 0})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid URL format")
 		return
 	}
 
 	propertyID, err := strconv.ParseInt(cleanParts[1], 10, 64)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(FloorResponse{false, "Invalid property ID", 0})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid property ID")
 		return
 	}
 
 	db, err := config.GetDBConnection()
 	if err != nil {
-		fmt.Printf("Database connection error: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(FloorResponse{false, "Database connection error", 0})
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
 		return
 	}
 
@@ -822,8 +794,7 @@ func GetFloorsHandler(w http.ResponseWriter, r *http.Request) {
 		)`, propertyID, userID).Scan(&exists)
 	
 	if err != nil || !exists {
-		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(FloorResponse{false, "Access denied to property", 0})
+		apierror.Write(w, r, http.StatusForbidden, "Access denied to property")
 		return
 	}
 
@@ -849,9 +820,8 @@ func GetFloorsHandler(w http.ResponseWriter, r *http.Request) {
 		ORDER BY f.created_at DESC`, propertyID)
 	
 	if err != nil {
-		fmt.Printf("Error querying floors: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(FloorResponse{false, "Error fetching floors", 0})
+		logger.Error(r.Context(), "Error querying floors", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error fetching floors")
 		return
 	}
 	defer rows.Close()
@@ -864,7 +834,7 @@ func GetFloorsHandler(w http.ResponseWriter, r *http.Request) {
 		var hasPendingRequest bool
 		var hasPendingAdvancePayment bool
 		if err := rows.Scan(&floor.ID, &floor.Name, &floor.Rent, &floor.CreatedAt, &tenant, &tenantName, &hasPendingRequest, &hasPendingAdvancePayment); err != nil {
-			fmt.Printf("Error scanning floor row: %v\n", err)
+			logger.Error(r.Context(), "Error scanning floor row", "error", err)
 			continue
 		}
 		if tenant.Valid {
@@ -880,7 +850,7 @@ func GetFloorsHandler(w http.ResponseWriter, r *http.Request) {
 		floors = append(floors, floor)
 	}
 
-	fmt.Printf("Found %d floors for property ID: %d\n", len(floors), propertyID)
+	logger.Debug(r.Context(), "Floors retrieved", "count", len(floors))
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -892,19 +862,13 @@ func GetFloorsHandler(w http.ResponseWriter, r *http.Request) {
 
 // GetFloorByIDHandler handles GET requests for a specific floor
 func GetFloorByIDHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("\n=== New Get Floor By ID Request ===")
-	fmt.Printf("Method: %s\n", r.Method)
-	fmt.Printf("URL: %s\n", r.URL)
-
 	// Set response header to JSON
 	w.Header().Set("Content-Type", "application/json")
 
 	// Get user ID from session
 	userID := getUserIDFromContext(r)
 	if userID == 0 {
-		fmt.Println("No user ID found in session")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(FloorResponse{false, "User not authenticated", 0})
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
@@ -918,30 +882,26 @@ func GetFloorByIDHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if len(cleanParts) != 4 {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(FloorResponse{false, "Invalid URL format", 0})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid URL format")
 		return
 	}
 
 	propertyID, err := strconv.ParseInt(cleanParts[1], 10, 64)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(FloorResponse{false, "Invalid property ID", 0})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid property ID")
 		return
 	}
 
 	floorID, err := strconv.ParseInt(cleanParts[3], 10, 64)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(FloorResponse{false, "Invalid floor ID", 0})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid floor ID")
 		return
 	}
 
 	db, err := config.GetDBConnection()
 	if err != nil {
-		fmt.Printf("Database connection error: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(FloorResponse{false, "Database connection error", 0})
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
 		return
 	}
 
@@ -954,8 +914,7 @@ func GetFloorByIDHandler(w http.ResponseWriter, r *http.Request) {
 		)`, propertyID, userID).Scan(&exists)
 	
 	if err != nil || !exists {
-		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(FloorResponse{false, "Access denied to property", 0})
+		apierror.Write(w, r, http.StatusForbidden, "Access denied to property")
 		return
 	}
 
@@ -975,9 +934,8 @@ func GetFloorByIDHandler(w http.ResponseWriter, r *http.Request) {
 		&floor.ID, &floor.Name, &floor.Rent, &floor.CreatedAt, &tenant, &tenantName)
 	
 	if err != nil {
-		fmt.Printf("Error querying floor: %v\n", err)
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(FloorResponse{false, "Floor not found", 0})
+		logger.Error(r.Context(), "Error querying floor", "error", err)
+		apierror.Write(w, r, http.StatusNotFound, "Floor not found")
 		return
 	}
 
@@ -988,8 +946,6 @@ func GetFloorByIDHandler(w http.ResponseWriter, r *http.Request) {
 		floor.TenantName = &tenantName.String
 	}
 
-	fmt.Printf("Found floor: ID=%d, Name=%s\n", floor.ID, floor.Name)
-
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success": true,
@@ -1000,19 +956,13 @@ func GetFloorByIDHandler(w http.ResponseWriter, r *http.Request) {
 
 // UpdateFloorHandler handles PUT requests to update a floor
 func UpdateFloorHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("\n=== New Update Floor Request ===")
-	fmt.Printf("Method: %s\n", r.Method)
-	fmt.Printf("URL: %s\n", r.URL)
-
 	// Set response header to JSON
 	w.Header().Set("Content-Type", "application/json")
 
 	// Get user ID from session
 	userID := getUserIDFromContext(r)
 	if userID == 0 {
-		fmt.Println("No user ID found in session")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(FloorResponse{false, "User not authenticated", 0})
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
@@ -1026,43 +976,37 @@ func UpdateFloorHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if len(cleanParts) != 4 {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(FloorResponse{false, "Invalid URL format", 0})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid URL format")
 		return
 	}
 
 	propertyID, err := strconv.ParseInt(cleanParts[1], 10, 64)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(FloorResponse{false, "Invalid property ID", 0})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid property ID")
 		return
 	}
 
 	floorID, err := strconv.ParseInt(cleanParts[3], 10, 64)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(FloorResponse{false, "Invalid floor ID", 0})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid floor ID")
 		return
 	}
 
 	var req FloorRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(FloorResponse{false, "Invalid request body", 0})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
 	if req.Name == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(FloorResponse{false, "Floor name is required", 0})
+		apierror.Field(w, r, "name", "Floor name is required")
 		return
 	}
 
 	db, err := config.GetDBConnection()
 	if err != nil {
-		fmt.Printf("Database connection error: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(FloorResponse{false, "Database connection error", 0})
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
 		return
 	}
 
@@ -1075,8 +1019,14 @@ func UpdateFloorHandler(w http.ResponseWriter, r *http.Request) {
 		)`, propertyID, userID).Scan(&exists)
 	
 	if err != nil || !exists {
-		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(FloorResponse{false, "Access denied to property", 0})
+		apierror.Write(w, r, http.StatusForbidden, "Access denied to property")
+		return
+	}
+
+	previousTenant, err := floorTenant(db, propertyID, floorID)
+	if err != nil {
+		logger.Error(r.Context(), "Error getting floor", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error updating floor")
 		return
 	}
 
@@ -1085,56 +1035,49 @@ func UpdateFloorHandler(w http.ResponseWriter, r *http.Request) {
 		UPDATE floor 
 		SET name = ?, rent = ?, tenant = ?, updated_at = ?, updated_by = ?
 		WHERE id = ? AND pid = ?`,
-		req.Name, req.Rent, req.Tenant, time.Now().In(time.FixedZone("BDT", 6*60*60)).Format("2006-01-02 15:04:05"), userID, floorID, propertyID)
+		req.Name, req.Rent, req.Tenant, time.Now().In(config.App.Location).Format("2006-01-02 15:04:05"), userID, floorID, propertyID)
 	
 	if err != nil {
-		fmt.Printf("Error updating floor: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(FloorResponse{false, "Error updating floor", 0})
+		logger.Error(r.Context(), "Error updating floor", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error updating floor")
 		return
 	}
 
+	var newTenant int64
+	if req.Tenant != nil {
+		newTenant = *req.Tenant
+	}
+	tenantChanged(r.Context(), propertyID, previousTenant, newTenant)
+
 	// If tenant is being added, create a payment record
 	if req.Tenant != nil {
 		// Generate random ID for payment
 		paymentID, err := utils.GenerateRandomID()
 		if err != nil {
-			fmt.Printf("Error generating payment ID: %v\n", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(FloorResponse{false, "Error generating payment ID", 0})
+			logger.Error(r.Context(), "Error generating payment ID", "error", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "Error generating payment ID")
 			return
 		}
 
-		// Insert payment record
-		_, err = db.Exec(`
-			INSERT INTO payment (
-                                id, rent, recieved_money, 
-				full_payment, created_at, created_by, updated_at, updated_by,
-				fid, uid
-                        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-			paymentID,
-			0, // due_rent
-			0, // received_money
-			true, // full_payment
-			time.Now().In(time.FixedZone("BDT", 6*60*60)).Format("2006-01-02 15:04:05"),
-			userID,
-			time.Now().In(time.FixedZone("BDT", 6*60*60)).Format("2006-01-02 15:04:05"),
-			userID,
-			floorID,
-			*req.Tenant,
-		)
+		// Start the tenant's ledger with an empty entry
+		err = recordPaymentTx(r.Context(), db, LedgerEntry{
+			ID:          paymentID,
+			FloorID:     floorID,
+			TenantID:    *req.Tenant,
+			FullPayment: true,
+			CreatedBy:   userID,
+		})
 
 		if err != nil {
-			fmt.Printf("Error creating payment record: %v\n", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(FloorResponse{false, fmt.Sprintf("Error creating payment record: %v", err), 0})
+			logger.Error(r.Context(), "Error creating payment record", "error", err)
+			apierror.Write(w, r, http.StatusInternalServerError, fmt.Sprintf("Error creating payment record: %v", err))
 			return
 		}
 
-		fmt.Printf("Successfully created payment record for floor ID: %d and tenant ID: %d\n", floorID, *req.Tenant)
+		logger.Info(r.Context(), "Payment record created for new tenant", "tenant_id", *req.Tenant)
 	}
 
-	fmt.Printf("Successfully updated floor ID: %d\n", floorID)
+	logger.Info(r.Context(), "Floor updated", "floor_id", floorID)
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(FloorResponse{
@@ -1146,33 +1089,25 @@ func UpdateFloorHandler(w http.ResponseWriter, r *http.Request) {
 
 // GetUserPhonesHandler handles GET requests for all users' phone numbers
 func GetUserPhonesHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("\n=== New Get User Phones Request ===")
-	fmt.Printf("Method: %s\n", r.Method)
-	fmt.Printf("URL: %s\n", r.URL)
-
 	// Set response header to JSON
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(UserPhonesResponse{false, "Method not allowed", nil})
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	// Get user ID from session
 	userID := getUserIDFromContext(r)
 	if userID == 0 {
-		fmt.Println("No user ID found in session")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(UserPhonesResponse{false, "User not authenticated", nil})
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
 	db, err := config.GetDBConnection()
 	if err != nil {
-		fmt.Printf("Database connection error: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(UserPhonesResponse{false, "Database connection error", nil})
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
 		return
 	}
 
@@ -1182,14 +1117,11 @@ func GetUserPhonesHandler(w http.ResponseWriter, r *http.Request) {
 		FROM user 
 		WHERE phone_number IS NOT NULL AND phone_number != ''
 		ORDER BY id DESC`
-	
-	fmt.Printf("Executing query: %s\n", query)
-	
+
 	rows, err := db.Query(query)
 	if err != nil {
-		fmt.Printf("Error querying users: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(UserPhonesResponse{false, "Error fetching users", nil})
+		logger.Error(r.Context(), "Error querying users", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error fetching users")
 		return
 	}
 	defer rows.Close()
@@ -1198,21 +1130,19 @@ func GetUserPhonesHandler(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var user UserPhone
 		if err := rows.Scan(&user.ID, &user.Phone); err != nil {
-			fmt.Printf("Error scanning user row: %v\n", err)
+			logger.Error(r.Context(), "Error scanning user row", "error", err)
 			continue
 		}
 		users = append(users, user)
-		fmt.Printf("Found user: ID=%d, Phone=%s\n", user.ID, user.Phone)
 	}
 
 	if err = rows.Err(); err != nil {
-		fmt.Printf("Error iterating user rows: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(UserPhonesResponse{false, "Error processing users", nil})
+		logger.Error(r.Context(), "Error iterating user rows", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error processing users")
 		return
 	}
 
-	fmt.Printf("Found %d users with phone numbers\n", len(users))
+	logger.Debug(r.Context(), "Users with phone numbers retrieved", "count", len(users))
 
 	response := UserPhonesResponse{
 		Success: true,
@@ -1220,35 +1150,24 @@ func GetUserPhonesHandler(w http.ResponseWriter, r *http.Request) {
 		Users:   users,
 	}
 
-	// Log the response
-	responseJSON, _ := json.MarshalIndent(response, "", "  ")
-	fmt.Printf("Sending response: %s\n", string(responseJSON))
-
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
 }
 
 // GetUserIDByPhoneHandler handles GET requests to get user ID by phone number
 func GetUserIDByPhoneHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("\n=== New Get User ID By Phone Request ===")
-	fmt.Printf("Method: %s\n", r.Method)
-	fmt.Printf("URL: %s\n", r.URL)
-
 	// Set response header to JSON
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(UserIDResponse{false, "Method not allowed", 0})
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	// Get user ID from session
 	userID := getUserIDFromContext(r)
 	if userID == 0 {
-		fmt.Println("No user ID found in session")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(UserIDResponse{false, "User not authenticated", 0})
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
@@ -1262,23 +1181,20 @@ func GetUserIDByPhoneHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if len(cleanParts) != 3 {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(UserIDResponse{false, "Invalid URL format", 0})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid URL format")
 		return
 	}
 
 	phoneNumber := cleanParts[2]
 	if phoneNumber == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(UserIDResponse{false, "Phone number is required", 0})
+		apierror.Field(w, r, "phone_number", "Phone number is required")
 		return
 	}
 
 	db, err := config.GetDBConnection()
 	if err != nil {
-		fmt.Printf("Database connection error: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(UserIDResponse{false, "Database connection error", 0})
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
 		return
 	}
 
@@ -1291,18 +1207,16 @@ func GetUserIDByPhoneHandler(w http.ResponseWriter, r *http.Request) {
 	
 	if err != nil {
 		if err == sql.ErrNoRows {
-			fmt.Printf("No user found with phone number: %s\n", phoneNumber)
-			w.WriteHeader(http.StatusNotFound)
-			json.NewEncoder(w).Encode(UserIDResponse{false, "User not found", 0})
+			logger.Debug(r.Context(), "No user found for phone number", "phone_number", phoneNumber)
+			apierror.Write(w, r, http.StatusNotFound, "User not found")
 			return
 		}
-		fmt.Printf("Error querying user: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(UserIDResponse{false, "Error fetching user", 0})
+		logger.Error(r.Context(), "Error querying user", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error fetching user")
 		return
 	}
 
-	fmt.Printf("Found user ID: %d for phone number: %s\n", foundUserID, phoneNumber)
+	logger.Debug(r.Context(), "User found for phone number", "found_user_id", foundUserID)
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(UserIDResponse{
@@ -1314,26 +1228,18 @@ func GetUserIDByPhoneHandler(w http.ResponseWriter, r *http.Request) {
 
 // CreatePaymentHandler handles POST requests to create a payment record
 func CreatePaymentHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("\n=== New Create Payment Request ===")
-	fmt.Printf("Method: %s\n", r.Method)
-	fmt.Printf("URL: %s\n", r.URL)
-
 	// Set response header to JSON
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(PaymentResponse{false, "Method not allowed", 0})
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	// Get user ID from session
 	userID := getUserIDFromContext(r)
-	fmt.Printf("User ID from session: %d\n", userID)
 	if userID == 0 {
-		fmt.Println("No user ID found in session")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(PaymentResponse{false, "User not authenticated", 0})
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
@@ -1346,66 +1252,50 @@ func CreatePaymentHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	fmt.Printf("URL parts: %v\n", cleanParts)
-
 	if len(cleanParts) != 5 {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(PaymentResponse{false, "Invalid URL format", 0})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid URL format")
 		return
 	}
 
 	propertyID, err := strconv.ParseInt(cleanParts[1], 10, 64)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(PaymentResponse{false, "Invalid property ID", 0})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid property ID")
 		return
 	}
 
 	floorID, err := strconv.ParseInt(cleanParts[3], 10, 64)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(PaymentResponse{false, "Invalid floor ID", 0})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid floor ID")
 		return
 	}
 
-	fmt.Printf("Property ID: %d, Floor ID: %d\n", propertyID, floorID)
-
 	var req PaymentRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		fmt.Printf("Error decoding request body: %v\n", err)
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(PaymentResponse{false, "Invalid request body", 0})
+		logger.Error(r.Context(), "Error decoding request body", "error", err)
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
-	fmt.Printf("Request body: %+v\n", req)
-
 	db, err := config.GetDBConnection()
 	if err != nil {
-		fmt.Printf("Database connection error: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(PaymentResponse{false, "Database connection error", 0})
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
 		return
 	}
 
 	// Check if user is a manager of the property
 	var isManager bool
 	managerQuery := "SELECT EXISTS(SELECT 1 FROM takes_care_of WHERE uid = ? AND pid = ?)"
-	fmt.Printf("Executing manager check query: %s with userID: %d, propertyID: %d\n", managerQuery, userID, propertyID)
 	
 	err = db.QueryRow(managerQuery, userID, propertyID).Scan(&isManager)
 	if err != nil {
-		fmt.Printf("Error checking manager status: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(PaymentResponse{false, "Database error", 0})
+		logger.Error(r.Context(), "Error checking manager status", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database error")
 		return
 	}
 
-	fmt.Printf("Is user %d manager of property %d: %v\n", userID, propertyID, isManager)
-
 	if !isManager {
-		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(PaymentResponse{false, "Only managers can create payments", 0})
+		apierror.Write(w, r, http.StatusForbidden, "Only managers can create payments")
 		return
 	}
 
@@ -1417,15 +1307,13 @@ func CreatePaymentHandler(w http.ResponseWriter, r *http.Request) {
 		WHERE id = ? AND pid = ?`, floorID, propertyID).Scan(&tenantID)
 	
 	if err != nil {
-		fmt.Printf("Error getting tenant ID: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(PaymentResponse{false, "Error getting tenant information", 0})
+		logger.Error(r.Context(), "Error getting tenant ID", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error getting tenant information")
 		return
 	}
 
 	if !tenantID.Valid {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(PaymentResponse{false, "No tenant assigned to this floor", 0})
+		apierror.Write(w, r, http.StatusBadRequest, "No tenant assigned to this floor")
 		return
 	}
 	
@@ -1435,49 +1323,36 @@ func CreatePaymentHandler(w http.ResponseWriter, r *http.Request) {
 	// Set full_payment based on after_receiving_money
 	fullPayment := afterReceivingMoney == 0
 
-        fmt.Printf("Rent: %d, Received: %d, After receiving: %d, Full payment: %v\n", 
-                req.Rent, req.ReceivedMoney, afterReceivingMoney, fullPayment)
+        logger.Debug(r.Context(), "Payment computed", "rent", req.Rent, "received_money", req.ReceivedMoney, "after_receiving", afterReceivingMoney, "full_payment", fullPayment)
 
 	// Always create a new payment record
 	paymentID, err := utils.GenerateRandomID()
 	if err != nil {
-		fmt.Printf("Error generating payment ID: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(PaymentResponse{false, "Error generating payment ID", 0})
+		logger.Error(r.Context(), "Error generating payment ID", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error generating payment ID")
 		return
 	}
 
-	        	// Insert new payment record
-	_, err = db.Exec(`
-		INSERT INTO payment (
-                        id, rent, recieved_money, 
-			full_payment, created_at, created_by, updated_at, updated_by,
-			fid, uid, electricity_bill, paid_bill
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		paymentID,
-                req.Rent,
-		req.ReceivedMoney,
-		fullPayment,
-		time.Now().In(time.FixedZone("BDT", 6*60*60)).Format("2006-01-02 15:04:05"),
-		userID,
-		time.Now().In(time.FixedZone("BDT", 6*60*60)).Format("2006-01-02 15:04:05"),
-		userID,
-		floorID,
-		tenantID.Int64,
-		req.ElectricityBill,
-		0, // paid_bill is 0 (NULL) for new payments
-	)
+	// Insert new payment record
+	err = recordPaymentTx(r.Context(), db, LedgerEntry{
+		ID:              paymentID,
+		FloorID:         floorID,
+		TenantID:        tenantID.Int64,
+		Rent:            req.Rent,
+		ReceivedMoney:   req.ReceivedMoney,
+		FullPayment:     fullPayment,
+		ElectricityBill: req.ElectricityBill,
+		PaidBill:        new(int), // nothing paid against the new bill yet
+		CreatedBy:       userID,
+	})
 
 	if err != nil {
-		fmt.Printf("Error creating payment record: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(PaymentResponse{false, fmt.Sprintf("Error creating payment record: %v", err), 0})
+		logger.Error(r.Context(), "Error creating payment record", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, fmt.Sprintf("Error creating payment record: %v", err))
 		return
 	}
 
-	fmt.Printf("Successfully created new payment record ID: %d for floor ID: %d and tenant ID: %d\n", paymentID, floorID, tenantID.Int64)
-
-	fmt.Printf("Successfully created payment record ID: %d for floor ID: %d and tenant ID: %d\n", paymentID, floorID, tenantID.Int64)
+	logger.Info(r.Context(), "Payment record created", "payment_id", paymentID, "tenant_id", tenantID.Int64)
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(PaymentResponse{
@@ -1489,24 +1364,18 @@ func CreatePaymentHandler(w http.ResponseWriter, r *http.Request) {
 
 // SendTenantRequestHandler handles POST requests to send a tenant request
 func SendTenantRequestHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("\n=== New Send Tenant Request ===")
-	fmt.Printf("Method: %s\n", r.Method)
-	fmt.Printf("URL: %s\n", r.URL)
-
 	// Set response header to JSON
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "Method not allowed"})
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	// Get user ID from session
 	userID := getUserIDFromContext(r)
 	if userID == 0 {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "User not authenticated"})
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
@@ -1514,13 +1383,13 @@ func SendTenantRequestHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	propertyID, err := strconv.ParseInt(vars["id"], 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid property ID", http.StatusBadRequest)
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid property ID")
 		return
 	}
 
 	floorID, err := strconv.ParseInt(vars["floor_id"], 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid floor ID", http.StatusBadRequest)
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid floor ID")
 		return
 	}
 
@@ -1529,21 +1398,18 @@ func SendTenantRequestHandler(w http.ResponseWriter, r *http.Request) {
 		PhoneNumber string `json:"phone_number"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "Invalid request body"})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
 	if req.PhoneNumber == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "Phone number is required"})
+		apierror.Field(w, r, "phone_number", "Phone number is required")
 		return
 	}
 
 	db, err := config.GetDBConnection()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "Database connection error"})
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
 		return
 	}
 
@@ -1556,8 +1422,7 @@ func SendTenantRequestHandler(w http.ResponseWriter, r *http.Request) {
 		)`, userID, propertyID).Scan(&isManager)
 	
 	if err != nil || !isManager {
-		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "Only managers can send tenant requests"})
+		apierror.Write(w, r, http.StatusForbidden, "Only managers can send tenant requests")
 		return
 	}
 
@@ -1570,8 +1435,7 @@ func SendTenantRequestHandler(w http.ResponseWriter, r *http.Request) {
 		WHERE p.id = ? AND f.id = ?`, propertyID, floorID).Scan(&propertyName, &floorName)
 	
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "Error getting property details"})
+		apierror.Write(w, r, http.StatusInternalServerError, "Error getting property details")
 		return
 	}
 
@@ -1583,12 +1447,10 @@ func SendTenantRequestHandler(w http.ResponseWriter, r *http.Request) {
 	
 	if err != nil {
 		if err == sql.ErrNoRows {
-			w.WriteHeader(http.StatusNotFound)
-			json.NewEncoder(w).Encode(TenantRequestResponse{false, "User not found with this phone number"})
+			apierror.Write(w, r, http.StatusNotFound, "User not found with this phone number")
 			return
 		}
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "Error finding user"})
+		apierror.Write(w, r, http.StatusInternalServerError, "Error finding user")
 		return
 	}
 
@@ -1602,14 +1464,12 @@ func SendTenantRequestHandler(w http.ResponseWriter, r *http.Request) {
 		)`, floorID).Scan(&pendingExists)
 	
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "Error checking pending notifications"})
+		apierror.Write(w, r, http.StatusInternalServerError, "Error checking pending notifications")
 		return
 	}
 
 	if pendingExists {
-		w.WriteHeader(http.StatusConflict)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "A pending request already exists for this floor"})
+		apierror.Write(w, r, http.StatusConflict, "A pending request already exists for this floor")
 		return
 	}
 
@@ -1617,10 +1477,9 @@ func SendTenantRequestHandler(w http.ResponseWriter, r *http.Request) {
 	message := fmt.Sprintf("Tenant request for %s - %s", propertyName, floorName)
 
 	// Create notification with push notification
-	err = SendNotificationWithPush(userID, tenantID, propertyID, floorID, message, "pending", nil)
+	err = SendNotificationWithPush(r.Context(), userID, tenantID, propertyID, floorID, message, "pending", nil)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "Error creating notification"})
+		apierror.Write(w, r, http.StatusInternalServerError, "Error creating notification")
 		return
 	}
 
@@ -1646,40 +1505,43 @@ func SendTenantRequestHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// GetUserNotificationsHandler handles GET requests to get all notifications for a user
+// GetUserNotificationsHandler handles GET requests to list the user's
+// notifications, one page at a time. See parseNotificationListQuery for the
+// filters; next_cursor fetches the following page.
 func GetUserNotificationsHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("\n=== New Get User Notifications Request ===")
-	fmt.Printf("Method: %s\n", r.Method)
-	fmt.Printf("URL: %s\n", r.URL)
-
 	// Set response header to JSON
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(NotificationsResponse{false, "Method not allowed", nil})
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	// Get user ID from session
 	userID := getUserIDFromContext(r)
 	if userID == 0 {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(NotificationsResponse{false, "User not authenticated", nil})
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	listQuery, problems := parseNotificationListQuery(r.URL.Query())
+	if len(problems) > 0 {
+		apierror.WriteFields(w, r, "Invalid notification filters", problems)
 		return
 	}
 
 	db, err := config.GetDBConnection()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(NotificationsResponse{false, "Database connection error", nil})
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
 		return
 	}
 
-	// Get all notifications for the user (only received notifications)
+	// Get the page of received notifications, plus one row to tell whether
+	// there is another page
+	where, args := listQuery.where(userID)
 	query := `
 		SELECT 
-			n.id, n.message, n.status, n.created_at,
+			n.id, n.message, n.kind, n.status, n.created_at,
 			p.id as property_id, p.name as property_name,
 			f.id as floor_id, f.name as floor_name,
 			CASE 
@@ -1690,34 +1552,45 @@ func GetUserNotificationsHandler(w http.ResponseWriter, r *http.Request) {
 			n.comment,
 			n.sender, n.receiver,
 			u1.name as sender_name,
-			u2.name as receiver_name
+			u2.name as receiver_name,
+			n.transaction_reference, ` + paymentProofColumns + `
 		FROM notification n
 		JOIN property p ON n.pid = p.id
 		JOIN floor f ON n.fid = f.id
 		JOIN user u1 ON n.sender = u1.id
 		JOIN user u2 ON n.receiver = u2.id
-		WHERE n.receiver = ?
-		ORDER BY n.created_at DESC
+		LEFT JOIN upload pr ON pr.id = n.proof_upload_id
+		WHERE ` + where + `
+		ORDER BY ` + listQuery.orderBy() + `
+		LIMIT ?
 	`
-	
-	fmt.Printf("Executing notification query for user %d\n", userID)
-	
-	rows, err := db.Query(query, userID)
+	args = append(args, listQuery.Limit+1)
+
+	rows, err := db.Query(query, args...)
 	if err != nil {
-		fmt.Printf("Error querying notifications: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(NotificationsResponse{false, "Error fetching notifications", nil})
+		logger.Error(r.Context(), "Error querying notifications", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error fetching notifications")
 		return
 	}
 	defer rows.Close()
 
-	var notifications []Notification
+	notifications := []Notification{}
+	var last notificationCursor
+	hasMore := false
+	expires := fileURLExpiry()
 	for rows.Next() {
+		if len(notifications) == listQuery.Limit {
+			hasMore = true
+			break
+		}
+
 		var n Notification
+		var createdAt time.Time
 		var senderID, receiverID int64
 		var senderName, receiverName string
-		if err := rows.Scan(
-			&n.ID, &n.Message, &n.Status, &n.CreatedAt,
+		var proof paymentProofRow
+		if err := rows.Scan(append([]interface{}{
+			&n.ID, &n.Message, &n.Kind, &n.Status, &createdAt,
 			&n.Property.ID, &n.Property.Name,
 			&n.Floor.ID, &n.Floor.Name,
 			&n.ShowActions,
@@ -1725,60 +1598,104 @@ func GetUserNotificationsHandler(w http.ResponseWriter, r *http.Request) {
 			&n.Comment,
 			&senderID, &receiverID,
 			&senderName, &receiverName,
-		); err != nil {
-			fmt.Printf("Error scanning notification row: %v\n", err)
+		}, proof.dest()...)...); err != nil {
+			logger.Error(r.Context(), "Error scanning notification row", "error", err)
 			continue
 		}
 		
+		n.CreatedAt = createdAt.Format(time.RFC3339Nano)
 		n.SenderID = &senderID
 		n.ReceiverID = &receiverID
 		n.SenderName = &senderName
 		n.ReceiverName = &receiverName
-		
-		// Debug logging for each notification
-		fmt.Printf("Notification: ID=%d, Message='%s', Status='%s', ShowActions=%v, IsRead=%v, Comment=%v\n", 
-			n.ID, n.Message, n.Status, n.ShowActions, n.IsRead, n.Comment)
-		
+		n.TransactionReference = proof.transactionReference()
+		n.PaymentProof = proof.upload(expires)
+		last = notificationCursor{CreatedAt: createdAt, ID: n.ID}
+
 		notifications = append(notifications, n)
 	}
 
 	if err = rows.Err(); err != nil {
-		fmt.Printf("Error iterating notification rows: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(NotificationsResponse{false, "Error processing notifications", nil})
+		logger.Error(r.Context(), "Error iterating notification rows", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error processing notifications")
 		return
 	}
 
-	fmt.Printf("Found %d notifications for user %d\n", len(notifications), userID)
+	logger.Debug(r.Context(), "Notifications retrieved", "count", len(notifications), "has_more", hasMore)
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(NotificationsResponse{
+	response := NotificationsResponse{
 		Success:       true,
 		Message:       "Notifications retrieved successfully",
 		Notifications: notifications,
+		HasMore:       hasMore,
+	}
+	if hasMore {
+		response.NextCursor = last.encode()
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetUnreadNotificationCountHandler handles GET requests for the number of
+// unread notifications, optionally for one property. It is cheap enough
+// for the app to poll.
+func GetUnreadNotificationCountHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := getUserIDFromContext(r)
+	if userID == 0 {
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	query := `SELECT COUNT(*) FROM notification WHERE receiver = ? AND COALESCE(is_read, false) = false`
+	args := []interface{}{userID}
+	if raw := r.URL.Query().Get("property_id"); raw != "" {
+		propertyID, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || propertyID <= 0 {
+			apierror.Field(w, r, "property_id", "property_id must be a positive integer")
+			return
+		}
+		query += ` AND pid = ?`
+		args = append(args, propertyID)
+	}
+
+	db, err := config.GetDBConnection()
+	if err != nil {
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
+		return
+	}
+
+	var count int
+	if err := db.QueryRow(query, args...).Scan(&count); err != nil {
+		logger.Error(r.Context(), "Error counting unread notifications", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error counting notifications")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(UnreadCountResponse{
+		Success:     true,
+		Message:     "Unread notifications counted",
+		UnreadCount: count,
 	})
 }
 
 // DeleteNotificationHandler handles DELETE requests to remove a notification
 func DeleteNotificationHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("\n=== New Delete Notification Request ===")
-	fmt.Printf("Method: %s\n", r.Method)
-	fmt.Printf("URL: %s\n", r.URL)
-
 	// Set response header to JSON
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodDelete {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "Method not allowed"})
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	// Get user ID from session
 	userID := getUserIDFromContext(r)
 	if userID == 0 {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "User not authenticated"})
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
@@ -1792,22 +1709,19 @@ func DeleteNotificationHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if len(cleanParts) != 3 {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "Invalid URL format"})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid URL format")
 		return
 	}
 
 	notificationID, err := strconv.ParseInt(cleanParts[2], 10, 64)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "Invalid notification ID"})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid notification ID")
 		return
 	}
 
 	db, err := config.GetDBConnection()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "Database connection error"})
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
 		return
 	}
 
@@ -1822,34 +1736,31 @@ func DeleteNotificationHandler(w http.ResponseWriter, r *http.Request) {
 		)`, notificationID, userID, userID).Scan(&canDelete)
 	
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "Error checking notification"})
+		apierror.Write(w, r, http.StatusInternalServerError, "Error checking notification")
 		return
 	}
 
 	if !canDelete {
-		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "You can only delete your own pending notifications"})
+		apierror.Write(w, r, http.StatusForbidden, "You can only delete your own pending notifications")
 		return
 	}
 
 	// Get the notification details before deleting
+	var senderID, receiverID int64
 	err = db.QueryRow(`
-		SELECT fid, message
+		SELECT fid, message, sender, receiver
 		FROM notification 
-		WHERE id = ? AND (sender = ? OR receiver = ?) AND status = 'pending'`, notificationID, userID, userID).Scan(&floorID, &message)
+		WHERE id = ? AND (sender = ? OR receiver = ?) AND status = 'pending'`, notificationID, userID, userID).Scan(&floorID, &message, &senderID, &receiverID)
 	
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "Error getting notification details"})
+		apierror.Write(w, r, http.StatusInternalServerError, "Error getting notification details")
 		return
 	}
 
 	// Start transaction
 	tx, err := db.Begin()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "Error starting transaction"})
+		apierror.Write(w, r, http.StatusInternalServerError, "Error starting transaction")
 		return
 	}
 	defer tx.Rollback()
@@ -1861,8 +1772,7 @@ func DeleteNotificationHandler(w http.ResponseWriter, r *http.Request) {
 		notificationID, userID, userID)
 	
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "Error deleting notification"})
+		apierror.Write(w, r, http.StatusInternalServerError, "Error deleting notification")
 		return
 	}
 
@@ -1877,8 +1787,7 @@ func DeleteNotificationHandler(w http.ResponseWriter, r *http.Request) {
 			AND message NOT LIKE 'Advance payment request:%'`, floorID).Scan(&remainingNotifications)
 		
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(TenantRequestResponse{false, "Error checking remaining notifications"})
+			apierror.Write(w, r, http.StatusInternalServerError, "Error checking remaining notifications")
 			return
 		}
 
@@ -1900,12 +1809,19 @@ func DeleteNotificationHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	err = recordEvent(tx, EventNotificationDeleted, NotificationEvent{NotificationID: notificationID}, senderID, receiverID)
+	if err != nil {
+		logger.Error(r.Context(), "Error recording notification event", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error deleting notification")
+		return
+	}
+
 	// Commit transaction
 	if err = tx.Commit(); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "Error committing transaction"})
+		apierror.Write(w, r, http.StatusInternalServerError, "Error committing transaction")
 		return
 	}
+	WakeEventDispatcher()
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(TenantRequestResponse{
@@ -1914,26 +1830,36 @@ func DeleteNotificationHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// SendMonthlyNotifications sends notifications to all tenants on the 5th of each month
+// SendMonthlyNotifications sends notifications to all tenants on the 5th of
+// each month and records the run in the scheduler job metrics
 func SendMonthlyNotifications() {
-	// Get current date in Bangladesh timezone
-	loc, _ := time.LoadLocation("Asia/Dhaka")
+	start := time.Now()
+	outcome := sendMonthlyNotifications(context.Background())
+	metrics.ObserveJob("monthly_notifications", outcome, start)
+}
+
+// sendMonthlyNotifications does the work of SendMonthlyNotifications and
+// returns the job outcome
+func sendMonthlyNotifications(ctx context.Context) string {
+
+	// Get current date in the configured timezone
+	loc := config.App.Location
 	now := time.Now().In(loc)
 
 	// Only send notifications if the current hour and minute match (for immediate test)
 	
 	// Only send notifications on the 5th of each month at 9:00 AM
 	if !(now.Day() == 5 && now.Hour() == 9 && now.Minute() == 0) {
-        return
-    }
+		return metrics.JobSkipped
+	}
 
-	fmt.Println("=== Sending Monthly Notifications ===")
+	logger.Info(ctx, "Sending monthly notifications")
 
 	// Get database connection
 	db, err := config.GetDBConnection()
 	if err != nil {
-		fmt.Printf("Database connection error: %v\n", err)
-		return
+		logger.Error(ctx, "Database connection error", "error", err)
+		return metrics.JobError
 	}
 
 	// Get all floors with tenants
@@ -1945,16 +1871,18 @@ func SendMonthlyNotifications() {
 	`
 	rows, err := db.Query(query)
 	if err != nil {
-		fmt.Printf("Error querying floors: %v\n", err)
-		return
+		logger.Error(ctx, "Error querying floors", "error", err)
+		return metrics.JobError
 	}
 	defer rows.Close()
 
+	failed := 0
 	for rows.Next() {
 		var floorID, propertyID, tenantID int64
 		var floorName, propertyName string
 		if err := rows.Scan(&floorID, &propertyID, &tenantID, &floorName, &propertyName); err != nil {
-			fmt.Printf("Error scanning floor: %v\n", err)
+			logger.Error(ctx, "Error scanning floor", "error", err)
+			failed++
 			continue
 		}
 
@@ -1973,8 +1901,9 @@ func SendMonthlyNotifications() {
 				// No payment record found, use default values
 				rent = 0
 			} else {
-			fmt.Printf("Error querying payment: %v\n", err)
-			continue
+				logger.Error(ctx, "Error querying payment", "error", err)
+				failed++
+				continue
 			}
 		}
 
@@ -1983,7 +1912,8 @@ func SendMonthlyNotifications() {
 		managerQuery := `SELECT uid FROM takes_care_of WHERE pid = ? LIMIT 1`
 		err = db.QueryRow(managerQuery, propertyID).Scan(&managerID)
 		if err != nil {
-			fmt.Printf("Error getting manager for property %d: %v\n", propertyID, err)
+			logger.Error(ctx, "Error getting manager for property", "property_id", propertyID, "error", err)
+			failed++
 			continue
 		}
 
@@ -1994,26 +1924,39 @@ func SendMonthlyNotifications() {
 		)
 
 		// Create notification with push notification
-		err = SendNotificationWithPush(managerID, tenantID, propertyID, floorID, message, "", nil)
+		err = SendNotificationWithPush(ctx, managerID, tenantID, propertyID, floorID, message, "", nil)
 		if err != nil {
-			fmt.Printf("Error creating notification: %v\n", err)
+			logger.Error(ctx, "Error creating notification", "error", err)
+			failed++
 			continue
 		}
 
-		fmt.Printf("Created notification for tenant %d in property %s, floor %s\n", tenantID, propertyName, floorName)
+		logger.Debug(ctx, "Monthly notification created", "tenant_id", tenantID, "property_id", propertyID, "floor_id", floorID)
 	}
 
-	fmt.Println("Monthly notifications sent successfully.")
+	if err := rows.Err(); err != nil {
+		logger.Error(ctx, "Error iterating floors", "error", err)
+		return metrics.JobError
+	}
+
+	if failed > 0 {
+		logger.Warn(ctx, "Monthly notifications sent with failures", "failed", failed)
+		return metrics.JobError
+	}
+	logger.Info(ctx, "Monthly notifications sent")
+	return metrics.JobSuccess
 }
 
 // TestSendNotifications is a test function to manually trigger notifications
 func TestSendNotifications() {
-	fmt.Println("=== Testing Send Notifications ===")
+	ctx := context.Background()
+
+	logger.Info(ctx, "Sending test notifications")
 
 	// Get database connection
 	db, err := config.GetDBConnection()
 	if err != nil {
-		fmt.Printf("Database connection error: %v\n", err)
+		logger.Error(ctx, "Database connection error", "error", err)
 		return
 	}
 
@@ -2026,7 +1969,7 @@ func TestSendNotifications() {
 	`
 	rows, err := db.Query(query)
 	if err != nil {
-		fmt.Printf("Error querying floors: %v\n", err)
+		logger.Error(ctx, "Error querying floors", "error", err)
 		return
 	}
 	defer rows.Close()
@@ -2036,7 +1979,7 @@ func TestSendNotifications() {
 		var floorID, propertyID, tenantID int64
 		var floorName, propertyName string
 		if err := rows.Scan(&floorID, &propertyID, &tenantID, &floorName, &propertyName); err != nil {
-			fmt.Printf("Error scanning floor: %v\n", err)
+			logger.Error(ctx, "Error scanning floor", "error", err)
 			continue
 		}
 
@@ -2055,7 +1998,7 @@ func TestSendNotifications() {
 				// No payment record found, use default values
 				rent = 0
 			} else {
-				fmt.Printf("Error querying payment: %v\n", err)
+				logger.Error(ctx, "Error querying payment", "error", err)
 				continue
 			}
 		}
@@ -2065,7 +2008,7 @@ func TestSendNotifications() {
 		managerQuery := `SELECT uid FROM takes_care_of WHERE pid = ? LIMIT 1`
 		err = db.QueryRow(managerQuery, propertyID).Scan(&managerID)
 		if err != nil {
-			fmt.Printf("Error getting manager for property %d: %v\n", propertyID, err)
+			logger.Error(ctx, "Error getting manager for property", "property_id", propertyID, "error", err)
 			continue
 		}
 
@@ -2076,45 +2019,33 @@ func TestSendNotifications() {
 		)
 
 		// Create notification with push notification
-		err = SendNotificationWithPush(managerID, tenantID, propertyID, floorID, message, "", nil)
+		err = SendNotificationWithPush(ctx, managerID, tenantID, propertyID, floorID, message, "", nil)
 		if err != nil {
-			fmt.Printf("Error creating notification: %v\n", err)
+			logger.Error(ctx, "Error creating notification", "error", err)
 			continue
 		}
 
-		fmt.Printf("Created test notification for tenant %d in property %s, floor %s\n", tenantID, propertyName, floorName)
+		logger.Debug(ctx, "Test notification created", "tenant_id", tenantID, "property_id", propertyID, "floor_id", floorID)
 		notificationCount++
 	}
 
-	fmt.Printf("Test completed. Created %d notifications successfully.\n", notificationCount)
+	logger.Info(ctx, "Test notifications sent", "count", notificationCount)
 }
 
 // TestSendNotificationsHandler handles the test endpoint to manually trigger notifications
 func TestSendNotificationsHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("\n=== Test Send Notifications Request ===")
-	fmt.Printf("Method: %s\n", r.Method)
-	fmt.Printf("URL: %s\n", r.URL)
-
 	// Set response header to JSON
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"message": "Method not allowed",
-		})
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	// Get user ID from session
 	userID := getUserIDFromContext(r)
 	if userID == 0 {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"message": "User not authenticated",
-		})
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
@@ -2131,24 +2062,18 @@ func TestSendNotificationsHandler(w http.ResponseWriter, r *http.Request) {
 
 // HandleTenantRequestAction handles POST requests to accept/reject tenant requests and payment notifications
 func HandleTenantRequestAction(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("\n=== New Notification Action Request ===")
-	fmt.Printf("Method: %s\n", r.Method)
-	fmt.Printf("URL: %s\n", r.URL)
-
 	// Set response header to JSON
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "Method not allowed"})
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	// Get user ID from session
 	userID := getUserIDFromContext(r)
 	if userID == 0 {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "User not authenticated"})
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
@@ -2159,27 +2084,27 @@ func HandleTenantRequestAction(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
 	// Get database connection
 	db, err := config.GetDBConnection()
 	if err != nil {
-		fmt.Printf("Database connection error: %v\n", err)
-		http.Error(w, "Database connection failed", http.StatusInternalServerError)
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection failed")
 		return
 	}
 	if db == nil {
-		http.Error(w, "Database connection is nil", http.StatusInternalServerError)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection is nil")
 		return
 	}
 
 	// Start transaction
 	tx, err := db.Begin()
 	if err != nil {
-		fmt.Printf("Transaction start error: %v\n", err)
-		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		logger.Error(r.Context(), "Transaction start error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to start transaction")
 		return
 	}
 	defer tx.Rollback()
@@ -2193,10 +2118,13 @@ func HandleTenantRequestAction(w http.ResponseWriter, r *http.Request) {
 		PID     int64
 		Sender  int64
 		Receiver int64
+		ProofUploadID        sql.NullInt64
+		TransactionReference sql.NullString
 	}
 
 	err = tx.QueryRow(`
-		SELECT n.id, n.message, n.status, n.fid, n.pid, n.sender, n.receiver
+		SELECT n.id, n.message, n.status, n.fid, n.pid, n.sender, n.receiver,
+			n.proof_upload_id, n.transaction_reference
 		FROM notification n
 		WHERE n.id = ? AND n.receiver = ?
 	`, request.NotificationID, userID).Scan(
@@ -2207,23 +2135,39 @@ func HandleTenantRequestAction(w http.ResponseWriter, r *http.Request) {
 		&notification.PID,
 		&notification.Sender,
 		&notification.Receiver,
+		&notification.ProofUploadID,
+		&notification.TransactionReference,
 	)
 
 	if err != nil {
-		fmt.Printf("Error getting notification: %v\n", err)
+		logger.Error(r.Context(), "Error getting notification", "error", err)
 		if err == sql.ErrNoRows {
-			http.Error(w, "Notification not found", http.StatusNotFound)
+			apierror.Write(w, r, http.StatusNotFound, "Notification not found")
 		} else {
-			http.Error(w, "Failed to get notification", http.StatusInternalServerError)
+			apierror.Write(w, r, http.StatusInternalServerError, "Failed to get notification")
 		}
 		return
 	}
 
 	if notification.Status != "pending" {
-		http.Error(w, "Notification is not pending", http.StatusBadRequest)
+		apierror.Write(w, r, http.StatusBadRequest, "Notification is not pending")
 		return
 	}
 
+	// Properties that require 2FA only let enrolled managers settle payments
+	if strings.HasPrefix(notification.Message, "Payment amount:") || strings.HasPrefix(notification.Message, "Advance payment request:") {
+		missing, err := twoFactorMissing(db, userID, notification.PID)
+		if err != nil {
+			logger.Error(r.Context(), "Error checking property two-factor requirement", "error", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "Failed to check authorization")
+			return
+		}
+		if missing {
+			apierror.WriteCode(w, r, http.StatusForbidden, apierror.CodeTwoFactorRequired, "This property requires two-factor authentication. Enable it on your account to continue.")
+			return
+		}
+	}
+
 	// Update notification status
 	newStatus := "rejected"
 	if request.Accept {
@@ -2237,8 +2181,8 @@ func HandleTenantRequestAction(w http.ResponseWriter, r *http.Request) {
 	`, newStatus, userID, request.NotificationID)
 
 	if err != nil {
-		fmt.Printf("Error updating notification: %v\n", err)
-		http.Error(w, "Failed to update notification", http.StatusInternalServerError)
+		logger.Error(r.Context(), "Error updating notification", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to update notification")
 		return
 	}
 
@@ -2250,7 +2194,7 @@ func HandleTenantRequestAction(w http.ResponseWriter, r *http.Request) {
 		// Handle payment notification
 		if request.Accept {
 			// Payment accepted - create payment record
-			fmt.Printf("Payment notification accepted: ID=%d, creating payment record", notification.ID)
+			logger.Info(r.Context(), "Payment notification accepted", "notification_id", notification.ID)
 			
 			// Extract amount from the notification message
 			// Message format: "Payment amount: X tk" or "Payment amount: X tk for MonthName"
@@ -2264,13 +2208,13 @@ func HandleTenantRequestAction(w http.ResponseWriter, r *http.Request) {
 				if parsedAmount, err := strconv.Atoi(amountMatches[1]); err == nil {
 					amount = parsedAmount
 				} else {
-					fmt.Printf("Error parsing amount from message: %v\n", err)
-					http.Error(w, "Failed to parse payment amount", http.StatusInternalServerError)
+					logger.Error(r.Context(), "Error parsing amount from message", "error", err)
+					apierror.Write(w, r, http.StatusInternalServerError, "Failed to parse payment amount")
 					return
 				}
 			} else {
-				fmt.Printf("Could not extract amount from message: %s\n", notification.Message)
-				http.Error(w, "Failed to extract payment amount from message", http.StatusInternalServerError)
+				logger.Error(r.Context(), "Could not extract amount from payment notification", "notification_id", notification.ID)
+				apierror.Write(w, r, http.StatusInternalServerError, "Failed to extract payment amount from message")
 				return
 			}
 			
@@ -2283,7 +2227,6 @@ func HandleTenantRequestAction(w http.ResponseWriter, r *http.Request) {
 			if len(electricityBillMatches) >= 2 {
 				if parsedElectricityBill, err := strconv.Atoi(electricityBillMatches[1]); err == nil {
 					electricityBill = &parsedElectricityBill
-					fmt.Printf("Extracted electricity bill: %d tk", parsedElectricityBill)
 				}
 			}
 			
@@ -2291,49 +2234,42 @@ func HandleTenantRequestAction(w http.ResponseWriter, r *http.Request) {
 			var tenantID int64
 			err = tx.QueryRow("SELECT tenant FROM floor WHERE id = ?", notification.FloorID).Scan(&tenantID)
 			if err != nil {
-				fmt.Printf("Error getting tenant ID: %v\n", err)
-				http.Error(w, "Failed to get tenant information", http.StatusInternalServerError)
+				logger.Error(r.Context(), "Error getting tenant ID", "error", err)
+				apierror.Write(w, r, http.StatusInternalServerError, "Failed to get tenant information")
 				return
 			}
 			
 			// Generate payment ID
 			paymentID, err := utils.GenerateRandomID()
 			if err != nil {
-				fmt.Printf("Error generating payment ID: %v\n", err)
-				http.Error(w, "Failed to generate payment ID", http.StatusInternalServerError)
+				logger.Error(r.Context(), "Error generating payment ID", "error", err)
+				apierror.Write(w, r, http.StatusInternalServerError, "Failed to generate payment ID")
 				return
 			}
 			
 			// Create payment record according to requirements:
 			// rent = 0, received_money = amount from message, electricity_bill = 0, paid_bill = electricity bill from message
-			_, err = tx.Exec(`
-				INSERT INTO payment (
-					id, rent, recieved_money, full_payment, created_at, created_by, updated_at, updated_by, 
-					fid, uid, electricity_bill, paid_bill
-				) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-			`,
-				paymentID,
-				0, // rent = 0 (as specified)
-				amount, // received_money = amount from message
-				true, // full_payment = true since it's accepted
-				time.Now().In(time.FixedZone("BDT", 6*60*60)).Format("2006-01-02 15:04:05"),
-				userID,
-				time.Now().In(time.FixedZone("BDT", 6*60*60)).Format("2006-01-02 15:04:05"),
-				userID,
-				notification.FloorID, // fid from notification
-				tenantID, // uid from floor.tenant
-				0, // electricity_bill = 0
-				electricityBill, // paid_bill = electricity bill from message
-			)
+			// The tenant's proof of payment is kept with the record
+			err = RecordPayment(tx, LedgerEntry{
+				ID:                   paymentID,
+				FloorID:              notification.FloorID,
+				TenantID:             tenantID,
+				ReceivedMoney:        amount,
+				FullPayment:          true, // accepted
+				ElectricityBill:      new(int),
+				PaidBill:             electricityBill,
+				ProofUploadID:        notification.ProofUploadID,
+				TransactionReference: notification.TransactionReference,
+				CreatedBy:            userID,
+			})
 			
 			if err != nil {
-				fmt.Printf("Error creating payment record: %v\n", err)
-				http.Error(w, "Failed to create payment record", http.StatusInternalServerError)
+				logger.Error(r.Context(), "Error creating payment record", "error", err)
+				apierror.Write(w, r, http.StatusInternalServerError, "Failed to create payment record")
 				return
 			}
 			
-			fmt.Printf("Created payment record: ID=%d, Floor=%d, Tenant=%d, Amount=%d, ElectricityBill=%v", 
-				paymentID, notification.FloorID, tenantID, amount, electricityBill)
+			logger.Info(r.Context(), "Payment record created", "payment_id", paymentID, "floor_id", notification.FloorID, "tenant_id", tenantID, "amount", amount)
 		}
 		// If rejected, just update the notification status (already done above)
 		
@@ -2348,12 +2284,12 @@ func HandleTenantRequestAction(w http.ResponseWriter, r *http.Request) {
 			`, userID, notification.FloorID)
 			
 			if err != nil {
-				fmt.Printf("Error updating advance payment status: %v\n", err)
-				http.Error(w, "Failed to update advance payment status", http.StatusInternalServerError)
+				logger.Error(r.Context(), "Error updating advance payment status", "error", err)
+				apierror.Write(w, r, http.StatusInternalServerError, "Failed to update advance payment status")
 				return
 			}
 			
-			fmt.Printf("Advance payment accepted: notification ID=%d, floor=%d", notification.ID, notification.FloorID)
+			logger.Info(r.Context(), "Advance payment accepted", "notification_id", notification.ID, "floor_id", notification.FloorID)
 		} else {
 			// Advance payment rejected - update the advance record status
 			_, err = tx.Exec(`
@@ -2363,12 +2299,12 @@ func HandleTenantRequestAction(w http.ResponseWriter, r *http.Request) {
 			`, userID, notification.FloorID)
 			
 			if err != nil {
-				fmt.Printf("Error updating advance payment status: %v\n", err)
-				http.Error(w, "Failed to update advance payment status", http.StatusInternalServerError)
+				logger.Error(r.Context(), "Error updating advance payment status", "error", err)
+				apierror.Write(w, r, http.StatusInternalServerError, "Failed to update advance payment status")
 				return
 			}
 			
-			fmt.Printf("Advance payment rejected: notification ID=%d, floor=%d", notification.ID, notification.FloorID)
+			logger.Info(r.Context(), "Advance payment rejected", "notification_id", notification.ID, "floor_id", notification.FloorID)
 		}
 		
 	} else {
@@ -2383,13 +2319,13 @@ func HandleTenantRequestAction(w http.ResponseWriter, r *http.Request) {
 			`, notification.FloorID).Scan(&isOccupied)
 
 			if err != nil {
-				fmt.Printf("Error checking floor status: %v\n", err)
-				http.Error(w, "Failed to check floor status", http.StatusInternalServerError)
+				logger.Error(r.Context(), "Error checking floor status", "error", err)
+				apierror.Write(w, r, http.StatusInternalServerError, "Failed to check floor status")
 				return
 			}
 
 			if isOccupied {
-				http.Error(w, "Floor is already occupied", http.StatusConflict)
+				apierror.Write(w, r, http.StatusConflict, "Floor is already occupied")
 				return
 			}
 
@@ -2401,19 +2337,32 @@ func HandleTenantRequestAction(w http.ResponseWriter, r *http.Request) {
 			`, notification.Receiver, userID, notification.FloorID)
 
 			if err != nil {
-				fmt.Printf("Error updating floor: %v\n", err)
-				http.Error(w, "Failed to update floor", http.StatusInternalServerError)
+				logger.Error(r.Context(), "Error updating floor", "error", err)
+				apierror.Write(w, r, http.StatusInternalServerError, "Failed to update floor")
 				return
 			}
 		}
 	}
 
+	updated := NotificationEvent{NotificationID: notification.ID, Status: newStatus}
+	if err := recordEvent(tx, EventNotificationUpdated, updated, notification.Sender, notification.Receiver); err != nil {
+		logger.Error(r.Context(), "Error recording notification event", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to update notification")
+		return
+	}
+
 	// Commit transaction
 	if err = tx.Commit(); err != nil {
-		fmt.Printf("Error committing transaction: %v\n", err)
-		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		logger.Error(r.Context(), "Error committing transaction", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to commit transaction")
 		return
 	}
+	WakeEventDispatcher()
+
+	// The new tenant starts receiving the property's broadcasts
+	if request.Accept && !isPaymentNotification && !isAdvancePaymentNotification {
+		tenantChanged(r.Context(), notification.PID, 0, notification.Receiver)
+	}
 
 	// Create auto-generated response notification
 	{
@@ -2472,13 +2421,12 @@ func HandleTenantRequestAction(w http.ResponseWriter, r *http.Request) {
 		responseReceiver := notification.Sender // Original sender of the request
 		
 		// Create auto-response notification with push notification
-		err = SendNotificationWithPush(responseSender, responseReceiver, notification.PID, notification.FloorID, responseMessage, newStatus, nil)
+		err = SendNotificationWithPush(r.Context(), responseSender, responseReceiver, notification.PID, notification.FloorID, responseMessage, newStatus, nil)
 		if err != nil {
-			fmt.Printf("Error creating auto-response notification: %v\n", err)
+			logger.Error(r.Context(), "Error creating auto-response notification", "error", err)
 			// Don't fail the whole request, just log the error
 		} else {
-			fmt.Printf("Created auto-response notification with push: Message='%s', from user=%d to user=%d", 
-				responseMessage, responseSender, responseReceiver)
+			logger.Debug(r.Context(), "Auto-response notification created", "sender_id", responseSender, "receiver_id", responseReceiver)
 		}
 	}
 
@@ -2498,25 +2446,18 @@ func HandleTenantRequestAction(w http.ResponseWriter, r *http.Request) {
 
 // GetAdvanceDetailsHandler handles GET requests to get advance details for a floor
 func GetAdvanceDetailsHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("\n=== New Get Advance Details Request ===")
-	fmt.Printf("Method: %s\n", r.Method)
-	fmt.Printf("URL: %s\n", r.URL)
-
 	// Set response header to JSON
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(AdvanceDetailsResponse{false, "Method not allowed", nil})
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	// Get user ID from session
 	userID := getUserIDFromContext(r)
 	if userID == 0 {
-		fmt.Println("No user ID found in session")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(AdvanceDetailsResponse{false, "User not authenticated", nil})
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
@@ -2524,18 +2465,14 @@ func GetAdvanceDetailsHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	floorID, err := strconv.ParseInt(vars["floor_id"], 10, 64)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(AdvanceDetailsResponse{false, "Invalid floor ID", nil})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid floor ID")
 		return
 	}
 
-	fmt.Printf("Fetching advance details for floor ID: %d\n", floorID)
-
 	db, err := config.GetDBConnection()
 	if err != nil {
-		fmt.Printf("Database connection error: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(AdvanceDetailsResponse{false, "Database connection error", nil})
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
 		return
 	}
 
@@ -2546,14 +2483,11 @@ func GetAdvanceDetailsHandler(w http.ResponseWriter, r *http.Request) {
 		INNER JOIN user u ON a.advance_uid = u.id
 		WHERE a.fid = ? AND a.money > 0
 		ORDER BY a.created_at DESC`
-	
-	fmt.Printf("Executing query: %s with floorID: %d\n", query, floorID)
-	
+
 	rows, err := db.Query(query, floorID)
 	if err != nil {
-		fmt.Printf("Error querying advance details: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(AdvanceDetailsResponse{false, "Error fetching advance details", nil})
+		logger.Error(r.Context(), "Error querying advance details", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error fetching advance details")
 		return
 	}
 	defer rows.Close()
@@ -2562,21 +2496,19 @@ func GetAdvanceDetailsHandler(w http.ResponseWriter, r *http.Request) {
 	for rows.Next() {
 		var advance AdvanceDetail
 		if err := rows.Scan(&advance.ID, &advance.AdvanceUID, &advance.UserName, &advance.Money, &advance.CreatedAt, &advance.Status); err != nil {
-			fmt.Printf("Error scanning advance detail row: %v\n", err)
+			logger.Error(r.Context(), "Error scanning advance detail row", "error", err)
 			continue
 		}
 		advances = append(advances, advance)
-		fmt.Printf("Found advance: ID=%d, User=%s, Money=%d\n", advance.ID, advance.UserName, advance.Money)
 	}
 
 	if err = rows.Err(); err != nil {
-		fmt.Printf("Error iterating advance detail rows: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(AdvanceDetailsResponse{false, "Error processing advance details", nil})
+		logger.Error(r.Context(), "Error iterating advance detail rows", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error processing advance details")
 		return
 	}
 
-	fmt.Printf("Found %d advance details for floor\n", len(advances))
+	logger.Debug(r.Context(), "Advance details retrieved", "count", len(advances))
 
 	response := AdvanceDetailsResponse{
 		Success: true,
@@ -2584,90 +2516,71 @@ func GetAdvanceDetailsHandler(w http.ResponseWriter, r *http.Request) {
 		Advances: advances,
 	}
 
-	// Log the response
-	responseJSON, _ := json.MarshalIndent(response, "", "  ")
-	fmt.Printf("Sending response: %s\n", string(responseJSON))
-
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
 }
 
 // GetUserTenantPropertiesHandler handles GET requests to get all properties where the user is a tenant
 func GetUserTenantPropertiesHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("\n=== New Get User Tenant Properties Request ===")
-	fmt.Printf("Method: %s\n", r.Method)
-	fmt.Printf("URL: %s\n", r.URL)
-
 	// Set response header to JSON
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(UserPropertiesResponse{false, "Method not allowed", nil})
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	// Get user ID from session
 	userID := getUserIDFromContext(r)
 	if userID == 0 {
-		fmt.Println("No user ID found in session")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(UserPropertiesResponse{false, "User not authenticated", nil})
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
-	fmt.Printf("Fetching properties where user ID: %d is a tenant\n", userID)
-
 	db, err := config.GetDBConnection()
 	if err != nil {
-		fmt.Printf("Database connection error: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(UserPropertiesResponse{false, "Database connection error", nil})
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
 		return
 	}
 
 	// Query to get all properties where the user is a tenant
 	query := `
-		SELECT DISTINCT p.id, p.name, p.address, p.photo, p.created_at 
+		SELECT DISTINCT p.id, p.name, p.address, p.photo, pu.storage_key, pu.thumbnail_key, p.created_at 
 		FROM property p
+		LEFT JOIN upload pu ON pu.id = p.photo_upload_id
 		INNER JOIN floor f ON p.id = f.pid
 		WHERE f.tenant = ?
 		ORDER BY p.created_at DESC`
-	
-	fmt.Printf("Executing query: %s with userID: %d\n", query, userID)
-	
+
 	rows, err := db.Query(query, userID)
 	if err != nil {
-		fmt.Printf("Error querying properties: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(UserPropertiesResponse{false, "Error fetching properties", nil})
+		logger.Error(r.Context(), "Error querying properties", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error fetching properties")
 		return
 	}
 	defer rows.Close()
 
 	var properties []Property
+	expires := fileURLExpiry()
 	for rows.Next() {
 		var prop Property
-		var photo sql.NullString
-		if err := rows.Scan(&prop.ID, &prop.Name, &prop.Address, &photo, &prop.CreatedAt); err != nil {
-			fmt.Printf("Error scanning property row: %v\n", err)
+		var photo, photoKey, thumbnailKey sql.NullString
+		if err := rows.Scan(&prop.ID, &prop.Name, &prop.Address, &photo, &photoKey, &thumbnailKey, &prop.CreatedAt); err != nil {
+			logger.Error(r.Context(), "Error scanning property row", "error", err)
 			continue
 		}
-		if photo.Valid {
-			prop.Photo = &photo.String
-		}
+		setPropertyPhoto(&prop, photo, photoKey, thumbnailKey, expires)
 		properties = append(properties, prop)
-		fmt.Printf("Found property: ID=%d, Name=%s\n", prop.ID, prop.Name)
 	}
 
 	if err = rows.Err(); err != nil {
-		fmt.Printf("Error iterating property rows: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(UserPropertiesResponse{false, "Error processing properties", nil})
+		logger.Error(r.Context(), "Error iterating property rows", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error processing properties")
 		return
 	}
 
-	fmt.Printf("Found %d properties where user is a tenant\n", len(properties))
+	logger.Debug(r.Context(), "Tenant properties retrieved", "count", len(properties))
 
 	response := UserPropertiesResponse{
 		Success: true,
@@ -2675,10 +2588,6 @@ func GetUserTenantPropertiesHandler(w http.ResponseWriter, r *http.Request) {
 		Properties: properties,
 	}
 
-	// Log the response
-	responseJSON, _ := json.MarshalIndent(response, "", "  ")
-	fmt.Printf("Sending response: %s\n", string(responseJSON))
-
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
 }
@@ -2686,8 +2595,7 @@ func GetUserTenantPropertiesHandler(w http.ResponseWriter, r *http.Request) {
 // RemoveTenantHandler handles POST requests to remove a tenant from a floor
 func RemoveTenantHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "Method not allowed"})
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
@@ -2696,38 +2604,37 @@ func RemoveTenantHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	propertyID, err := strconv.ParseInt(vars["id"], 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid property ID", http.StatusBadRequest)
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid property ID")
 		return
 	}
 	floorID, err := strconv.ParseInt(vars["floor_id"], 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid floor ID", http.StatusBadRequest)
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid floor ID")
 		return
 	}
 
 	if userID == 0 {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "User not authenticated"})
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
 	// Get database connection
 	db, err := config.GetDBConnection()
 	if err != nil {
-		fmt.Printf("Database connection error: %v\n", err)
-		http.Error(w, "Database connection failed", http.StatusInternalServerError)
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection failed")
 		return
 	}
 	if db == nil {
-		http.Error(w, "Database connection is nil", http.StatusInternalServerError)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection is nil")
 		return
 	}
 
 	// Start transaction
 	tx, err := db.Begin()
 	if err != nil {
-		fmt.Printf("Transaction start error: %v\n", err)
-		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		logger.Error(r.Context(), "Transaction start error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to start transaction")
 		return
 	}
 	defer tx.Rollback()
@@ -2741,13 +2648,13 @@ func RemoveTenantHandler(w http.ResponseWriter, r *http.Request) {
 		)`, userID, propertyID).Scan(&isManager)
 
 	if err != nil {
-		fmt.Printf("Error checking property manager: %v\n", err)
-		http.Error(w, "Failed to check authorization", http.StatusInternalServerError)
+		logger.Error(r.Context(), "Error checking property manager", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to check authorization")
 		return
 	}
 
 	if !isManager {
-		http.Error(w, "You are not authorized to manage this property", http.StatusForbidden)
+		apierror.Write(w, r, http.StatusForbidden, "You are not authorized to manage this property")
 		return
 	}
 
@@ -2760,13 +2667,20 @@ func RemoveTenantHandler(w http.ResponseWriter, r *http.Request) {
 		)`, floorID, propertyID).Scan(&hasTenant)
 
 	if err != nil {
-		fmt.Printf("Error checking tenant: %v\n", err)
-		http.Error(w, "Failed to check tenant", http.StatusInternalServerError)
+		logger.Error(r.Context(), "Error checking tenant", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to check tenant")
 		return
 	}
 
 	if !hasTenant {
-		http.Error(w, "No tenant found in this floor", http.StatusBadRequest)
+		apierror.Write(w, r, http.StatusBadRequest, "No tenant found in this floor")
+		return
+	}
+
+	tenantID, err := floorTenant(tx, propertyID, floorID)
+	if err != nil {
+		logger.Error(r.Context(), "Error checking tenant", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to check tenant")
 		return
 	}
 
@@ -2778,18 +2692,21 @@ func RemoveTenantHandler(w http.ResponseWriter, r *http.Request) {
 	`, userID, floorID, propertyID)
 
 	if err != nil {
-		fmt.Printf("Error removing tenant: %v\n", err)
-		http.Error(w, "Failed to remove tenant", http.StatusInternalServerError)
+		logger.Error(r.Context(), "Error removing tenant", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to remove tenant")
 		return
 	}
 
 	// Commit transaction
 	if err = tx.Commit(); err != nil {
-		fmt.Printf("Error committing transaction: %v\n", err)
-		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		logger.Error(r.Context(), "Error committing transaction", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to commit transaction")
 		return
 	}
 
+	// The former tenant stops receiving the property's broadcasts
+	tenantChanged(r.Context(), propertyID, tenantID, 0)
+
 	// Send response
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -2806,25 +2723,18 @@ type ManagerCheckResponse struct {
 
 // CheckUserManagerHandler handles GET requests to check if user is a manager of a property
 func CheckUserManagerHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("\n=== New Check User Manager Request ===")
-	fmt.Printf("Method: %s\n", r.Method)
-	fmt.Printf("URL: %s\n", r.URL)
-
 	// Set response header to JSON
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(ManagerCheckResponse{false, "Method not allowed", false})
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	// Get user ID from session
 	userID := getUserIDFromContext(r)
 	if userID == 0 {
-		fmt.Println("No user ID found in session")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(ManagerCheckResponse{false, "User not authenticated", false})
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
@@ -2838,25 +2748,20 @@ func CheckUserManagerHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if len(cleanParts) != 3 {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ManagerCheckResponse{false, "Invalid URL format", false})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid URL format")
 		return
 	}
 
 	propertyID, err := strconv.ParseInt(cleanParts[1], 10, 64)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(ManagerCheckResponse{false, "Invalid property ID", false})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid property ID")
 		return
 	}
 
-	fmt.Printf("Checking if user ID: %d is manager of property ID: %d\n", userID, propertyID)
-
 	db, err := config.GetDBConnection()
 	if err != nil {
-		fmt.Printf("Database connection error: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ManagerCheckResponse{false, "Database connection error", false})
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
 		return
 	}
 
@@ -2869,14 +2774,11 @@ func CheckUserManagerHandler(w http.ResponseWriter, r *http.Request) {
 		)`, userID, propertyID).Scan(&isManager)
 	
 	if err != nil {
-		fmt.Printf("Error checking manager status: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ManagerCheckResponse{false, "Error checking manager status", false})
+		logger.Error(r.Context(), "Error checking manager status", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error checking manager status")
 		return
 	}
 
-	fmt.Printf("User %d is manager of property %d: %v\n", userID, propertyID, isManager)
-
 	response := ManagerCheckResponse{
 		Success:   true,
 		Message:   "Manager check completed",
@@ -2891,49 +2793,74 @@ func SendPaymentNotificationHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	propertyID, err := strconv.ParseInt(vars["id"], 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid property ID", http.StatusBadRequest)
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid property ID")
 		return
 	}
 	floorID, err := strconv.ParseInt(vars["floor_id"], 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid floor ID", http.StatusBadRequest)
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid floor ID")
 		return
 	}
 
 	var req PaymentNotificationRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid request")
+		return
+	}
+	reference, problem := cleanTransactionReference(req.TransactionReference)
+	if problem != "" {
+		apierror.Field(w, r, "transaction_reference", problem)
 		return
 	}
 
 	userID := getUserIDFromContext(r) // sender (tenant)
 	db, err := config.GetDBConnection()
 	if err != nil {
-		http.Error(w, "Database error", http.StatusInternalServerError)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database error")
 		return
 	}
 
 	// Check if user is the tenant of this floor
 	var isTenant bool
 	err = db.QueryRow(`SELECT EXISTS(SELECT 1 FROM floor WHERE id = ? AND pid = ? AND tenant = ?)`, floorID, propertyID, userID).Scan(&isTenant)
-	log.Printf("[Tenant Check] floorID=%d, propertyID=%d, userID=%d, isTenant=%v, err=%v", floorID, propertyID, userID, isTenant, err)
-	
 	if err != nil || !isTenant {
 		if err != nil {
-			log.Printf("[Tenant Check] DB error: %v", err)
+			logger.Error(r.Context(), "Error checking floor tenant", "error", err)
 		}
-		if !isTenant {
-			log.Printf("[Tenant Check] User is not the tenant of this floor.")
-		}
-		http.Error(w, "You are not the tenant of this floor", http.StatusForbidden)
+		apierror.Write(w, r, http.StatusForbidden, "You are not the tenant of this floor")
 		return
 	}
 
+	// A proof must be the tenant's own and not already sent with another
+	// payment notification. The unique key add_unique_payment_proof.sql puts
+	// on notification.proof_upload_id settles two sent at once.
+	var proofUploadID interface{}
+	if req.ProofUploadID != nil {
+		_, err := ownUpload(db, userID, *req.ProofUploadID, uploadPurposePaymentProof)
+		if err == nil {
+			var used bool
+			err = db.QueryRow(`SELECT EXISTS (SELECT 1 FROM notification WHERE proof_upload_id = ?)`, *req.ProofUploadID).Scan(&used)
+			if err == nil && used {
+				err = sql.ErrNoRows
+			}
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			apierror.Field(w, r, "proof_upload_id", "proof_upload_id must be an unused payment_proof upload of yours")
+			return
+		}
+		if err != nil {
+			logger.Error(r.Context(), "Error loading payment proof upload", "error", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "Failed to send notification")
+			return
+		}
+		proofUploadID = *req.ProofUploadID
+	}
+
 	// Get property manager (receiver)
 	var managerID int64
 	err = db.QueryRow(`SELECT uid FROM takes_care_of WHERE pid = ? LIMIT 1`, propertyID).Scan(&managerID)
 	if err != nil {
-		http.Error(w, "Manager not found", http.StatusInternalServerError)
+		apierror.Write(w, r, http.StatusInternalServerError, "Manager not found")
 		return
 	}
 
@@ -2941,12 +2868,12 @@ func SendPaymentNotificationHandler(w http.ResponseWriter, r *http.Request) {
 	var propertyName, floorName string
 	err = db.QueryRow(`SELECT name FROM property WHERE id = ?`, propertyID).Scan(&propertyName)
 	if err != nil {
-		http.Error(w, "Property not found", http.StatusInternalServerError)
+		apierror.Write(w, r, http.StatusInternalServerError, "Property not found")
 		return
 	}
 	err = db.QueryRow(`SELECT name FROM floor WHERE id = ?`, floorID).Scan(&floorName)
 	if err != nil {
-		http.Error(w, "Floor not found", http.StatusInternalServerError)
+		apierror.Write(w, r, http.StatusInternalServerError, "Floor not found")
 		return
 	}
 
@@ -2966,20 +2893,36 @@ func SendPaymentNotificationHandler(w http.ResponseWriter, r *http.Request) {
 		message += fmt.Sprintf(", Paid electricity bill: %d tk", *req.PaidElectricityBill)
 	}
 
-	// Generate notification ID
-	notificationID, err := utils.GenerateRandomID()
+	// Create notification with push notification, with its proof of payment
+	tx, err := db.Begin()
 	if err != nil {
-		http.Error(w, "Error generating notification ID", http.StatusInternalServerError)
+		logger.Error(r.Context(), "Transaction start error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to send notification")
 		return
 	}
+	defer tx.Rollback()
 
-	// Create notification with push notification
-	err = SendNotificationWithPush(userID, managerID, propertyID, floorID, message, "pending", nil)
+	notificationID, err := SendNotificationWithPushTx(r.Context(), tx, userID, managerID, propertyID, floorID, message, "pending", nil)
+	if err == nil && (proofUploadID != nil || reference != "") {
+		_, err = tx.Exec(`
+			UPDATE notification
+			SET proof_upload_id = ?, transaction_reference = ?
+			WHERE id = ?`, proofUploadID, nullString(reference), notificationID)
+		if config.IsDuplicateKey(err) {
+			apierror.Field(w, r, "proof_upload_id", "proof_upload_id must be an unused payment_proof upload of yours")
+			return
+		}
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
-		log.Printf("Error creating notification: %v", err)
-		http.Error(w, "Failed to send notification", http.StatusInternalServerError)
+		logger.Error(r.Context(), "Error creating notification", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to send notification")
 		return
 	}
+	WakePushDispatcher()
+	WakeEventDispatcher()
 
 	// Update floor status to 'pending' to show "Request Pending"
 	// TODO: Uncomment this after adding status column to floor table
@@ -2999,7 +2942,7 @@ func SendPaymentNotificationHandler(w http.ResponseWriter, r *http.Request) {
 	// Note: Payment records are now only created when the manager accepts the notification
 	// This prevents duplicate payment records from being created
 
-	log.Printf("Successfully sent payment notification: ID=%d, from user=%d to manager=%d", notificationID, userID, managerID)
+	logger.Info(r.Context(), "Payment notification sent", "notification_id", notificationID, "manager_id", managerID)
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "message": "Payment notification sent"})
@@ -3008,35 +2951,23 @@ func SendPaymentNotificationHandler(w http.ResponseWriter, r *http.Request) {
 // HandlePaymentNotificationAction handles POST requests to accept/reject payment notifications
 func HandlePaymentNotificationAction(w http.ResponseWriter, r *http.Request) {
 	// This function is no longer needed as payment notifications are handled in HandleTenantRequestAction
-	http.Error(w, "Use /notifications/action endpoint instead", http.StatusMovedPermanently)
+	apierror.Write(w, r, http.StatusGone, "Use /notifications/action endpoint instead")
 }
 
 // GetPaymentDetailsHandler handles GET requests to get payment details for a floor
 func GetPaymentDetailsHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("\n=== New Get Payment Details Request ===")
-	fmt.Printf("Method: %s\n", r.Method)
-	fmt.Printf("URL: %s\n", r.URL)
-
 	// Set response header to JSON
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"message": "Method not allowed",
-		})
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	// Get user ID from session
 	userID := getUserIDFromContext(r)
 	if userID == 0 {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"message": "User not authenticated",
-		})
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
@@ -3044,21 +2975,13 @@ func GetPaymentDetailsHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	floorID, err := strconv.ParseInt(vars["floor_id"], 10, 64)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"message": "Invalid floor ID",
-		})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid floor ID")
 		return
 	}
 
 	db, err := config.GetDBConnection()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"message": "Database connection error",
-		})
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
 		return
 	}
 
@@ -3066,11 +2989,7 @@ func GetPaymentDetailsHandler(w http.ResponseWriter, r *http.Request) {
 	var tenantID sql.NullInt64
 	err = db.QueryRow(`SELECT tenant FROM floor WHERE id = ?`, floorID).Scan(&tenantID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"message": "Floor not found",
-		})
+		apierror.Write(w, r, http.StatusInternalServerError, "Floor not found")
 		return
 	}
 
@@ -3089,19 +3008,19 @@ func GetPaymentDetailsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Calculate total outstanding rent: sum(rent - received_money) for all payments of this floor and tenant
+	// Total outstanding rent is the running balance of the tenant's latest payment
 	var totalOutstandingRent sql.NullFloat64
 	err = db.QueryRow(`
-		SELECT COALESCE(SUM(rent - recieved_money), 0) as total_outstanding
-		FROM payment
-		WHERE fid = ? AND uid = ?
+		SELECT COALESCE((
+			SELECT balance_rent
+			FROM payment
+			WHERE fid = ? AND uid = ?
+			ORDER BY seq DESC
+			LIMIT 1
+		), 0) as total_outstanding
 	`, floorID, tenantID.Int64).Scan(&totalOutstandingRent)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"message": "Error calculating outstanding rent",
-		})
+		apierror.Write(w, r, http.StatusInternalServerError, "Error calculating outstanding rent")
 		return
 	}
 
@@ -3133,11 +3052,7 @@ func GetPaymentDetailsHandler(w http.ResponseWriter, r *http.Request) {
 			})
 			return
 		}
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"message": "Error retrieving payment details",
-		})
+		apierror.Write(w, r, http.StatusInternalServerError, "Error retrieving payment details")
 		return
 	}
 
@@ -3160,8 +3075,7 @@ func GetPaymentDetailsHandler(w http.ResponseWriter, r *http.Request) {
 			totalOutstanding = int64(totalOutstandingRent.Float64)
 		}
 
-		fmt.Printf("Payment details for floor %d: Total Outstanding=%d, Latest Rent=%d, Received=%d, Created=%s\n",
-				floorID, totalOutstanding, rentValue, receivedMoneyValue, createdAt)
+		logger.Debug(r.Context(), "Payment details retrieved", "total_outstanding", totalOutstanding, "rent", rentValue, "received_money", receivedMoneyValue)
 
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(map[string]interface{}{
@@ -3177,25 +3091,18 @@ func GetPaymentDetailsHandler(w http.ResponseWriter, r *http.Request) {
 
 // GetPendingPaymentNotificationsHandler handles GET requests to get pending payment notifications for a floor
 func GetPendingPaymentNotificationsHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("\n=== New Get Pending Payment Notifications Request ===")
-	fmt.Printf("Method: %s\n", r.Method)
-	fmt.Printf("URL: %s\n", r.URL)
-
 	// Set response header to JSON
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(NotificationsResponse{false, "Method not allowed", nil})
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	// Get user ID from session
 	userID := getUserIDFromContext(r)
 	if userID == 0 {
-		fmt.Println("No user ID found in session")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(NotificationsResponse{false, "User not authenticated", nil})
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
@@ -3203,23 +3110,20 @@ func GetPendingPaymentNotificationsHandler(w http.ResponseWriter, r *http.Reques
 	vars := mux.Vars(r)
 	propertyID, err := strconv.ParseInt(vars["id"], 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid property ID", http.StatusBadRequest)
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid property ID")
 		return
 	}
 
 	floorID, err := strconv.ParseInt(vars["floor_id"], 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid floor ID", http.StatusBadRequest)
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid floor ID")
 		return
 	}
 
-	fmt.Printf("Fetching pending payment notifications for floor ID: %d, property ID: %d, user ID: %d\n", floorID, propertyID, userID)
-
 	db, err := config.GetDBConnection()
 	if err != nil {
-		fmt.Printf("Database connection error: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(NotificationsResponse{false, "Database connection error", nil})
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
 		return
 	}
 
@@ -3232,75 +3136,71 @@ func GetPendingPaymentNotificationsHandler(w http.ResponseWriter, r *http.Reques
 		)`, floorID, propertyID, userID).Scan(&isTenant)
 	
 	if err != nil {
-		fmt.Printf("Error checking tenant status: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(NotificationsResponse{false, "Error checking tenant status", nil})
+		logger.Error(r.Context(), "Error checking tenant status", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error checking tenant status")
 		return
 	}
 
 	if !isTenant {
-		fmt.Printf("User %d is not a tenant of floor %d in property %d\n", userID, floorID, propertyID)
-		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(NotificationsResponse{false, "You are not a tenant of this floor", nil})
+		logger.Warn(r.Context(), "User is not a tenant of floor")
+		apierror.Write(w, r, http.StatusForbidden, "You are not a tenant of this floor")
 		return
 	}
 
 	// Get pending payment notifications for this floor where the user is the sender
 	query := `
 		SELECT 
-			n.id, n.message, n.status, n.created_at,
+			n.id, n.message, n.kind, n.status, n.created_at,
 			p.id as property_id, p.name as property_name,
 			f.id as floor_id, f.name as floor_name,
-			false as show_actions
+			false as show_actions,
+			n.transaction_reference, ` + paymentProofColumns + `
 		FROM notification n
 		JOIN property p ON n.pid = p.id
 		JOIN floor f ON n.fid = f.id
+		LEFT JOIN upload pr ON pr.id = n.proof_upload_id
 		WHERE n.fid = ? 
 		AND n.sender = ? 
 		AND n.message LIKE 'Payment amount:%'
 		AND n.status = 'pending'
 		ORDER BY n.created_at DESC
 	`
-	
-	fmt.Printf("Executing query: %s with floorID: %d, userID: %d\n", query, floorID, userID)
-	
+
 	rows, err := db.Query(query, floorID, userID)
 	if err != nil {
-		fmt.Printf("Error querying notifications: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(NotificationsResponse{false, "Error fetching notifications", nil})
+		logger.Error(r.Context(), "Error querying notifications", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error fetching notifications")
 		return
 	}
 	defer rows.Close()
 
 	var notifications []Notification
+	expires := fileURLExpiry()
 	for rows.Next() {
 		var n Notification
-		if err := rows.Scan(
-			&n.ID, &n.Message, &n.Status, &n.CreatedAt,
+		var proof paymentProofRow
+		if err := rows.Scan(append([]interface{}{
+			&n.ID, &n.Message, &n.Kind, &n.Status, &n.CreatedAt,
 			&n.Property.ID, &n.Property.Name,
 			&n.Floor.ID, &n.Floor.Name,
 			&n.ShowActions,
-		); err != nil {
-			fmt.Printf("Error scanning notification row: %v\n", err)
+		}, proof.dest()...)...); err != nil {
+			logger.Error(r.Context(), "Error scanning notification row", "error", err)
 			continue
 		}
-		
-		// Debug logging for each notification
-		fmt.Printf("Payment notification: ID=%d, Message='%s', Status='%s'\n", 
-			n.ID, n.Message, n.Status)
-		
+		n.TransactionReference = proof.transactionReference()
+		n.PaymentProof = proof.upload(expires)
+
 		notifications = append(notifications, n)
 	}
 
 	if err = rows.Err(); err != nil {
-		fmt.Printf("Error iterating notification rows: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(NotificationsResponse{false, "Error processing notifications", nil})
+		logger.Error(r.Context(), "Error iterating notification rows", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error processing notifications")
 		return
 	}
 
-	fmt.Printf("Found %d pending payment notifications for floor %d\n", len(notifications), floorID)
+	logger.Debug(r.Context(), "Pending payment notifications retrieved", "count", len(notifications))
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(NotificationsResponse{
@@ -3312,49 +3212,51 @@ func GetPendingPaymentNotificationsHandler(w http.ResponseWriter, r *http.Reques
 
 // MarkNotificationsAsReadHandler handles POST requests to mark notifications as read
 func MarkNotificationsAsReadHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("\n=== New Mark Notifications As Read Request ===")
-	fmt.Printf("Method: %s\n", r.Method)
-	fmt.Printf("URL: %s\n", r.URL)
-
 	// Set response header to JSON
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "Method not allowed"})
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	// Get user ID from session
 	userID := getUserIDFromContext(r)
 	if userID == 0 {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "User not authenticated"})
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
 	db, err := config.GetDBConnection()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "Database connection error"})
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
 		return
 	}
 
 	// Mark all notifications for this user as read
-	_, err = db.Exec(`
+	result, err := db.Exec(`
 		UPDATE notification 
 		SET is_read = true, updated_at = NOW(), updated_by = ?
 		WHERE receiver = ? AND is_read = false
 	`, userID, userID)
 
 	if err != nil {
-		fmt.Printf("Error marking notifications as read: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "Error marking notifications as read"})
+		logger.Error(r.Context(), "Error marking notifications as read", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error marking notifications as read")
 		return
 	}
 
-	fmt.Printf("Marked all notifications as read for user %d\n", userID)
+	// Let the user's other devices clear their badges; they catch up over
+	// REST if this is lost, so it does not fail the request
+	if marked, _ := result.RowsAffected(); marked > 0 {
+		if err := recordEvent(db, EventNotificationsRead, map[string]int{"unread_count": 0}, userID); err != nil {
+			logger.Warn(r.Context(), "Error recording notifications read event", "error", err)
+		} else {
+			WakeEventDispatcher()
+		}
+	}
+
+	logger.Debug(r.Context(), "Notifications marked as read")
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(TenantRequestResponse{
@@ -3368,16 +3270,14 @@ func AddTenantToFloorHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "Method not allowed"})
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	// Get user ID from session
 	userID := getUserIDFromContext(r)
 	if userID == 0 {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "User not authenticated"})
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
@@ -3385,14 +3285,12 @@ func AddTenantToFloorHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	propertyID, err := strconv.ParseInt(vars["id"], 10, 64)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "Invalid property ID"})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid property ID")
 		return
 	}
 	floorID, err := strconv.ParseInt(vars["floor_id"], 10, 64)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "Invalid floor ID"})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid floor ID")
 		return
 	}
 
@@ -3402,20 +3300,24 @@ func AddTenantToFloorHandler(w http.ResponseWriter, r *http.Request) {
 		PhoneNumber string `json:"phone_number"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "Invalid request body"})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 	if req.Name == "" || req.PhoneNumber == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "Name and phone number are required"})
+		fields := make(map[string]string)
+		if req.Name == "" {
+			fields["name"] = "Name is required"
+		}
+		if req.PhoneNumber == "" {
+			fields["phone_number"] = "Phone number is required"
+		}
+		apierror.WriteFields(w, r, "Name and phone number are required", fields)
 		return
 	}
 
 	db, err := config.GetDBConnection()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "Database connection error"})
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
 		return
 	}
 
@@ -3424,22 +3326,26 @@ func AddTenantToFloorHandler(w http.ResponseWriter, r *http.Request) {
 	err = db.QueryRow(`SELECT id FROM user WHERE phone_number = ?`, req.PhoneNumber).Scan(&tenantID)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			w.WriteHeader(http.StatusNotFound)
-			json.NewEncoder(w).Encode(TenantRequestResponse{false, "User not found with this phone number"})
+			apierror.Write(w, r, http.StatusNotFound, "User not found with this phone number")
 			return
 		}
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "Error finding user"})
+		apierror.Write(w, r, http.StatusInternalServerError, "Error finding user")
+		return
+	}
+
+	previousTenant, err := floorTenant(db, propertyID, floorID)
+	if err != nil {
+		apierror.Write(w, r, http.StatusInternalServerError, "Error finding floor")
 		return
 	}
 
 	// Update floor with tenant
 	_, err = db.Exec(`UPDATE floor SET tenant = ?, updated_at = NOW(), updated_by = ? WHERE id = ? AND pid = ?`, tenantID, userID, floorID, propertyID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(TenantRequestResponse{false, "Error updating floor with tenant"})
+		apierror.Write(w, r, http.StatusInternalServerError, "Error updating floor with tenant")
 		return
 	}
+	tenantChanged(r.Context(), propertyID, previousTenant, tenantID)
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(TenantRequestResponse{true, "Tenant added to floor successfully"})
@@ -3447,30 +3353,18 @@ func AddTenantToFloorHandler(w http.ResponseWriter, r *http.Request) {
 
 // SendCommentHandler handles POST requests to send comments to notifications
 func SendCommentHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("\n=== New Send Comment Request ===")
-	fmt.Printf("Method: %s\n", r.Method)
-	fmt.Printf("URL: %s\n", r.URL)
-
 	// Set response header to JSON
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"message": "Method not allowed",
-		})
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	// Get user ID from session
 	userID := getUserIDFromContext(r)
 	if userID == 0 {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"message": "User not authenticated",
-		})
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
@@ -3481,23 +3375,23 @@ func SendCommentHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
 	// Get database connection
 	db, err := config.GetDBConnection()
 	if err != nil {
-		fmt.Printf("Database connection error: %v\n", err)
-		http.Error(w, "Database connection failed", http.StatusInternalServerError)
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection failed")
 		return
 	}
 
 	// Start transaction
 	tx, err := db.Begin()
 	if err != nil {
-		fmt.Printf("Transaction start error: %v\n", err)
-		http.Error(w, "Failed to start transaction", http.StatusInternalServerError)
+		logger.Error(r.Context(), "Transaction start error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to start transaction")
 		return
 	}
 	defer tx.Rollback()
@@ -3528,11 +3422,11 @@ func SendCommentHandler(w http.ResponseWriter, r *http.Request) {
 	)
 
 	if err != nil {
-		fmt.Printf("Error getting notification: %v\n", err)
+		logger.Error(r.Context(), "Error getting notification", "error", err)
 		if err == sql.ErrNoRows {
-			http.Error(w, "Notification not found", http.StatusNotFound)
+			apierror.Write(w, r, http.StatusNotFound, "Notification not found")
 		} else {
-			http.Error(w, "Failed to get notification", http.StatusInternalServerError)
+			apierror.Write(w, r, http.StatusInternalServerError, "Failed to get notification")
 		}
 		return
 	}
@@ -3569,19 +3463,11 @@ func SendCommentHandler(w http.ResponseWriter, r *http.Request) {
 		newMessage = "Response sent"
 	}
 
-	// Generate new notification ID
-	newNotificationID, err := utils.GenerateRandomID()
-	if err != nil {
-		fmt.Printf("Error generating notification ID: %v\n", err)
-		http.Error(w, "Error generating notification ID", http.StatusInternalServerError)
-		return
-	}
-
-	// Create notification with push notification (outside transaction since SendNotificationWithPush manages its own DB connection)
-	err = SendNotificationWithPush(newSender, newReceiver, originalNotification.PID, originalNotification.FloorID, newMessage, newStatus, nil)
+	// Create the notification and queue its push in the same transaction
+	newNotificationID, err := SendNotificationWithPushTx(r.Context(), tx, newSender, newReceiver, originalNotification.PID, originalNotification.FloorID, newMessage, newStatus, nil)
 	if err != nil {
-		fmt.Printf("Error creating notification: %v\n", err)
-		http.Error(w, "Failed to create notification", http.StatusInternalServerError)
+		logger.Error(r.Context(), "Error creating notification", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to create notification")
 		return
 	}
 
@@ -3593,20 +3479,55 @@ func SendCommentHandler(w http.ResponseWriter, r *http.Request) {
 	`, request.Comment, userID, request.NotificationID)
 
 	if err != nil {
-		fmt.Printf("Error updating original notification comment: %v\n", err)
-		http.Error(w, "Failed to update original notification comment", http.StatusInternalServerError)
+		logger.Error(r.Context(), "Error updating original notification comment", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to update original notification comment")
+		return
+	}
+
+	// Apps that read threads see the comment there too
+	if request.Comment != "" {
+		if _, err := appendComment(tx, originalNotification.PID, originalNotification.FloorID, newSender, newReceiver, request.Comment, originalNotification.ID, newNotificationID); err != nil {
+			logger.Error(r.Context(), "Error adding comment to thread", "error", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "Failed to create notification")
+			return
+		}
+	}
+
+	// Stream the reply to both sides of the conversation, and the comment
+	// on the original to whoever is viewing it
+	message := NotificationEvent{
+		NotificationID: newNotificationID,
+		Status:         newStatus,
+		Message:        newMessage,
+		SenderID:       newSender,
+		ReceiverID:     newReceiver,
+		PropertyID:     originalNotification.PID,
+		FloorID:        originalNotification.FloorID,
+		InReplyTo:      originalNotification.ID,
+		CreatedAt:      time.Now().In(config.App.Location).Format("2006-01-02 15:04:05"),
+	}
+	if err := recordEvent(tx, EventConversationMessage, message, newSender, newReceiver); err != nil {
+		logger.Error(r.Context(), "Error recording conversation event", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to create notification")
+		return
+	}
+	updated := NotificationEvent{NotificationID: originalNotification.ID, Comment: &request.Comment}
+	if err := recordEvent(tx, EventNotificationUpdated, updated, originalNotification.Sender, originalNotification.Receiver); err != nil {
+		logger.Error(r.Context(), "Error recording notification event", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to update original notification comment")
 		return
 	}
 
 	// Commit transaction
 	if err = tx.Commit(); err != nil {
-		fmt.Printf("Error committing transaction: %v\n", err)
-		http.Error(w, "Failed to commit transaction", http.StatusInternalServerError)
+		logger.Error(r.Context(), "Error committing transaction", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to commit transaction")
 		return
 	}
+	WakePushDispatcher()
+	WakeEventDispatcher()
 
-	fmt.Printf("Created comment notification: ID=%d, Message='%s', from user=%d to user=%d", 
-		newNotificationID, newMessage, newSender, newReceiver)
+	logger.Info(r.Context(), "Comment notification created", "notification_id", newNotificationID, "sender_id", newSender, "receiver_id", newReceiver)
 
 	// Send response
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -3618,59 +3539,39 @@ func SendCommentHandler(w http.ResponseWriter, r *http.Request) {
 
 // GetConversationHistoryHandler handles GET requests to retrieve conversation history between users
 func GetConversationHistoryHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("\n=== New Get Conversation History Request ===")
-	fmt.Printf("Method: %s\n", r.Method)
-	fmt.Printf("URL: %s\n", r.URL)
-
 	// Set response header to JSON
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"message": "Method not allowed",
-		})
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	// Get user ID from session
 	userID := getUserIDFromContext(r)
 	if userID == 0 {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"message": "User not authenticated",
-		})
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
 	// Get the floor ID from query parameters
 	floorIDStr := r.URL.Query().Get("floor_id")
 	if floorIDStr == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"message": "floor_id parameter is required",
-		})
+		apierror.Field(w, r, "floor_id", "floor_id parameter is required")
 		return
 	}
 
 	floorID, err := strconv.ParseInt(floorIDStr, 10, 64)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"message": "Invalid floor_id parameter",
-		})
+		apierror.Field(w, r, "floor_id", "Invalid floor_id parameter")
 		return
 	}
 
 	// Get database connection
 	db, err := config.GetDBConnection()
 	if err != nil {
-		fmt.Printf("Database connection error: %v\n", err)
-		http.Error(w, "Database connection failed", http.StatusInternalServerError)
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection failed")
 		return
 	}
 
@@ -3719,8 +3620,8 @@ func GetConversationHistoryHandler(w http.ResponseWriter, r *http.Request) {
 	`, floorID, userID, userID, floorID, userID, userID, userID, userID, floorID, userID, userID)
 
 	if err != nil {
-		fmt.Printf("Error querying conversation history: %v\n", err)
-		http.Error(w, "Failed to get conversation history", http.StatusInternalServerError)
+		logger.Error(r.Context(), "Error querying conversation history", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to get conversation history")
 		return
 	}
 	defer rows.Close()
@@ -3760,7 +3661,7 @@ func GetConversationHistoryHandler(w http.ResponseWriter, r *http.Request) {
 		)
 
 		if err != nil {
-			fmt.Printf("Error scanning conversation row: %v\n", err)
+			logger.Error(r.Context(), "Error scanning conversation row", "error", err)
 			continue
 		}
 
@@ -3789,8 +3690,8 @@ func GetConversationHistoryHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err = rows.Err(); err != nil {
-		fmt.Printf("Error iterating conversation rows: %v\n", err)
-		http.Error(w, "Failed to process conversation history", http.StatusInternalServerError)
+		logger.Error(r.Context(), "Error iterating conversation rows", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to process conversation history")
 		return
 	}
 
@@ -3804,26 +3705,18 @@ func GetConversationHistoryHandler(w http.ResponseWriter, r *http.Request) {
 
 // CreateAdvancePaymentRequestHandler handles POST requests to create an advance payment request
 func CreateAdvancePaymentRequestHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("\n=== New Create Advance Payment Request ===")
-	fmt.Printf("Method: %s\n", r.Method)
-	fmt.Printf("URL: %s\n", r.URL)
-
 	// Set response header to JSON
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(AdvancePaymentResponse{false, "Method not allowed", 0})
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	// Get user ID from session
 	userID := getUserIDFromContext(r)
-	fmt.Printf("User ID from session: %d\n", userID)
 	if userID == 0 {
-		fmt.Println("No user ID found in session")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(AdvancePaymentResponse{false, "User not authenticated", 0})
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
@@ -3836,81 +3729,61 @@ func CreateAdvancePaymentRequestHandler(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
-	fmt.Printf("URL parts: %v\n", cleanParts)
-
 	if len(cleanParts) != 5 {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(AdvancePaymentResponse{false, "Invalid URL format", 0})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid URL format")
 		return
 	}
 
 	propertyID, err := strconv.ParseInt(cleanParts[1], 10, 64)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(AdvancePaymentResponse{false, "Invalid property ID", 0})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid property ID")
 		return
 	}
 
 	floorID, err := strconv.ParseInt(cleanParts[3], 10, 64)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(AdvancePaymentResponse{false, "Invalid floor ID", 0})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid floor ID")
 		return
 	}
 
-	fmt.Printf("Property ID: %d, Floor ID: %d\n", propertyID, floorID)
-
 	var req AdvancePaymentRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		fmt.Printf("Error decoding request body: %v\n", err)
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(AdvancePaymentResponse{false, "Invalid request body", 0})
+		logger.Error(r.Context(), "Error decoding request body", "error", err)
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
-	fmt.Printf("Request body: %+v\n", req)
-
 	// Validate request
 	if req.AdvanceUID <= 0 {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(AdvancePaymentResponse{false, "Invalid advance_uid", 0})
+		apierror.Field(w, r, "advance_uid", "Invalid advance_uid")
 		return
 	}
 
 	if req.Money <= 0 {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(AdvancePaymentResponse{false, "Money amount must be greater than 0", 0})
+		apierror.Field(w, r, "money", "Money amount must be greater than 0")
 		return
 	}
 
-
-
 	db, err := config.GetDBConnection()
 	if err != nil {
-		fmt.Printf("Database connection error: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(AdvancePaymentResponse{false, "Database connection error", 0})
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
 		return
 	}
 
 	// Check if user is a manager of the property
 	var isManager bool
 	managerQuery := "SELECT EXISTS(SELECT 1 FROM takes_care_of WHERE uid = ? AND pid = ?)"
-	fmt.Printf("Executing manager check query: %s with userID: %d, propertyID: %d\n", managerQuery, userID, propertyID)
 	
 	err = db.QueryRow(managerQuery, userID, propertyID).Scan(&isManager)
 	if err != nil {
-		fmt.Printf("Error checking manager status: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(AdvancePaymentResponse{false, "Database error", 0})
+		logger.Error(r.Context(), "Error checking manager status", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database error")
 		return
 	}
 
-	fmt.Printf("Is user %d manager of property %d: %v\n", userID, propertyID, isManager)
-
 	if !isManager {
-		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(AdvancePaymentResponse{false, "Only managers can create advance payment requests", 0})
+		apierror.Write(w, r, http.StatusForbidden, "Only managers can create advance payment requests")
 		return
 	}
 
@@ -3918,15 +3791,13 @@ func CreateAdvancePaymentRequestHandler(w http.ResponseWriter, r *http.Request)
 	var floorExists bool
 	err = db.QueryRow("SELECT EXISTS(SELECT 1 FROM floor WHERE id = ? AND pid = ?)", floorID, propertyID).Scan(&floorExists)
 	if err != nil {
-		fmt.Printf("Error checking floor existence: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(AdvancePaymentResponse{false, "Database error", 0})
+		logger.Error(r.Context(), "Error checking floor existence", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database error")
 		return
 	}
 
 	if !floorExists {
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(AdvancePaymentResponse{false, "Floor not found", 0})
+		apierror.Write(w, r, http.StatusNotFound, "Floor not found")
 		return
 	}
 
@@ -3934,29 +3805,26 @@ func CreateAdvancePaymentRequestHandler(w http.ResponseWriter, r *http.Request)
 	var userExists bool
 	err = db.QueryRow("SELECT EXISTS(SELECT 1 FROM user WHERE id = ?)", req.AdvanceUID).Scan(&userExists)
 	if err != nil {
-		fmt.Printf("Error checking user existence: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(AdvancePaymentResponse{false, "Database error", 0})
+		logger.Error(r.Context(), "Error checking user existence", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database error")
 		return
 	}
 
 	if !userExists {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(AdvancePaymentResponse{false, "User not found", 0})
+		apierror.Write(w, r, http.StatusBadRequest, "User not found")
 		return
 	}
 
 	// Generate random ID for advance payment
 	advanceID, err := utils.GenerateRandomID()
 	if err != nil {
-		fmt.Printf("Error generating advance payment ID: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(AdvancePaymentResponse{false, "Error generating advance payment ID", 0})
+		logger.Error(r.Context(), "Error generating advance payment ID", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error generating advance payment ID")
 		return
 	}
 
 	// Insert advance payment record
-	currentTime := time.Now().In(time.FixedZone("BDT", 6*60*60)).Format("2006-01-02")
+	currentTime := time.Now().In(config.App.Location).Format("2006-01-02")
 	_, err = db.Exec(`
 		INSERT INTO advance (
 			id, advance_uid, money, fid, created_at, created_by, updated_at, updated_by, status
@@ -3973,9 +3841,8 @@ func CreateAdvancePaymentRequestHandler(w http.ResponseWriter, r *http.Request)
 	)
 
 	if err != nil {
-		fmt.Printf("Error creating advance payment record: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(AdvancePaymentResponse{false, fmt.Sprintf("Error creating advance payment record: %v", err), 0})
+		logger.Error(r.Context(), "Error creating advance payment record", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, fmt.Sprintf("Error creating advance payment record: %v", err))
 		return
 	}
 
@@ -3985,17 +3852,15 @@ func CreateAdvancePaymentRequestHandler(w http.ResponseWriter, r *http.Request)
 		message := fmt.Sprintf("Advance payment request: %d tk", req.Money)
 
 		// Create notification with push notification
-		err = SendNotificationWithPush(userID, req.AdvanceUID, propertyID, floorID, message, "pending", nil)
+		err = SendNotificationWithPush(r.Context(), userID, req.AdvanceUID, propertyID, floorID, message, "pending", nil)
 		if err != nil {
-			fmt.Printf("Error creating notification: %v\n", err)
+			logger.Error(r.Context(), "Error creating notification", "error", err)
 			// Don't fail the entire request if notification creation fails
-			fmt.Printf("Failed to create notification for advance payment")
 		} else {
-			fmt.Printf("Successfully created notification with push for advance payment request")
 		}
 	}
 
-	fmt.Printf("Successfully created advance payment record ID: %d for floor ID: %d and user ID: %d\n", advanceID, floorID, req.AdvanceUID)
+	logger.Info(r.Context(), "Advance payment record created", "advance_id", advanceID, "advance_uid", req.AdvanceUID)
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(AdvancePaymentResponse{
@@ -4007,26 +3872,18 @@ func CreateAdvancePaymentRequestHandler(w http.ResponseWriter, r *http.Request)
 
 // CheckPendingAdvancePaymentHandler handles GET requests to check if there's a pending advance payment for a floor
 func CheckPendingAdvancePaymentHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("\n=== New Check Pending Advance Payment Request ===")
-	fmt.Printf("Method: %s\n", r.Method)
-	fmt.Printf("URL: %s\n", r.URL)
-
 	// Set response header to JSON
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(AdvancePaymentCheckResponse{false, "Method not allowed", false})
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	// Get user ID from session
 	userID := getUserIDFromContext(r)
-	fmt.Printf("User ID from session: %d\n", userID)
 	if userID == 0 {
-		fmt.Println("No user ID found in session")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(AdvancePaymentCheckResponse{false, "User not authenticated", false})
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
@@ -4039,28 +3896,21 @@ func CheckPendingAdvancePaymentHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	fmt.Printf("URL parts: %v\n", cleanParts)
-
 	if len(cleanParts) != 3 {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(AdvancePaymentCheckResponse{false, "Invalid URL format", false})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid URL format")
 		return
 	}
 
 	floorID, err := strconv.ParseInt(cleanParts[1], 10, 64)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(AdvancePaymentCheckResponse{false, "Invalid floor ID", false})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid floor ID")
 		return
 	}
 
-	fmt.Printf("Floor ID: %d\n", floorID)
-
 	db, err := config.GetDBConnection()
 	if err != nil {
-		fmt.Printf("Database connection error: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(AdvancePaymentCheckResponse{false, "Database connection error", false})
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
 		return
 	}
 
@@ -4068,13 +3918,12 @@ func CheckPendingAdvancePaymentHandler(w http.ResponseWriter, r *http.Request) {
 	var hasPending bool
 	err = db.QueryRow("SELECT EXISTS(SELECT 1 FROM advance WHERE fid = ? AND status = 'pending')", floorID).Scan(&hasPending)
 	if err != nil {
-		fmt.Printf("Error checking pending advance payment: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(AdvancePaymentCheckResponse{false, "Database error", false})
+		logger.Error(r.Context(), "Error checking pending advance payment", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database error")
 		return
 	}
 
-	fmt.Printf("Floor %d has pending advance payment: %v\n", floorID, hasPending)
+	logger.Debug(r.Context(), "Pending advance payment checked", "has_pending", hasPending)
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(AdvancePaymentCheckResponse{
@@ -4086,26 +3935,18 @@ func CheckPendingAdvancePaymentHandler(w http.ResponseWriter, r *http.Request) {
 
 // CancelAdvancePaymentHandler handles DELETE requests to cancel an advance payment request
 func CancelAdvancePaymentHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("\n=== New Cancel Advance Payment Request ===")
-	fmt.Printf("Method: %s\n", r.Method)
-	fmt.Printf("URL: %s\n", r.URL)
-
 	// Set response header to JSON
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodDelete {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(AdvancePaymentResponse{false, "Method not allowed", 0})
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	// Get user ID from session
 	userID := getUserIDFromContext(r)
-	fmt.Printf("User ID from session: %d\n", userID)
 	if userID == 0 {
-		fmt.Println("No user ID found in session")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(AdvancePaymentResponse{false, "User not authenticated", 0})
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
@@ -4118,28 +3959,21 @@ func CancelAdvancePaymentHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	fmt.Printf("URL parts: %v\n", cleanParts)
-
 	if len(cleanParts) != 3 {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(AdvancePaymentResponse{false, "Invalid URL format", 0})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid URL format")
 		return
 	}
 
 	floorID, err := strconv.ParseInt(cleanParts[1], 10, 64)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(AdvancePaymentResponse{false, "Invalid floor ID", 0})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid floor ID")
 		return
 	}
 
-	fmt.Printf("Floor ID: %d\n", floorID)
-
 	db, err := config.GetDBConnection()
 	if err != nil {
-		fmt.Printf("Database connection error: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(AdvancePaymentResponse{false, "Database connection error", 0})
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
 		return
 	}
 
@@ -4147,57 +3981,48 @@ func CancelAdvancePaymentHandler(w http.ResponseWriter, r *http.Request) {
 	var propertyID int64
 	err = db.QueryRow("SELECT pid FROM floor WHERE id = ?", floorID).Scan(&propertyID)
 	if err != nil {
-		fmt.Printf("Error getting property ID for floor: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(AdvancePaymentResponse{false, "Database error", 0})
+		logger.Error(r.Context(), "Error getting property ID for floor", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database error")
 		return
 	}
 
 	// Check if user is a manager of the property
 	var isManager bool
 	managerQuery := "SELECT EXISTS(SELECT 1 FROM takes_care_of WHERE uid = ? AND pid = ?)"
-	fmt.Printf("Executing manager check query: %s with userID: %d, propertyID: %d\n", managerQuery, userID, propertyID)
 	
 	err = db.QueryRow(managerQuery, userID, propertyID).Scan(&isManager)
 	if err != nil {
-		fmt.Printf("Error checking manager status: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(AdvancePaymentResponse{false, "Database error", 0})
+		logger.Error(r.Context(), "Error checking manager status", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database error")
 		return
 	}
 
-	fmt.Printf("Is user %d manager of property %d: %v\n", userID, propertyID, isManager)
-
 	if !isManager {
-		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(AdvancePaymentResponse{false, "Only managers can cancel advance payment requests", 0})
+		apierror.Write(w, r, http.StatusForbidden, "Only managers can cancel advance payment requests")
 		return
 	}
 
 	// Delete the pending advance payment record
 	result, err := db.Exec("DELETE FROM advance WHERE fid = ? AND status = 'pending'", floorID)
 	if err != nil {
-		fmt.Printf("Error deleting advance payment record: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(AdvancePaymentResponse{false, fmt.Sprintf("Error deleting advance payment record: %v", err), 0})
+		logger.Error(r.Context(), "Error deleting advance payment record", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, fmt.Sprintf("Error deleting advance payment record: %v", err))
 		return
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		fmt.Printf("Error getting rows affected: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(AdvancePaymentResponse{false, "Database error", 0})
+		logger.Error(r.Context(), "Error getting rows affected", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database error")
 		return
 	}
 
 	if rowsAffected == 0 {
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(AdvancePaymentResponse{false, "No pending advance payment found for this floor", 0})
+		apierror.Write(w, r, http.StatusNotFound, "No pending advance payment found for this floor")
 		return
 	}
 
-	fmt.Printf("Successfully deleted advance payment record for floor ID: %d\n", floorID)
+	logger.Info(r.Context(), "Advance payment record deleted", "floor_id", floorID)
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(AdvancePaymentResponse{
@@ -4208,63 +4033,40 @@ func CancelAdvancePaymentHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func GetPaymentHistoryHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("\n=== New Get Payment History Request ===")
-	fmt.Printf("Method: %s\n", r.Method)
-	fmt.Printf("URL: %s\n", r.URL)
-
 	// Set response header to JSON
 	w.Header().Set("Content-Type", "application/json")
 
 	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"message": "Method not allowed",
-		})
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	// Get user ID from context
 	userID := getUserIDFromContext(r)
 	if userID == 0 {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"message": "Unauthorized",
-		})
+		apierror.Write(w, r, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
-	fmt.Printf("User authenticated: ID=%d\n", userID)
-
 	// Parse URL to get floor ID
 	urlParts := strings.Split(r.URL.Path, "/")
-	fmt.Printf("URL parts: %v\n", urlParts)
 	
 	if len(urlParts) < 4 {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"message": "Invalid URL format",
-		})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid URL format")
 		return
 	}
 
 	floorID, err := strconv.ParseInt(urlParts[2], 10, 64)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"message": "Invalid floor ID",
-		})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid floor ID")
 		return
 	}
 
-	fmt.Printf("Floor ID: %d\n", floorID)
-
-	// Parse pagination parameters
+	// Parse pagination parameters. cursor (next_cursor from the previous
+	// page) is preferred; page is still accepted for older clients.
 	page := 1
 	limit := 25
+	var cursor int64
 	
 	if pageStr := r.URL.Query().Get("page"); pageStr != "" {
 		if parsedPage, err := strconv.Atoi(pageStr); err == nil && parsedPage > 0 {
@@ -4277,30 +4079,47 @@ func GetPaymentHistoryHandler(w http.ResponseWriter, r *http.Request) {
 			limit = parsedLimit
 		}
 	}
-	
-	offset := (page - 1) * limit
+
+	if cursorStr := r.URL.Query().Get("cursor"); cursorStr != "" {
+		cursor, err = strconv.ParseInt(cursorStr, 10, 64)
+		if err != nil || cursor < 1 {
+			apierror.Field(w, r, "cursor", "cursor is invalid; pass next_cursor from the previous page unchanged")
+			return
+		}
+	}
 
 	// Get database connection
 	db, err := config.GetDBConnection()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"message": "Database connection error",
-		})
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
 		return
 	}
 
-	// Get the tenant ID for this floor first
+	writePaymentHistory(w, r, db, userID, floorID, page, limit, cursor)
+}
+
+// writePaymentHistory writes a page of the payment history of floorID's
+// current tenant. Payments carry signed proof URLs, so only that tenant and
+// the property's managers may see them.
+func writePaymentHistory(w http.ResponseWriter, r *http.Request, db *sql.DB, userID, floorID int64, page, limit int, cursor int64) {
 	var tenantID sql.NullInt64
-	err = db.QueryRow("SELECT tenant FROM floor WHERE id = ?", floorID).Scan(&tenantID)
+	var allowed bool
+	err := db.QueryRow(`
+		SELECT f.tenant, f.tenant <=> ? OR EXISTS (SELECT 1 FROM takes_care_of c WHERE c.pid = f.pid AND c.uid = ?)
+		FROM floor f
+		WHERE f.id = ?`, userID, userID, floorID).Scan(&tenantID, &allowed)
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, r, http.StatusNotFound, "Floor not found")
+		return
+	}
 	if err != nil {
-		fmt.Printf("Error getting tenant for floor: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"message": "Error getting tenant information",
-		})
+		logger.Error(r.Context(), "Error getting tenant for floor", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error getting tenant information")
+		return
+	}
+	if !allowed {
+		logger.Warn(r.Context(), "User is neither tenant nor manager of floor", "floor_id", floorID)
+		apierror.Write(w, r, http.StatusForbidden, "You are not the tenant or a manager of this floor")
 		return
 	}
 
@@ -4314,131 +4133,53 @@ func GetPaymentHistoryHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get payment history for the floor with calculated fields and pagination
-	query := `
-		SELECT 
-			p.id,
-			p.rent as new_added_rent,
-			p.recieved_money,
-			p.full_payment,
-			p.created_at,
-			p.electricity_bill as new_added_electricity_bill,
-			p.paid_bill as paid_electricity_bill,
-			COALESCE((
-				SELECT SUM(p2.rent - p2.recieved_money)
-				FROM payment p2
-				WHERE p2.fid = p.fid AND p2.uid = p.uid AND p2.created_at < p.created_at
-			), 0) as rent,
-			COALESCE((
-				SELECT SUM(p3.rent - p3.recieved_money)
-				FROM payment p3
-				WHERE p3.fid = p.fid AND p3.uid = p.uid AND p3.created_at <= p.created_at
-			), 0) as due_rent,
-			COALESCE((
-				SELECT SUM(COALESCE(p4.electricity_bill, 0) - COALESCE(p4.paid_bill, 0))
-				FROM payment p4
-				WHERE p4.fid = p.fid AND p4.uid = p.uid AND p4.created_at <= p.created_at
-			), 0) as due_electricity_bill,
-			COALESCE((
-				SELECT SUM(COALESCE(p5.electricity_bill, 0) - COALESCE(p5.paid_bill, 0))
-				FROM payment p5
-				WHERE p5.fid = p.fid AND p5.uid = p.uid AND p5.created_at < p.created_at
-			), 0) as electricity_bill
-		FROM payment p
-		WHERE p.fid = ? AND p.uid = ?
-		ORDER BY p.created_at DESC
-		LIMIT ? OFFSET ?
-	`
-	fmt.Printf("Executing query: %s with floorID: %d, tenantID: %d, limit: %d, offset: %d\n", query, floorID, tenantID.Int64, limit, offset)
-	
-	rows, err := db.Query(query, floorID, tenantID.Int64, limit, offset)
+	// The ledger's seq runs from 1 to totalCount without gaps, so both a
+	// cursor and a page number map directly to the seq a page starts at
+	ledgerSize, err := ledgerLength(r.Context(), db, floorID, tenantID.Int64)
 	if err != nil {
-		fmt.Printf("Error querying payment history: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"message": "Error querying payment history",
-		})
+		logger.Error(r.Context(), "Error reading payment ledger length", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error querying payment history")
 		return
 	}
-	defer rows.Close()
+	totalCount := int(ledgerSize)
 
-	var payments []PaymentHistory
-	paymentCount := 0
-	for rows.Next() {
-		var payment PaymentHistory
-		var createdAt time.Time
-		var newAddedRent, rent, dueRent, dueElectricityBill, electricityBill float64
-		var newAddedElectricityBill, paidElectricityBill sql.NullFloat64
-		err := rows.Scan(&payment.ID, &newAddedRent, &payment.ReceivedMoney, &payment.FullPayment, &createdAt, &newAddedElectricityBill, &paidElectricityBill, &rent, &dueRent, &dueElectricityBill, &electricityBill)
-		if err != nil {
-			fmt.Printf("Error scanning payment: %v\n", err)
-			continue
-		}
-		
-		// Set new_added_rent (the actual rent amount from payment table)
-		payment.NewAddedRent = newAddedRent
-		
-		// Set rent (sum of previous outstanding amounts)
-		payment.Rent = rent
-		
-		// Set due_rent (sum after this row was inserted)
-		payment.DueRent = dueRent
-		
-		// Set electricity bill fields
-		if newAddedElectricityBill.Valid {
-			payment.NewAddedElectricityBill = &newAddedElectricityBill.Float64
-		}
-		if paidElectricityBill.Valid {
-			payment.PaidElectricityBill = &paidElectricityBill.Float64
-		}
-		payment.DueElectricityBill = &dueElectricityBill
-		payment.ElectricityBill = &electricityBill
-		
-		payment.CreatedAt = createdAt.Format("2006-01-02T15:04:05Z")
-		payments = append(payments, payment)
-		paymentCount++
-		
-		fmt.Printf("Found payment: ID=%d, NewAddedRent=%.2f, Rent(Previous Outstanding)=%.2f, ReceivedMoney=%.2f, DueRent=%.2f, ElectricityBill=%.2f, FullPayment=%v\n", 
-			payment.ID, payment.NewAddedRent, payment.Rent, payment.ReceivedMoney, payment.DueRent, *payment.ElectricityBill, payment.FullPayment)
+	throughSeq := ledgerSize - int64((page-1)*limit)
+	if cursor != 0 {
+		throughSeq = cursor - 1
+		page = int((ledgerSize-throughSeq)/int64(limit)) + 1
 	}
 
-	fmt.Printf("Total payments found: %d\n", paymentCount)
-
-	// Get total count for pagination
-	var totalCount int
-	err = db.QueryRow(`
-		SELECT COUNT(*)
-		FROM payment p
-		WHERE p.fid = ? AND p.uid = ?
-	`, floorID, tenantID.Int64).Scan(&totalCount)
-	
+	payments, err := PaymentHistoryPage(r.Context(), db, floorID, tenantID.Int64, throughSeq, limit)
 	if err != nil {
-		fmt.Printf("Error getting total count: %v\n", err)
-		totalCount = paymentCount
+		logger.Error(r.Context(), "Error querying payment history", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error querying payment history")
+		return
 	}
 
+	logger.Debug(r.Context(), "Payment history retrieved", "count", len(payments))
+
 	// Calculate pagination metadata
 	totalPages := (totalCount + limit - 1) / limit
-	hasNextPage := page < totalPages
-	hasPrevPage := page > 1
+	pagination := PaginationInfo{
+		CurrentPage: page,
+		TotalPages:  totalPages,
+		TotalCount:  totalCount,
+		Limit:       limit,
+		HasNextPage: len(payments) > 0 && payments[len(payments)-1].Seq > 1,
+		HasPrevPage: throughSeq < ledgerSize,
+	}
+	if pagination.HasNextPage {
+		pagination.NextCursor = strconv.FormatInt(payments[len(payments)-1].Seq, 10)
+	}
 
 	// Return success response
 	response := PaymentHistoryResponse{
-		Success:  true,
-		Message:  "Payment history retrieved successfully",
-		Payments: payments,
-		Pagination: PaginationInfo{
-			CurrentPage:  page,
-			TotalPages:   totalPages,
-			TotalCount:   totalCount,
-			Limit:        limit,
-			HasNextPage:  hasNextPage,
-			HasPrevPage:  hasPrevPage,
-		},
+		Success:    true,
+		Message:    "Payment history retrieved successfully",
+		Payments:   payments,
+		Pagination: pagination,
 	}
 	
-	fmt.Printf("Sending response: %+v\n", response)
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(response)
-}
\ No newline at end of file
+}
@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"go-rent/config"
+	"go-rent/logger"
+	"go-rent/metrics"
+	"go-rent/notify"
+	"time"
+)
+
+// Every tenant's devices are subscribed to an FCM topic for each property they
+// rent a floor in, so that a broadcast reaches all of them with one send.
+// Membership follows the floor: a tenant's devices join when they move in
+// and leave when they leave their last floor in the property. It also
+// follows the user: a newly registered device joins all of their
+// properties, an unregistered one leaves, and turning FCM off in
+// /user/notification-channels takes all of their devices out.
+//
+// Changes are made in the background once the request has committed. A
+// failed change is logged, and SyncPropertyTopics, run daily by the
+// scheduler, subscribes any tenant device that was missed.
+
+// topicChangeTimeout bounds the topic changes made after one request
+const topicChangeTimeout = time.Minute
+
+// PropertyTopic returns the FCM topic of a property's tenants
+func PropertyTopic(propertyID int64) string {
+	return fmt.Sprintf("property-%d", propertyID)
+}
+
+// floorTenant returns the tenant of a floor of propertyID, or 0 when it has
+// none
+func floorTenant(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, propertyID, floorID int64) (int64, error) {
+	var tenant sql.NullInt64
+	err := q.QueryRow(`SELECT tenant FROM floor WHERE id = ? AND pid = ?`, floorID, propertyID).Scan(&tenant)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return tenant.Int64, err
+}
+
+// tenantChanged updates topic membership in the background after a floor of
+// propertyID passed from oldTenant to newTenant; either may be 0
+func tenantChanged(ctx context.Context, propertyID, oldTenant, newTenant int64) {
+	if oldTenant == newTenant || fcmNotifier == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), topicChangeTimeout)
+	go func() {
+		defer cancel()
+		db, err := config.GetDBConnection()
+		if err != nil {
+			logger.Error(ctx, "Database connection error", "error", err)
+			return
+		}
+
+		// Check the floors rather than trusting the caller, so that a user
+		// who still rents another floor in the property stays subscribed
+		topic := PropertyTopic(propertyID)
+		for _, userID := range []int64{oldTenant, newTenant} {
+			if userID == 0 {
+				continue
+			}
+			var rents bool
+			err := db.QueryRow(`
+				SELECT EXISTS(SELECT 1 FROM floor WHERE pid = ? AND tenant = ?)`,
+				propertyID, userID).Scan(&rents)
+			if err != nil {
+				logger.Error(ctx, "Error checking tenant floors", "user_id", userID, "error", err)
+				continue
+			}
+			tokens, member, err := topicTokens(db, userID)
+			if err != nil {
+				logger.Error(ctx, "Error loading devices", "user_id", userID, "error", err)
+				continue
+			}
+			if len(tokens) > 0 && (!rents || member) {
+				changeTopic(ctx, rents && member, topic, userID, tokens...)
+			}
+		}
+	}()
+}
+
+// userTopicsChanged updates topic membership in the background after the
+// user's devices or channels changed: the removed tokens leave all of their
+// property topics, and their current devices join them if FCM is enabled or
+// leave them if not
+func userTopicsChanged(ctx context.Context, userID int64, removed ...string) {
+	if fcmNotifier == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), topicChangeTimeout)
+	go func() {
+		defer cancel()
+		db, err := config.GetDBConnection()
+		if err != nil {
+			logger.Error(ctx, "Database connection error", "error", err)
+			return
+		}
+
+		tokens, member, err := topicTokens(db, userID)
+		if err != nil {
+			logger.Error(ctx, "Error loading devices", "user_id", userID, "error", err)
+			return
+		}
+		rows, err := db.Query(`SELECT DISTINCT pid FROM floor WHERE tenant = ?`, userID)
+		if err != nil {
+			logger.Error(ctx, "Error loading tenant properties", "user_id", userID, "error", err)
+			return
+		}
+		var propertyIDs []int64
+		for rows.Next() {
+			var propertyID int64
+			if err := rows.Scan(&propertyID); err == nil {
+				propertyIDs = append(propertyIDs, propertyID)
+			}
+		}
+		rows.Close()
+
+		for _, propertyID := range propertyIDs {
+			topic := PropertyTopic(propertyID)
+			if len(removed) > 0 {
+				changeTopic(ctx, false, topic, userID, removed...)
+			}
+			if len(tokens) > 0 {
+				changeTopic(ctx, member, topic, userID, tokens...)
+			}
+		}
+	}()
+}
+
+// topicTokens returns the tokens of the user's devices and whether they
+// should be subscribed to the user's property topics, which they are when
+// the user has FCM enabled
+func topicTokens(db *sql.DB, userID int64) ([]string, bool, error) {
+	_, channels, err := loadRecipient(db, userID)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	devices, err := userDevices(db, userID)
+	if err != nil {
+		return nil, false, err
+	}
+	tokens := make([]string, 0, len(devices))
+	for _, device := range devices {
+		tokens = append(tokens, device.Token)
+	}
+	return tokens, contains(channels, notify.ChannelFCM), nil
+}
+
+// changeTopic subscribes or unsubscribes a user's devices and logs the
+// result
+func changeTopic(ctx context.Context, subscribe bool, topic string, userID int64, tokens ...string) {
+	action, change := "unsubscribe", fcmNotifier.Unsubscribe
+	if subscribe {
+		action, change = "subscribe", fcmNotifier.Subscribe
+	}
+	if err := change(ctx, topic, tokens...); err != nil {
+		metrics.TopicChanges.Inc(action, "failure")
+		logger.Warn(ctx, "FCM topic change failed", "action", action, "topic", topic, "user_id", userID,
+			"reason", notify.Reason(err), "error", err)
+		return
+	}
+	metrics.TopicChanges.Inc(action, "success")
+	logger.Debug(ctx, "FCM topic changed", "action", action, "topic", topic, "user_id", userID)
+}
+
+// SyncPropertyTopics subscribes every tenant device with FCM enabled to its
+// property topics, and records the run in the scheduler job metrics.
+// Subscribing is idempotent, so this repairs changes that failed or were
+// made before topics existed.
+func SyncPropertyTopics() {
+	start := time.Now()
+	outcome := syncPropertyTopics(context.Background())
+	metrics.ObserveJob("property_topics", outcome, start)
+}
+
+// syncPropertyTopics does the work of SyncPropertyTopics and returns the
+// job outcome
+func syncPropertyTopics(ctx context.Context) string {
+	if fcmNotifier == nil {
+		return metrics.JobSkipped
+	}
+
+	db, err := config.GetDBConnection()
+	if err != nil {
+		logger.Error(ctx, "Database connection error", "error", err)
+		return metrics.JobError
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT DISTINCT f.pid, d.token
+		FROM floor f
+		JOIN user u ON u.id = f.tenant
+		JOIN device_token d ON d.user_id = u.id
+		WHERE FIND_IN_SET(?, u.notification_channels) > 0
+		ORDER BY f.pid`, notify.ChannelFCM)
+	if err != nil {
+		logger.Error(ctx, "Error loading tenant devices", "error", err)
+		return metrics.JobError
+	}
+	tokens := make(map[int64][]string)
+	var propertyIDs []int64
+	for rows.Next() {
+		var propertyID int64
+		var token string
+		if err := rows.Scan(&propertyID, &token); err != nil {
+			rows.Close()
+			logger.Error(ctx, "Error loading tenant devices", "error", err)
+			return metrics.JobError
+		}
+		if tokens[propertyID] == nil {
+			propertyIDs = append(propertyIDs, propertyID)
+		}
+		tokens[propertyID] = append(tokens[propertyID], token)
+	}
+	rows.Close()
+	if len(propertyIDs) == 0 {
+		return metrics.JobSkipped
+	}
+
+	failed := 0
+	for _, propertyID := range propertyIDs {
+		topic := PropertyTopic(propertyID)
+		devices := tokens[propertyID]
+		for len(devices) > 0 {
+			batch := devices
+			if len(batch) > notify.MaxTopicBatch {
+				batch = batch[:notify.MaxTopicBatch]
+			}
+			devices = devices[len(batch):]
+
+			sendCtx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+			err := fcmNotifier.Subscribe(sendCtx, topic, batch...)
+			cancel()
+			if err != nil {
+				// The other devices in the batch are still subscribed. An
+				// unregistered device is expected now and then and does not
+				// fail the run; anything else is retried on the next one.
+				metrics.TopicChanges.Inc("subscribe", "failure")
+				logger.Warn(ctx, "FCM topic sync failed", "topic", topic, "devices", len(batch),
+					"reason", notify.Reason(err), "error", err)
+				if !notify.Permanent(err) {
+					failed++
+				}
+				continue
+			}
+			metrics.TopicChanges.Inc("subscribe", "success")
+		}
+	}
+
+	logger.Info(ctx, "FCM property topics synced", "properties", len(propertyIDs), "failed_batches", failed)
+	if failed > 0 {
+		return metrics.JobError
+	}
+	return metrics.JobSuccess
+}
@@ -0,0 +1,476 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"go-rent/config"
+	"go-rent/logger"
+	"go-rent/metrics"
+	"go-rent/notify"
+	"math/rand"
+	"time"
+)
+
+// Notifications are delivered through an outbox. SendNotificationWithPush
+// writes a push_outbox row for each of the receiver's channels (for push,
+// one per device) in the same transaction as the notification, and the
+// scheduler runs DispatchPushOutbox in a loop to send the rows that are due
+// through Notifiers. A failed send
+// is retried with exponential backoff. Permanent failures, and rows that run
+// out of attempts, are kept as dead letters that an operator can inspect and
+// replay through the /admin/push-outbox routes. A property broadcast is a
+// single FCM row addressed to the property's topic rather than one per
+// tenant.
+//
+// Delivery is at least once: if the process stops between sending a message
+// and recording it, the row is sent again once its claim expires.
+
+// Outbox row states
+const (
+	pushOutboxPending = "pending"
+	pushOutboxSent    = "sent"
+	pushOutboxDead    = "dead"
+)
+
+// deliveryTimeout bounds one send on any channel
+const deliveryTimeout = 30 * time.Second
+
+// pushOutboxStatuses lists the states, for validating filters
+var pushOutboxStatuses = []string{pushOutboxPending, pushOutboxSent, pushOutboxDead}
+
+// pushOutboxWake nudges the dispatcher when a push is queued, so it does not
+// wait for the next poll
+var pushOutboxWake = make(chan struct{}, 1)
+
+// WakePushDispatcher asks the dispatcher to look for due pushes now
+func WakePushDispatcher() {
+	select {
+	case pushOutboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// PushDispatcherWakeups receives a value after WakePushDispatcher is called
+func PushDispatcherWakeups() <-chan struct{} {
+	return pushOutboxWake
+}
+
+// queuedPush is an outbox row claimed for sending. FCM rows name one of
+// the receiver's devices, and broadcasts have a Topic instead of a
+// ReceiverID.
+type queuedPush struct {
+	ID         int64
+	ReceiverID int64
+	DeviceID   int64
+	Channel    string
+	Topic      string
+	Title      string
+	Body       string
+	Data       map[string]interface{}
+	Attempts   int
+}
+
+// enqueueNotification queues a message of the given kind for receiverID on
+// the channels their preferences choose for it, other than those in except,
+// that are configured on the server and that they have an address for.
+// Kinds the receiver gets as a daily digest are held for it instead, and
+// messages queued during their quiet hours wait until the quiet hours end.
+// notificationID may be 0 for messages that have no stored notification. It
+// returns the channels queued.
+func enqueueNotification(tx *sql.Tx, notificationID, receiverID int64, kind, title, body string, data map[string]interface{}, except ...string) ([]string, error) {
+	recipient, enabled, err := loadRecipient(tx, receiverID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load notification channels: %v", err)
+	}
+	prefs, err := loadPreferences(tx, receiverID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load notification preferences: %v", err)
+	}
+
+	var channels []string
+	for _, channel := range prefs.channelsFor(kind, enabled) {
+		if Notifiers.Get(channel) == nil || recipient.Address(channel) == "" || contains(except, channel) {
+			continue
+		}
+		channels = append(channels, channel)
+	}
+	if len(channels) == 0 {
+		return nil, nil
+	}
+
+	now := time.Now()
+	if prefs.digestFor(kind) {
+		if err := enqueueDigestItem(tx, receiverID, notificationID, kind, channels, title, body, prefs.nextDigest(now)); err != nil {
+			return nil, err
+		}
+		return channels, nil
+	}
+
+	for _, channel := range channels {
+		if err := enqueuePush(tx, channel, notificationID, receiverID, title, body, data, prefs.sendAfter(channel, now)); err != nil {
+			return nil, err
+		}
+	}
+	return channels, nil
+}
+
+// loadRecipient reads a user's addresses and enabled channels. FCMToken is
+// the token of the device seen most recently; pushes go to all of them.
+func loadRecipient(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, userID int64) (notify.Recipient, []string, error) {
+	recipient := notify.Recipient{UserID: userID}
+	var email, fcmToken, webhookURL sql.NullString
+	var channels string
+	err := q.QueryRow(`
+		SELECT u.name, u.phone_number, u.email,
+			(SELECT d.token FROM device_token d WHERE d.user_id = u.id ORDER BY d.last_seen_at DESC, d.id DESC LIMIT 1),
+			u.webhook_url, u.notification_channels
+		FROM user u
+		WHERE u.id = ?`, userID).Scan(&recipient.Name, &recipient.Phone, &email, &fcmToken, &webhookURL, &channels)
+	if err != nil {
+		return recipient, nil, err
+	}
+	recipient.Email = email.String
+	recipient.FCMToken = fcmToken.String
+	recipient.WebhookURL = webhookURL.String
+	return recipient, splitList(channels), nil
+}
+
+// enqueuePush queues one message on one channel within tx, to be sent once
+// notBefore has passed. An FCM message is queued once for each of the
+// receiver's devices.
+func enqueuePush(tx *sql.Tx, channel string, notificationID, receiverID int64, title, body string, data map[string]interface{}, notBefore time.Time) error {
+	push := queuedPush{ReceiverID: receiverID, Channel: channel, Title: title, Body: body, Data: data}
+	if channel != notify.ChannelFCM {
+		return insertPush(tx, push, notificationID, notBefore)
+	}
+
+	devices, err := userDevices(tx, receiverID)
+	if err != nil {
+		return fmt.Errorf("failed to load devices: %v", err)
+	}
+	for _, device := range devices {
+		push.DeviceID = device.ID
+		if err := insertPush(tx, push, notificationID, notBefore); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enqueueTopicPush queues one FCM message to every device subscribed to
+// topic within tx. One message cannot follow each subscriber's preferences,
+// so it is held through the server-wide topic quiet hours instead.
+func enqueueTopicPush(tx *sql.Tx, topic, title, body string, data map[string]interface{}) error {
+	push := queuedPush{Channel: notify.ChannelFCM, Topic: topic, Title: title, Body: body, Data: data}
+	return insertPush(tx, push, 0, topicSendAfter(time.Now()))
+}
+
+// topicSendAfter returns when a topic push queued at now may be sent: the
+// end of the configured topic quiet hours if now falls inside them,
+// otherwise now
+func topicSendAfter(now time.Time) time.Time {
+	window := NotificationPreferences{QuietHours: &QuietHours{
+		Start: config.App.FCM.TopicQuietStart,
+		End:   config.App.FCM.TopicQuietEnd,
+	}}
+	return window.sendAfter(notify.ChannelFCM, now)
+}
+
+// insertPush writes push as an outbox row due at notBefore. Its zero
+// receiver and device IDs, empty topic and a zero notificationID are stored
+// as NULL.
+func insertPush(tx *sql.Tx, push queuedPush, notificationID int64, notBefore time.Time) error {
+	payload, err := json.Marshal(push.Data)
+	if err != nil {
+		return fmt.Errorf("failed to encode push data: %v", err)
+	}
+
+	var notification, receiver, device, topic interface{}
+	if notificationID != 0 {
+		notification = notificationID
+	}
+	if push.ReceiverID != 0 {
+		receiver = push.ReceiverID
+	}
+	if push.DeviceID != 0 {
+		device = push.DeviceID
+	}
+	if push.Topic != "" {
+		topic = push.Topic
+	}
+	now := time.Now().In(config.App.Location).Format("2006-01-02 15:04:05")
+	_, err = tx.Exec(`
+		INSERT INTO push_outbox (
+			notification_id, receiver, channel, topic, device_id, title, body, data,
+			status, attempts, next_attempt_at, created_at, updated_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, 0, ?, ?, ?)`,
+		notification, receiver, push.Channel, topic, device, push.Title, push.Body, payload,
+		pushOutboxPending, notBefore.In(config.App.Location).Format("2006-01-02 15:04:05"), now, now,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to queue %s notification: %v", push.Channel, err)
+	}
+	return nil
+}
+
+// DispatchPushOutbox sends one batch of due messages and records the result
+// of each. It returns how many it claimed, so the caller can go straight on to
+// the next batch when this one was full. If ctx is cancelled it stops
+// between sends and hands the unsent rows back.
+func DispatchPushOutbox(ctx context.Context) (int, error) {
+	db, err := config.GetDBConnection()
+	if err != nil {
+		return 0, fmt.Errorf("database connection failed: %v", err)
+	}
+
+	batch := config.App.Outbox.BatchSize
+	pushes, err := claimDuePushes(db, batch)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, push := range pushes {
+		if ctx.Err() != nil {
+			releasePushes(ctx, db, pushes[i:])
+			return len(pushes), nil
+		}
+		err := deliverPush(ctx, db, push)
+		if err != nil && ctx.Err() != nil {
+			// Interrupted by shutdown; this is not the message's fault
+			releasePushes(ctx, db, pushes[i:])
+			return len(pushes), nil
+		}
+		recordPushResult(ctx, db, push, err)
+	}
+	return len(pushes), nil
+}
+
+// deliverPush sends a queued message through its channel's notifier, to the
+// receiver's current address or to its topic
+func deliverPush(ctx context.Context, db *sql.DB, push queuedPush) (err error) {
+	defer func() {
+		reason := metrics.PushReasonOK
+		if err != nil {
+			reason = notify.Reason(err)
+		}
+		metrics.RecordNotification(push.Channel, reason)
+		if push.Channel == notify.ChannelFCM {
+			metrics.RecordPush(reason)
+		}
+	}()
+
+	notifier := Notifiers.Get(push.Channel)
+	if notifier == nil {
+		return notify.Errorf(metrics.PushReasonNotConfigured, "%s notifications are not configured", push.Channel)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+	defer cancel()
+	msg := notify.Message{Title: push.Title, Body: push.Body, Data: push.Data}
+
+	if push.Topic != "" {
+		sender, ok := notifier.(notify.TopicSender)
+		if !ok {
+			return notify.Errorf(metrics.PushReasonNotConfigured, "%s notifications cannot be sent to a topic", push.Channel)
+		}
+		return sender.SendToTopic(ctx, push.Topic, msg)
+	}
+
+	recipient, _, err := loadRecipient(db, push.ReceiverID)
+	if err == sql.ErrNoRows {
+		return notify.Errorf(metrics.PushReasonInvalidArgument, "user %d no longer exists", push.ReceiverID)
+	}
+	if err != nil {
+		return notify.Errorf(metrics.PushReasonDatabase, "failed to load recipient: %v", err)
+	}
+	if push.DeviceID == 0 {
+		return notifier.Send(ctx, recipient, msg)
+	}
+
+	// Send to the row's device, and forget it if FCM no longer accepts it
+	err = db.QueryRow(`SELECT token FROM device_token WHERE id = ? AND user_id = ?`,
+		push.DeviceID, push.ReceiverID).Scan(&recipient.FCMToken)
+	if err == sql.ErrNoRows {
+		return notify.Errorf(metrics.PushReasonNoToken, "device %d is no longer registered", push.DeviceID)
+	}
+	if err != nil {
+		return notify.Errorf(metrics.PushReasonDatabase, "failed to load device: %v", err)
+	}
+	err = notifier.Send(ctx, recipient, msg)
+	if notify.BadAddress(err) {
+		pruneDevice(ctx, db, push.ReceiverID, push.DeviceID, notify.Reason(err))
+	}
+	return err
+}
+
+// claimDuePushes locks up to limit due rows and pushes their next attempt
+// past the time it takes to send them all, so that another server instance
+// polling the same table skips them
+func claimDuePushes(db *sql.DB, limit int) ([]queuedPush, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().In(config.App.Location)
+	rows, err := tx.Query(`
+		SELECT id, receiver, device_id, channel, topic, title, body, data, attempts
+		FROM push_outbox
+		WHERE status = ? AND next_attempt_at <= ?
+		ORDER BY next_attempt_at, id
+		LIMIT ?
+		FOR UPDATE SKIP LOCKED`,
+		pushOutboxPending, now.Format("2006-01-02 15:04:05"), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read push outbox: %v", err)
+	}
+
+	var pushes []queuedPush
+	var ids []interface{}
+	for rows.Next() {
+		var push queuedPush
+		var receiver, device sql.NullInt64
+		var topic sql.NullString
+		var payload []byte
+		if err := rows.Scan(&push.ID, &receiver, &device, &push.Channel, &topic, &push.Title, &push.Body, &payload, &push.Attempts); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read push outbox: %v", err)
+		}
+		if err := json.Unmarshal(payload, &push.Data); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("push %d has invalid data: %v", push.ID, err)
+		}
+		push.ReceiverID = receiver.Int64
+		push.DeviceID = device.Int64
+		push.Topic = topic.String
+		pushes = append(pushes, push)
+		ids = append(ids, push.ID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read push outbox: %v", err)
+	}
+	if len(pushes) == 0 {
+		return nil, nil
+	}
+
+	lease := time.Duration(len(pushes))*deliveryTimeout + time.Minute
+	args := append([]interface{}{
+		now.Add(lease).Format("2006-01-02 15:04:05"),
+		now.Format("2006-01-02 15:04:05"),
+	}, ids...)
+	_, err = tx.Exec(`
+		UPDATE push_outbox
+		SET next_attempt_at = ?, updated_at = ?
+		WHERE id IN (`+placeholders(len(ids))+`)`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim pushes: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to claim pushes: %v", err)
+	}
+	return pushes, nil
+}
+
+// recordPushResult marks a push sent, schedules its retry, or dead-letters it
+func recordPushResult(ctx context.Context, db *sql.DB, push queuedPush, sendErr error) {
+	now := time.Now().In(config.App.Location)
+	stamp := now.Format("2006-01-02 15:04:05")
+	attempts := push.Attempts + 1
+
+	if sendErr == nil {
+		_, err := db.Exec(`
+			UPDATE push_outbox
+			SET status = ?, attempts = ?, sent_at = ?, last_error = NULL, last_reason = NULL, updated_at = ?
+			WHERE id = ?`, pushOutboxSent, attempts, stamp, stamp, push.ID)
+		if err != nil {
+			logger.Error(ctx, "Failed to record push delivery", "push_id", push.ID, "error", err)
+			return
+		}
+		metrics.PushOutboxEvents.Inc(metrics.PushOutboxSent)
+		logger.Info(ctx, "Notification delivered", "push_id", push.ID, "channel", push.Channel, "receiver_id", push.ReceiverID, "device_id", push.DeviceID, "topic", push.Topic, "attempts", attempts)
+		return
+	}
+
+	reason := notify.Reason(sendErr)
+	if notify.Permanent(sendErr) || attempts >= config.App.Outbox.MaxAttempts {
+		_, err := db.Exec(`
+			UPDATE push_outbox
+			SET status = ?, attempts = ?, last_error = ?, last_reason = ?, updated_at = ?
+			WHERE id = ?`, pushOutboxDead, attempts, sendErr.Error(), reason, stamp, push.ID)
+		if err != nil {
+			logger.Error(ctx, "Failed to record push failure", "push_id", push.ID, "error", err)
+			return
+		}
+		metrics.PushOutboxEvents.Inc(metrics.PushOutboxDead)
+		logger.Warn(ctx, "Notification dead-lettered", "push_id", push.ID, "channel", push.Channel, "receiver_id", push.ReceiverID, "device_id", push.DeviceID, "topic", push.Topic,
+			"attempts", attempts, "reason", reason, "error", sendErr)
+		return
+	}
+
+	retryAt := now.Add(pushRetryDelay(attempts))
+	_, err := db.Exec(`
+		UPDATE push_outbox
+		SET attempts = ?, next_attempt_at = ?, last_error = ?, last_reason = ?, updated_at = ?
+		WHERE id = ?`, attempts, retryAt.Format("2006-01-02 15:04:05"), sendErr.Error(), reason, stamp, push.ID)
+	if err != nil {
+		logger.Error(ctx, "Failed to record push failure", "push_id", push.ID, "error", err)
+		return
+	}
+	metrics.PushOutboxEvents.Inc(metrics.PushOutboxRetry)
+	logger.Warn(ctx, "Notification delivery failed, will retry", "push_id", push.ID, "channel", push.Channel, "receiver_id", push.ReceiverID, "device_id", push.DeviceID, "topic", push.Topic,
+		"attempts", attempts, "reason", reason, "retry_at", retryAt.Format(time.RFC3339), "error", sendErr)
+}
+
+// releasePushes makes claimed but unsent pushes due again
+func releasePushes(ctx context.Context, db *sql.DB, pushes []queuedPush) {
+	ids := make([]interface{}, 0, len(pushes))
+	for _, push := range pushes {
+		ids = append(ids, push.ID)
+	}
+	now := time.Now().In(config.App.Location).Format("2006-01-02 15:04:05")
+	_, err := db.Exec(`
+		UPDATE push_outbox
+		SET next_attempt_at = ?, updated_at = ?
+		WHERE id IN (`+placeholders(len(ids))+`)`, append([]interface{}{now, now}, ids...)...)
+	if err != nil {
+		logger.Error(ctx, "Failed to release claimed pushes", "count", len(ids), "error", err)
+	}
+}
+
+// pushRetryDelay returns the wait before the next send after attempts
+// failed sends: the base delay doubled for each earlier failure, capped at
+// the maximum, plus up to 10% jitter so that pushes that failed together
+// are not all retried in the same instant
+func pushRetryDelay(attempts int) time.Duration {
+	delay := config.App.Outbox.BaseDelay
+	for i := 1; i < attempts && delay < config.App.Outbox.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > config.App.Outbox.MaxDelay {
+		delay = config.App.Outbox.MaxDelay
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/10+1))
+}
+
+// PrunePushOutbox deletes delivered pushes older than the retention period
+// and returns how many it removed
+func PrunePushOutbox(ctx context.Context) (int64, error) {
+	db, err := config.GetDBConnection()
+	if err != nil {
+		return 0, fmt.Errorf("database connection failed: %v", err)
+	}
+	cutoff := time.Now().In(config.App.Location).Add(-config.App.Outbox.Retention)
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM push_outbox
+		WHERE status = ? AND sent_at < ?`, pushOutboxSent, cutoff.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune push outbox: %v", err)
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,392 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"go-rent/apierror"
+	"go-rent/config"
+	"go-rent/logger"
+	"go-rent/metrics"
+	"go-rent/notify"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Page sizes for GET /admin/push-outbox
+const (
+	defaultPushOutboxLimit = 50
+	maxPushOutboxLimit     = 200
+)
+
+// PushDelivery is a push_outbox row as shown to operators. Broadcasts have a
+// topic instead of a receiver, and FCM pushes to a user name the device.
+type PushDelivery struct {
+	ID             int64                  `json:"id"`
+	NotificationID *int64                 `json:"notification_id,omitempty"`
+	ReceiverID     *int64                 `json:"receiver_id,omitempty"`
+	Channel        string                 `json:"channel"`
+	Topic          *string                `json:"topic,omitempty"`
+	DeviceID       *int64                 `json:"device_id,omitempty"`
+	Title          string                 `json:"title"`
+	Body           string                 `json:"body"`
+	Data           map[string]interface{} `json:"data"`
+	Status         string                 `json:"status"`
+	Attempts       int                    `json:"attempts"`
+	LastError      *string                `json:"last_error,omitempty"`
+	LastReason     *string                `json:"last_reason,omitempty"`
+	NextAttemptAt  time.Time              `json:"next_attempt_at"`
+	SentAt         *time.Time             `json:"sent_at,omitempty"`
+	CreatedAt      time.Time              `json:"created_at"`
+	UpdatedAt      time.Time              `json:"updated_at"`
+}
+
+type PushOutboxResponse struct {
+	Success    bool            `json:"success"`
+	Message    string          `json:"message"`
+	Delivery   *PushDelivery   `json:"delivery,omitempty"`
+	Deliveries []PushDelivery  `json:"deliveries,omitempty"`
+	Counts     map[string]int  `json:"counts,omitempty"`
+	Pagination *PaginationInfo `json:"pagination,omitempty"`
+	Replayed   *int64          `json:"replayed,omitempty"`
+}
+
+// pushDeliveryColumns is the column list read by scanPushDelivery
+const pushDeliveryColumns = `id, notification_id, receiver, channel, topic, device_id, title, body, data, status, attempts,
+	last_error, last_reason, next_attempt_at, sent_at, created_at, updated_at`
+
+// GetPushOutboxHandler lists queued, delivered or dead-lettered messages,
+// most recently changed first, with the number of rows in each state.
+// Query parameters: status (default dead), channel, receiver_id, page and
+// limit.
+func GetPushOutboxHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	problems := make(map[string]string)
+
+	status := query.Get("status")
+	if status == "" {
+		status = pushOutboxDead
+	} else if !contains(pushOutboxStatuses, status) {
+		problems["status"] = "status must be one of " + strings.Join(pushOutboxStatuses, ", ")
+	}
+
+	channel := query.Get("channel")
+	if channel != "" && !contains(notify.Channels, channel) {
+		problems["channel"] = "channel must be one of " + strings.Join(notify.Channels, ", ")
+	}
+
+	var receiverID int64
+	if raw := query.Get("receiver_id"); raw != "" {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || id <= 0 {
+			problems["receiver_id"] = "receiver_id must be a positive integer"
+		}
+		receiverID = id
+	}
+
+	page, limit := 1, defaultPushOutboxLimit
+	if raw := query.Get("page"); raw != "" {
+		if n, err := strconv.Atoi(raw); err != nil || n < 1 {
+			problems["page"] = "page must be a positive integer"
+		} else {
+			page = n
+		}
+	}
+	if raw := query.Get("limit"); raw != "" {
+		if n, err := strconv.Atoi(raw); err != nil || n < 1 || n > maxPushOutboxLimit {
+			problems["limit"] = fmt.Sprintf("limit must be between 1 and %d", maxPushOutboxLimit)
+		} else {
+			limit = n
+		}
+	}
+
+	if len(problems) > 0 {
+		apierror.WriteFields(w, r, "Invalid query parameters", problems)
+		return
+	}
+
+	db, err := config.GetDBConnection()
+	if err != nil {
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
+		return
+	}
+
+	// Counts per state, for the same channel and receiver filters
+	filter := `1 = 1`
+	var filterArgs []interface{}
+	if channel != "" {
+		filter += ` AND channel = ?`
+		filterArgs = append(filterArgs, channel)
+	}
+	if receiverID != 0 {
+		filter += ` AND receiver = ?`
+		filterArgs = append(filterArgs, receiverID)
+	}
+	rows, err := db.Query(`SELECT status, COUNT(*) FROM push_outbox WHERE `+filter+` GROUP BY status`, filterArgs...)
+	if err != nil {
+		logger.Error(r.Context(), "Error counting push outbox", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error fetching push outbox")
+		return
+	}
+	counts := map[string]int{pushOutboxPending: 0, pushOutboxSent: 0, pushOutboxDead: 0}
+	for rows.Next() {
+		var rowStatus string
+		var count int
+		if err := rows.Scan(&rowStatus, &count); err != nil {
+			rows.Close()
+			logger.Error(r.Context(), "Error counting push outbox", "error", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "Error fetching push outbox")
+			return
+		}
+		counts[rowStatus] = count
+	}
+	rows.Close()
+
+	args := append([]interface{}{status}, filterArgs...)
+	rows, err = db.Query(`
+		SELECT `+pushDeliveryColumns+`
+		FROM push_outbox
+		WHERE status = ? AND `+filter+`
+		ORDER BY updated_at DESC, id DESC
+		LIMIT ? OFFSET ?`, append(args, limit, (page-1)*limit)...)
+	if err != nil {
+		logger.Error(r.Context(), "Error querying push outbox", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error fetching push outbox")
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []PushDelivery{}
+	for rows.Next() {
+		delivery, err := scanPushDelivery(rows)
+		if err != nil {
+			logger.Error(r.Context(), "Error scanning push delivery", "error", err)
+			continue
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+
+	total := counts[status]
+	totalPages := (total + limit - 1) / limit
+	json.NewEncoder(w).Encode(PushOutboxResponse{
+		Success:    true,
+		Message:    "Push outbox retrieved successfully",
+		Deliveries: deliveries,
+		Counts:     counts,
+		Pagination: &PaginationInfo{
+			CurrentPage: page,
+			TotalPages:  totalPages,
+			TotalCount:  total,
+			Limit:       limit,
+			HasNextPage: page < totalPages,
+			HasPrevPage: page > 1,
+		},
+	})
+}
+
+// GetPushDeliveryHandler shows one push_outbox row, including the last error
+func GetPushDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	pushID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid push ID")
+		return
+	}
+
+	db, err := config.GetDBConnection()
+	if err != nil {
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
+		return
+	}
+
+	delivery, err := getPushDelivery(db, pushID)
+	if err == sql.ErrNoRows {
+		apierror.Write(w, r, http.StatusNotFound, "Push not found")
+		return
+	}
+	if err != nil {
+		logger.Error(r.Context(), "Error getting push delivery", "push_id", pushID, "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error fetching push")
+		return
+	}
+
+	json.NewEncoder(w).Encode(PushOutboxResponse{
+		Success:  true,
+		Message:  "Push retrieved successfully",
+		Delivery: delivery,
+	})
+}
+
+// ReplayPushDeliveryHandler queues a dead push to be sent straight away with
+// a fresh set of attempts. Pushes that were delivered, or are still pending
+// and may be in the middle of a send, cannot be replayed.
+func ReplayPushDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	pushID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid push ID")
+		return
+	}
+
+	db, err := config.GetDBConnection()
+	if err != nil {
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
+		return
+	}
+
+	now := time.Now().In(config.App.Location).Format("2006-01-02 15:04:05")
+	result, err := db.Exec(`
+		UPDATE push_outbox
+		SET status = ?, attempts = 0, next_attempt_at = ?, updated_at = ?
+		WHERE id = ? AND status = ?`, pushOutboxPending, now, now, pushID, pushOutboxDead)
+	if err != nil {
+		logger.Error(r.Context(), "Error replaying push", "push_id", pushID, "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error replaying push")
+		return
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		delivery, err := getPushDelivery(db, pushID)
+		switch {
+		case err == sql.ErrNoRows:
+			apierror.Write(w, r, http.StatusNotFound, "Push not found")
+		case err == nil && delivery.Status == pushOutboxSent:
+			apierror.Write(w, r, http.StatusConflict, "Push was already delivered")
+		case err == nil && delivery.Status == pushOutboxPending:
+			apierror.Write(w, r, http.StatusConflict, "Push is still pending; only dead pushes can be replayed")
+		default:
+			logger.Error(r.Context(), "Error replaying push", "push_id", pushID, "error", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "Error replaying push")
+		}
+		return
+	}
+
+	metrics.PushOutboxEvents.Inc(metrics.PushOutboxReplayed)
+	logger.Info(r.Context(), "Push replayed", "push_id", pushID)
+	WakePushDispatcher()
+
+	delivery, err := getPushDelivery(db, pushID)
+	if err != nil {
+		logger.Error(r.Context(), "Error getting push delivery", "push_id", pushID, "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error fetching push")
+		return
+	}
+	json.NewEncoder(w).Encode(PushOutboxResponse{
+		Success:  true,
+		Message:  "Push queued for delivery",
+		Delivery: delivery,
+	})
+}
+
+// ReplayPushOutboxHandler queues every dead push, or those of one receiver
+// when the body has a receiver_id, to be sent again with a fresh set of
+// attempts
+func ReplayPushOutboxHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var request struct {
+		ReceiverID int64 `json:"receiver_id"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			apierror.Write(w, r, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	db, err := config.GetDBConnection()
+	if err != nil {
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
+		return
+	}
+
+	now := time.Now().In(config.App.Location).Format("2006-01-02 15:04:05")
+	query := `
+		UPDATE push_outbox
+		SET status = ?, attempts = 0, next_attempt_at = ?, updated_at = ?
+		WHERE status = ?`
+	args := []interface{}{pushOutboxPending, now, now, pushOutboxDead}
+	if request.ReceiverID != 0 {
+		query += ` AND receiver = ?`
+		args = append(args, request.ReceiverID)
+	}
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		logger.Error(r.Context(), "Error replaying dead pushes", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error replaying pushes")
+		return
+	}
+
+	replayed, _ := result.RowsAffected()
+	if replayed > 0 {
+		metrics.PushOutboxEvents.Add(float64(replayed), metrics.PushOutboxReplayed)
+		WakePushDispatcher()
+	}
+	logger.Info(r.Context(), "Dead pushes replayed", "count", replayed, "receiver_id", request.ReceiverID)
+
+	json.NewEncoder(w).Encode(PushOutboxResponse{
+		Success:  true,
+		Message:  fmt.Sprintf("%d pushes queued for delivery", replayed),
+		Replayed: &replayed,
+	})
+}
+
+// getPushDelivery loads a single push_outbox row
+func getPushDelivery(db *sql.DB, pushID int64) (*PushDelivery, error) {
+	row := db.QueryRow(`SELECT `+pushDeliveryColumns+` FROM push_outbox WHERE id = ?`, pushID)
+	return scanPushDelivery(row)
+}
+
+// scanPushDelivery reads a row selected with pushDeliveryColumns
+func scanPushDelivery(row interface{ Scan(...interface{}) error }) (*PushDelivery, error) {
+	var delivery PushDelivery
+	var notificationID, receiverID, deviceID sql.NullInt64
+	var topic sql.NullString
+	var payload []byte
+	var lastError, lastReason sql.NullString
+	var sentAt sql.NullTime
+	if err := row.Scan(
+		&delivery.ID, &notificationID, &receiverID, &delivery.Channel, &topic, &deviceID, &delivery.Title, &delivery.Body, &payload,
+		&delivery.Status, &delivery.Attempts, &lastError, &lastReason,
+		&delivery.NextAttemptAt, &sentAt, &delivery.CreatedAt, &delivery.UpdatedAt,
+	); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(payload, &delivery.Data); err != nil {
+		return nil, fmt.Errorf("push %d has invalid data: %v", delivery.ID, err)
+	}
+	if notificationID.Valid {
+		delivery.NotificationID = &notificationID.Int64
+	}
+	if receiverID.Valid {
+		delivery.ReceiverID = &receiverID.Int64
+	}
+	if topic.Valid {
+		delivery.Topic = &topic.String
+	}
+	if deviceID.Valid {
+		delivery.DeviceID = &deviceID.Int64
+	}
+	if lastError.Valid {
+		delivery.LastError = &lastError.String
+	}
+	if lastReason.Valid {
+		delivery.LastReason = &lastReason.String
+	}
+	if sentAt.Valid {
+		delivery.SentAt = &sentAt.Time
+	}
+	return &delivery, nil
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"context"
+	"go-rent/config"
+	"go-rent/notify"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestPushRetryDelay(t *testing.T) {
+	saved := config.App
+	defer func() { config.App = saved }()
+	config.App = &config.Config{Outbox: config.OutboxConfig{BaseDelay: 30 * time.Second, MaxDelay: 10 * time.Minute}}
+
+	tests := []struct {
+		attempts int
+		base     time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{5, 8 * time.Minute},
+		{6, 10 * time.Minute},
+		{7, 10 * time.Minute},
+		{1000, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		// Jitter adds up to a tenth of the delay; sample enough to see its range
+		for i := 0; i < 200; i++ {
+			got := pushRetryDelay(tt.attempts)
+			if got < tt.base || got > tt.base+tt.base/10 {
+				t.Fatalf("attempts=%d: delay %v outside [%v, %v]", tt.attempts, got, tt.base, tt.base+tt.base/10)
+			}
+		}
+	}
+}
+
+func TestPushRetryDelayBaseEqualsMax(t *testing.T) {
+	saved := config.App
+	defer func() { config.App = saved }()
+	config.App = &config.Config{Outbox: config.OutboxConfig{BaseDelay: time.Minute, MaxDelay: time.Minute}}
+
+	for _, attempts := range []int{1, 2, 10} {
+		if got := pushRetryDelay(attempts); got < time.Minute || got > time.Minute+6*time.Second {
+			t.Errorf("attempts=%d: delay %v, want between 1m and 1m6s", attempts, got)
+		}
+	}
+}
+
+// useMemoryNotifiers replaces Notifiers with Memory notifiers for channels
+// until the test ends
+func useMemoryNotifiers(t *testing.T, channels ...string) map[string]*notify.Memory {
+	saved, savedApp := Notifiers, config.App
+	t.Cleanup(func() { Notifiers, config.App = saved, savedApp })
+	config.App = &config.Config{Location: time.UTC}
+
+	memories := make(map[string]*notify.Memory)
+	var notifiers []notify.Notifier
+	for _, channel := range channels {
+		memories[channel] = notify.NewMemory(channel)
+		notifiers = append(notifiers, memories[channel])
+	}
+	Notifiers = notify.NewRegistry(notifiers...)
+	return memories
+}
+
+func TestEnqueueNotificationChannels(t *testing.T) {
+	everyAddress := func(channels, preferences string) *fakeUser {
+		return &fakeUser{
+			name: "Rahim", phone: "+8801712345678", email: "rahim@example.com",
+			webhookURL: "https://hooks.example.com/rent", channels: channels, preferences: preferences,
+			devices: []deviceToken{{ID: 11, Token: "phone-token"}, {ID: 12, Token: "tablet-token"}},
+		}
+	}
+	tests := []struct {
+		name       string
+		configured []string
+		user       *fakeUser
+		kind       string
+		except     []string
+		want       []string
+		wantRows   []string // channel of each outbox row, FCM once per device
+	}{
+		{
+			name:       "every enabled, configured channel",
+			configured: notify.Channels,
+			user:       everyAddress("fcm,email,sms,webhook", ""),
+			want:       []string{"fcm", "email", "sms", "webhook"},
+			wantRows:   []string{"email", "fcm", "fcm", "sms", "webhook"},
+		},
+		{
+			name:       "channels the server has no notifier for are skipped",
+			configured: []string{"fcm", "email"},
+			user:       everyAddress("fcm,email,sms,webhook", ""),
+			want:       []string{"fcm", "email"},
+			wantRows:   []string{"email", "fcm", "fcm"},
+		},
+		{
+			name:       "channels the user turned off are skipped",
+			configured: notify.Channels,
+			user:       everyAddress("email", ""),
+			want:       []string{"email"},
+			wantRows:   []string{"email"},
+		},
+		{
+			name:       "channels without an address are skipped",
+			configured: notify.Channels,
+			user:       &fakeUser{name: "Karim", phone: "+8801812345678", channels: "fcm,email,sms,webhook"},
+			want:       []string{"sms"},
+			wantRows:   []string{"sms"},
+		},
+		{
+			name:       "except",
+			configured: notify.Channels,
+			user:       everyAddress("fcm,email", ""),
+			except:     []string{"fcm"},
+			want:       []string{"email"},
+			wantRows:   []string{"email"},
+		},
+		{
+			name:       "kind preference replaces the defaults",
+			configured: notify.Channels,
+			user:       everyAddress("fcm,email", `{"kinds":{"payment":{"channels":["sms"]}}}`),
+			kind:       "payment",
+			want:       []string{"sms"},
+			wantRows:   []string{"sms"},
+		},
+		{
+			name:       "empty kind preference keeps it in the app",
+			configured: notify.Channels,
+			user:       everyAddress("fcm,email", `{"kinds":{"payment":{"channels":[]}}}`),
+			kind:       "payment",
+			want:       nil,
+			wantRows:   nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMemoryNotifiers(t, tt.configured...)
+			db, fake := newFakeDB(t, map[int64]*fakeUser{7: tt.user})
+			kind := tt.kind
+			if kind == "" {
+				kind = "notification"
+			}
+
+			tx := begin(t, db)
+			got, err := enqueueNotification(tx, 0, 7, kind, "Rent due", "Pay by the 10th", nil, tt.except...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tx.Commit()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("channels %v, want %v", got, tt.want)
+			}
+
+			var rows []string
+			for _, row := range fake.outboxRows() {
+				if row.receiver != 7 {
+					t.Errorf("row for receiver %d, want 7", row.receiver)
+				}
+				rows = append(rows, row.channel)
+			}
+			sort.Strings(rows)
+			if !reflect.DeepEqual(rows, tt.wantRows) {
+				t.Errorf("outbox rows %v, want %v", rows, tt.wantRows)
+			}
+		})
+	}
+}
+
+func TestDeliverQueuedNotifications(t *testing.T) {
+	memories := useMemoryNotifiers(t, notify.ChannelFCM, notify.ChannelEmail)
+	user := &fakeUser{
+		name: "Rahim", phone: "+8801712345678", email: "rahim@example.com", channels: "fcm,email",
+		devices: []deviceToken{{ID: 11, Token: "phone-token"}, {ID: 12, Token: "tablet-token"}},
+	}
+	db, fake := newFakeDB(t, map[int64]*fakeUser{7: user})
+
+	tx := begin(t, db)
+	if _, err := enqueueNotification(tx, 0, 7, "notification", "Rent due", "Pay by the 10th", nil); err != nil {
+		t.Fatal(err)
+	}
+	tx.Commit()
+
+	for _, row := range fake.outboxRows() {
+		push := queuedPush{ReceiverID: row.receiver, DeviceID: row.device, Channel: row.channel, Title: row.title}
+		if err := deliverPush(context.Background(), db, push); err != nil {
+			t.Fatalf("delivering %s push: %v", row.channel, err)
+		}
+	}
+
+	var tokens []string
+	for _, sent := range memories[notify.ChannelFCM].Sent() {
+		tokens = append(tokens, sent.To.FCMToken)
+	}
+	sort.Strings(tokens)
+	if want := []string{"phone-token", "tablet-token"}; !reflect.DeepEqual(tokens, want) {
+		t.Errorf("FCM sent to %v, want %v", tokens, want)
+	}
+	emails := memories[notify.ChannelEmail].Sent()
+	if len(emails) != 1 || emails[0].To.Email != "rahim@example.com" || emails[0].Message.Title != "Rent due" {
+		t.Errorf("emails sent: %+v", emails)
+	}
+}
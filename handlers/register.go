@@ -1,14 +1,14 @@
 package handlers
 
 import (
-	"bytes"
 	"crypto/rand"
 	"encoding/json"
 	"fmt"
+	"go-rent/apierror"
 	"go-rent/config"
+	"go-rent/logger"
 	"go-rent/utils"
 	"golang.org/x/crypto/bcrypt"
-	"io"
 	"math/big"
 	"net/http"
 	"regexp"
@@ -43,37 +43,21 @@ func generateRandomID() (int64, error) {
 }
 
 func RegisterHandler(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("\n=== New Registration Request ===")
-	fmt.Printf("Method: %s\n", r.Method)
-	fmt.Printf("URL: %s\n", r.URL)
-	fmt.Printf("Headers: %v\n", r.Header)
-
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		fmt.Printf("Error reading request body: %v\n", err)
-	}
-	fmt.Printf("Request Body: %s\n", string(body))
-
-	r.Body = io.NopCloser(bytes.NewBuffer(body)) // restore body for decoding
-
 	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(RegisterResponse{false, "Method not allowed", 0})
+		apierror.Write(w, r, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	var req RegisterRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(RegisterResponse{false, "Invalid request body", 0})
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid request body")
 		return
 	}
 
 	// Validate phone number
 	phoneRegex := regexp.MustCompile(`^\+880 \d{4}-\d{6}$`)
 	if !phoneRegex.MatchString(req.PhoneNumber) {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(RegisterResponse{false, "Invalid phone number format. Use format: +880 XXXX-XXXXXX", 0})
+		apierror.Field(w, r, "phone_number", "Invalid phone number format. Use format: +880 XXXX-XXXXXX")
 		return
 	}
 
@@ -82,44 +66,37 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	phoneNumber = strings.ReplaceAll(phoneNumber, "-", "")
 
 	if req.Password == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(RegisterResponse{false, "Password is required", 0})
+		apierror.Field(w, r, "password", "Password is required")
 		return
 	}
 
 	if req.Name == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(RegisterResponse{false, "Name is required", 0})
+		apierror.Field(w, r, "name", "Name is required")
 		return
 	}
 
 	db, err := config.GetDBConnection()
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(RegisterResponse{false, "Database connection error", 0})
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
 		return
 	}
-	defer db.Close()
 
 	// Check if phone number exists
 	var exists int
 	err = db.QueryRow("SELECT COUNT(*) FROM user WHERE phone_number = ?", phoneNumber).Scan(&exists)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(RegisterResponse{false, "Database error", 0})
+		apierror.Write(w, r, http.StatusInternalServerError, "Database error")
 		return
 	}
 	if exists > 0 {
-		w.WriteHeader(http.StatusConflict)
-		json.NewEncoder(w).Encode(RegisterResponse{false, "Phone number already registered", 0})
+		apierror.Write(w, r, http.StatusConflict, "Phone number already registered")
 		return
 	}
 
 	// Hash password
 	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(RegisterResponse{false, "Error hashing password", 0})
+		apierror.Write(w, r, http.StatusInternalServerError, "Error hashing password")
 		return
 	}
 
@@ -131,8 +108,7 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		// Validate email format
 		emailRegex := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
 		if !emailRegex.MatchString(req.Email) {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(RegisterResponse{false, "Invalid email format", 0})
+			apierror.Field(w, r, "email", "Invalid email format")
 			return
 		}
 		email = req.Email
@@ -145,8 +121,7 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		// Validate NID format (assuming it should be numeric and 10-17 digits)
 		nidRegex := regexp.MustCompile(`^\d{10,17}$`)
 		if !nidRegex.MatchString(req.NID) {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(RegisterResponse{false, "Invalid NID format. Should be 10-17 digits", 0})
+			apierror.Field(w, r, "nid", "Invalid NID format. Should be 10-17 digits")
 			return
 		}
 		nid = req.NID
@@ -162,9 +137,8 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	// Generate random ID
 	randomID, err := utils.GenerateRandomID()
 	if err != nil {
-		fmt.Printf("Error generating random ID: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(RegisterResponse{false, "Error generating user ID", 0})
+		logger.Error(r.Context(), "Error generating random ID", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error generating user ID")
 		return
 	}
 
@@ -185,17 +159,16 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		randomID,
 	)
 	if err != nil {
-		fmt.Printf("Error inserting user: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(RegisterResponse{false, fmt.Sprintf("Error inserting user: %v", err), 0})
+		logger.Error(r.Context(), "Error inserting user", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, fmt.Sprintf("Error inserting user: %v", err))
 		return
 	}
 
 	lastID, err := result.LastInsertId()
 	if err != nil {
-		fmt.Println("Inserted but failed to get last ID:", err)
+		logger.Error(r.Context(), "Inserted but failed to get last ID", "error", err)
 	} else {
-		fmt.Printf("User inserted with ID: %d\n", lastID)
+		logger.Info(r.Context(), "User registered", "new_user_id", lastID)
 	}
 
 	w.WriteHeader(http.StatusCreated)
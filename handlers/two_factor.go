@@ -0,0 +1,599 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"go-rent/apierror"
+	"go-rent/config"
+	"go-rent/logger"
+	"go-rent/utils"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// recoveryCodeCount is how many recovery codes are issued at a time
+const recoveryCodeCount = 10
+
+type LoginTwoFactorRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code,omitempty"`
+	RecoveryCode   string `json:"recovery_code,omitempty"`
+	ReturnToken    bool   `json:"return_token,omitempty"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+type TwoFactorResponse struct {
+	Success                bool     `json:"success"`
+	Message                string   `json:"message"`
+	Enabled                bool     `json:"enabled"`
+	RequiredByProperty     bool     `json:"required_by_property,omitempty"`
+	RecoveryCodesRemaining int      `json:"recovery_codes_remaining,omitempty"`
+	Secret                 string   `json:"secret,omitempty"`
+	ProvisioningURI        string   `json:"provisioning_uri,omitempty"`
+	RecoveryCodes          []string `json:"recovery_codes,omitempty"`
+}
+
+// LoginTwoFactorHandler completes a login for a user with 2FA enabled. It
+// takes the challenge token returned by LoginHandler plus either a TOTP code
+// or an unused recovery code, and issues the session on success.
+func LoginTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req LoginTwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	userID, err := utils.ValidateTwoFactorChallenge(req.ChallengeToken)
+	if err != nil {
+		logger.Error(r.Context(), "Invalid two-factor challenge", "error", err)
+		apierror.Write(w, r, http.StatusUnauthorized, "Login challenge is invalid or expired. Please log in again.")
+		return
+	}
+
+	db, err := config.GetDBConnection()
+	if err != nil {
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
+		return
+	}
+
+	ok, err := verifySecondFactor(r.Context(), db, userID, req.Code, req.RecoveryCode)
+	if err != nil {
+		logger.Error(r.Context(), "Error verifying second factor", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error verifying authentication code")
+		return
+	}
+	if !ok {
+		apierror.WriteCode(w, r, http.StatusUnauthorized, apierror.CodeInvalidCredentials, "Invalid authentication code")
+		return
+	}
+
+	var name string
+	if err := db.QueryRow("SELECT name FROM user WHERE id = ?", userID).Scan(&name); err != nil {
+		logger.Error(r.Context(), "Database error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	issueSession(w, r, db, userID, name, req.ReturnToken)
+}
+
+// GetTwoFactorStatusHandler reports whether 2FA is enabled for the current user
+func GetTwoFactorStatusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := getUserIDFromContext(r)
+	if userID == 0 {
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	db, err := config.GetDBConnection()
+	if err != nil {
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
+		return
+	}
+
+	resp := TwoFactorResponse{Success: true, Message: "Two-factor status retrieved successfully"}
+	err = db.QueryRow(`
+		SELECT u.totp_enabled,
+			EXISTS(
+				SELECT 1 FROM takes_care_of t
+				JOIN property p ON p.id = t.pid
+				WHERE t.uid = u.id AND p.require_2fa = TRUE
+			),
+			(SELECT COUNT(*) FROM recovery_code rc WHERE rc.uid = u.id AND rc.used_at IS NULL)
+		FROM user u
+		WHERE u.id = ?`, userID).Scan(&resp.Enabled, &resp.RequiredByProperty, &resp.RecoveryCodesRemaining)
+	if err != nil {
+		logger.Error(r.Context(), "Error getting two-factor status", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error getting two-factor status")
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// SetupTwoFactorHandler starts enrollment by generating a new TOTP secret.
+// The secret only takes effect once confirmed with EnableTwoFactorHandler.
+func SetupTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := getUserIDFromContext(r)
+	if userID == 0 {
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	db, err := config.GetDBConnection()
+	if err != nil {
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
+		return
+	}
+
+	var enabled bool
+	var phoneNumber string
+	if err := db.QueryRow("SELECT totp_enabled, phone_number FROM user WHERE id = ?", userID).Scan(&enabled, &phoneNumber); err != nil {
+		logger.Error(r.Context(), "Error getting user", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if enabled {
+		apierror.Write(w, r, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		logger.Error(r.Context(), "Error generating TOTP secret", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error generating two-factor secret")
+		return
+	}
+
+	_, err = db.Exec(`
+		UPDATE user SET totp_secret = ?, totp_last_step = NULL, updated_at = NOW(), updated_by = ?
+		WHERE id = ?`, secret, userID, userID)
+	if err != nil {
+		logger.Error(r.Context(), "Error storing TOTP secret", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error starting two-factor setup")
+		return
+	}
+
+	json.NewEncoder(w).Encode(TwoFactorResponse{
+		Success:         true,
+		Message:         "Scan the QR code in your authenticator app, then confirm with a code to enable two-factor authentication",
+		Secret:          secret,
+		ProvisioningURI: utils.TOTPProvisioningURI(secret, phoneNumber),
+	})
+}
+
+// EnableTwoFactorHandler confirms enrollment with a code from the
+// authenticator app and returns a fresh set of recovery codes
+func EnableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := getUserIDFromContext(r)
+	if userID == 0 {
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		apierror.Field(w, r, "code", "An authentication code is required")
+		return
+	}
+
+	db, err := config.GetDBConnection()
+	if err != nil {
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
+		return
+	}
+
+	var enabled bool
+	var secret sql.NullString
+	if err := db.QueryRow("SELECT totp_enabled, totp_secret FROM user WHERE id = ?", userID).Scan(&enabled, &secret); err != nil {
+		logger.Error(r.Context(), "Error getting user", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if enabled {
+		apierror.Write(w, r, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+	if !secret.Valid {
+		apierror.Write(w, r, http.StatusBadRequest, "Start two-factor setup first")
+		return
+	}
+
+	step, ok := utils.ValidateTOTP(secret.String, req.Code, time.Now())
+	if !ok {
+		apierror.WriteCode(w, r, http.StatusUnauthorized, apierror.CodeInvalidCredentials, "Invalid authentication code")
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Error(r.Context(), "Transaction start error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE user SET totp_enabled = TRUE, totp_last_step = ?, updated_at = NOW(), updated_by = ?
+		WHERE id = ?`, step, userID, userID)
+	if err != nil {
+		logger.Error(r.Context(), "Error enabling two-factor", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error enabling two-factor authentication")
+		return
+	}
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		logger.Error(r.Context(), "Error creating recovery codes", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error creating recovery codes")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.Error(r.Context(), "Transaction commit error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to commit transaction")
+		return
+	}
+
+	logger.Info(r.Context(), "Two-factor authentication enabled")
+
+	json.NewEncoder(w).Encode(TwoFactorResponse{
+		Success:       true,
+		Message:       "Two-factor authentication enabled. Store these recovery codes somewhere safe, they will not be shown again.",
+		Enabled:       true,
+		RecoveryCodes: codes,
+	})
+}
+
+// DisableTwoFactorHandler turns 2FA off after checking a code. Managers of a
+// property that requires 2FA cannot disable it.
+func DisableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := getUserIDFromContext(r)
+	if userID == 0 {
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	db, err := config.GetDBConnection()
+	if err != nil {
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
+		return
+	}
+
+	required, err := twoFactorRequiredForUser(db, userID, 0)
+	if err != nil {
+		logger.Error(r.Context(), "Error checking property two-factor requirement", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if required {
+		apierror.WriteCode(w, r, http.StatusForbidden, apierror.CodeTwoFactorRequired, "A property you manage requires two-factor authentication")
+		return
+	}
+
+	ok, err := verifySecondFactor(r.Context(), db, userID, req.Code, req.RecoveryCode)
+	if err != nil {
+		logger.Error(r.Context(), "Error verifying second factor", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error verifying authentication code")
+		return
+	}
+	if !ok {
+		apierror.WriteCode(w, r, http.StatusUnauthorized, apierror.CodeInvalidCredentials, "Invalid authentication code")
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Error(r.Context(), "Transaction start error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE user SET totp_enabled = FALSE, totp_secret = NULL, totp_last_step = NULL, updated_at = NOW(), updated_by = ?
+		WHERE id = ?`, userID, userID)
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM recovery_code WHERE uid = ?", userID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		logger.Error(r.Context(), "Error disabling two-factor", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error disabling two-factor authentication")
+		return
+	}
+
+	logger.Info(r.Context(), "Two-factor authentication disabled")
+
+	json.NewEncoder(w).Encode(TwoFactorResponse{Success: true, Message: "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodesHandler replaces all recovery codes after checking a TOTP code
+func RegenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := getUserIDFromContext(r)
+	if userID == 0 {
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		apierror.Field(w, r, "code", "An authentication code is required")
+		return
+	}
+
+	db, err := config.GetDBConnection()
+	if err != nil {
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
+		return
+	}
+
+	ok, err := verifySecondFactor(r.Context(), db, userID, req.Code, "")
+	if err != nil {
+		logger.Error(r.Context(), "Error verifying second factor", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error verifying authentication code")
+		return
+	}
+	if !ok {
+		apierror.WriteCode(w, r, http.StatusUnauthorized, apierror.CodeInvalidCredentials, "Invalid authentication code")
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		logger.Error(r.Context(), "Transaction start error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Failed to start transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		logger.Error(r.Context(), "Error regenerating recovery codes", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error creating recovery codes")
+		return
+	}
+
+	json.NewEncoder(w).Encode(TwoFactorResponse{
+		Success:       true,
+		Message:       "New recovery codes generated. Previous codes no longer work.",
+		Enabled:       true,
+		RecoveryCodes: codes,
+	})
+}
+
+// SetPropertyTwoFactorHandler lets a manager require 2FA for everyone who
+// manages the property. The manager must have 2FA enabled to turn it on.
+func SetPropertyTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := getUserIDFromContext(r)
+	if userID == 0 {
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	propertyID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid property ID")
+		return
+	}
+
+	var req struct {
+		Required bool `json:"required"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	db, err := config.GetDBConnection()
+	if err != nil {
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
+		return
+	}
+
+	if req.Required {
+		var enabled bool
+		if err := db.QueryRow("SELECT totp_enabled FROM user WHERE id = ?", userID).Scan(&enabled); err != nil {
+			logger.Error(r.Context(), "Error getting user", "error", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "Database error")
+			return
+		}
+		if !enabled {
+			apierror.Write(w, r, http.StatusBadRequest, "Enable two-factor authentication on your own account first")
+			return
+		}
+	}
+
+	_, err = db.Exec(`
+		UPDATE property SET require_2fa = ?, updated_at = NOW(), updated_by = ?
+		WHERE id = ?`, req.Required, userID, propertyID)
+	if err != nil {
+		logger.Error(r.Context(), "Error updating property two-factor requirement", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error updating property")
+		return
+	}
+
+	logger.Info(r.Context(), "Property two-factor requirement updated", "property_id", propertyID, "require_2fa", req.Required)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":     true,
+		"message":     "Property two-factor requirement updated",
+		"require_2fa": req.Required,
+	})
+}
+
+// acceptTOTP validates code at now and returns its time step, rejecting a
+// code from the step last used (lastStep) or an earlier one, which would be
+// a replay
+func acceptTOTP(secret, code string, lastStep sql.NullInt64, now time.Time) (int64, bool) {
+	step, ok := utils.ValidateTOTP(secret, code, now)
+	if !ok || (lastStep.Valid && step <= lastStep.Int64) {
+		return 0, false
+	}
+	return step, true
+}
+
+// verifySecondFactor checks a TOTP code or, failing that, a recovery code.
+// Accepted TOTP steps and recovery codes are consumed so neither can be replayed.
+func verifySecondFactor(ctx context.Context, db *sql.DB, userID int64, code, recoveryCode string) (bool, error) {
+	var enabled bool
+	var secret sql.NullString
+	var lastStep sql.NullInt64
+	err := db.QueryRow("SELECT totp_enabled, totp_secret, totp_last_step FROM user WHERE id = ?", userID).
+		Scan(&enabled, &secret, &lastStep)
+	if err != nil {
+		return false, err
+	}
+	if !enabled || !secret.Valid {
+		return false, nil
+	}
+
+	if code != "" {
+		step, ok := acceptTOTP(secret.String, code, lastStep, time.Now())
+		if !ok {
+			return false, nil
+		}
+		// Conditional update so two concurrent requests can't both use the code
+		result, err := db.Exec(`
+			UPDATE user SET totp_last_step = ?
+			WHERE id = ? AND (totp_last_step IS NULL OR totp_last_step < ?)`, step, userID, step)
+		if err != nil {
+			return false, err
+		}
+		affected, _ := result.RowsAffected()
+		return affected == 1, nil
+	}
+
+	recoveryCode = strings.ToLower(strings.TrimSpace(recoveryCode))
+	if recoveryCode == "" {
+		return false, nil
+	}
+
+	rows, err := db.Query("SELECT id, code_hash FROM recovery_code WHERE uid = ? AND used_at IS NULL", userID)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	var matchedID int64
+	for rows.Next() {
+		var id int64
+		var hash string
+		if err := rows.Scan(&id, &hash); err != nil {
+			return false, err
+		}
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(recoveryCode)) == nil {
+			matchedID = id
+			break
+		}
+	}
+	rows.Close()
+	if matchedID == 0 {
+		return false, nil
+	}
+
+	result, err := db.Exec("UPDATE recovery_code SET used_at = NOW() WHERE id = ? AND used_at IS NULL", matchedID)
+	if err != nil {
+		return false, err
+	}
+	affected, _ := result.RowsAffected()
+	if affected == 1 {
+		logger.Warn(ctx, "Recovery code used", "user_id", userID)
+	}
+	return affected == 1, nil
+}
+
+// replaceRecoveryCodes deletes the user's recovery codes and stores a new set,
+// returning the plaintext codes
+func replaceRecoveryCodes(tx *sql.Tx, userID int64) ([]string, error) {
+	codes, err := utils.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM recovery_code WHERE uid = ?", userID); err != nil {
+		return nil, err
+	}
+
+	for _, code := range codes {
+		hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		id, err := utils.GenerateRandomID()
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO recovery_code (id, uid, code_hash, created_at)
+			VALUES (?, ?, ?, NOW())`, id, userID, string(hash))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, nil
+}
+
+// twoFactorRequiredForUser reports whether the user manages a property that
+// requires 2FA. A propertyID of 0 checks every property the user manages.
+func twoFactorRequiredForUser(db *sql.DB, userID, propertyID int64) (bool, error) {
+	var required bool
+	err := db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM takes_care_of t
+			JOIN property p ON p.id = t.pid
+			WHERE t.uid = ? AND p.require_2fa = TRUE AND (? = 0 OR p.id = ?)
+		)`, userID, propertyID, propertyID).Scan(&required)
+	return required, err
+}
+
+// twoFactorMissing reports whether the user manages the property, the
+// property requires 2FA, and the user has not enabled it
+func twoFactorMissing(db *sql.DB, userID, propertyID int64) (bool, error) {
+	required, err := twoFactorRequiredForUser(db, userID, propertyID)
+	if err != nil || !required {
+		return false, err
+	}
+
+	var enabled bool
+	if err := db.QueryRow("SELECT totp_enabled FROM user WHERE id = ?", userID).Scan(&enabled); err != nil {
+		return false, err
+	}
+	return !enabled, nil
+}
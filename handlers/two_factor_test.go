@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestAcceptTOTPReplayWindow(t *testing.T) {
+	// RFC 6238 SHA-1 key; 050471 is the code for step 37037037
+	const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	const step = 37037037
+	now := time.Unix(1111111111, 0)
+
+	tests := []struct {
+		name     string
+		lastStep sql.NullInt64
+		ok       bool
+	}{
+		{"never used", sql.NullInt64{}, true},
+		{"earlier step used", sql.NullInt64{Int64: step - 1, Valid: true}, true},
+		{"same step used", sql.NullInt64{Int64: step, Valid: true}, false},
+		{"later step used", sql.NullInt64{Int64: step + 1, Valid: true}, false},
+	}
+	for _, tt := range tests {
+		got, ok := acceptTOTP(secret, "050471", tt.lastStep, now)
+		if ok != tt.ok {
+			t.Errorf("%s: ok = %v, want %v", tt.name, ok, tt.ok)
+		}
+		if ok && got != step {
+			t.Errorf("%s: step = %d, want %d", tt.name, got, step)
+		}
+	}
+
+	// A code from the previous step, still inside the skew window, is a
+	// replay once a later step has been used
+	previous := now.Add(30 * time.Second)
+	if _, ok := acceptTOTP(secret, "050471", sql.NullInt64{Int64: step, Valid: true}, previous); ok {
+		t.Error("code replayed in the next step was accepted")
+	}
+}
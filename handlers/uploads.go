@@ -0,0 +1,696 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-rent/apierror"
+	"go-rent/config"
+	"go-rent/logger"
+	"go-rent/metrics"
+	"go-rent/storage"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gorilla/mux"
+)
+
+// Files are uploaded as multipart/form-data, checked by their content
+// rather than the type the client claims, and stored through Files under a
+// random key. Images also get a JPEG thumbnail. The upload table records
+// who uploaded each file and what for; clients refer to uploads by ID, and
+// responses that show a file carry URLs signed for STORAGE_URL_TTL, so
+// clients should fetch the resource again rather than keep the URLs.
+
+// Upload purposes
+const (
+	uploadPurposeAttachment    = "attachment"
+	uploadPurposePropertyPhoto = "property_photo"
+	uploadPurposePaymentProof  = "payment_proof"
+)
+
+// uploadPurposes lists the purposes accepted by POST /uploads
+var uploadPurposes = []string{uploadPurposeAttachment, uploadPurposePropertyPhoto, uploadPurposePaymentProof}
+
+// uploadTypes maps each accepted content type, as sniffed, to the file
+// extension it is stored with
+var uploadTypes = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"application/pdf": ".pdf",
+}
+
+// Upload limits
+const (
+	maxUploadNameLength = 255
+	maxUploadFieldSize  = 256
+	// multipartOverhead is allowed on top of UPLOAD_MAX_BYTES for the
+	// multipart headers and other fields
+	multipartOverhead = 64 << 10
+	// uploadReadTimeout replaces the server's read timeout while a file is
+	// received, so that slow mobile connections can finish
+	uploadReadTimeout = 2 * time.Minute
+)
+
+// Upload is an uploaded file as shown to clients. URL and ThumbnailURL
+// stop working at URLExpiresAt.
+type Upload struct {
+	ID           int64     `json:"id"`
+	Purpose      string    `json:"purpose"`
+	Name         string    `json:"name,omitempty"`
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	Width        int       `json:"width,omitempty"`
+	Height       int       `json:"height,omitempty"`
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url,omitempty"`
+	URLExpiresAt time.Time `json:"url_expires_at"`
+	CreatedAt    time.Time `json:"created_at"`
+
+	userID       int64
+	key          string
+	thumbnailKey string
+}
+
+type UploadResponse struct {
+	Success bool    `json:"success"`
+	Message string  `json:"message"`
+	Upload  *Upload `json:"upload"`
+}
+
+// uploadFile is a file received in a multipart request
+type uploadFile struct {
+	Purpose     string
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// UploadFileHandler stores a file sent in the "file" field of a multipart
+// form. The optional "purpose" field is attachment (the default), for
+// message attachments, property_photo, or payment_proof, for the receipt
+// sent with a payment notification. The returned ID is then passed to the
+// endpoint that uses the file.
+func UploadFileHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := getUserIDFromContext(r)
+	if userID == 0 {
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	file, ok := readUpload(w, r)
+	if !ok {
+		return
+	}
+	if file.Purpose == "" {
+		file.Purpose = uploadPurposeAttachment
+	}
+	if !contains(uploadPurposes, file.Purpose) {
+		apierror.Field(w, r, "purpose", "purpose must be one of "+strings.Join(uploadPurposes, ", "))
+		return
+	}
+	if !uploadAllowed(w, r, file) {
+		return
+	}
+
+	db, err := config.GetDBConnection()
+	if err != nil {
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
+		return
+	}
+
+	upload, ok := saveUpload(w, r, db, userID, file)
+	if !ok {
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(UploadResponse{
+		Success: true,
+		Message: "File uploaded successfully",
+		Upload:  upload,
+	})
+}
+
+// GetUploadHandler returns an upload with fresh URLs. Its uploader can
+// see it, and so can whoever can see the property or message that uses it.
+func GetUploadHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := getUserIDFromContext(r)
+	if userID == 0 {
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+	uploadID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid upload ID")
+		return
+	}
+
+	db, err := config.GetDBConnection()
+	if err != nil {
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
+		return
+	}
+
+	upload, err := loadUpload(db, uploadID)
+	if err == nil {
+		var visible bool
+		visible, err = canViewUpload(db, upload, userID)
+		if err == nil && !visible {
+			err = sql.ErrNoRows
+		}
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		apierror.Write(w, r, http.StatusNotFound, "Upload not found")
+		return
+	}
+	if err != nil {
+		logger.Error(r.Context(), "Error loading upload", "upload_id", uploadID, "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error loading upload")
+		return
+	}
+
+	upload.sign(fileURLExpiry())
+	json.NewEncoder(w).Encode(UploadResponse{
+		Success: true,
+		Message: "Upload retrieved successfully",
+		Upload:  upload,
+	})
+}
+
+// SetPropertyPhotoHandler replaces a property's photo with an image sent
+// in the "file" field of a multipart form. The previous photo, if it was
+// uploaded, is deleted.
+func SetPropertyPhotoHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := getUserIDFromContext(r)
+	if userID == 0 {
+		apierror.Write(w, r, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+	propertyID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, "Invalid property ID")
+		return
+	}
+
+	file, ok := readUpload(w, r)
+	if !ok {
+		return
+	}
+	file.Purpose = uploadPurposePropertyPhoto
+	if !uploadAllowed(w, r, file) {
+		return
+	}
+
+	db, err := config.GetDBConnection()
+	if err != nil {
+		logger.Error(r.Context(), "Database connection error", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
+		return
+	}
+
+	var previous sql.NullInt64
+	if err := db.QueryRow(`SELECT photo_upload_id FROM property WHERE id = ?`, propertyID).Scan(&previous); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			apierror.Write(w, r, http.StatusNotFound, "Property not found")
+			return
+		}
+		logger.Error(r.Context(), "Error loading property", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error updating property photo")
+		return
+	}
+
+	upload, ok := saveUpload(w, r, db, userID, file)
+	if !ok {
+		return
+	}
+	_, err = db.Exec(`
+		UPDATE property
+		SET photo_upload_id = ?, photo = NULL, updated_at = ?, updated_by = ?
+		WHERE id = ?`,
+		upload.ID, time.Now().In(config.App.Location).Format("2006-01-02 15:04:05"), userID, propertyID)
+	if err != nil {
+		logger.Error(r.Context(), "Error saving property photo", "error", err)
+		deleteUpload(r.Context(), db, upload)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error updating property photo")
+		return
+	}
+	if previous.Valid {
+		if old, err := loadUpload(db, previous.Int64); err == nil {
+			deleteUpload(r.Context(), db, old)
+		}
+	}
+
+	logger.Info(r.Context(), "Property photo updated", "upload_id", upload.ID)
+	json.NewEncoder(w).Encode(UploadResponse{
+		Success: true,
+		Message: "Property photo updated successfully",
+		Upload:  upload,
+	})
+}
+
+// readUpload reads the multipart form of an upload request, sniffing the
+// file's content type. It writes the error response and returns false when
+// the request has no acceptable file.
+func readUpload(w http.ResponseWriter, r *http.Request) (*uploadFile, bool) {
+	if err := http.NewResponseController(w).SetReadDeadline(time.Now().Add(uploadReadTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		logger.Warn(r.Context(), "Could not extend the read deadline of an upload", "error", err)
+	}
+	maxBytes := config.App.Storage.MaxBytes
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+multipartOverhead)
+	tooLarge := func() (*uploadFile, bool) {
+		metrics.Uploads.Inc("unknown", metrics.UploadTooLarge)
+		apierror.Write(w, r, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("Files can be at most %.1f MB", float64(maxBytes)/(1<<20)))
+		return nil, false
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, "Send the file as multipart/form-data in a field named file")
+		return nil, false
+	}
+	file := &uploadFile{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return tooLarge()
+		}
+		if err != nil {
+			apierror.Write(w, r, http.StatusBadRequest, "Invalid multipart body")
+			return nil, false
+		}
+
+		switch part.FormName() {
+		case "file":
+			if file.Data != nil {
+				part.Close()
+				apierror.Field(w, r, "file", "Send one file at a time")
+				return nil, false
+			}
+			data, err := io.ReadAll(io.LimitReader(part, maxBytes+1))
+			if errors.As(err, &maxErr) || int64(len(data)) > maxBytes {
+				part.Close()
+				return tooLarge()
+			}
+			if err != nil {
+				part.Close()
+				apierror.Write(w, r, http.StatusBadRequest, "Invalid multipart body")
+				return nil, false
+			}
+			file.Data = data
+			file.Name = cleanUploadName(part.FileName())
+		case "purpose":
+			value, _ := io.ReadAll(io.LimitReader(part, maxUploadFieldSize))
+			file.Purpose = strings.TrimSpace(string(value))
+		}
+		part.Close()
+	}
+
+	if len(file.Data) == 0 {
+		apierror.Field(w, r, "file", "Choose a file to upload")
+		return nil, false
+	}
+	file.ContentType, _, _ = strings.Cut(http.DetectContentType(file.Data), ";")
+	return file, true
+}
+
+// uploadAllowed reports whether file's content type is accepted for its
+// purpose, writing a 415 response when it is not. Property photos must be
+// images.
+func uploadAllowed(w http.ResponseWriter, r *http.Request, file *uploadFile) bool {
+	_, ok := uploadTypes[file.ContentType]
+	if file.Purpose == uploadPurposePropertyPhoto && !strings.HasPrefix(file.ContentType, "image/") {
+		ok = false
+	}
+	if !ok {
+		metrics.Uploads.Inc(file.Purpose, metrics.UploadUnsupported)
+		message := "Only JPEG, PNG, GIF and WebP images and PDF documents can be uploaded"
+		if file.Purpose == uploadPurposePropertyPhoto {
+			message = "Property photos must be JPEG, PNG, GIF or WebP images"
+		}
+		apierror.Write(w, r, http.StatusUnsupportedMediaType, message)
+	}
+	return ok
+}
+
+// saveUpload stores file and its thumbnail and records them for userID,
+// returning the upload with signed URLs. It writes the error response and
+// returns false on failure, or when the file would take userID's uploads
+// past UPLOAD_USER_QUOTA_BYTES. The quota is checked before storing rather
+// than locked, so concurrent uploads may overshoot it by a file or two.
+func saveUpload(w http.ResponseWriter, r *http.Request, db *sql.DB, userID int64, file *uploadFile) (*Upload, bool) {
+	ctx := r.Context()
+	var used int64
+	if err := db.QueryRow(`SELECT COALESCE(SUM(size_bytes), 0) FROM upload WHERE user_id = ?`, userID).Scan(&used); err != nil {
+		metrics.Uploads.Inc(file.Purpose, metrics.UploadFailed)
+		logger.Error(ctx, "Error loading upload usage", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error saving file")
+		return nil, false
+	}
+	if quota := config.App.Storage.QuotaBytes; used+int64(len(file.Data)) > quota {
+		metrics.Uploads.Inc(file.Purpose, metrics.UploadOverQuota)
+		logger.Warn(ctx, "Upload quota reached", "used", used, "size", len(file.Data))
+		apierror.Write(w, r, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("Your files can take up at most %d MB; unused uploads are removed after %s",
+				quota>>20, config.App.Storage.OrphanTTL))
+		return nil, false
+	}
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		logger.Error(ctx, "Error generating upload key", "error", err)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error saving file")
+		return nil, false
+	}
+	now := time.Now().In(config.App.Location)
+	upload := &Upload{
+		Purpose:     file.Purpose,
+		Name:        file.Name,
+		ContentType: file.ContentType,
+		Size:        int64(len(file.Data)),
+		CreatedAt:   now,
+		userID:      userID,
+		key:         path.Join("uploads", now.Format("2006/01"), hex.EncodeToString(random)) + uploadTypes[file.ContentType],
+	}
+
+	var thumbnail []byte
+	if storage.HasThumbnail(file.ContentType) {
+		var err error
+		thumbnail, upload.Width, upload.Height, err = storage.Thumbnail(file.Data, config.App.Storage.ThumbnailSize)
+		switch {
+		case errors.Is(err, storage.ErrImageTooLarge):
+			metrics.Uploads.Inc(file.Purpose, metrics.UploadTooLarge)
+			apierror.Write(w, r, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("Images can be at most %d megapixels", storage.MaxImagePixels/1_000_000))
+			return nil, false
+		case errors.Is(err, storage.ErrImageUnreadable):
+			metrics.Uploads.Inc(file.Purpose, metrics.UploadUnsupported)
+			apierror.Write(w, r, http.StatusUnsupportedMediaType, "The image could not be read")
+			return nil, false
+		case err != nil:
+			logger.Error(ctx, "Error making thumbnail", "error", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "Error saving file")
+			return nil, false
+		}
+		upload.thumbnailKey = strings.TrimSuffix(upload.key, path.Ext(upload.key)) + "_thumb.jpg"
+	}
+
+	failed := func(message string, err error) (*Upload, bool) {
+		metrics.Uploads.Inc(file.Purpose, metrics.UploadFailed)
+		logger.Error(ctx, message, "key", upload.key, "error", err)
+		deleteUploadFiles(ctx, upload)
+		apierror.Write(w, r, http.StatusInternalServerError, "Error saving file")
+		return nil, false
+	}
+	if err := Files.Put(ctx, upload.key, bytes.NewReader(file.Data), upload.Size, upload.ContentType); err != nil {
+		return failed("Error storing upload", err)
+	}
+	if thumbnail != nil {
+		if err := Files.Put(ctx, upload.thumbnailKey, bytes.NewReader(thumbnail), int64(len(thumbnail)), "image/jpeg"); err != nil {
+			return failed("Error storing thumbnail", err)
+		}
+	}
+
+	result, err := db.Exec(`
+		INSERT INTO upload (user_id, purpose, storage_key, thumbnail_key, name, content_type, size_bytes, width, height, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, upload.Purpose, upload.key, nullString(upload.thumbnailKey), nullString(upload.Name),
+		upload.ContentType, upload.Size, nullInt(upload.Width), nullInt(upload.Height), now.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return failed("Error recording upload", err)
+	}
+	if upload.ID, err = result.LastInsertId(); err != nil {
+		return failed("Error reading upload ID", err)
+	}
+
+	metrics.Uploads.Inc(file.Purpose, metrics.UploadStored)
+	logger.Info(ctx, "File uploaded", "upload_id", upload.ID, "purpose", upload.Purpose,
+		"content_type", upload.ContentType, "size", upload.Size)
+	upload.sign(fileURLExpiry())
+	return upload, true
+}
+
+// loadUpload returns the upload with ID id, or sql.ErrNoRows
+func loadUpload(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, id int64) (*Upload, error) {
+	upload := &Upload{}
+	var name, thumbnailKey sql.NullString
+	var width, height sql.NullInt64
+	err := q.QueryRow(`
+		SELECT id, user_id, purpose, storage_key, thumbnail_key, name, content_type, size_bytes, width, height, created_at
+		FROM upload
+		WHERE id = ?`, id).Scan(&upload.ID, &upload.userID, &upload.Purpose, &upload.key, &thumbnailKey,
+		&name, &upload.ContentType, &upload.Size, &width, &height, &upload.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	upload.Name, upload.thumbnailKey = name.String, thumbnailKey.String
+	upload.Width, upload.Height = int(width.Int64), int(height.Int64)
+	return upload, nil
+}
+
+// ownUpload returns userID's upload with ID id for purpose, or
+// sql.ErrNoRows when they have none
+func ownUpload(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, userID, id int64, purpose string) (*Upload, error) {
+	upload, err := loadUpload(q, id)
+	if err != nil {
+		return nil, err
+	}
+	if upload.userID != userID || upload.Purpose != purpose {
+		return nil, sql.ErrNoRows
+	}
+	return upload, nil
+}
+
+// canViewUpload reports whether userID may see upload: they uploaded it,
+// manage or rent on the property it is the photo of, take part in a thread
+// where it was attached, or it is the proof of a payment notification or
+// payment that they received or that was made on a property they manage
+func canViewUpload(q interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}, upload *Upload, userID int64) (bool, error) {
+	if upload.userID == userID {
+		return true, nil
+	}
+	var visible bool
+	err := q.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM property p
+			WHERE p.photo_upload_id = ? AND (
+				EXISTS (SELECT 1 FROM takes_care_of c WHERE c.pid = p.id AND c.uid = ?)
+				OR EXISTS (SELECT 1 FROM floor f WHERE f.pid = p.id AND f.tenant = ?))
+		) OR EXISTS (
+			SELECT 1
+			FROM message_attachment a
+			JOIN message m ON m.id = a.message_id
+			JOIN message_thread t ON t.id = m.thread_id
+			WHERE a.upload_id = ? AND `+threadParticipant+`
+		) OR EXISTS (
+			SELECT 1 FROM notification n
+			WHERE n.proof_upload_id = ? AND (
+				n.receiver = ?
+				OR EXISTS (SELECT 1 FROM takes_care_of c WHERE c.pid = n.pid AND c.uid = ?))
+		) OR EXISTS (
+			SELECT 1
+			FROM payment pay
+			JOIN floor f ON f.id = pay.fid
+			WHERE pay.proof_upload_id = ? AND (
+				pay.uid = ?
+				OR EXISTS (SELECT 1 FROM takes_care_of c WHERE c.pid = f.pid AND c.uid = ?))
+		)`, upload.ID, userID, userID, upload.ID, userID, userID,
+		upload.ID, userID, userID, upload.ID, userID, userID).Scan(&visible)
+	if err != nil {
+		return false, fmt.Errorf("failed to check upload access: %v", err)
+	}
+	return visible, nil
+}
+
+// deleteUpload removes an upload's record and files. Failures are logged,
+// since the upload is no longer used either way.
+func deleteUpload(ctx context.Context, db *sql.DB, upload *Upload) {
+	if upload.ID != 0 {
+		if _, err := db.Exec(`DELETE FROM upload WHERE id = ?`, upload.ID); err != nil {
+			logger.Error(ctx, "Error deleting upload", "upload_id", upload.ID, "error", err)
+			return
+		}
+	}
+	deleteUploadFiles(ctx, upload)
+}
+
+// unreferencedUpload is an SQL condition, on upload u, that is true while
+// no message attachment, property, payment notification or payment refers
+// to the upload
+const unreferencedUpload = `
+	NOT EXISTS (SELECT 1 FROM message_attachment a WHERE a.upload_id = u.id)
+	AND NOT EXISTS (SELECT 1 FROM property p WHERE p.photo_upload_id = u.id)
+	AND NOT EXISTS (SELECT 1 FROM notification n WHERE n.proof_upload_id = u.id)
+	AND NOT EXISTS (SELECT 1 FROM payment pay WHERE pay.proof_upload_id = u.id)`
+
+// orphanUploadBatch is how many unreferenced uploads one run deletes at
+// most; the rest wait for the next run
+const orphanUploadBatch = 500
+
+// PruneOrphanUploads deletes uploads that nothing refers to once they are
+// older than UPLOAD_ORPHAN_TTL, such as files uploaded for a message that
+// was never sent, and records the run in the scheduler job metrics
+func PruneOrphanUploads() {
+	start := time.Now()
+	outcome := pruneOrphanUploads(context.Background())
+	metrics.ObserveJob("orphan_uploads", outcome, start)
+}
+
+// pruneOrphanUploads does the work of PruneOrphanUploads and returns the
+// job outcome
+func pruneOrphanUploads(ctx context.Context) string {
+	db, err := config.GetDBConnection()
+	if err != nil {
+		logger.Error(ctx, "Database connection error", "error", err)
+		return metrics.JobError
+	}
+
+	cutoff := time.Now().In(config.App.Location).Add(-config.App.Storage.OrphanTTL)
+	rows, err := db.QueryContext(ctx, `
+		SELECT u.id, u.storage_key, u.thumbnail_key
+		FROM upload u
+		WHERE u.created_at < ? AND`+unreferencedUpload+`
+		ORDER BY u.id
+		LIMIT ?`, cutoff.Format("2006-01-02 15:04:05"), orphanUploadBatch)
+	if err != nil {
+		logger.Error(ctx, "Error loading unreferenced uploads", "error", err)
+		return metrics.JobError
+	}
+	var orphans []*Upload
+	for rows.Next() {
+		upload := &Upload{}
+		var thumbnailKey sql.NullString
+		if err := rows.Scan(&upload.ID, &upload.key, &thumbnailKey); err != nil {
+			rows.Close()
+			logger.Error(ctx, "Error loading unreferenced uploads", "error", err)
+			return metrics.JobError
+		}
+		upload.thumbnailKey = thumbnailKey.String
+		orphans = append(orphans, upload)
+	}
+	rows.Close()
+	if len(orphans) == 0 {
+		return metrics.JobSkipped
+	}
+
+	deleted := 0
+	for _, upload := range orphans {
+		// The condition is checked again, so that an upload used since it
+		// was loaded is kept
+		result, err := db.ExecContext(ctx, `
+			DELETE u FROM upload u
+			WHERE u.id = ? AND`+unreferencedUpload, upload.ID)
+		if err != nil {
+			logger.Error(ctx, "Error deleting upload", "upload_id", upload.ID, "error", err)
+			return metrics.JobError
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			continue
+		}
+		deleteUploadFiles(ctx, upload)
+		deleted++
+	}
+
+	logger.Info(ctx, "Pruned unreferenced uploads", "count", deleted)
+	return metrics.JobSuccess
+}
+
+func deleteUploadFiles(ctx context.Context, upload *Upload) {
+	for _, key := range []string{upload.key, upload.thumbnailKey} {
+		if key == "" {
+			continue
+		}
+		if err := Files.Delete(ctx, key); err != nil {
+			logger.Error(ctx, "Error deleting stored file", "key", key, "error", err)
+		}
+	}
+}
+
+// sign sets the upload's URLs to ones that expire at expires
+func (u *Upload) sign(expires time.Time) {
+	u.URL = fileURL(u.key, expires)
+	u.ThumbnailURL = fileURL(u.thumbnailKey, expires)
+	u.URLExpiresAt = expires
+}
+
+// cleanUploadName keeps the base name of a client's file name, without
+// control characters, for showing next to attachments
+func cleanUploadName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "." || name == "/" {
+		return ""
+	}
+	if runes := []rune(name); len(runes) > maxUploadNameLength {
+		name = string(runes[:maxUploadNameLength])
+	}
+	return name
+}
+
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func nullInt(n int) interface{} {
+	if n == 0 {
+		return nil
+	}
+	return n
+}
+
+// setPropertyPhoto sets prop's photo from the photo column, or to signed
+// URLs when photoKey, the key of an uploaded photo, is set
+func setPropertyPhoto(prop *Property, photo, photoKey, thumbnailKey sql.NullString, expires time.Time) {
+	if photoKey.Valid {
+		if u := fileURL(photoKey.String, expires); u != "" {
+			prop.Photo = &u
+		}
+		if u := fileURL(thumbnailKey.String, expires); u != "" {
+			prop.PhotoThumbnail = &u
+		}
+		return
+	}
+	if photo.Valid {
+		prop.Photo = &photo.String
+	}
+}
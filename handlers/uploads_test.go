@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"go-rent/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSaveUploadQuota(t *testing.T) {
+	saved := config.App
+	t.Cleanup(func() { config.App = saved })
+	config.App = &config.Config{Location: time.UTC,
+		Storage: config.StorageConfig{MaxBytes: 100, QuotaBytes: 100, OrphanTTL: 24 * time.Hour}}
+
+	db, _ := newFakeDB(t, map[int64]*fakeUser{7: {name: "Rahim", uploadBytes: 60}})
+	file := &uploadFile{Purpose: uploadPurposeAttachment, ContentType: "application/pdf", Data: make([]byte, 41)}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/uploads", nil)
+	// One byte over the quota; Files is unset, so storing the file would panic
+	if upload, ok := saveUpload(w, r, db, 7, file); ok || upload != nil {
+		t.Fatalf("saveUpload over quota = %+v, %v", upload, ok)
+	}
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want 413", w.Code)
+	}
+}
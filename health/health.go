@@ -0,0 +1,124 @@
+// Package health serves the liveness and readiness probes.
+//
+// /healthz only reports that the process is up and serving requests.
+// /readyz additionally checks every dependency the server needs to do
+// useful work and returns 503 when any of them fails, so a load balancer
+// or orchestrator can hold traffic back until they recover.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-rent/config"
+	"go-rent/handlers"
+	"go-rent/logger"
+	"go-rent/scheduler"
+	"net/http"
+	"time"
+)
+
+// checkTimeout bounds each dependency check so a hung dependency cannot
+// hang the probe
+const checkTimeout = 2 * time.Second
+
+var startedAt = time.Now()
+
+// Check is one named dependency check
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Checks are the dependency checks run by the readiness probe, in order
+var Checks = []Check{
+	{Name: "database", Run: checkDatabase},
+	{Name: "migrations", Run: checkMigrations},
+	{Name: "fcm_credentials", Run: func(ctx context.Context) error { return handlers.CheckFCMCredentials() }},
+	{Name: "storage", Run: handlers.CheckStorage},
+	{Name: "scheduler", Run: func(ctx context.Context) error { return scheduler.Status() }},
+}
+
+// CheckResult is the outcome of one dependency check
+type CheckResult struct {
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// Response is the body of both probes
+type Response struct {
+	Status        string                 `json:"status"`
+	UptimeSeconds int64                  `json:"uptime_seconds"`
+	Checks        map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// Probe statuses
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// LivenessHandler reports that the process is running. It checks no
+// dependencies, so a database outage does not get the container restarted.
+func LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, http.StatusOK, Response{
+		Status:        StatusOK,
+		UptimeSeconds: int64(time.Since(startedAt).Seconds()),
+	})
+}
+
+// ReadinessHandler runs every dependency check and reports each result.
+// It responds 503 if any check fails.
+func ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	response := Response{
+		Status:        StatusOK,
+		UptimeSeconds: int64(time.Since(startedAt).Seconds()),
+		Checks:        make(map[string]CheckResult, len(Checks)),
+	}
+
+	for _, check := range Checks {
+		result := runCheck(r.Context(), check)
+		if result.Status != StatusOK {
+			response.Status = StatusFail
+			logger.Warn(r.Context(), "Readiness check failed", "check", check.Name, "error", result.Error)
+		}
+		response.Checks[check.Name] = result
+	}
+
+	status := http.StatusOK
+	if response.Status != StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	writeResponse(w, status, response)
+}
+
+func runCheck(ctx context.Context, check Check) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check.Run(ctx)
+	result := CheckResult{Status: StatusOK, DurationMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func writeResponse(w http.ResponseWriter, status int, response Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// checkDatabase pings the shared pool without reconnecting it
+func checkDatabase(ctx context.Context) error {
+	db := config.CurrentDB()
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	return db.PingContext(ctx)
+}
@@ -0,0 +1,131 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"go-rent/config"
+	"strings"
+)
+
+// schemaColumn is a column that a migration script adds and the code relies on
+type schemaColumn struct {
+	Table     string
+	Column    string
+	Migration string
+}
+
+// RequiredSchema lists, for each migration script the code depends on, one
+// column it creates. Migrations are applied by hand, so readiness fails with
+// the script name when one has not been run. Add an entry with each new
+// migration.
+var RequiredSchema = []schemaColumn{
+	{"user", "fcm_token", "add_fcm_token_column.sql"},
+	{"notification", "is_read", "add_is_read_column.sql"},
+	{"payment", "month", "add_month_to_payment.sql"},
+	{"payment", "electricity_bill", "add_electricity_bill_columns.sql"},
+	{"property", "photo", "add_property_photo_column.sql"},
+	{"floor", "status", "add_status_column.sql"},
+	{"api_token", "token_hash", "create_api_token_table.sql"},
+	{"user", "totp_enabled", "add_two_factor_auth.sql"},
+	{"property", "require_2fa", "add_two_factor_auth.sql"},
+	{"recovery_code", "code_hash", "add_two_factor_auth.sql"},
+	{"notification", "kind", "add_notification_kind_column.sql"},
+	{"payment", "balance_rent", "add_payment_balances.sql"},
+	{"push_outbox", "next_attempt_at", "create_push_outbox_table.sql"},
+	{"user", "notification_channels", "add_notification_channels.sql"},
+	{"push_outbox", "channel", "add_notification_channels.sql"},
+	{"push_outbox", "topic", "add_property_broadcasts.sql"},
+	{"user", "notification_preferences", "add_notification_preferences.sql"},
+	{"notification_digest", "due_at", "add_notification_preferences.sql"},
+	{"device_token", "last_seen_at", "create_device_token_table.sql"},
+	{"push_outbox", "device_id", "create_device_token_table.sql"},
+	{"user_event", "created_at", "create_user_event_table.sql"},
+	{"message", "source_notification_id", "create_message_tables.sql"},
+	{"upload", "storage_key", "create_upload_table.sql"},
+	{"property", "photo_upload_id", "create_upload_table.sql"},
+	{"message_attachment", "upload_id", "create_upload_table.sql"},
+	{"notification", "proof_upload_id", "add_payment_proof.sql"},
+	{"payment", "proof_upload_id", "add_payment_proof.sql"},
+}
+
+// schemaUniqueKey is a unique key that a migration script adds to a table
+// that already existed
+type schemaUniqueKey struct {
+	Table     string
+	Key       string
+	Migration string
+}
+
+// RequiredUniqueKeys lists the unique keys the code relies on to reject
+// duplicates, for migrations that add no column
+var RequiredUniqueKeys = []schemaUniqueKey{
+	{"notification", "uq_notification_proof_upload", "add_unique_payment_proof.sql"},
+}
+
+// checkMigrations reports the migration scripts whose columns or unique
+// keys are missing
+func checkMigrations(ctx context.Context) error {
+	db := config.CurrentDB()
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT table_name, column_name
+		FROM information_schema.columns
+		WHERE table_schema = DATABASE()`)
+	if err != nil {
+		return fmt.Errorf("failed to read schema: %v", err)
+	}
+	defer rows.Close()
+
+	present := make(map[string]bool)
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return fmt.Errorf("failed to read schema: %v", err)
+		}
+		present[strings.ToLower(table+"."+column)] = true
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read schema: %v", err)
+	}
+
+	keys, err := db.QueryContext(ctx, `
+		SELECT DISTINCT table_name, index_name
+		FROM information_schema.statistics
+		WHERE table_schema = DATABASE() AND non_unique = 0`)
+	if err != nil {
+		return fmt.Errorf("failed to read schema: %v", err)
+	}
+	defer keys.Close()
+	for keys.Next() {
+		var table, key string
+		if err := keys.Scan(&table, &key); err != nil {
+			return fmt.Errorf("failed to read schema: %v", err)
+		}
+		present[strings.ToLower(table+"#"+key)] = true
+	}
+	if err := keys.Err(); err != nil {
+		return fmt.Errorf("failed to read schema: %v", err)
+	}
+
+	var missing []string
+	seen := make(map[string]bool)
+	for _, c := range RequiredSchema {
+		if !present[strings.ToLower(c.Table+"."+c.Column)] && !seen[c.Migration] {
+			seen[c.Migration] = true
+			missing = append(missing, c.Migration)
+		}
+	}
+	for _, k := range RequiredUniqueKeys {
+		if !present[strings.ToLower(k.Table+"#"+k.Key)] && !seen[k.Migration] {
+			seen[k.Migration] = true
+			missing = append(missing, k.Migration)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("pending migrations: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+type fieldsKey struct{}
+
+// fields holds the identifiers attached to every log line of one request.
+// Middleware fills them in as the request is authenticated and routed.
+type fields struct {
+	mu         sync.RWMutex
+	requestID  string
+	userID     int64
+	propertyID int64
+	floorID    int64
+}
+
+func (f *fields) attrs() []slog.Attr {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	attrs := []slog.Attr{slog.String("request_id", f.requestID)}
+	if f.userID != 0 {
+		attrs = append(attrs, slog.Int64("user_id", f.userID))
+	}
+	if f.propertyID != 0 {
+		attrs = append(attrs, slog.Int64("property_id", f.propertyID))
+	}
+	if f.floorID != 0 {
+		attrs = append(attrs, slog.Int64("floor_id", f.floorID))
+	}
+	return attrs
+}
+
+func fieldsFromContext(ctx context.Context) *fields {
+	if ctx == nil {
+		return nil
+	}
+	f, _ := ctx.Value(fieldsKey{}).(*fields)
+	return f
+}
+
+// NewRequestContext returns a context that carries the request ID. The user,
+// property and floor IDs can be added later with the Set functions.
+func NewRequestContext(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, fieldsKey{}, &fields{requestID: requestID})
+}
+
+// RequestID returns the request ID stored in the context, or ""
+func RequestID(ctx context.Context) string {
+	f := fieldsFromContext(ctx)
+	if f == nil {
+		return ""
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.requestID
+}
+
+// SetUserID records the authenticated user for the request's log lines
+func SetUserID(ctx context.Context, userID int64) {
+	if f := fieldsFromContext(ctx); f != nil {
+		f.mu.Lock()
+		f.userID = userID
+		f.mu.Unlock()
+	}
+}
+
+// SetPropertyID records the property the request addresses
+func SetPropertyID(ctx context.Context, propertyID int64) {
+	if f := fieldsFromContext(ctx); f != nil {
+		f.mu.Lock()
+		f.propertyID = propertyID
+		f.mu.Unlock()
+	}
+}
+
+// SetFloorID records the floor the request addresses
+func SetFloorID(ctx context.Context, floorID int64) {
+	if f := fieldsFromContext(ctx); f != nil {
+		f.mu.Lock()
+		f.floorID = floorID
+		f.mu.Unlock()
+	}
+}
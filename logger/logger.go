@@ -0,0 +1,105 @@
+// Package logger provides leveled, structured logging for the server.
+//
+// Log lines are written as JSON by default. Lines logged with a request
+// context automatically carry the request ID and the user, property and
+// floor IDs recorded for that request, and secrets and PII are redacted
+// before anything is written.
+package logger
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+var base = slog.New(newHandler(os.Stdout, slog.LevelInfo, "json"))
+
+// Init configures the process-wide logger. level is one of debug, info,
+// warn or error; format is json or text.
+func Init(level, format string, w io.Writer) {
+	base = slog.New(newHandler(w, ParseLevel(level), format))
+	slog.SetDefault(base)
+}
+
+// ParseLevel converts a level name into a slog.Level, defaulting to info
+func ParseLevel(level string) slog.Level {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+func newHandler(w io.Writer, level slog.Level, format string) slog.Handler {
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redactAttr}
+	var h slog.Handler
+	if strings.ToLower(format) == "text" {
+		h = slog.NewTextHandler(w, opts)
+	} else {
+		h = slog.NewJSONHandler(w, opts)
+	}
+	return &contextHandler{Handler: h}
+}
+
+// Debug logs at debug level. args are alternating keys and values.
+func Debug(ctx context.Context, msg string, args ...any) {
+	base.Log(ctx, slog.LevelDebug, msg, args...)
+}
+
+// Info logs at info level. args are alternating keys and values.
+func Info(ctx context.Context, msg string, args ...any) {
+	base.Log(ctx, slog.LevelInfo, msg, args...)
+}
+
+// Warn logs at warn level. args are alternating keys and values.
+func Warn(ctx context.Context, msg string, args ...any) {
+	base.Log(ctx, slog.LevelWarn, msg, args...)
+}
+
+// Error logs at error level. args are alternating keys and values.
+func Error(ctx context.Context, msg string, args ...any) {
+	base.Log(ctx, slog.LevelError, msg, args...)
+}
+
+// Fatal logs at error level and exits the process
+func Fatal(ctx context.Context, msg string, args ...any) {
+	base.Log(ctx, slog.LevelError, msg, args...)
+	os.Exit(1)
+}
+
+// contextHandler adds the request fields stored in the context to every
+// record. Keys the caller already set on the record take precedence.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if f := fieldsFromContext(ctx); f != nil {
+		present := make(map[string]bool, r.NumAttrs())
+		r.Attrs(func(a slog.Attr) bool {
+			present[a.Key] = true
+			return true
+		})
+		for _, a := range f.attrs() {
+			if !present[a.Key] {
+				r.AddAttrs(a)
+			}
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,76 @@
+package logger
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// secretKeys are attribute keys whose values are never written. A key matches
+// when it contains one of these words. Keys ending in "token" ("fcm_token",
+// "challenge_token") are secret too, while IDs such as "token_id" are not.
+var secretKeys = []string{"password", "secret", "authorization", "cookie", "csrf", "private_key"}
+
+// exactSecretKeys are keys too short to match by substring
+var exactSecretKeys = map[string]bool{"code": true, "recovery_code": true, "otp": true, "totp": true}
+
+// piiKeys are attribute keys whose values are masked, keeping a short suffix
+// so a support engineer can still tell records apart
+var piiKeys = []string{"phone", "nid", "email"}
+
+var (
+	jwtPattern      = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`)
+	apiTokenPattern = regexp.MustCompile(`grt_[A-Za-z0-9_-]+`)
+	bearerPattern   = regexp.MustCompile(`(?i)bearer\s+[A-Za-z0-9._~+/=-]+`)
+	phonePattern    = regexp.MustCompile(`\+?880[\s-]?\d{4}[\s-]?\d{6}|\b01\d{9}\b`)
+)
+
+// redactAttr is the slog ReplaceAttr hook. It drops secrets by key, masks
+// PII by key, and scrubs tokens and phone numbers out of any string value,
+// including the message and error texts.
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+
+	if exactSecretKeys[key] || strings.HasSuffix(key, "token") {
+		return slog.String(a.Key, redacted)
+	}
+	for _, s := range secretKeys {
+		if strings.Contains(key, s) {
+			return slog.String(a.Key, redacted)
+		}
+	}
+	for _, p := range piiKeys {
+		if strings.Contains(key, p) {
+			return slog.String(a.Key, mask(a.Value.String()))
+		}
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, scrub(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, scrub(err.Error()))
+		}
+	}
+	return a
+}
+
+// scrub removes tokens and phone numbers embedded in free text
+func scrub(s string) string {
+	s = jwtPattern.ReplaceAllString(s, redacted)
+	s = apiTokenPattern.ReplaceAllString(s, redacted)
+	s = bearerPattern.ReplaceAllString(s, "Bearer "+redacted)
+	s = phonePattern.ReplaceAllStringFunc(s, mask)
+	return s
+}
+
+// mask hides all but the last three characters of a value
+func mask(s string) string {
+	if len(s) <= 3 {
+		return strings.Repeat("*", len(s))
+	}
+	return strings.Repeat("*", len(s)-3) + s[len(s)-3:]
+}
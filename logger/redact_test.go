@@ -0,0 +1,74 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRedactAttr(t *testing.T) {
+	tests := []struct {
+		name string
+		attr slog.Attr
+		want string
+	}{
+		{"password", slog.String("password", "hunter2"), redacted},
+		{"password key in any case", slog.String("New_Password", "hunter2"), redacted},
+		{"secret", slog.String("totp_secret", "JBSWY3DP"), redacted},
+		{"authorization header", slog.String("Authorization", "Bearer abc"), redacted},
+		{"private key", slog.String("private_key", "-----BEGIN"), redacted},
+		{"key ending in token", slog.String("fcm_token", "dGhpcyBpcyBh"), redacted},
+		{"bare token", slog.String("token", "abc"), redacted},
+		{"non-string token", slog.Int("challenge_token", 42), redacted},
+		{"exact code key", slog.String("code", "123456"), redacted},
+		{"recovery code", slog.String("recovery_code", "abcde-fghij"), redacted},
+		{"token id is kept", slog.Int("token_id", 7), "7"},
+		{"code inside a longer key is kept", slog.String("status_code", "200"), "200"},
+		{"phone is masked", slog.String("phone", "+8801712345678"), "***********678"},
+		{"email is masked", slog.String("user_email", "a@b.io"), "***.io"},
+		{"short pii is fully masked", slog.String("nid", "12"), "**"},
+		{"plain value is kept", slog.String("path", "/properties"), "/properties"},
+		{"jwt in text", slog.String("detail", "got eyJhbGciOiJI.eyJzdWIiOjF9.sig_-x here"), "got " + redacted + " here"},
+		{"api token in text", slog.String("detail", "key grt_AbC123_x used"), "key " + redacted + " used"},
+		{"bearer in text", slog.String("detail", "header bearer abc.def"), "header Bearer " + redacted},
+		{"phone in text", slog.String("detail", "call 01712345678 now"), "call ********678 now"},
+		{"phone in an error", slog.Any("err", errors.New("no user +880 1712-345678")), "no user *************678"},
+	}
+	for _, tt := range tests {
+		got := redactAttr(nil, tt.attr)
+		if got.Key != tt.attr.Key {
+			t.Errorf("%s: key %q, want %q", tt.name, got.Key, tt.attr.Key)
+		}
+		if got.Value.String() != tt.want {
+			t.Errorf("%s: value %q, want %q", tt.name, got.Value.String(), tt.want)
+		}
+	}
+}
+
+func TestHandlerRedactsOutput(t *testing.T) {
+	var buf bytes.Buffer
+	log := slog.New(newHandler(&buf, slog.LevelInfo, "json"))
+	log.Log(context.Background(), slog.LevelInfo, "login by 01712345678",
+		"password", "hunter2", "fcm_token", "abc", "user_id", 3)
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("output is not JSON: %v\n%s", err, buf.String())
+	}
+	if line["msg"] != "login by ********678" {
+		t.Errorf("msg = %v", line["msg"])
+	}
+	if line["password"] != redacted || line["fcm_token"] != redacted {
+		t.Errorf("secrets written: password=%v fcm_token=%v", line["password"], line["fcm_token"])
+	}
+	if line["user_id"] != float64(3) {
+		t.Errorf("user_id = %v, want 3", line["user_id"])
+	}
+	if strings.Contains(buf.String(), "hunter2") {
+		t.Errorf("password leaked: %s", buf.String())
+	}
+}
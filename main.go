@@ -1,141 +1,129 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"go-rent/apidocs"
 	"go-rent/config"
 	"go-rent/handlers"
+	"go-rent/logger"
+	"go-rent/metrics"
 	"go-rent/middleware"
 	"go-rent/scheduler"
-	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
+	_ "time/tzdata"
 	"github.com/gorilla/mux"
-	"github.com/robfig/cron/v3"
 )
 
 func main() {
-	// Initialize database connection
-	err := config.InitDB()
-	if err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+	// `go-rent check-openapi` verifies that every route is documented. It
+	// needs no configuration or database, so it can run in CI.
+	if len(os.Args) > 1 && os.Args[1] == "check-openapi" {
+		if err := apidocs.Check(newRouter()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println("openapi.json documents every registered route")
+		return
 	}
-	fmt.Println("Successfully connected to the database!")
-
-	// Start scheduler
-	go scheduler.StartScheduler()
-	c := cron.New()
-	// Run every minute for immediate test
-	c.AddFunc("* * * * *", handlers.SendMonthlyNotifications)
-	c.Start()
-
-	// ✅ Use gorilla/mux router, not net/http ServeMux
-	router := mux.NewRouter()
-
-	// Apply CORS middleware to all routes
-	router.Use(middleware.CORSMiddleware)
-	
-	// Apply rate limiting middleware to all routes
-	router.Use(middleware.RateLimitMiddleware)
-
-	// Public routes (no authentication required)
-	router.HandleFunc("/login", handlers.LoginHandler).Methods("POST")
-	router.HandleFunc("/register", handlers.RegisterHandler).Methods("POST")
-	
-	// Chatbot routes (public for now, can be made protected if needed)
-	router.HandleFunc("/chat", handlers.ChatHandler).Methods("POST", "OPTIONS")
-	router.HandleFunc("/chat/health", handlers.ChatHealthHandler).Methods("GET")
-
-	// Protected routes (authentication required)
-	// Apply auth middleware to all protected routes
-	protectedRouter := router.PathPrefix("/").Subrouter()
-	protectedRouter.Use(middleware.AuthMiddleware)
 
-	// Property routes
-	protectedRouter.HandleFunc("/properties", handlers.GetUserPropertiesHandler).Methods("GET")
-	protectedRouter.HandleFunc("/properties/tenant", handlers.GetUserTenantPropertiesHandler).Methods("GET")
-	protectedRouter.HandleFunc("/property/{id:[0-9]+}", handlers.GetPropertyByIDHandler).Methods("GET")
-	protectedRouter.HandleFunc("/property/{id:[0-9]+}/manager", handlers.CheckUserManagerHandler).Methods("GET")
-	protectedRouter.HandleFunc("/property", handlers.AddPropertyHandler).Methods("POST")
+	// ctx is cancelled on SIGINT or SIGTERM, which starts the shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// Manager-only routes (require manager privileges)
-	managerRouter := protectedRouter.PathPrefix("/property/{id:[0-9]+}").Subrouter()
-	managerRouter.Use(middleware.ManagerMiddleware)
-	
-	managerRouter.HandleFunc("/floor", handlers.GetFloorsHandler).Methods("GET")
-	managerRouter.HandleFunc("/floor", handlers.AddFloorHandler).Methods("POST")
-	managerRouter.HandleFunc("/floor/{floor_id:[0-9]+}", handlers.GetFloorByIDHandler).Methods("GET")
-	managerRouter.HandleFunc("/floor/{floor_id:[0-9]+}", handlers.UpdateFloorHandler).Methods("PUT")
-	managerRouter.HandleFunc("/floor/{floor_id:[0-9]+}/request", handlers.SendTenantRequestHandler).Methods("POST")
-	managerRouter.HandleFunc("/floor/{floor_id:[0-9]+}/tenant", handlers.AddTenantToFloorHandler).Methods("POST")
-	managerRouter.HandleFunc("/floor/{floor_id:[0-9]+}/tenant", handlers.RemoveTenantHandler).Methods("DELETE")
-	managerRouter.HandleFunc("/floor/{floor_id:[0-9]+}/payment", handlers.CreatePaymentHandler).Methods("POST")
-	protectedRouter.HandleFunc("/floor/{floor_id:[0-9]+}/payment-history", handlers.GetPaymentHistoryHandler).Methods("GET")
-	protectedRouter.HandleFunc("/floor/{floor_id:[0-9]+}/payment", handlers.GetPaymentDetailsHandler).Methods("GET")
-	managerRouter.HandleFunc("/floor/{floor_id:[0-9]+}/advance-payment", handlers.CreateAdvancePaymentRequestHandler).Methods("POST")
-
-	// Advance payment check and cancel routes
-	protectedRouter.HandleFunc("/floor/{floor_id:[0-9]+}/advance-payment/check", handlers.CheckPendingAdvancePaymentHandler).Methods("GET")
-	protectedRouter.HandleFunc("/floor/{floor_id:[0-9]+}/advance-payment", handlers.CancelAdvancePaymentHandler).Methods("DELETE")
-	
-	// Advance details route
-	protectedRouter.HandleFunc("/floor/{floor_id:[0-9]+}/advance-details", handlers.GetAdvanceDetailsHandler).Methods("GET")
-
-	// User routes
-	protectedRouter.HandleFunc("/users/phones", handlers.GetUserPhonesHandler).Methods("GET")
-	protectedRouter.HandleFunc("/users/phones/{phone}", handlers.GetUserIDByPhoneHandler).Methods("GET")
-
-	// Notification routes
-	protectedRouter.HandleFunc("/notifications", handlers.GetUserNotificationsHandler).Methods("GET")
-	protectedRouter.HandleFunc("/notifications/mark-read", handlers.MarkNotificationsAsReadHandler).Methods("POST")
-	protectedRouter.HandleFunc("/notifications/delete/{id}", handlers.DeleteNotificationHandler).Methods("DELETE")
-	protectedRouter.HandleFunc("/notifications/action", handlers.HandleTenantRequestAction).Methods("POST")
-	protectedRouter.HandleFunc("/notifications/send-comment", handlers.SendCommentHandler).Methods("POST")
-	protectedRouter.HandleFunc("/notifications/conversation", handlers.GetConversationHistoryHandler).Methods("GET")
-
-	// FCM token routes
-	protectedRouter.HandleFunc("/user/fcm-token", handlers.UpdateFCMTokenHandler).Methods("POST")
-	
-	// Public test endpoints
-	router.HandleFunc("/test/fcm-connection-public", handlers.TestFCMConnectionPublicHandler).Methods("GET")
-	
-	// Test endpoints
-	protectedRouter.HandleFunc("/test/fcm-connection", handlers.TestFCMConnectionHandler).Methods("GET")
-	protectedRouter.HandleFunc("/test/push-notification", handlers.TestPushNotificationHandler).Methods("POST")
-
-	// New payment notification route
-	protectedRouter.HandleFunc("/property/{id:[0-9]+}/floor/{floor_id:[0-9]+}/payment-notification", handlers.SendPaymentNotificationHandler).Methods("POST")
+	// Load and validate configuration before anything else uses it
+	cfg, err := config.Load()
+	if err != nil {
+		logger.Fatal(ctx, "Failed to load configuration", "error", err)
+	}
+	logger.Init(cfg.Log.Level, cfg.Log.Format, os.Stdout)
+	if err := handlers.ConfigureNotifiers(cfg); err != nil {
+		logger.Fatal(ctx, "Failed to configure notifications", "error", err)
+	}
+	logger.Info(ctx, "Notification channels configured", "channels", handlers.Notifiers.Channels())
+	if err := handlers.ConfigureStorage(cfg); err != nil {
+		logger.Fatal(ctx, "Failed to configure file storage", "error", err)
+	}
+	logger.Info(ctx, "File storage configured", "backend", handlers.Files.Backend())
 
-	// Get pending payment notifications for a floor
-	protectedRouter.HandleFunc("/property/{id:[0-9]+}/floor/{floor_id:[0-9]+}/pending-payments", handlers.GetPendingPaymentNotificationsHandler).Methods("GET")
+	// Initialize database connection
+	err = config.InitDB()
+	if err != nil {
+		logger.Fatal(ctx, "Failed to initialize database", "error", err)
+	}
+	logger.Info(ctx, "Connected to the database")
+	metrics.RegisterDBStats(config.CurrentDB)
 
-	// Test endpoint to manually trigger notifications
-	protectedRouter.HandleFunc("/test/notifications", handlers.TestSendNotificationsHandler).Methods("POST")
+	// Start scheduler and cron jobs
+	scheduler.StartScheduler(ctx)
 
+	router := newRouter()
+	if err := apidocs.Check(router); err != nil {
+		logger.Warn(ctx, "API documentation does not match the routes", "error", err)
+	}
 	router.Walk(func(route *mux.Route, r *mux.Router, ancestors []*mux.Route) error {
 		path, _ := route.GetPathTemplate()
 		methods, _ := route.GetMethods()
-		fmt.Printf("Registered route: %s %v\n", path, methods)
+		logger.Debug(ctx, "Registered route", "path", path, "methods", methods)
 		return nil
 	})
-	
-	// Get port from environment variable or use default
-	port := ":8080"
-	if envPort := os.Getenv("PORT"); envPort != "" {
-		port = ":" + envPort
-	} else if envPort := os.Getenv("SERVER_PORT"); envPort != "" {
-		port = ":" + envPort
-	}
 
-	// Create server with timeouts
+	// Create server with timeouts. CORS wraps the router itself so that
+	// preflight requests are answered for every route and subrouter, and
+	// request logging and metrics wrap CORS so every request is counted and
+	// gets an ID and access log. The /v1 prefix is stripped just before
+	// routing.
 	server := &http.Server{
-		Addr:         port,
-		Handler:      router,
+		Addr:         cfg.Server.Addr,
+		Handler:      middleware.RequestLoggingMiddleware(middleware.MetricsMiddleware(middleware.CORSMiddleware(middleware.APIVersionMiddleware(router)))),
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
+	// Event streams never finish by themselves, so end them when shutdown
+	// starts rather than waiting for clients to disconnect
+	server.RegisterOnShutdown(handlers.CloseEventStreams)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		logger.Info(ctx, "Server starting", "addr", cfg.Server.Addr)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		logger.Fatal(ctx, "Server stopped", "error", err)
+	case <-ctx.Done():
+	}
+	stop()
+	shutdown(server, cfg.Server.ShutdownTimeout)
+}
+
+// shutdown drains in-flight requests, waits for running scheduled jobs and
+// closes the database pool, giving up after timeout
+func shutdown(server *http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	logger.Info(ctx, "Shutting down", "timeout", timeout.String())
+
+	// Stop the scheduler while requests drain so no new cron run starts
+	schedulerErr := make(chan error, 1)
+	go func() { schedulerErr <- scheduler.Stop(ctx) }()
+
+	if err := server.Shutdown(ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error(ctx, "HTTP server did not shut down cleanly", "error", err)
+	}
+	if err := <-schedulerErr; err != nil {
+		logger.Error(ctx, "Scheduler did not stop cleanly", "error", err)
+	}
+	if err := config.CloseDB(); err != nil {
+		logger.Error(ctx, "Error closing database", "error", err)
+	}
 
-	fmt.Printf("Server starting on http://192.168.0.230%s\n", port)
-	log.Fatal(server.ListenAndServe())
+	logger.Info(ctx, "Server stopped")
 }
\ No newline at end of file
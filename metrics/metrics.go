@@ -0,0 +1,198 @@
+package metrics
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+)
+
+// HTTP metrics. The route label is the mux path template, such as
+// "/property/{id:[0-9]+}/floor", so IDs do not create new series.
+var (
+	HTTPRequests = NewCounterVec("gorent_http_requests_total",
+		"HTTP requests handled, by route template, method and status code.",
+		"route", "method", "status")
+	HTTPDuration = NewHistogramVec("gorent_http_request_duration_seconds",
+		"HTTP request latency, by route template and method.",
+		DefaultBuckets, "route", "method")
+)
+
+// Push notification metrics. result is "success" or "failure"; reason is
+// "ok" on success and otherwise one of the PushReason constants.
+// NotificationSends counts sends on every channel; PushSends counts the FCM
+// ones alone and predates the other channels.
+var (
+	PushSends = NewCounterVec("gorent_push_sends_total",
+		"FCM push notification sends, by result and failure reason.",
+		"result", "reason")
+	NotificationSends = NewCounterVec("gorent_notification_sends_total",
+		"Notification sends, by channel, result and failure reason.",
+		"channel", "result", "reason")
+)
+
+// Send failure reasons, shared by every channel. no_token means the user
+// has no address on the channel.
+const (
+	PushReasonOK              = "ok"
+	PushReasonNoToken         = "no_token"
+	PushReasonDatabase        = "database"
+	PushReasonAuth            = "auth"
+	PushReasonNetwork         = "network"
+	PushReasonUnregistered    = "unregistered"
+	PushReasonInvalidArgument = "invalid_argument"
+	PushReasonQuota           = "quota"
+	PushReasonUnavailable     = "unavailable"
+	PushReasonNotConfigured   = "not_configured"
+	PushReasonOther           = "other"
+)
+
+// RecordPush counts one push send attempt
+func RecordPush(reason string) {
+	if reason == PushReasonOK {
+		PushSends.Inc("success", reason)
+		return
+	}
+	PushSends.Inc("failure", reason)
+}
+
+// RecordNotification counts one send attempt on channel
+func RecordNotification(channel, reason string) {
+	if reason == PushReasonOK {
+		NotificationSends.Inc(channel, "success", reason)
+		return
+	}
+	NotificationSends.Inc(channel, "failure", reason)
+}
+
+// Push outbox metrics. event is "sent", "retry" (a send failed and will be
+// tried again), "dead" (given up) or "replayed" (an operator requeued it).
+var PushOutboxEvents = NewCounterVec("gorent_push_outbox_events_total",
+	"Push outbox delivery state changes, by event.",
+	"event")
+
+// Push outbox events
+const (
+	PushOutboxSent     = "sent"
+	PushOutboxRetry    = "retry"
+	PushOutboxDead     = "dead"
+	PushOutboxReplayed = "replayed"
+)
+
+// FCM topic membership changes. action is "subscribe" or "unsubscribe" and
+// result is "success" or "failure".
+var TopicChanges = NewCounterVec("gorent_fcm_topic_changes_total",
+	"FCM property topic subscription changes, by action and result.",
+	"action", "result")
+
+// Devices forgotten because FCM rejected their token, by failure reason
+var DeviceTokensPruned = NewCounterVec("gorent_device_tokens_pruned_total",
+	"Device tokens removed after FCM rejected them, by reason.",
+	"reason")
+
+// Event stream metrics. reason is why a stream ended: "client" (it
+// disconnected), "slow" (it fell too far behind and must resume) or
+// "shutdown".
+var (
+	EventStreamsOpen = NewGaugeVec("gorent_event_streams_open",
+		"Event streams currently connected.")
+	EventsStreamed = NewCounterVec("gorent_events_streamed_total",
+		"Events written to event streams, by type.",
+		"type")
+	EventStreamsClosed = NewCounterVec("gorent_event_streams_closed_total",
+		"Event streams ended, by reason.",
+		"reason")
+)
+
+// Event stream end reasons
+const (
+	EventStreamClient   = "client"
+	EventStreamSlow     = "slow"
+	EventStreamShutdown = "shutdown"
+)
+
+// Upload metrics. result is "stored", "too_large", "unsupported" (not an
+// accepted file type, or an image that could not be decoded), "over_quota"
+// (the user's uploads would exceed UPLOAD_USER_QUOTA_BYTES) or "failed"
+// (the store or database failed). purpose is "unknown" for files rejected
+// as too large while they were being received.
+var Uploads = NewCounterVec("gorent_uploads_total",
+	"File uploads, by purpose and result.",
+	"purpose", "result")
+
+// Upload results
+const (
+	UploadStored      = "stored"
+	UploadTooLarge    = "too_large"
+	UploadUnsupported = "unsupported"
+	UploadOverQuota   = "over_quota"
+	UploadFailed      = "failed"
+)
+
+// Scheduler metrics. outcome is "success", "error" or "skipped" (the job
+// ran but had nothing to do, e.g. the cron tick was not the 5th at 9:00).
+var (
+	JobRuns = NewCounterVec("gorent_scheduler_job_runs_total",
+		"Scheduled job runs, by job and outcome.",
+		"job", "outcome")
+	JobDuration = NewHistogramVec("gorent_scheduler_job_duration_seconds",
+		"Scheduled job run time, by job.",
+		[]float64{0.01, 0.1, 0.5, 1, 5, 15, 30, 60, 300}, "job")
+	JobLastRun = NewGaugeVec("gorent_scheduler_job_last_run_timestamp_seconds",
+		"Unix time the job last finished, by job.",
+		"job")
+)
+
+// Job outcomes
+const (
+	JobSuccess = "success"
+	JobError   = "error"
+	JobSkipped = "skipped"
+)
+
+// ObserveJob records one finished run of a scheduled job
+func ObserveJob(job, outcome string, start time.Time) {
+	now := time.Now()
+	JobRuns.Inc(job, outcome)
+	JobDuration.Observe(now.Sub(start).Seconds(), job)
+	JobLastRun.Set(float64(now.Unix()), job)
+}
+
+// ObserveHTTP records one finished HTTP request
+func ObserveHTTP(route, method string, status int, duration time.Duration) {
+	HTTPRequests.Inc(route, method, strconv.Itoa(status))
+	HTTPDuration.Observe(duration.Seconds(), route, method)
+}
+
+// RegisterDBStats exposes the connection pool statistics of the database
+// returned by current, which is read on every scrape so that a reconnect
+// is picked up
+func RegisterDBStats(current func() *sql.DB) {
+	stat := func(f func(sql.DBStats) float64) func() float64 {
+		return func() float64 {
+			db := current()
+			if db == nil {
+				return 0
+			}
+			return f(db.Stats())
+		}
+	}
+
+	NewGaugeFunc("gorent_db_max_open_connections", "Maximum number of open connections to the database.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }))
+	NewGaugeFunc("gorent_db_open_connections", "Established connections, both in use and idle.",
+		stat(func(s sql.DBStats) float64 { return float64(s.OpenConnections) }))
+	NewGaugeFunc("gorent_db_in_use_connections", "Connections currently in use.",
+		stat(func(s sql.DBStats) float64 { return float64(s.InUse) }))
+	NewGaugeFunc("gorent_db_idle_connections", "Idle connections.",
+		stat(func(s sql.DBStats) float64 { return float64(s.Idle) }))
+	NewCounterFunc("gorent_db_wait_count_total", "Connections waited for because the pool was exhausted.",
+		stat(func(s sql.DBStats) float64 { return float64(s.WaitCount) }))
+	NewCounterFunc("gorent_db_wait_duration_seconds_total", "Time spent waiting for a connection.",
+		stat(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }))
+	NewCounterFunc("gorent_db_max_idle_closed_total", "Connections closed due to SetMaxIdleConns.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }))
+	NewCounterFunc("gorent_db_max_idle_time_closed_total", "Connections closed due to SetConnMaxIdleTime.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }))
+	NewCounterFunc("gorent_db_max_lifetime_closed_total", "Connections closed due to SetConnMaxLifetime.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }))
+}
@@ -0,0 +1,390 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// The exposition is checked with a strict parser of the text format
+// (version 0.0.4) rather than prometheus/common/expfmt, which the module
+// does not depend on. It rejects anything Prometheus would: bad names,
+// unknown escapes, samples before their TYPE line, split families, and
+// histograms whose buckets are not cumulative or lack +Inf.
+
+var metricNamePattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+type family struct {
+	help, kind string
+	samples    []sample
+}
+
+type sample struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+// parseExposition parses a scrape into metric families by name
+func parseExposition(text string) (map[string]*family, error) {
+	families := make(map[string]*family)
+	get := func(name string) *family {
+		f, ok := families[name]
+		if !ok {
+			f = &family{}
+			families[name] = f
+		}
+		return f
+	}
+	closed := make(map[string]bool)
+	current := ""
+	enter := func(name string) error {
+		if name == current {
+			return nil
+		}
+		if closed[name] {
+			return fmt.Errorf("family %s is not contiguous", name)
+		}
+		if current != "" {
+			closed[current] = true
+		}
+		current = name
+		return nil
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			fields := strings.SplitN(line, " ", 4)
+			if len(fields) < 3 || (fields[1] != "HELP" && fields[1] != "TYPE") {
+				continue
+			}
+			name := fields[2]
+			if !metricNamePattern.MatchString(name) {
+				return nil, fmt.Errorf("line %d: bad metric name %q", n, name)
+			}
+			if err := enter(name); err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			f := get(name)
+			rest := ""
+			if len(fields) == 4 {
+				rest = fields[3]
+			}
+			if fields[1] == "HELP" {
+				if f.help != "" {
+					return nil, fmt.Errorf("line %d: second HELP for %s", n, name)
+				}
+				help, err := unescape(rest, false)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", n, err)
+				}
+				f.help = help
+				continue
+			}
+			if f.kind != "" || len(f.samples) > 0 {
+				return nil, fmt.Errorf("line %d: TYPE for %s after its samples or a second TYPE", n, name)
+			}
+			switch rest {
+			case "counter", "gauge", "histogram", "summary", "untyped":
+				f.kind = rest
+			default:
+				return nil, fmt.Errorf("line %d: unknown type %q", n, rest)
+			}
+			continue
+		}
+
+		s, err := parseSample(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		name := s.name
+		for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+			base := strings.TrimSuffix(s.name, suffix)
+			if base != s.name && families[base] != nil && families[base].kind == "histogram" {
+				name = base
+			}
+		}
+		f := families[name]
+		if f == nil || f.kind == "" {
+			return nil, fmt.Errorf("line %d: sample %s has no TYPE line", n, s.name)
+		}
+		if err := enter(name); err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		f.samples = append(f.samples, s)
+	}
+	return families, scanner.Err()
+}
+
+// parseSample parses `name{label="value",...} value`
+func parseSample(line string) (sample, error) {
+	s := sample{labels: make(map[string]string)}
+	end := strings.IndexAny(line, "{ ")
+	if end < 0 {
+		return s, fmt.Errorf("no value in %q", line)
+	}
+	s.name = line[:end]
+	if !metricNamePattern.MatchString(s.name) {
+		return s, fmt.Errorf("bad metric name %q", s.name)
+	}
+	rest := line[end:]
+	if rest[0] == '{' {
+		rest = rest[1:]
+		for !strings.HasPrefix(rest, "}") {
+			eq := strings.Index(rest, `="`)
+			if eq < 0 {
+				return s, fmt.Errorf("bad label in %q", line)
+			}
+			label := rest[:eq]
+			if !labelNamePattern.MatchString(label) {
+				return s, fmt.Errorf("bad label name %q", label)
+			}
+			if _, dup := s.labels[label]; dup {
+				return s, fmt.Errorf("duplicate label %q", label)
+			}
+			rest = rest[eq+2:]
+			quote := -1
+			for i := 0; i < len(rest); i++ {
+				if rest[i] == '\\' {
+					i++
+				} else if rest[i] == '"' {
+					quote = i
+					break
+				}
+			}
+			if quote < 0 {
+				return s, fmt.Errorf("unterminated label value in %q", line)
+			}
+			value, err := unescape(rest[:quote], true)
+			if err != nil {
+				return s, err
+			}
+			s.labels[label] = value
+			rest = rest[quote+1:]
+			if strings.HasPrefix(rest, ",") {
+				rest = rest[1:]
+			} else if !strings.HasPrefix(rest, "}") {
+				return s, fmt.Errorf("expected , or } in %q", line)
+			}
+		}
+		rest = rest[1:]
+	}
+	if !strings.HasPrefix(rest, " ") {
+		return s, fmt.Errorf("no space before the value in %q", line)
+	}
+	value, err := strconv.ParseFloat(rest[1:], 64)
+	if err != nil {
+		return s, fmt.Errorf("bad value in %q", line)
+	}
+	s.value = value
+	return s, nil
+}
+
+// unescape resolves \\ and \n, and \" inside label values; any other
+// escape is an error
+func unescape(s string, quoted bool) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\n' || (quoted && c == '"') {
+			return "", fmt.Errorf("unescaped %q in %q", c, s)
+		}
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		if i++; i == len(s) {
+			return "", fmt.Errorf("trailing backslash in %q", s)
+		}
+		switch {
+		case s[i] == '\\':
+			b.WriteByte('\\')
+		case s[i] == 'n':
+			b.WriteByte('\n')
+		case s[i] == '"' && quoted:
+			b.WriteByte('"')
+		default:
+			return "", fmt.Errorf("unknown escape \\%c in %q", s[i], s)
+		}
+	}
+	return b.String(), nil
+}
+
+// find returns the value of the sample with the given name and labels
+func (f *family) find(name string, labels map[string]string) (float64, bool) {
+	for _, s := range f.samples {
+		if s.name != name || len(s.labels) != len(labels) {
+			continue
+		}
+		match := true
+		for k, v := range labels {
+			if s.labels[k] != v {
+				match = false
+			}
+		}
+		if match {
+			return s.value, true
+		}
+	}
+	return 0, false
+}
+
+func scrape(t *testing.T) map[string]*family {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	families, err := parseExposition(rec.Body.String())
+	if err != nil {
+		t.Fatalf("invalid exposition: %v\n%s", err, rec.Body.String())
+	}
+	return families
+}
+
+func TestHandlerExposition(t *testing.T) {
+	odd := "a\\b \"quoted\"\nline"
+	counter := NewCounterVec("gorent_test_events_total", "Test events.\nSecond \\ line.", "kind")
+	counter.Inc(odd)
+	counter.Add(2, "plain")
+
+	gauge := NewGaugeVec("gorent_test_temperature_celsius", "Test gauge.", "room")
+	gauge.Set(-1.5, "lab")
+	NewGaugeFunc("gorent_test_answer", "Test gauge func.", func() float64 { return 42 })
+
+	histogram := NewHistogramVec("gorent_test_duration_seconds", "Test histogram.", []float64{0.1, 1}, "job")
+	observed := []float64{0.05, 0.5, 5}
+	sum := 0.0
+	for _, v := range observed {
+		histogram.Observe(v, "backup")
+		sum += v
+	}
+
+	families := scrape(t)
+
+	c := families["gorent_test_events_total"]
+	if c == nil || c.kind != "counter" || c.help != "Test events.\nSecond \\ line." {
+		t.Fatalf("counter family = %+v", c)
+	}
+	if v, ok := c.find("gorent_test_events_total", map[string]string{"kind": odd}); !ok || v != 1 {
+		t.Errorf("escaped label: %v, %v, want 1", v, ok)
+	}
+	if v, ok := c.find("gorent_test_events_total", map[string]string{"kind": "plain"}); !ok || v != 2 {
+		t.Errorf("plain label: %v, %v, want 2", v, ok)
+	}
+
+	g := families["gorent_test_temperature_celsius"]
+	if g == nil || g.kind != "gauge" {
+		t.Fatalf("gauge family = %+v", g)
+	}
+	if v, ok := g.find("gorent_test_temperature_celsius", map[string]string{"room": "lab"}); !ok || v != -1.5 {
+		t.Errorf("gauge: %v, %v, want -1.5", v, ok)
+	}
+	if f := families["gorent_test_answer"]; f == nil || f.kind != "gauge" || len(f.samples) != 1 || f.samples[0].value != 42 {
+		t.Errorf("gauge func family = %+v", f)
+	}
+
+	h := families["gorent_test_duration_seconds"]
+	if h == nil || h.kind != "histogram" {
+		t.Fatalf("histogram family = %+v", h)
+	}
+	buckets := []struct {
+		le   string
+		want float64
+	}{{"0.1", 1}, {"1", 2}, {"+Inf", 3}}
+	for _, b := range buckets {
+		v, ok := h.find("gorent_test_duration_seconds_bucket", map[string]string{"job": "backup", "le": b.le})
+		if !ok || v != b.want {
+			t.Errorf("bucket le=%s: %v, %v, want %v", b.le, v, ok, b.want)
+		}
+	}
+	if v, ok := h.find("gorent_test_duration_seconds_sum", map[string]string{"job": "backup"}); !ok || v != sum {
+		t.Errorf("sum: %v, %v, want %v", v, ok, sum)
+	}
+	if v, ok := h.find("gorent_test_duration_seconds_count", map[string]string{"job": "backup"}); !ok || v != 3 {
+		t.Errorf("count: %v, %v, want 3", v, ok)
+	}
+}
+
+func TestHistogramsAreCumulative(t *testing.T) {
+	HTTPDuration.Observe(0.2, "/properties", "GET")
+	HTTPDuration.Observe(3, "/properties", "GET")
+
+	for name, f := range scrape(t) {
+		if f.kind != "histogram" {
+			continue
+		}
+		series := make(map[string][]sample)
+		counts := make(map[string]float64)
+		for _, s := range f.samples {
+			key := seriesLabels(s.labels)
+			switch s.name {
+			case name + "_bucket":
+				series[key] = append(series[key], s)
+			case name + "_count":
+				counts[key] = s.value
+			}
+		}
+		for key, buckets := range series {
+			last := buckets[len(buckets)-1]
+			if last.labels["le"] != "+Inf" {
+				t.Errorf("%s{%s}: last bucket le=%q, want +Inf", name, key, last.labels["le"])
+			}
+			if last.value != counts[key] {
+				t.Errorf("%s{%s}: +Inf bucket %v, count %v", name, key, last.value, counts[key])
+			}
+			for i := 1; i < len(buckets); i++ {
+				prevLe, _ := strconv.ParseFloat(buckets[i-1].labels["le"], 64)
+				le, _ := strconv.ParseFloat(buckets[i].labels["le"], 64)
+				if le <= prevLe || buckets[i].value < buckets[i-1].value {
+					t.Errorf("%s{%s}: bucket %d not cumulative", name, key, i)
+				}
+			}
+		}
+	}
+}
+
+// seriesLabels identifies a histogram series by its labels other than le
+func seriesLabels(labels map[string]string) string {
+	var parts []string
+	for k, v := range labels {
+		if k != "le" {
+			parts = append(parts, k+"="+v)
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func TestParseExpositionRejects(t *testing.T) {
+	tests := map[string]string{
+		"sample without TYPE":  "foo 1\n",
+		"bad escape":           "# TYPE foo counter\nfoo{a=\"\\t\"} 1\n",
+		"unterminated label":   "# TYPE foo counter\nfoo{a=\"x} 1\n",
+		"bad value":            "# TYPE foo counter\nfoo one\n",
+		"TYPE after samples":   "# TYPE foo counter\nfoo 1\n# TYPE foo gauge\n",
+		"split family":         "# TYPE foo counter\nfoo 1\n# TYPE bar gauge\nbar 1\nfoo 2\n",
+		"duplicate label":      "# TYPE foo counter\nfoo{a=\"1\",a=\"2\"} 1\n",
+		"unknown type":         "# TYPE foo meter\n",
+		"bad metric name":      "# TYPE 1foo counter\n",
+		"missing value spacer": "# TYPE foo counter\nfoo{a=\"1\"}1\n",
+	}
+	for name, text := range tests {
+		if _, err := parseExposition(text); err == nil {
+			t.Errorf("%s: parsed without error", name)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"go-rent/apierror"
+	"go-rent/config"
+	"go-rent/logger"
+	"net/http"
+	"strings"
+)
+
+// AdminAuthMiddleware requires config.App.AdminToken as a bearer token. The
+// admin routes are operator tools rather than user features, so they do not
+// accept sessions or API tokens, and they are refused outright when no
+// admin token is configured.
+func AdminAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.App.AdminToken == "" {
+			apierror.Write(w, r, http.StatusForbidden, "Admin API is disabled")
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(config.App.AdminToken)) != 1 {
+			logger.Warn(r.Context(), "Invalid admin token")
+			apierror.Write(w, r, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -2,60 +2,70 @@ package middleware
 
 import (
 	"context"
+	"database/sql"
 	
 	"fmt"
+	"go-rent/apierror"
 	"go-rent/config"
+	"go-rent/logger"
 	"go-rent/utils"
 	"net/http"
 	"strconv"
 	"strings"
-	"time"
 )
 
-// AuthMiddleware checks if the user is authenticated via session token
+// AuthMiddleware checks if the user is authenticated via the session cookie,
+// an "Authorization: Bearer" session token, or a personal API token
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Printf("\n=== Auth Middleware Check ===")
-		fmt.Printf("Method: %s\n", r.Method)
-		fmt.Printf("URL: %s\n", r.URL.Path)
-
 		// Skip authentication for login and register endpoints
-		if r.URL.Path == "/login" || r.URL.Path == "/register" {
-			fmt.Println("Skipping auth for login/register endpoint")
+		if r.URL.Path == "/login" || r.URL.Path == "/login/2fa" || r.URL.Path == "/register" {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		// Get session token from cookie
-		cookie, err := r.Cookie("sessiontoken")
-		if err != nil {
-			fmt.Printf("No session token found: %v\n", err)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusUnauthorized)
-			fmt.Fprintf(w, `{"success":false,"message":"Authentication required"}`)
+		token := requestToken(r)
+		if token == "" {
+			logger.Debug(r.Context(), "No session token or bearer token found")
+			apierror.Write(w, r, http.StatusUnauthorized, "Authentication required")
 			return
 		}
 
-		// Validate the session token
-		userID, err := utils.ValidateToken(cookie.Value)
-		if err != nil {
-			fmt.Printf("Invalid session token: %v\n", err)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusUnauthorized)
-			fmt.Fprintf(w, `{"success":false,"message":"Invalid or expired session"}`)
-			return
+		var userID int64
+		var err error
+		if utils.IsAPIToken(token) {
+			var scopes []string
+			userID, scopes, err = lookupAPIToken(r.Context(), token)
+			if err != nil {
+				logger.Warn(r.Context(), "Invalid API token", "error", err)
+				apierror.Write(w, r, http.StatusUnauthorized, "Invalid, expired or revoked API token")
+				return
+			}
+
+			required, ok := requiredScope(r)
+			if !ok || !hasScope(scopes, required) {
+				logger.Warn(r.Context(), "API token lacks scope", "user_id", userID, "required_scope", required)
+				apierror.WriteCode(w, r, http.StatusForbidden, apierror.CodeInsufficientScope, "API token does not grant access to this endpoint")
+				return
+			}
+		} else {
+			// Validate the session token
+			userID, err = utils.ValidateToken(token)
+			if err != nil {
+				logger.Warn(r.Context(), "Invalid session token", "error", err)
+				apierror.Write(w, r, http.StatusUnauthorized, "Invalid or expired session")
+				return
+			}
 		}
 
 		if userID == 0 {
-			fmt.Println("No user ID found in token")
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusUnauthorized)
-			fmt.Fprintf(w, `{"success":false,"message":"Invalid session"}`)
+			logger.Warn(r.Context(), "No user ID found in token")
+			apierror.Write(w, r, http.StatusUnauthorized, "Invalid session")
 			return
 		}
 
-		fmt.Printf("User authenticated: ID=%d\n", userID)
-		
+		logger.SetUserID(r.Context(), userID)
+
 		// Add user ID to request context for handlers to use
 		ctx := r.Context()
 		ctx = context.WithValue(ctx, "userID", userID)
@@ -65,18 +75,98 @@ func AuthMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// requestToken returns the bearer token from the Authorization header, or
+// the session cookie when no bearer token is present
+func requestToken(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	}
+	if cookie, err := r.Cookie("sessiontoken"); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// lookupAPIToken resolves a personal API token to its owner and scopes,
+// and records its use
+func lookupAPIToken(ctx context.Context, token string) (int64, []string, error) {
+	tokenID, userID, scopes, err := findAPIToken(token)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	db, err := config.GetDBConnection()
+	if err != nil {
+		return 0, nil, fmt.Errorf("database connection error: %v", err)
+	}
+	if _, err := db.Exec(`UPDATE api_token SET last_used_at = NOW() WHERE id = ?`, tokenID); err != nil {
+		logger.Error(ctx, "Error updating API token last use", "error", err)
+	}
+
+	return userID, strings.Split(scopes, ","), nil
+}
+
+// findAPIToken returns the ID, owner and scopes of a personal API token
+// that is neither revoked nor expired, or sql.ErrNoRows
+func findAPIToken(token string) (tokenID, userID int64, scopes string, err error) {
+	db, err := config.GetDBConnection()
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("database connection error: %v", err)
+	}
+	err = db.QueryRow(`
+		SELECT id, uid, scopes FROM api_token
+		WHERE token_hash = ? AND revoked_at IS NULL
+		AND (expires_at IS NULL OR expires_at > NOW())`,
+		utils.HashAPIToken(token)).Scan(&tokenID, &userID, &scopes)
+	return tokenID, userID, scopes, err
+}
+
+// requiredScope maps a request to the API token scope it needs. Token
+// management itself is never reachable with an API token.
+func requiredScope(r *http.Request) (string, bool) {
+	path := r.URL.Path
+	var area string
+	switch {
+	case strings.HasPrefix(path, "/user/api-tokens"):
+		return "", false
+	case strings.HasPrefix(path, "/notifications"):
+		area = "notifications"
+	case strings.HasPrefix(path, "/users"):
+		area = "users"
+	case strings.Contains(path, "payment") || strings.Contains(path, "advance"):
+		area = "payments"
+	case strings.HasPrefix(path, "/propert") || strings.HasPrefix(path, "/floor"):
+		area = "properties"
+	default:
+		return "", false
+	}
+
+	if r.Method == http.MethodGet {
+		return area + ":read", true
+	}
+	return area + ":write", true
+}
+
+// hasScope reports whether scopes grants required. Write access implies read.
+func hasScope(scopes []string, required string) bool {
+	area, _, _ := strings.Cut(required, ":")
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		if scope == required || (strings.HasSuffix(required, ":read") && scope == area+":write") {
+			return true
+		}
+	}
+	return false
+}
+
 // ManagerMiddleware checks if the user is a manager of a specific property
 func ManagerMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Printf("\n=== Manager Middleware Check ===")
-		
 		// Get user ID from context (set by AuthMiddleware)
 		userID, ok := r.Context().Value("userID").(int64)
 		if !ok {
-			fmt.Println("No user ID in context")
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusUnauthorized)
-			fmt.Fprintf(w, `{"success":false,"message":"Authentication required"}`)
+			logger.Warn(r.Context(), "No user ID in context")
+			apierror.Write(w, r, http.StatusUnauthorized, "Authentication required")
 			return
 		}
 
@@ -102,99 +192,46 @@ func ManagerMiddleware(next http.Handler) http.Handler {
 		}
 
 		if propertyID == 0 {
-			fmt.Println("No property ID found in URL")
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			fmt.Fprintf(w, `{"success":false,"message":"Invalid property ID"}`)
+			logger.Warn(r.Context(), "No property ID found in URL")
+			apierror.Write(w, r, http.StatusBadRequest, "Invalid property ID")
 			return
 		}
 
 		// Check if user is a manager of this property
 		db, err := config.GetDBConnection()
 		if err != nil {
-			fmt.Printf("Database connection error: %v\n", err)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, `{"success":false,"message":"Database connection error"}`)
+			logger.Error(r.Context(), "Database connection error", "error", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "Database connection error")
 			return
 		}
 
-		var isManager bool
+		var isManager, require2FA, totpEnabled bool
 		err = db.QueryRow(`
-			SELECT EXISTS(
-				SELECT 1 FROM takes_care_of 
-				WHERE uid = ? AND pid = ?
-			)`, userID, propertyID).Scan(&isManager)
+			SELECT TRUE, p.require_2fa, u.totp_enabled
+			FROM takes_care_of t
+			JOIN property p ON p.id = t.pid
+			JOIN user u ON u.id = t.uid
+			WHERE t.uid = ? AND t.pid = ?
+			LIMIT 1`, userID, propertyID).Scan(&isManager, &require2FA, &totpEnabled)
 		
-		if err != nil {
-			fmt.Printf("Error checking manager status: %v\n", err)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, `{"success":false,"message":"Error checking authorization"}`)
+		if err != nil && err != sql.ErrNoRows {
+			logger.Error(r.Context(), "Error checking manager status", "error", err)
+			apierror.Write(w, r, http.StatusInternalServerError, "Error checking authorization")
 			return
 		}
 
 		if !isManager {
-			fmt.Printf("User %d is not a manager of property %d\n", userID, propertyID)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusForbidden)
-			fmt.Fprintf(w, `{"success":false,"message":"Access denied. Manager privileges required."}`)
+			logger.Warn(r.Context(), "User is not a manager of property", "property_id", propertyID)
+			apierror.Write(w, r, http.StatusForbidden, "Access denied. Manager privileges required.")
 			return
 		}
 
-		fmt.Printf("User %d is authorized as manager of property %d\n", userID, propertyID)
-		next.ServeHTTP(w, r)
-	})
-}
-
-// CORSMiddleware handles Cross-Origin Resource Sharing
-func CORSMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Set CORS headers
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Cookie")
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
-
-		// Handle preflight requests
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
+		if require2FA && !totpEnabled {
+			logger.Warn(r.Context(), "Manager must enable two-factor authentication", "property_id", propertyID)
+			apierror.WriteCode(w, r, http.StatusForbidden, apierror.CodeTwoFactorRequired, "This property requires two-factor authentication. Enable it on your account to continue.")
 			return
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
-
-// RateLimitMiddleware implements basic rate limiting
-func RateLimitMiddleware(next http.Handler) http.Handler {
-	// Simple in-memory rate limiter
-	clients := make(map[string]int64)
-	
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		clientIP := r.RemoteAddr
-		now := time.Now().Unix()
-		
-		// Clean old entries (older than 1 minute)
-		for ip, timestamp := range clients {
-			if now-timestamp > 60 {
-				delete(clients, ip)
-			}
-		}
-		
-		// Check if client has made too many requests
-		if lastRequest, exists := clients[clientIP]; exists {
-			if now-lastRequest < 1 { // 1 second between requests
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusTooManyRequests)
-				fmt.Fprintf(w, `{"success":false,"message":"Rate limit exceeded. Please wait before making another request."}`)
-				return
-			}
-		}
-		
-		// Update last request time
-		clients[clientIP] = now
-		
-		next.ServeHTTP(w, r)
-	})
-} 
\ No newline at end of file
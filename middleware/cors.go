@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"go-rent/config"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CORSMiddleware handles Cross-Origin Resource Sharing using the allowlist in
+// config. Allowed origins are echoed back rather than answered with "*",
+// since browsers refuse a wildcard origin on credentialed requests.
+//
+// It must wrap the whole router rather than be registered with router.Use:
+// mux only runs middleware for matched routes, and a preflight OPTIONS
+// request never matches a route registered for GET or POST.
+func CORSMiddleware(next http.Handler) http.Handler {
+	allowedMethods := strings.Join(config.App.CORS.AllowedMethods, ", ")
+	allowedHeaders := strings.Join(config.App.CORS.AllowedHeaders, ", ")
+	exposedHeaders := strings.Join(config.App.CORS.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(config.App.CORS.MaxAge)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		allowed := origin != "" && originAllowed(origin, config.App.CORS.AllowedOrigins)
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			if exposedHeaders != "" {
+				w.Header().Set("Access-Control-Expose-Headers", exposedHeaders)
+			}
+		}
+
+		// Handle preflight requests
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			if !allowed {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", allowedMethods)
+			w.Header().Set("Access-Control-Allow-Headers", allowedHeaders)
+			w.Header().Set("Access-Control-Max-Age", maxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// originAllowed reports whether origin matches an entry of the allowlist.
+// An entry ending in ":*" matches the same scheme and host on any port.
+func originAllowed(origin string, allowlist []string) bool {
+	for _, allowed := range allowlist {
+		if allowed == origin {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(allowed, ":*"); ok {
+			if origin == prefix {
+				return true
+			}
+			if port, ok := strings.CutPrefix(origin, prefix+":"); ok && port != "" {
+				if _, err := strconv.Atoi(port); err == nil {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
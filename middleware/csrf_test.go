@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequiresCSRFCheck(t *testing.T) {
+	tests := []struct {
+		name          string
+		method, path  string
+		sessionCookie bool
+		authorization string
+		want          bool
+	}{
+		{"cookie POST", http.MethodPost, "/property", true, "", true},
+		{"cookie PUT", http.MethodPut, "/property/1/floor/2", true, "", true},
+		{"cookie PATCH", http.MethodPatch, "/user", true, "", true},
+		{"cookie DELETE", http.MethodDelete, "/user/devices/1", true, "", true},
+		{"cookie GET", http.MethodGet, "/properties", true, "", false},
+		{"cookie HEAD", http.MethodHead, "/properties", true, "", false},
+		{"cookie OPTIONS", http.MethodOptions, "/property", true, "", false},
+		{"no cookie", http.MethodPost, "/property", false, "", false},
+		{"bearer token", http.MethodPost, "/property", true, "Bearer abc", false},
+		{"basic auth", http.MethodPost, "/property", true, "Basic abc", true},
+		{"login", http.MethodPost, "/login", true, "", false},
+		{"login 2fa", http.MethodPost, "/login/2fa", true, "", false},
+		{"register", http.MethodPost, "/register", true, "", false},
+		{"logout", http.MethodPost, "/logout", true, "", true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.sessionCookie {
+			r.AddCookie(&http.Cookie{Name: "sessiontoken", Value: "session"})
+		}
+		if tt.authorization != "" {
+			r.Header.Set("Authorization", tt.authorization)
+		}
+		if got := requiresCSRFCheck(r); got != tt.want {
+			t.Errorf("%s: requiresCSRFCheck = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCSRFMiddleware(t *testing.T) {
+	handler := CSRFMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		name           string
+		cookie, header string
+		want           int
+	}{
+		{"matching header", "token", "token", http.StatusOK},
+		{"wrong header", "token", "other", http.StatusForbidden},
+		{"missing header", "token", "", http.StatusForbidden},
+		{"missing cookie", "", "token", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/property", nil)
+		r.AddCookie(&http.Cookie{Name: "sessiontoken", Value: "session"})
+		if tt.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: "csrf_token", Value: tt.cookie})
+		}
+		if tt.header != "" {
+			r.Header.Set(CSRFHeader, tt.header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+}
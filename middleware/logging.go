@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"go-rent/logger"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// RequestIDHeader carries the request ID in both directions. A well-formed
+// incoming value is kept so a request can be traced across services.
+const RequestIDHeader = "X-Request-ID"
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// statusRecorder captures the status code and size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// Flush lets streaming handlers flush through the recorder
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// RequestLoggingMiddleware assigns each request an ID, stores it in the
+// request context for the logger, echoes it in the X-Request-ID response
+// header, and writes one access log line when the request completes.
+// It wraps the whole router so that unmatched routes are logged too.
+func RequestLoggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		requestID := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+
+		ctx := logger.NewRequestContext(r.Context(), requestID)
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		args := []any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"bytes", rec.bytes,
+			"duration_ms", time.Since(start).Milliseconds(),
+			"remote_ip", clientIP(r),
+		}
+		switch {
+		case status >= 500:
+			logger.Error(ctx, "HTTP request", args...)
+		case status >= 400:
+			logger.Warn(ctx, "HTTP request", args...)
+		default:
+			logger.Info(ctx, "HTTP request", args...)
+		}
+	})
+}
+
+// RouteFieldsMiddleware records the property and floor IDs from the matched
+// route's path variables so every log line of the request carries them,
+// and hands the route template to MetricsMiddleware
+func RouteFieldsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		if route := mux.CurrentRoute(r); route != nil {
+			if template, err := route.GetPathTemplate(); err == nil {
+				setRouteTemplate(r, template)
+				// "id" is only a property ID on /property/{id}/... routes
+				if strings.HasPrefix(template, "/property/{id") {
+					if id, err := strconv.ParseInt(vars["id"], 10, 64); err == nil {
+						logger.SetPropertyID(r.Context(), id)
+					}
+				}
+			}
+		}
+		if id, err := strconv.ParseInt(vars["floor_id"], 10, 64); err == nil {
+			logger.SetFloorID(r.Context(), id)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"context"
+	"crypto/subtle"
+	"go-rent/apierror"
+	"go-rent/config"
+	"go-rent/metrics"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type routeTemplateKey struct{}
+
+// unmatchedRoute labels requests that no route matched, so that scans for
+// random paths cannot create unbounded metric series
+const unmatchedRoute = "unmatched"
+
+// MetricsMiddleware records request counts and latency per route template.
+// It wraps the whole router like RequestLoggingMiddleware; the matched
+// template is filled in by RouteFieldsMiddleware once mux has routed the
+// request.
+func MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		template := new(string)
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), routeTemplateKey{}, template)))
+
+		route := *template
+		if route == "" {
+			route = unmatchedRoute
+		}
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		metrics.ObserveHTTP(route, r.Method, status, time.Since(start))
+	})
+}
+
+// setRouteTemplate stores the matched route template for MetricsMiddleware
+func setRouteTemplate(r *http.Request, template string) {
+	if t, ok := r.Context().Value(routeTemplateKey{}).(*string); ok {
+		*t = template
+	}
+}
+
+// MetricsAuthMiddleware requires config.App.MetricsToken as a bearer token when
+// one is configured
+func MetricsAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.App.MetricsToken != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(config.App.MetricsToken)) != 1 {
+				apierror.Write(w, r, http.StatusUnauthorized, "Unauthorized")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
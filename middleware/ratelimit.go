@@ -0,0 +1,220 @@
+package middleware
+
+import (
+	"go-rent/apierror"
+	"go-rent/config"
+	"go-rent/utils"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitRule describes a token bucket: Burst requests may be made at once,
+// and the bucket refills at Rate tokens per second. PerIP rules keep one
+// bucket per client IP whatever credentials the request carries.
+type RateLimitRule struct {
+	Name  string
+	Rate  float64
+	Burst int
+	PerIP bool
+}
+
+// RouteLimit binds a rule to requests matching a method and path prefix.
+// An empty Method matches any method.
+type RouteLimit struct {
+	Method     string
+	PathPrefix string
+	Rule       RateLimitRule
+}
+
+// ConfiguredRouteLimits builds the route table used by RateLimitMiddleware
+// from the limits in config
+func ConfiguredRouteLimits(limits config.RateLimitConfig) []RouteLimit {
+	// Logging in is limited per IP, so that sending a different made-up
+	// token with each guess does not get a fresh bucket
+	auth := configuredRule("auth", limits.Auth)
+	auth.PerIP = true
+	read := configuredRule("read", limits.Read)
+	write := configuredRule("write", limits.Write)
+	return []RouteLimit{
+		{Method: http.MethodPost, PathPrefix: "/login", Rule: auth},
+		{Method: http.MethodPost, PathPrefix: "/register", Rule: auth},
+		{Method: http.MethodGet, PathPrefix: "/", Rule: read},
+		{Method: "", PathPrefix: "/", Rule: write},
+	}
+}
+
+func configuredRule(name string, limit config.RateLimit) RateLimitRule {
+	return RateLimitRule{Name: name, Rate: limit.PerMinute / 60, Burst: limit.Burst}
+}
+
+// bucket holds the token state for one client on one rule
+type bucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// RateLimiter is a concurrency-safe token-bucket limiter keyed by client and rule
+type RateLimiter struct {
+	mu        sync.Mutex
+	routes    []RouteLimit
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewRateLimiter creates a limiter for the given route table. Routes are
+// matched in order and the first match wins.
+func NewRateLimiter(routes []RouteLimit) *RateLimiter {
+	return &RateLimiter{
+		routes:    routes,
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// rateLimitResult is the outcome of taking a token from a bucket
+type rateLimitResult struct {
+	allowed    bool
+	limit      int
+	remaining  int
+	retryAfter time.Duration
+	resetAfter time.Duration
+}
+
+// ruleFor returns the rule for the request, or false when no route matches
+func (rl *RateLimiter) ruleFor(r *http.Request) (RateLimitRule, bool) {
+	for _, route := range rl.routes {
+		if route.Method != "" && route.Method != r.Method {
+			continue
+		}
+		if strings.HasPrefix(r.URL.Path, route.PathPrefix) {
+			return route.Rule, true
+		}
+	}
+	return RateLimitRule{}, false
+}
+
+// take consumes one token from the client's bucket for the rule
+func (rl *RateLimiter) take(key string, rule RateLimitRule) rateLimitResult {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	rl.sweep(now)
+
+	b, exists := rl.buckets[key]
+	if !exists {
+		b = &bucket{tokens: float64(rule.Burst), lastSeen: now}
+		rl.buckets[key] = b
+	} else {
+		elapsed := now.Sub(b.lastSeen).Seconds()
+		b.tokens = math.Min(float64(rule.Burst), b.tokens+elapsed*rule.Rate)
+		b.lastSeen = now
+	}
+
+	result := rateLimitResult{limit: rule.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.allowed = true
+	} else {
+		result.retryAfter = secondsToDuration((1 - b.tokens) / rule.Rate)
+	}
+	result.remaining = int(b.tokens)
+	result.resetAfter = secondsToDuration((float64(rule.Burst) - b.tokens) / rule.Rate)
+	return result
+}
+
+// sweep drops buckets that have been idle long enough to have refilled.
+// Called with rl.mu held.
+func (rl *RateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < time.Minute {
+		return
+	}
+	rl.lastSweep = now
+	for key, b := range rl.buckets {
+		if now.Sub(b.lastSeen) > 10*time.Minute {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+// Middleware enforces the limiter and sets the X-RateLimit-* headers
+func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule, ok := rl.ruleFor(r)
+		if !ok || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := "ip:" + clientIP(r)
+		if !rule.PerIP {
+			key = rateLimitKey(r)
+		}
+		result := rl.take(rule.Name+"|"+key, rule)
+
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(rl.now().Add(result.resetAfter).Unix(), 10))
+
+		if !result.allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(result.retryAfter.Seconds()))))
+			apierror.Write(w, r, http.StatusTooManyRequests, "Rate limit exceeded. Please wait before making another request.")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitKey identifies the caller: the authenticated user when the
+// request carries a valid session, the token itself for an active personal
+// API token, otherwise the client IP without its port. Tokens are checked
+// first, so that made-up ones share the IP's bucket.
+func rateLimitKey(r *http.Request) string {
+	token := requestToken(r)
+	if utils.IsAPIToken(token) {
+		if _, _, _, err := findAPIToken(token); err == nil {
+			return "token:" + utils.HashAPIToken(token)[:16]
+		}
+	} else if token != "" {
+		if userID, err := utils.ValidateToken(token); err == nil && userID != 0 {
+			return "user:" + strconv.FormatInt(userID, 10)
+		}
+	}
+	return "ip:" + clientIP(r)
+}
+
+// clientIP returns the remote address of the request with the port stripped
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// The default limiter is built on first use, after config has been loaded.
+// mux applies middleware on every request, so it must be shared.
+var (
+	defaultRateLimiter     *RateLimiter
+	defaultRateLimiterOnce sync.Once
+)
+
+// RateLimitMiddleware applies the configured per-route token-bucket limits
+func RateLimitMiddleware(next http.Handler) http.Handler {
+	defaultRateLimiterOnce.Do(func() {
+		defaultRateLimiter = NewRateLimiter(ConfiguredRouteLimits(config.App.RateLimit))
+	})
+	return defaultRateLimiter.Middleware(next)
+}
@@ -0,0 +1,205 @@
+package middleware
+
+import (
+	"go-rent/config"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock is a settable time source for the limiter
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// newTestLimiter returns a limiter whose clock only moves when advanced
+func newTestLimiter(routes []RouteLimit) (*RateLimiter, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	rl := NewRateLimiter(routes)
+	rl.now = clock.now
+	rl.lastSweep = clock.t
+	return rl, clock
+}
+
+func TestTakeRefill(t *testing.T) {
+	// 3 requests at once, refilled at one token every two seconds
+	rule := RateLimitRule{Name: "test", Rate: 0.5, Burst: 3}
+
+	type step struct {
+		advance   time.Duration
+		allowed   bool
+		remaining int
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"burst then empty", []step{
+			{0, true, 2}, {0, true, 1}, {0, true, 0}, {0, false, 0},
+		}},
+		{"refills one token per two seconds", []step{
+			{0, true, 2}, {0, true, 1}, {0, true, 0},
+			{time.Second, false, 0},
+			{time.Second, true, 0},
+			{time.Second, false, 0},
+		}},
+		{"refill never exceeds burst", []step{
+			{0, true, 2},
+			{time.Hour, true, 2},
+			{0, true, 1}, {0, true, 0}, {0, false, 0},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl, clock := newTestLimiter(nil)
+			for i, s := range tt.steps {
+				clock.advance(s.advance)
+				got := rl.take("client", rule)
+				if got.allowed != s.allowed || got.remaining != s.remaining {
+					t.Fatalf("step %d: allowed=%v remaining=%d, want allowed=%v remaining=%d",
+						i, got.allowed, got.remaining, s.allowed, s.remaining)
+				}
+				if got.limit != rule.Burst {
+					t.Fatalf("step %d: limit=%d, want %d", i, got.limit, rule.Burst)
+				}
+			}
+		})
+	}
+}
+
+func TestTakeRetryAfter(t *testing.T) {
+	rule := RateLimitRule{Name: "test", Rate: 0.5, Burst: 1}
+	rl, clock := newTestLimiter(nil)
+	rl.take("client", rule)
+	clock.advance(500 * time.Millisecond)
+	got := rl.take("client", rule)
+	if got.allowed {
+		t.Fatal("second request allowed, want limited")
+	}
+	// 0.25 tokens refilled, 0.75 to go at 0.5 per second
+	if got.retryAfter != 1500*time.Millisecond {
+		t.Errorf("retryAfter = %v, want 1.5s", got.retryAfter)
+	}
+}
+
+func TestTakeSeparatesClients(t *testing.T) {
+	rule := RateLimitRule{Name: "test", Rate: 1, Burst: 1}
+	rl, _ := newTestLimiter(nil)
+	if !rl.take("a", rule).allowed || !rl.take("b", rule).allowed {
+		t.Fatal("first request of each client must be allowed")
+	}
+	if rl.take("a", rule).allowed {
+		t.Fatal("client a's second request allowed")
+	}
+}
+
+func TestSweepDropsIdleBuckets(t *testing.T) {
+	rule := RateLimitRule{Name: "test", Rate: 1, Burst: 1}
+	rl, clock := newTestLimiter(nil)
+	rl.take("idle", rule)
+	clock.advance(11 * time.Minute)
+	rl.take("active", rule)
+	if _, ok := rl.buckets["idle"]; ok {
+		t.Error("idle bucket was not swept")
+	}
+	if _, ok := rl.buckets["active"]; !ok {
+		t.Error("active bucket is missing")
+	}
+}
+
+func TestRuleFor(t *testing.T) {
+	auth := RateLimitRule{Name: "auth"}
+	read := RateLimitRule{Name: "read"}
+	write := RateLimitRule{Name: "write"}
+	rl := NewRateLimiter([]RouteLimit{
+		{Method: http.MethodPost, PathPrefix: "/login", Rule: auth},
+		{Method: http.MethodGet, PathPrefix: "/", Rule: read},
+		{Method: "", PathPrefix: "/", Rule: write},
+	})
+	tests := []struct {
+		method, path, want string
+	}{
+		{http.MethodPost, "/login", "auth"},
+		{http.MethodGet, "/login", "read"},
+		{http.MethodGet, "/properties", "read"},
+		{http.MethodPut, "/property/1", "write"},
+		{http.MethodDelete, "/user/devices/1", "write"},
+	}
+	for _, tt := range tests {
+		rule, ok := rl.ruleFor(httptest.NewRequest(tt.method, tt.path, nil))
+		if !ok || rule.Name != tt.want {
+			t.Errorf("%s %s: rule %q (matched %v), want %q", tt.method, tt.path, rule.Name, ok, tt.want)
+		}
+	}
+}
+
+func TestMiddlewareHeaders(t *testing.T) {
+	rule := RateLimitRule{Name: "read", Rate: 1, Burst: 1}
+	rl, _ := newTestLimiter([]RouteLimit{{PathPrefix: "/", Rule: rule}})
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	request := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/properties", nil)
+		req.RemoteAddr = "203.0.113.7:5000"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := request()
+	if first.Code != http.StatusOK || first.Header().Get("X-RateLimit-Limit") != "1" || first.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Fatalf("first request: %d limit=%q remaining=%q", first.Code,
+			first.Header().Get("X-RateLimit-Limit"), first.Header().Get("X-RateLimit-Remaining"))
+	}
+	second := request()
+	if second.Code != http.StatusTooManyRequests || second.Header().Get("Retry-After") != "1" {
+		t.Fatalf("second request: %d Retry-After=%q, want 429 and 1", second.Code, second.Header().Get("Retry-After"))
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := map[string]string{
+		"203.0.113.7:5000":   "203.0.113.7",
+		"[2001:db8::1]:5000": "2001:db8::1",
+		"203.0.113.7":        "203.0.113.7",
+	}
+	for remote, want := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remote
+		if got := clientIP(r); got != want {
+			t.Errorf("clientIP(%q) = %q, want %q", remote, got, want)
+		}
+	}
+}
+
+func TestAuthLimitIgnoresMadeUpTokens(t *testing.T) {
+	limits := config.RateLimitConfig{
+		Auth:  config.RateLimit{PerMinute: 5, Burst: 3},
+		Read:  config.RateLimit{PerMinute: 60, Burst: 10},
+		Write: config.RateLimit{PerMinute: 60, Burst: 10},
+	}
+	rl, _ := newTestLimiter(ConfiguredRouteLimits(limits))
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// Each guess carries a new token that was never issued; none of them
+	// may be looked up or get a bucket of its own
+	for i, path := range []string{"/login", "/login/2fa", "/register", "/login"} {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.RemoteAddr = "203.0.113.7:5000"
+		req.Header.Set("Authorization", "Bearer grt_"+strings.Repeat(strconv.Itoa(i), 40))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		want := http.StatusOK
+		if i >= limits.Auth.Burst {
+			want = http.StatusTooManyRequests
+		}
+		if rec.Code != want {
+			t.Fatalf("request %d to %s: status %d, want %d", i, path, rec.Code, want)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+)
+
+// APIVersionPrefix is the path prefix of the current API version
+const APIVersionPrefix = "/v1"
+
+// APIVersionMiddleware serves every route under /v1 as well as at its
+// original unversioned path, which keeps working while clients move over.
+// It strips the prefix before routing, so the router, the CSRF and rate
+// limit rules and the metrics route templates see the same path either way.
+func APIVersionMiddleware(next http.Handler) http.Handler {
+	versioned := http.StripPrefix(APIVersionPrefix, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, APIVersionPrefix+"/") {
+			versioned.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
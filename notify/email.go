@@ -0,0 +1,151 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"go-rent/metrics"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// smtpTimeout bounds connecting to the SMTP server and sending one message
+const smtpTimeout = 30 * time.Second
+
+// SMTP TLS modes
+const (
+	SMTPStartTLS = "starttls" // plain connection upgraded with STARTTLS, usually port 587
+	SMTPTLS      = "tls"      // TLS from the start, usually port 465
+	SMTPNoTLS    = "none"     // unencrypted, for local relays and test servers only
+)
+
+// Email sends notifications as plain-text email through an SMTP server
+type Email struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     *mail.Address
+	TLS      string
+}
+
+// NewEmail returns an email notifier. from may include a display name, as
+// in "GoRent <no-reply@example.com>".
+func NewEmail(host string, port int, username, password, from, tlsMode string) (*Email, error) {
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender address %q: %v", from, err)
+	}
+	return &Email{Host: host, Port: port, Username: username, Password: password, From: sender, TLS: tlsMode}, nil
+}
+
+func (e *Email) Channel() string { return ChannelEmail }
+
+// Send emails msg to the recipient, with the title as the subject
+func (e *Email) Send(ctx context.Context, to Recipient, msg Message) error {
+	if to.Email == "" {
+		return Errorf(metrics.PushReasonNoToken, "no email address for user %d", to.UserID)
+	}
+	if _, err := mail.ParseAddress(to.Email); err != nil {
+		return Errorf(metrics.PushReasonInvalidArgument, "invalid email address for user %d: %v", to.UserID, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, smtpTimeout)
+	defer cancel()
+
+	addr := net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	var err error
+	if e.TLS == SMTPTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: e.Host}}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return Errorf(metrics.PushReasonNetwork, "failed to connect to SMTP server: %v", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, e.Host)
+	if err != nil {
+		conn.Close()
+		return Errorf(metrics.PushReasonNetwork, "SMTP handshake failed: %v", err)
+	}
+	defer client.Close()
+
+	if e.TLS == SMTPStartTLS {
+		if err := client.StartTLS(&tls.Config{ServerName: e.Host}); err != nil {
+			return Errorf(metrics.PushReasonNetwork, "STARTTLS failed: %v", err)
+		}
+	}
+	if e.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", e.Username, e.Password, e.Host)); err != nil {
+			return Errorf(metrics.PushReasonAuth, "SMTP authentication failed: %v", err)
+		}
+	}
+
+	if err := client.Mail(e.From.Address); err != nil {
+		return smtpError("MAIL FROM", err)
+	}
+	if err := client.Rcpt(to.Email); err != nil {
+		return smtpError("RCPT TO", err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return smtpError("DATA", err)
+	}
+	if _, err := w.Write(e.compose(to, msg)); err != nil {
+		return Errorf(metrics.PushReasonNetwork, "failed to write message: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return smtpError("DATA", err)
+	}
+	return client.Quit()
+}
+
+// compose builds the RFC 5322 message, quoted-printable encoded so that
+// Bengali text and the taka sign survive any relay
+func (e *Email) compose(to Recipient, msg Message) []byte {
+	var buf bytes.Buffer
+	header := func(key, value string) { fmt.Fprintf(&buf, "%s: %s\r\n", key, value) }
+	header("From", e.From.String())
+	header("To", (&mail.Address{Name: to.Name, Address: to.Email}).String())
+	// Long subjects become several encoded words; fold between them so that
+	// no header line passes the 78 characters RFC 5322 recommends
+	header("Subject", strings.ReplaceAll(mime.QEncoding.Encode("utf-8", msg.Title), "?= =?", "?=\r\n =?"))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "quoted-printable")
+	buf.WriteString("\r\n")
+
+	qp := quotedprintable.NewWriter(&buf)
+	qp.Write([]byte(msg.Body + "\r\n"))
+	qp.Close()
+	return buf.Bytes()
+}
+
+// smtpError classifies an SMTP command failure. 5xx replies are permanent
+// (such as an unknown mailbox); anything else may succeed later.
+func smtpError(command string, err error) error {
+	var reply *textproto.Error
+	if errors.As(err, &reply) && reply.Code >= 500 {
+		return Errorf(metrics.PushReasonInvalidArgument, "SMTP %s rejected: %v", command, err)
+	}
+	if errors.As(err, &reply) {
+		return Errorf(metrics.PushReasonUnavailable, "SMTP %s failed: %v", command, err)
+	}
+	return Errorf(metrics.PushReasonNetwork, "SMTP %s failed: %v", command, err)
+}
@@ -0,0 +1,99 @@
+package notify
+
+import (
+	"bytes"
+	"errors"
+	"go-rent/metrics"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmailCompose(t *testing.T) {
+	e, err := NewEmail("smtp.example.com", 587, "", "", "GoRent <no-reply@example.com>", SMTPStartTLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := Recipient{UserID: 7, Name: "রহিম Uddin", Email: "rahim@example.com"}
+	// A long Bengali body with the taka sign and a line over 76 characters,
+	// which quoted-printable must wrap with soft line breaks
+	body := "আপনার ভাড়া ৳১২,০০০ বাকি আছে। Please pay by the 10th; " + strings.Repeat("=", 100)
+	msg := Message{Title: "ভাড়া বাকি: Rent due", Body: body}
+
+	raw := e.compose(to, msg)
+	for _, line := range bytes.Split(raw, []byte("\r\n")) {
+		if len(line) > 78 {
+			t.Errorf("line longer than 78 characters: %q", line)
+		}
+		for _, c := range line {
+			if c >= 0x80 {
+				t.Fatalf("raw message has 8-bit byte in %q", line)
+			}
+		}
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("message does not parse: %v\n%s", err, raw)
+	}
+	from, err := parsed.Header.AddressList("From")
+	if err != nil || len(from) != 1 || from[0].Name != "GoRent" || from[0].Address != "no-reply@example.com" {
+		t.Errorf("From = %v (%v)", from, err)
+	}
+	recipients, err := parsed.Header.AddressList("To")
+	if err != nil || len(recipients) != 1 || recipients[0].Name != to.Name || recipients[0].Address != to.Email {
+		t.Errorf("To = %v (%v)", recipients, err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil || subject != msg.Title {
+		t.Errorf("Subject = %q (%v), want %q", subject, err, msg.Title)
+	}
+	if date, err := parsed.Header.Date(); err != nil || time.Since(date) > time.Minute {
+		t.Errorf("Date = %v (%v)", date, err)
+	}
+	for key, want := range map[string]string{
+		"MIME-Version":              "1.0",
+		"Content-Type":              "text/plain; charset=utf-8",
+		"Content-Transfer-Encoding": "quoted-printable",
+	} {
+		if got := parsed.Header.Get(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+
+	decoded, err := io.ReadAll(quotedprintable.NewReader(parsed.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != body+"\r\n" {
+		t.Errorf("body = %q, want %q", decoded, body+"\r\n")
+	}
+}
+
+func TestNewEmailRejectsBadSender(t *testing.T) {
+	if _, err := NewEmail("smtp.example.com", 587, "", "", "not an address", SMTPStartTLS); err == nil {
+		t.Error("NewEmail accepted an invalid sender")
+	}
+}
+
+func TestSMTPError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		reason string
+	}{
+		{"unknown mailbox", &textproto.Error{Code: 550, Msg: "no such user"}, metrics.PushReasonInvalidArgument},
+		{"mailbox full", &textproto.Error{Code: 452, Msg: "insufficient storage"}, metrics.PushReasonUnavailable},
+		{"connection dropped", errors.New("EOF"), metrics.PushReasonNetwork},
+	}
+	for _, tt := range tests {
+		if got := Reason(smtpError("RCPT TO", tt.err)); got != tt.reason {
+			t.Errorf("%s: reason %q, want %q", tt.name, got, tt.reason)
+		}
+	}
+}
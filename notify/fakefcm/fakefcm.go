@@ -0,0 +1,347 @@
+// Package fakefcm is a stand-in for the FCM HTTP v1 and Instance ID APIs
+// that records messages instead of delivering them. Integration tests serve
+// it with httptest and point notify.FCM at it; cmd/fakefcm runs it for
+// local development.
+//
+// Device tokens starting with "unregistered" are answered as uninstalled
+// apps (404 NOT_FOUND with FCM error code UNREGISTERED) and tokens starting
+// with "invalid" as malformed (400 INVALID_ARGUMENT with a field violation
+// on message.token), so that pruning can be exercised. Errors carry the
+// same details as FCM's. Recorded
+// messages are listed at GET /messages and cleared with DELETE /messages,
+// and topic subscriptions are listed at GET /topics.
+package fakefcm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a message accepted by the server. Recipients lists the tokens
+// subscribed to Topic when a topic message was sent.
+type Message struct {
+	Name       string            `json:"name"`
+	Project    string            `json:"project"`
+	Token      string            `json:"token,omitempty"`
+	Topic      string            `json:"topic,omitempty"`
+	Title      string            `json:"title"`
+	Body       string            `json:"body"`
+	Data       map[string]string `json:"data,omitempty"`
+	Recipients []string          `json:"recipients,omitempty"`
+	ReceivedAt time.Time         `json:"received_at"`
+}
+
+// Server is the fake FCM server. The zero value is not usable; call New.
+type Server struct {
+	mu       sync.Mutex
+	messages []Message
+	topics   map[string]map[string]bool
+	failures []int
+}
+
+// New returns an empty server
+func New() *Server {
+	return &Server{topics: make(map[string]map[string]bool)}
+}
+
+// Messages returns the messages accepted so far, oldest first
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
+
+// Subscribers returns the tokens subscribed to topic, sorted
+func (s *Server) Subscribers(topic string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.subscribers(topic)
+}
+
+// FailNext makes the next count sends and topic changes fail with status,
+// for example 503 to exercise retries
+func (s *Server) FailNext(count, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < count; i++ {
+		s.failures = append(s.failures, status)
+	}
+}
+
+// Reset forgets the recorded messages, subscriptions and pending failures
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = nil
+	s.topics = make(map[string]map[string]bool)
+	s.failures = nil
+}
+
+// ServeHTTP implements the FCM send and Instance ID batch routes, plus the
+// /messages and /topics routes for inspecting what was received
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	path := r.URL.Path
+
+	switch {
+	case path == "/messages" && r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(map[string]interface{}{"messages": nonNil(s.Messages())})
+	case path == "/messages" && r.Method == http.MethodDelete:
+		s.Reset()
+		w.WriteHeader(http.StatusNoContent)
+	case path == "/topics" && r.Method == http.MethodGet:
+		s.mu.Lock()
+		topics := make(map[string][]string, len(s.topics))
+		for topic := range s.topics {
+			topics[topic] = s.subscribers(topic)
+		}
+		s.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"topics": topics})
+	case strings.HasPrefix(path, "/v1/projects/") && strings.HasSuffix(path, "/messages:send") && r.Method == http.MethodPost:
+		project := strings.TrimSuffix(strings.TrimPrefix(path, "/v1/projects/"), "/messages:send")
+		s.send(w, r, project)
+	case (path == "/iid/v1:batchAdd" || path == "/iid/v1:batchRemove") && r.Method == http.MethodPost:
+		s.changeTopic(w, r, path == "/iid/v1:batchAdd")
+	default:
+		writeError(w, http.StatusNotFound, "", fmt.Sprintf("%s %s is not served by the fake FCM server", r.Method, path))
+	}
+}
+
+// send handles messages:send
+func (s *Server) send(w http.ResponseWriter, r *http.Request, project string) {
+	if !authorized(w, r) || s.failed(w) {
+		return
+	}
+
+	var req struct {
+		Message struct {
+			Token        string                       `json:"token"`
+			Topic        string                       `json:"topic"`
+			Notification struct{ Title, Body string } `json:"notification"`
+			Data         map[string]interface{}       `json:"data"`
+		} `json:"message"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "Invalid JSON payload received: "+err.Error())
+		return
+	}
+	m := req.Message
+	if (m.Token == "") == (m.Topic == "") {
+		writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "Exactly one of token or topic must be set",
+			fieldViolation("message", "Exactly one of token or topic must be set"))
+		return
+	}
+	data := make(map[string]string, len(m.Data))
+	for key, value := range m.Data {
+		text, ok := value.(string)
+		if !ok {
+			message := fmt.Sprintf("Invalid value at 'message.data[%s].value', expected a string", key)
+			writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", message, fieldViolation("message.data["+key+"].value", message))
+			return
+		}
+		data[key] = text
+	}
+	if m.Token != "" {
+		if status, code, message := tokenError(m.Token); status != 0 {
+			var details []interface{}
+			if code == "INVALID_ARGUMENT" {
+				details = append(details, fieldViolation("message.token", message))
+			}
+			writeError(w, status, code, message, details...)
+			return
+		}
+	}
+
+	s.mu.Lock()
+	msg := Message{
+		Name:       fmt.Sprintf("projects/%s/messages/%d", project, len(s.messages)+1),
+		Project:    project,
+		Token:      m.Token,
+		Topic:      m.Topic,
+		Title:      m.Notification.Title,
+		Body:       m.Notification.Body,
+		Data:       data,
+		ReceivedAt: time.Now(),
+	}
+	if m.Topic != "" {
+		msg.Recipients = s.subscribers(m.Topic)
+	}
+	s.messages = append(s.messages, msg)
+	s.mu.Unlock()
+
+	json.NewEncoder(w).Encode(map[string]string{"name": msg.Name})
+}
+
+// changeTopic handles batchAdd and batchRemove, answering per token as the
+// Instance ID API does
+func (s *Server) changeTopic(w http.ResponseWriter, r *http.Request, subscribe bool) {
+	if !authorized(w, r) || s.failed(w) {
+		return
+	}
+
+	var req struct {
+		To     string   `json:"to"`
+		Tokens []string `json:"registration_tokens"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "", "Invalid JSON payload received: "+err.Error())
+		return
+	}
+	topic := strings.TrimPrefix(req.To, "/topics/")
+	if topic == req.To || topic == "" || len(req.Tokens) == 0 {
+		writeError(w, http.StatusBadRequest, "", "to must be /topics/<name> and registration_tokens must not be empty")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	results := make([]map[string]string, 0, len(req.Tokens))
+	for _, token := range req.Tokens {
+		result := map[string]string{}
+		switch status, _, _ := tokenError(token); status {
+		case http.StatusNotFound:
+			result["error"] = "NOT_FOUND"
+		case http.StatusBadRequest:
+			result["error"] = "INVALID_ARGUMENT"
+		default:
+			if subscribe {
+				if s.topics[topic] == nil {
+					s.topics[topic] = make(map[string]bool)
+				}
+				s.topics[topic][token] = true
+			} else {
+				delete(s.topics[topic], token)
+			}
+		}
+		results = append(results, result)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+}
+
+// failed answers with the next failure set by FailNext, if there is one
+func (s *Server) failed(w http.ResponseWriter) bool {
+	s.mu.Lock()
+	if len(s.failures) == 0 {
+		s.mu.Unlock()
+		return false
+	}
+	status := s.failures[0]
+	s.failures = s.failures[1:]
+	s.mu.Unlock()
+
+	writeError(w, status, failureErrorCodes[status], "Failure requested with FailNext")
+	return true
+}
+
+// subscribers returns topic's tokens, sorted; s.mu must be held
+func (s *Server) subscribers(topic string) []string {
+	tokens := make([]string, 0, len(s.topics[topic]))
+	for token := range s.topics[topic] {
+		tokens = append(tokens, token)
+	}
+	sort.Strings(tokens)
+	return tokens
+}
+
+// authorized requires a bearer token, as FCM does; its value is not checked
+func authorized(w http.ResponseWriter, r *http.Request) bool {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		writeError(w, http.StatusUnauthorized, "", "Request is missing required authentication credential")
+		return false
+	}
+	return true
+}
+
+// failureErrorCodes are the FCM error codes sent with a FailNext status
+var failureErrorCodes = map[int]string{
+	http.StatusBadRequest:          "INVALID_ARGUMENT",
+	http.StatusTooManyRequests:     "QUOTA_EXCEEDED",
+	http.StatusInternalServerError: "INTERNAL",
+	http.StatusServiceUnavailable:  "UNAVAILABLE",
+}
+
+// tokenError returns the status and FCM error code FCM gives for a device
+// token, or a zero status when the token is accepted
+func tokenError(token string) (status int, code, message string) {
+	switch {
+	case strings.HasPrefix(token, "unregistered"):
+		return http.StatusNotFound, "UNREGISTERED", "Requested entity was not found."
+	case strings.HasPrefix(token, "invalid"):
+		return http.StatusBadRequest, "INVALID_ARGUMENT", "The registration token is not a valid FCM registration token"
+	}
+	return 0, "", ""
+}
+
+// writeError writes an error in Google's API error format, as FCM does:
+// the canonical status name for the HTTP status, and FCM's own error code,
+// when there is one, as an FcmError detail followed by any other details
+func writeError(w http.ResponseWriter, status int, errorCode, message string, details ...interface{}) {
+	if errorCode != "" {
+		details = append([]interface{}{map[string]string{
+			"@type":     "type.googleapis.com/google.firebase.fcm.v1.FcmError",
+			"errorCode": errorCode,
+		}}, details...)
+	}
+	body := map[string]interface{}{
+		"code":    status,
+		"message": message,
+		"status":  canonicalStatus(status),
+	}
+	if len(details) > 0 {
+		body["details"] = details
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": body})
+}
+
+// fieldViolation is a google.rpc.BadRequest detail naming the invalid field
+func fieldViolation(field, description string) map[string]interface{} {
+	return map[string]interface{}{
+		"@type": "type.googleapis.com/google.rpc.BadRequest",
+		"fieldViolations": []map[string]string{
+			{"field": field, "description": description},
+		},
+	}
+}
+
+// canonicalStatus is the google.rpc.Code name Google APIs send with an
+// HTTP status
+func canonicalStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "INVALID_ARGUMENT"
+	case http.StatusUnauthorized:
+		return "UNAUTHENTICATED"
+	case http.StatusForbidden:
+		return "PERMISSION_DENIED"
+	case http.StatusNotFound:
+		return "NOT_FOUND"
+	case http.StatusConflict:
+		return "ABORTED"
+	case http.StatusTooManyRequests:
+		return "RESOURCE_EXHAUSTED"
+	case http.StatusNotImplemented:
+		return "UNIMPLEMENTED"
+	case http.StatusServiceUnavailable:
+		return "UNAVAILABLE"
+	case http.StatusGatewayTimeout:
+		return "DEADLINE_EXCEEDED"
+	}
+	if status >= 500 {
+		return "INTERNAL"
+	}
+	return "UNKNOWN"
+}
+
+// nonNil keeps an empty list from encoding as null
+func nonNil(messages []Message) []Message {
+	if messages == nil {
+		return []Message{}
+	}
+	return messages
+}
@@ -0,0 +1,193 @@
+package fakefcm_test
+
+import (
+	"context"
+	"encoding/json"
+	"go-rent/metrics"
+	"go-rent/notify"
+	"go-rent/notify/fakefcm"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newFCM serves a fake server and returns an FCM notifier pointed at it
+func newFCM(t *testing.T) (*notify.FCM, *fakefcm.Server) {
+	fake := fakefcm.New()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return &notify.FCM{
+		ProjectID:  "demo-gorent",
+		BaseURL:    server.URL,
+		IIDBaseURL: server.URL,
+		Emulator:   true,
+		Client:     server.Client(),
+	}, fake
+}
+
+func TestSendIsRecorded(t *testing.T) {
+	fcm, fake := newFCM(t)
+	msg := notify.Message{Title: "Rent due", Body: "Pay by the 10th", Data: map[string]interface{}{"floor_id": 3}}
+	if err := fcm.Send(context.Background(), notify.Recipient{UserID: 1, FCMToken: "device-1"}, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := fake.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("%d messages recorded, want 1", len(messages))
+	}
+	got := messages[0]
+	if got.Project != "demo-gorent" || got.Token != "device-1" || got.Title != "Rent due" ||
+		got.Body != "Pay by the 10th" || got.Data["floor_id"] != "3" {
+		t.Errorf("recorded %+v", got)
+	}
+}
+
+func TestRejectedTokens(t *testing.T) {
+	fcm, fake := newFCM(t)
+	tests := []struct {
+		token  string
+		reason string
+	}{
+		{"unregistered-device", metrics.PushReasonUnregistered},
+		{"invalid-token", metrics.PushReasonInvalidArgument},
+	}
+	for _, tt := range tests {
+		err := fcm.Send(context.Background(), notify.Recipient{UserID: 1, FCMToken: tt.token}, notify.Message{Title: "t"})
+		if notify.Reason(err) != tt.reason || !notify.BadAddress(err) || !notify.Permanent(err) {
+			t.Errorf("%s: %v (reason %q, bad address %v), want reason %q and a bad address",
+				tt.token, err, notify.Reason(err), notify.BadAddress(err), tt.reason)
+		}
+	}
+	if n := len(fake.Messages()); n != 0 {
+		t.Errorf("%d rejected messages recorded", n)
+	}
+}
+
+func TestFailNext(t *testing.T) {
+	fcm, fake := newFCM(t)
+	to := notify.Recipient{UserID: 1, FCMToken: "device-1"}
+	tests := []struct {
+		status int
+		reason string
+	}{
+		{http.StatusServiceUnavailable, metrics.PushReasonUnavailable},
+		{http.StatusInternalServerError, metrics.PushReasonUnavailable},
+		{http.StatusTooManyRequests, metrics.PushReasonQuota},
+	}
+	for _, tt := range tests {
+		fake.FailNext(1, tt.status)
+		err := fcm.Send(context.Background(), to, notify.Message{Title: "t"})
+		if notify.Reason(err) != tt.reason || notify.Permanent(err) || notify.BadAddress(err) {
+			t.Errorf("status %d: %v (reason %q), want retryable %q", tt.status, err, notify.Reason(err), tt.reason)
+		}
+	}
+
+	if err := fcm.Send(context.Background(), to, notify.Message{Title: "t"}); err != nil {
+		t.Errorf("send after the failures: %v", err)
+	}
+}
+
+func TestUnknownRouteIsNotABadAddress(t *testing.T) {
+	fcm, _ := newFCM(t)
+	fcm.BaseURL += "/wrong"
+	err := fcm.Send(context.Background(), notify.Recipient{UserID: 1, FCMToken: "device-1"}, notify.Message{Title: "t"})
+	if err == nil || notify.BadAddress(err) {
+		t.Errorf("send to an unknown route: %v (bad address %v), want an error that keeps the device", err, notify.BadAddress(err))
+	}
+}
+
+func TestTopics(t *testing.T) {
+	fcm, fake := newFCM(t)
+	ctx := context.Background()
+	if err := fcm.Subscribe(ctx, "property-7", "device-b", "device-a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fcm.SendToTopic(ctx, "property-7", notify.Message{Title: "Water off"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fcm.Unsubscribe(ctx, "property-7", "device-b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fcm.SendToTopic(ctx, "property-7", notify.Message{Title: "Water back"}); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := fake.Messages()
+	if len(messages) != 2 {
+		t.Fatalf("%d messages recorded, want 2", len(messages))
+	}
+	if want := []string{"device-a", "device-b"}; messages[0].Topic != "property-7" || !reflect.DeepEqual(messages[0].Recipients, want) {
+		t.Errorf("first broadcast to %q reached %v, want %v", messages[0].Topic, messages[0].Recipients, want)
+	}
+	if want := []string{"device-a"}; !reflect.DeepEqual(messages[1].Recipients, want) {
+		t.Errorf("second broadcast reached %v, want %v", messages[1].Recipients, want)
+	}
+	if got := fake.Subscribers("property-7"); !reflect.DeepEqual(got, []string{"device-a"}) {
+		t.Errorf("subscribers %v", got)
+	}
+
+	err := fcm.Subscribe(ctx, "property-7", "device-c", "unregistered-device")
+	if notify.Reason(err) != metrics.PushReasonUnregistered {
+		t.Errorf("subscribing an unregistered device: %v, want reason unregistered", err)
+	}
+	if got := fake.Subscribers("property-7"); !reflect.DeepEqual(got, []string{"device-a", "device-c"}) {
+		t.Errorf("subscribers after a partial failure %v", got)
+	}
+}
+
+func TestErrorShape(t *testing.T) {
+	server := httptest.NewServer(fakefcm.New())
+	defer server.Close()
+
+	tests := []struct {
+		token, status, errorCode, field string
+		code                            int
+	}{
+		{"unregistered-device", "NOT_FOUND", "UNREGISTERED", "", http.StatusNotFound},
+		{"invalid-token", "INVALID_ARGUMENT", "INVALID_ARGUMENT", "message.token", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/v1/projects/demo/messages:send",
+			strings.NewReader(`{"message":{"token":"`+tt.token+`","notification":{"title":"t"}}}`))
+		req.Header.Set("Authorization", "Bearer emulator")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct {
+			Error struct {
+				Code    int    `json:"code"`
+				Status  string `json:"status"`
+				Details []struct {
+					Type            string `json:"@type"`
+					ErrorCode       string `json:"errorCode"`
+					FieldViolations []struct {
+						Field string `json:"field"`
+					} `json:"fieldViolations"`
+				} `json:"details"`
+			} `json:"error"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		e := body.Error
+		if resp.StatusCode != tt.code || e.Code != tt.code || e.Status != tt.status {
+			t.Errorf("%s: HTTP %d, code %d, status %q, want %d and %q", tt.token, resp.StatusCode, e.Code, e.Status, tt.code, tt.status)
+		}
+		if len(e.Details) == 0 || e.Details[0].Type != "type.googleapis.com/google.firebase.fcm.v1.FcmError" || e.Details[0].ErrorCode != tt.errorCode {
+			t.Errorf("%s: details %+v, want an FcmError with errorCode %s", tt.token, e.Details, tt.errorCode)
+		}
+		if tt.field != "" {
+			if len(e.Details) < 2 || e.Details[1].Type != "type.googleapis.com/google.rpc.BadRequest" ||
+				len(e.Details[1].FieldViolations) != 1 || e.Details[1].FieldViolations[0].Field != tt.field {
+				t.Errorf("%s: details %+v, want a BadRequest violation on %s", tt.token, e.Details, tt.field)
+			}
+		}
+	}
+}
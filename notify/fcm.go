@@ -0,0 +1,381 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-rent/metrics"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"golang.org/x/oauth2/jwt"
+)
+
+// fcmRequestTimeout bounds one FCM send
+const fcmRequestTimeout = 30 * time.Second
+
+// MaxTopicBatch is the most devices Subscribe and Unsubscribe take at once
+const MaxTopicBatch = 1000
+
+// Google's API roots, used unless an FCM is pointed elsewhere
+const (
+	DefaultFCMBaseURL = "https://fcm.googleapis.com"
+	DefaultIIDBaseURL = "https://iid.googleapis.com"
+)
+
+// emulatorAccessToken is sent to a fake server in place of an OAuth token
+const emulatorAccessToken = "emulator"
+
+// topicPattern is the set of names FCM accepts for a topic
+var topicPattern = regexp.MustCompile(`^[a-zA-Z0-9_.~%-]{1,900}$`)
+
+// FCM sends push notifications through the Firebase Cloud Messaging HTTP v1
+// API, authenticating with a service account key file. The OAuth access
+// token minted from the key is reused until it expires. BaseURL and
+// IIDBaseURL can point at a fake server; Emulator then skips the key.
+type FCM struct {
+	ProjectID       string
+	CredentialsFile string
+	BaseURL         string
+	IIDBaseURL      string
+	Emulator        bool
+	Client          *http.Client
+
+	mu    sync.Mutex
+	token *oauth2.Token
+}
+
+// NewFCM returns an FCM notifier for the project that talks to Google
+func NewFCM(projectID, credentialsFile string) *FCM {
+	return &FCM{
+		ProjectID:       projectID,
+		CredentialsFile: credentialsFile,
+		BaseURL:         DefaultFCMBaseURL,
+		IIDBaseURL:      DefaultIIDBaseURL,
+		Client:          &http.Client{Timeout: fcmRequestTimeout},
+	}
+}
+
+func (f *FCM) Channel() string { return ChannelFCM }
+
+// Send pushes msg to the recipient's registered device
+func (f *FCM) Send(ctx context.Context, to Recipient, msg Message) error {
+	if to.FCMToken == "" {
+		return Errorf(metrics.PushReasonNoToken, "no FCM token found for user %d", to.UserID)
+	}
+	// A rejected token comes back as an Error with BadAddress set; see
+	// fcmFailure
+	return f.send(ctx, "token", to.FCMToken, msg)
+}
+
+// SendToTopic pushes msg once to every device subscribed to topic
+func (f *FCM) SendToTopic(ctx context.Context, topic string, msg Message) error {
+	if !topicPattern.MatchString(topic) {
+		return Errorf(metrics.PushReasonInvalidArgument, "invalid FCM topic %q", topic)
+	}
+	return f.send(ctx, "topic", topic, msg)
+}
+
+// Subscribe adds the devices with the given registration tokens to topic
+func (f *FCM) Subscribe(ctx context.Context, topic string, tokens ...string) error {
+	return f.manageTopic(ctx, "batchAdd", topic, tokens)
+}
+
+// Unsubscribe removes the devices with the given registration tokens from
+// topic
+func (f *FCM) Unsubscribe(ctx context.Context, topic string, tokens ...string) error {
+	return f.manageTopic(ctx, "batchRemove", topic, tokens)
+}
+
+// send posts msg to a single target: a device token or a topic
+func (f *FCM) send(ctx context.Context, targetKey, target string, msg Message) error {
+	// Convert all data values to strings (FCM requirement)
+	stringData := make(map[string]string)
+	for key, value := range msg.Data {
+		stringData[key] = fmt.Sprintf("%v", value)
+	}
+
+	// Create the FCM message payload for HTTP v1 API
+	message := map[string]interface{}{
+		"message": map[string]interface{}{
+			targetKey: target,
+			"notification": map[string]string{
+				"title": msg.Title,
+				"body":  msg.Body,
+			},
+			"data": stringData,
+			"android": map[string]interface{}{
+				"priority": "high",
+			},
+		},
+	}
+
+	url := fmt.Sprintf("%s/v1/projects/%s/messages:send", f.BaseURL, f.ProjectID)
+	_, err := f.post(ctx, url, message, nil)
+	return err
+}
+
+// manageTopic subscribes or unsubscribes devices through the Instance ID
+// API. It fails when any device could not be changed, with the reason of
+// the first failure.
+func (f *FCM) manageTopic(ctx context.Context, action, topic string, tokens []string) error {
+	if !topicPattern.MatchString(topic) {
+		return Errorf(metrics.PushReasonInvalidArgument, "invalid FCM topic %q", topic)
+	}
+	if len(tokens) == 0 {
+		return nil
+	}
+	if len(tokens) > MaxTopicBatch {
+		return Errorf(metrics.PushReasonInvalidArgument, "%d devices is more than the %d allowed per request", len(tokens), MaxTopicBatch)
+	}
+
+	payload := map[string]interface{}{
+		"to":                  "/topics/" + topic,
+		"registration_tokens": tokens,
+	}
+	// The Instance ID API only accepts OAuth tokens when asked to
+	header := http.Header{"access_token_auth": []string{"true"}}
+	body, err := f.post(ctx, f.IIDBaseURL+"/iid/v1:"+action, payload, header)
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		Results []struct {
+			Error string `json:"error"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return Errorf(metrics.PushReasonOther, "invalid Instance ID response: %v", err)
+	}
+	failed, firstError := 0, ""
+	for _, r := range result.Results {
+		if r.Error != "" {
+			if failed == 0 {
+				firstError = r.Error
+			}
+			failed++
+		}
+	}
+	if failed > 0 {
+		return Errorf(topicErrorReason(firstError), "%s failed for %d of %d devices: %s", action, failed, len(tokens), firstError)
+	}
+	return nil
+}
+
+// post sends payload as JSON with an OAuth access token and returns the
+// response body. A non-200 response is an Error with the matching reason.
+func (f *FCM) post(ctx context.Context, url string, payload interface{}, header http.Header) ([]byte, error) {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal message: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	accessToken, err := f.AccessToken(ctx)
+	if err != nil {
+		return nil, Errorf(metrics.PushReasonAuth, "failed to get access token: %v", err)
+	}
+
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return nil, Errorf(metrics.PushReasonNetwork, "failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, Errorf(metrics.PushReasonNetwork, "failed to read response: %v", err)
+	}
+
+	// A token revoked before it expired is dropped so that the retry mints
+	// a new one
+	if resp.StatusCode == http.StatusUnauthorized {
+		f.dropAccessToken(accessToken)
+	}
+	if resp.StatusCode != http.StatusOK {
+		reason, badAddress := fcmFailure(resp.StatusCode, bodyBytes)
+		return nil, &Error{
+			Reason:     reason,
+			Err:        fmt.Errorf("FCM API returned status %d: %s", resp.StatusCode, string(bodyBytes)),
+			BadAddress: badAddress,
+		}
+	}
+	return bodyBytes, nil
+}
+
+// loadCredentials reads and parses the service account key file
+func (f *FCM) loadCredentials() (*jwt.Config, error) {
+	creds, err := os.ReadFile(f.CredentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account key file: %v", err)
+	}
+
+	jwtConfig, err := google.JWTConfigFromJSON(creds, "https://www.googleapis.com/auth/firebase.messaging")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JWT config: %v", err)
+	}
+	return jwtConfig, nil
+}
+
+// CheckCredentials verifies that the service account key can be loaded,
+// without contacting Google
+func (f *FCM) CheckCredentials() error {
+	if f.Emulator {
+		return nil
+	}
+	_, err := f.loadCredentials()
+	return err
+}
+
+// AccessToken returns an OAuth access token for the service account. It is
+// minted on first use and reused until shortly before it expires; the key
+// file is read again each time a new one is minted, so a replaced key is
+// picked up then.
+func (f *FCM) AccessToken(ctx context.Context) (string, error) {
+	if f.Emulator {
+		return emulatorAccessToken, nil
+	}
+
+	// Holding the lock while minting makes concurrent senders wait for one
+	// token rather than each minting their own
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.token.Valid() {
+		return f.token.AccessToken, nil
+	}
+
+	jwtConfig, err := f.loadCredentials()
+	if err != nil {
+		return "", err
+	}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, f.Client)
+	token, err := jwtConfig.TokenSource(ctx).Token()
+	if err != nil {
+		return "", fmt.Errorf("failed to get token: %v", err)
+	}
+	f.token = token
+	return token.AccessToken, nil
+}
+
+// dropAccessToken forgets the cached access token if it is still token
+func (f *FCM) dropAccessToken(token string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.token != nil && f.token.AccessToken == token {
+		f.token = nil
+	}
+}
+
+// fcmErrorBody is Google's API error format. FCM puts its own error code
+// in a details entry of type google.firebase.fcm.v1.FcmError, and names the
+// offending fields of a bad request in a google.rpc.BadRequest entry.
+type fcmErrorBody struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+		Details []struct {
+			Type            string `json:"@type"`
+			ErrorCode       string `json:"errorCode"`
+			FieldViolations []struct {
+				Field       string `json:"field"`
+				Description string `json:"description"`
+			} `json:"fieldViolations"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
+// Detail types in fcmErrorBody
+const (
+	fcmErrorType        = "type.googleapis.com/google.firebase.fcm.v1.FcmError"
+	fcmBadRequestType   = "type.googleapis.com/google.rpc.BadRequest"
+	fcmTokenFieldPrefix = "message.token"
+)
+
+// fcmFailure maps a non-200 FCM response to a failure reason, and reports
+// whether it rejects the device token itself. Only FCM's error code is
+// trusted for that: UNREGISTERED for an uninstalled app, or
+// INVALID_ARGUMENT with a field violation on message.token for a malformed
+// token. The same status codes are used for bad messages, a wrong project
+// or a mistyped URL, none of which may cost the user their device.
+func fcmFailure(status int, body []byte) (reason string, badAddress bool) {
+	var parsed fcmErrorBody
+	json.Unmarshal(body, &parsed)
+
+	errorCode, tokenViolation := "", false
+	for _, detail := range parsed.Error.Details {
+		switch detail.Type {
+		case fcmErrorType:
+			errorCode = detail.ErrorCode
+		case fcmBadRequestType:
+			for _, violation := range detail.FieldViolations {
+				if strings.HasPrefix(violation.Field, fcmTokenFieldPrefix) {
+					tokenViolation = true
+				}
+			}
+		}
+	}
+
+	switch errorCode {
+	case "UNREGISTERED":
+		return metrics.PushReasonUnregistered, true
+	case "INVALID_ARGUMENT":
+		return metrics.PushReasonInvalidArgument, tokenViolation
+	case "SENDER_ID_MISMATCH":
+		return metrics.PushReasonInvalidArgument, false
+	case "QUOTA_EXCEEDED":
+		return metrics.PushReasonQuota, false
+	case "UNAVAILABLE", "INTERNAL":
+		return metrics.PushReasonUnavailable, false
+	case "THIRD_PARTY_AUTH_ERROR":
+		return metrics.PushReasonAuth, false
+	}
+
+	switch {
+	case status == http.StatusBadRequest:
+		return metrics.PushReasonInvalidArgument, false
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return metrics.PushReasonAuth, false
+	case status == http.StatusTooManyRequests:
+		return metrics.PushReasonQuota, false
+	case status >= 500:
+		return metrics.PushReasonUnavailable, false
+	default:
+		return metrics.PushReasonOther, false
+	}
+}
+
+// topicErrorReason maps a per-device Instance ID error to a failure reason
+func topicErrorReason(code string) string {
+	switch code {
+	case "NOT_FOUND":
+		return metrics.PushReasonUnregistered
+	case "INVALID_ARGUMENT", "TOO_MANY_TOPICS":
+		return metrics.PushReasonInvalidArgument
+	case "RESOURCE_EXHAUSTED":
+		return metrics.PushReasonQuota
+	case "INTERNAL":
+		return metrics.PushReasonUnavailable
+	default:
+		return metrics.PushReasonOther
+	}
+}
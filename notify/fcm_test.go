@@ -0,0 +1,52 @@
+package notify
+
+import (
+	"go-rent/metrics"
+	"testing"
+)
+
+func TestFCMFailure(t *testing.T) {
+	const (
+		unregistered = `{"error":{"code":404,"message":"Requested entity was not found.","status":"NOT_FOUND",
+			"details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"UNREGISTERED"}]}}`
+		invalidToken = `{"error":{"code":400,"message":"The registration token is not a valid FCM registration token","status":"INVALID_ARGUMENT",
+			"details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"INVALID_ARGUMENT"},
+			{"@type":"type.googleapis.com/google.rpc.BadRequest","fieldViolations":[{"field":"message.token","description":"Invalid registration token"}]}]}}`
+		invalidMessage = `{"error":{"code":400,"message":"Invalid value at 'message.data[0].value'","status":"INVALID_ARGUMENT",
+			"details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"INVALID_ARGUMENT"},
+			{"@type":"type.googleapis.com/google.rpc.BadRequest","fieldViolations":[{"field":"message.data[0].value","description":"registration token UNREGISTERED"}]}]}}`
+		quota       = `{"error":{"code":429,"status":"RESOURCE_EXHAUSTED","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"QUOTA_EXCEEDED"}]}}`
+		apns        = `{"error":{"code":401,"status":"UNAUTHENTICATED","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"THIRD_PARTY_AUTH_ERROR"}]}}`
+		wrongSender = `{"error":{"code":403,"status":"PERMISSION_DENIED","details":[{"@type":"type.googleapis.com/google.firebase.fcm.v1.FcmError","errorCode":"SENDER_ID_MISMATCH"}]}}`
+		notFound    = `{"error":{"code":404,"message":"Requested entity was not found.","status":"NOT_FOUND"}}`
+	)
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		reason     string
+		badAddress bool
+	}{
+		{"unregistered token", 404, unregistered, metrics.PushReasonUnregistered, true},
+		{"malformed token", 400, invalidToken, metrics.PushReasonInvalidArgument, true},
+		{"bad message", 400, invalidMessage, metrics.PushReasonInvalidArgument, false},
+		{"quota", 429, quota, metrics.PushReasonQuota, false},
+		{"APNs credentials", 401, apns, metrics.PushReasonAuth, false},
+		{"sender mismatch", 403, wrongSender, metrics.PushReasonInvalidArgument, false},
+		// Without FCM's error code a 404 is a wrong project or URL, not a
+		// dead device, whatever the body says
+		{"plain 404", 404, notFound, metrics.PushReasonOther, false},
+		{"404 without a body", 404, "", metrics.PushReasonOther, false},
+		{"UNREGISTERED only in text", 404, `{"error":{"message":"UNREGISTERED registration token"}}`, metrics.PushReasonOther, false},
+		{"HTML error page", 502, "<html>Bad Gateway</html>", metrics.PushReasonUnavailable, false},
+		{"plain 400", 400, `{"error":{"code":400,"status":"INVALID_ARGUMENT"}}`, metrics.PushReasonInvalidArgument, false},
+		{"expired OAuth token", 401, `{"error":{"code":401,"status":"UNAUTHENTICATED"}}`, metrics.PushReasonAuth, false},
+		{"unavailable", 503, `{"error":{"code":503,"status":"UNAVAILABLE"}}`, metrics.PushReasonUnavailable, false},
+	}
+	for _, tt := range tests {
+		reason, badAddress := fcmFailure(tt.status, []byte(tt.body))
+		if reason != tt.reason || badAddress != tt.badAddress {
+			t.Errorf("%s: reason %q, bad address %v, want %q, %v", tt.name, reason, badAddress, tt.reason, tt.badAddress)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package notify
+
+import (
+	"context"
+	"sync"
+)
+
+// Sent is a message recorded by Memory. Topic is set, and To is empty, for
+// messages sent with SendToTopic.
+type Sent struct {
+	To      Recipient
+	Topic   string
+	Message Message
+}
+
+// Memory is a Notifier that records messages instead of sending them, for
+// tests and local development. SetErr makes sends fail.
+type Memory struct {
+	channel string
+
+	mu   sync.Mutex
+	sent []Sent
+	err  error
+}
+
+// NewMemory returns a Memory notifier standing in for channel
+func NewMemory(channel string) *Memory {
+	return &Memory{channel: channel}
+}
+
+func (m *Memory) Channel() string { return m.channel }
+
+// Send records the message, or fails with the error given to SetErr
+func (m *Memory) Send(ctx context.Context, to Recipient, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	m.sent = append(m.sent, Sent{To: to, Message: msg})
+	return nil
+}
+
+// SendToTopic records the message as sent to topic, or fails with the error
+// given to SetErr
+func (m *Memory) SendToTopic(ctx context.Context, topic string, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	m.sent = append(m.sent, Sent{Topic: topic, Message: msg})
+	return nil
+}
+
+// SetErr makes every later send fail with err, until it is set to nil.
+// Wrap err with Errorf to choose its failure reason.
+func (m *Memory) SetErr(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.err = err
+}
+
+// Sent returns the messages recorded so far
+func (m *Memory) Sent() []Sent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Sent(nil), m.sent...)
+}
+
+// Reset forgets the recorded messages
+func (m *Memory) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = nil
+}
@@ -0,0 +1,170 @@
+// Package notify delivers notifications to users over one channel each:
+// FCM push, email, SMS or a webhook. Every channel implements Notifier, and
+// Memory stands in for any of them in tests.
+//
+// Send errors carry a failure reason (see Reason) so that callers can tell
+// permanent failures, such as a missing address or an unregistered device,
+// from ones worth retrying.
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-rent/metrics"
+	"net/http"
+)
+
+// Channels
+const (
+	ChannelFCM     = "fcm"
+	ChannelEmail   = "email"
+	ChannelSMS     = "sms"
+	ChannelWebhook = "webhook"
+)
+
+// Channels lists every channel, for validating user settings
+var Channels = []string{ChannelFCM, ChannelEmail, ChannelSMS, ChannelWebhook}
+
+// Message is what a user is told. Data is extra key/value context; push
+// clients use it to navigate, and webhooks receive it as is.
+type Message struct {
+	Title string
+	Body  string
+	Data  map[string]interface{}
+}
+
+// Recipient holds the user's address on every channel. A Notifier returns
+// a no_token failure when the address it needs is empty.
+type Recipient struct {
+	UserID     int64
+	Name       string
+	FCMToken   string
+	Email      string
+	Phone      string
+	WebhookURL string
+}
+
+// Address returns the recipient's address on channel, or "" if they have none
+func (r Recipient) Address(channel string) string {
+	switch channel {
+	case ChannelFCM:
+		return r.FCMToken
+	case ChannelEmail:
+		return r.Email
+	case ChannelSMS:
+		return r.Phone
+	case ChannelWebhook:
+		return r.WebhookURL
+	}
+	return ""
+}
+
+// Notifier sends a message to one recipient over one channel
+type Notifier interface {
+	// Channel is the channel name, one of Channels
+	Channel() string
+	Send(ctx context.Context, to Recipient, msg Message) error
+}
+
+// TopicSender is a Notifier that can also send one message to every device
+// subscribed to a topic. FCM and Memory implement it.
+type TopicSender interface {
+	Notifier
+	SendToTopic(ctx context.Context, topic string, msg Message) error
+}
+
+// Error is a failed send tagged with the reason reported in metrics, one of
+// the metrics.PushReason constants. BadAddress is set when the provider
+// says the recipient's address itself is unusable, so callers can forget it.
+type Error struct {
+	Reason     string
+	Err        error
+	BadAddress bool
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+func (e *Error) Unwrap() error { return e.Err }
+
+// Errorf returns an Error with the given reason
+func Errorf(reason string, format string, args ...interface{}) error {
+	return &Error{Reason: reason, Err: fmt.Errorf(format, args...)}
+}
+
+// Reason returns the failure reason of a send error
+func Reason(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Reason
+	}
+	return metrics.PushReasonOther
+}
+
+// BadAddress reports whether a send failed because the provider no longer
+// accepts the recipient's address, such as an uninstalled app's FCM token
+func BadAddress(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.BadAddress
+}
+
+// Permanent reports whether retrying a send that failed with err cannot
+// help: the recipient has no address on the channel, the provider no longer
+// accepts it, the message was rejected, or the channel is not configured
+func Permanent(err error) bool {
+	switch Reason(err) {
+	case metrics.PushReasonNoToken, metrics.PushReasonUnregistered,
+		metrics.PushReasonInvalidArgument, metrics.PushReasonNotConfigured:
+		return true
+	}
+	return false
+}
+
+// Registry holds the configured notifier for each channel
+type Registry struct {
+	notifiers map[string]Notifier
+}
+
+// NewRegistry returns a registry of the given notifiers. A later notifier
+// replaces an earlier one for the same channel.
+func NewRegistry(notifiers ...Notifier) *Registry {
+	r := &Registry{notifiers: make(map[string]Notifier)}
+	for _, n := range notifiers {
+		r.notifiers[n.Channel()] = n
+	}
+	return r
+}
+
+// Get returns the notifier for channel, or nil if the channel is not
+// configured
+func (r *Registry) Get(channel string) Notifier {
+	return r.notifiers[channel]
+}
+
+// Channels returns the configured channels in the order of Channels
+func (r *Registry) Channels() []string {
+	var channels []string
+	for _, channel := range Channels {
+		if r.notifiers[channel] != nil {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
+// statusReason maps a non-2xx HTTP response from an SMS or webhook endpoint
+// to a failure reason
+func statusReason(status int) string {
+	switch {
+	case status == http.StatusNotFound || status == http.StatusGone:
+		return metrics.PushReasonUnregistered
+	case status == http.StatusBadRequest || status == http.StatusUnprocessableEntity:
+		return metrics.PushReasonInvalidArgument
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return metrics.PushReasonAuth
+	case status == http.StatusTooManyRequests:
+		return metrics.PushReasonQuota
+	case status >= 500:
+		return metrics.PushReasonUnavailable
+	}
+	return metrics.PushReasonOther
+}
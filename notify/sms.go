@@ -0,0 +1,78 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"go-rent/metrics"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// smsMaxLength keeps a message within a few SMS segments
+const smsMaxLength = 480
+
+// SMS sends text messages through the Twilio Messages API, or any gateway
+// that implements the same request at BaseURL
+type SMS struct {
+	BaseURL    string
+	AccountSID string
+	AuthToken  string
+	From       string
+	Client     *http.Client
+}
+
+// NewSMS returns an SMS notifier. baseURL is normally https://api.twilio.com.
+func NewSMS(baseURL, accountSID, authToken, from string) *SMS {
+	return &SMS{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		AccountSID: accountSID,
+		AuthToken:  authToken,
+		From:       from,
+		Client:     &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+func (s *SMS) Channel() string { return ChannelSMS }
+
+// Send texts "title: body" to the recipient's phone number
+func (s *SMS) Send(ctx context.Context, to Recipient, msg Message) error {
+	if to.Phone == "" {
+		return Errorf(metrics.PushReasonNoToken, "no phone number for user %d", to.UserID)
+	}
+
+	text := msg.Body
+	if msg.Title != "" {
+		text = msg.Title + ": " + msg.Body
+	}
+	if runes := []rune(text); len(runes) > smsMaxLength {
+		text = string(runes[:smsMaxLength-1]) + "…"
+	}
+
+	form := url.Values{}
+	form.Set("To", to.Phone)
+	form.Set("From", s.From)
+	form.Set("Body", text)
+
+	endpoint := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json", s.BaseURL, url.PathEscape(s.AccountSID))
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(s.AccountSID, s.AuthToken)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return Errorf(metrics.PushReasonNetwork, "failed to send SMS: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return Errorf(statusReason(resp.StatusCode), "SMS API returned status %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go-rent/metrics"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Webhook request headers. The signature is the hex HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the signing secret, so receivers can
+// verify the sender and reject replays of old deliveries.
+const (
+	WebhookSignatureHeader = "X-GoRent-Signature"
+	WebhookTimestampHeader = "X-GoRent-Timestamp"
+)
+
+// Webhook posts notifications as JSON to a URL chosen by the user
+type Webhook struct {
+	Secret []byte
+	Client *http.Client
+}
+
+// NewWebhook returns a webhook notifier that signs requests with secret.
+// Unless allowPrivate is set, it refuses to connect to loopback, private
+// and link-local addresses, so that a user's webhook URL cannot be used to
+// reach services inside the server's network.
+func NewWebhook(secret string, allowPrivate bool) *Webhook {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if !allowPrivate {
+		dialer.Control = rejectPrivateAddress
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &Webhook{
+		Secret: []byte(secret),
+		Client: &http.Client{
+			Timeout:   15 * time.Second,
+			Transport: transport,
+			// A redirect would be followed without the URL checks
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+	}
+}
+
+func (wh *Webhook) Channel() string { return ChannelWebhook }
+
+// webhookPayload is the JSON body of a webhook delivery
+type webhookPayload struct {
+	Event  string                 `json:"event"`
+	UserID int64                  `json:"user_id"`
+	Title  string                 `json:"title"`
+	Body   string                 `json:"body"`
+	Data   map[string]interface{} `json:"data,omitempty"`
+	SentAt time.Time              `json:"sent_at"`
+}
+
+// Send posts msg to the recipient's webhook URL
+func (wh *Webhook) Send(ctx context.Context, to Recipient, msg Message) error {
+	if to.WebhookURL == "" {
+		return Errorf(metrics.PushReasonNoToken, "no webhook URL for user %d", to.UserID)
+	}
+	if err := ValidateWebhookURL(to.WebhookURL); err != nil {
+		return Errorf(metrics.PushReasonInvalidArgument, "webhook URL for user %d: %v", to.UserID, err)
+	}
+
+	now := time.Now()
+	body, err := json.Marshal(webhookPayload{
+		Event:  "notification",
+		UserID: to.UserID,
+		Title:  msg.Title,
+		Body:   msg.Body,
+		Data:   msg.Data,
+		SentAt: now.UTC(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook payload: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", to.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return Errorf(metrics.PushReasonInvalidArgument, "failed to create request: %v", err)
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "GoRent-Webhook/1")
+	req.Header.Set(WebhookTimestampHeader, timestamp)
+	req.Header.Set(WebhookSignatureHeader, "sha256="+wh.sign(timestamp, body))
+
+	resp, err := wh.Client.Do(req)
+	if err != nil {
+		return Errorf(metrics.PushReasonNetwork, "webhook request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return Errorf(statusReason(resp.StatusCode), "webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (wh *Webhook) sign(timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, wh.Secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ValidateWebhookURL checks that a user-supplied webhook URL is an absolute
+// https URL without credentials
+func ValidateWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("not a valid URL")
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("must be an https:// URL")
+	}
+	if u.User != nil {
+		return fmt.Errorf("must not contain credentials")
+	}
+	return nil
+}
+
+// rejectPrivateAddress is a net.Dialer Control hook that refuses
+// connections to addresses inside private networks. It runs after DNS
+// resolution, so a public name that resolves to a private address is
+// refused too.
+func rejectPrivateAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return fmt.Errorf("webhook address %s is not publicly routable", host)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"go-rent/apidocs"
+	"go-rent/apierror"
+	"go-rent/handlers"
+	"go-rent/health"
+	"go-rent/metrics"
+	"go-rent/middleware"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// newRouter registers every route. Document new routes in
+// apidocs/openapi.json; `go run . check-openapi` fails until they are.
+func newRouter() *mux.Router {
+	// Use gorilla/mux router, not net/http ServeMux
+	router := mux.NewRouter()
+
+	// Unknown paths and methods get the same JSON error envelope as handlers
+	router.NotFoundHandler = http.HandlerFunc(apierror.NotFoundHandler)
+	router.MethodNotAllowedHandler = http.HandlerFunc(apierror.MethodNotAllowedHandler)
+
+	// Attach property and floor IDs from the route to the request's log lines
+	router.Use(middleware.RouteFieldsMiddleware)
+
+	// Apply rate limiting middleware to all routes
+	router.Use(middleware.RateLimitMiddleware)
+
+	// Require the CSRF token on cookie-authenticated mutations
+	router.Use(middleware.CSRFMiddleware)
+
+	// Public routes (no authentication required)
+	router.HandleFunc("/login", handlers.LoginHandler).Methods("POST")
+	router.HandleFunc("/login/2fa", handlers.LoginTwoFactorHandler).Methods("POST")
+	router.HandleFunc("/register", handlers.RegisterHandler).Methods("POST")
+
+	// Liveness and readiness probes
+	router.HandleFunc("/healthz", health.LivenessHandler).Methods("GET")
+	router.HandleFunc("/readyz", health.ReadinessHandler).Methods("GET")
+
+	// API documentation
+	router.HandleFunc("/openapi.json", apidocs.SpecHandler).Methods("GET")
+	router.HandleFunc("/docs", apidocs.DocsHandler).Methods("GET")
+
+	// Uploaded files in local storage; the signed URL is the credential
+	router.HandleFunc("/files/{key:.+}", handlers.ServeFileHandler).Methods("GET")
+
+	// Prometheus scrape endpoint
+	router.Handle("/metrics", middleware.MetricsAuthMiddleware(metrics.Handler())).Methods("GET")
+
+	// Chatbot status; /chat itself is a protected route
+	router.HandleFunc("/chat/health", handlers.ChatHealthHandler).Methods("GET")
+
+	// Operator routes, authenticated with ADMIN_TOKEN
+	adminRouter := router.PathPrefix("/admin").Subrouter()
+	adminRouter.Use(middleware.AdminAuthMiddleware)
+	adminRouter.HandleFunc("/push-outbox", handlers.GetPushOutboxHandler).Methods("GET")
+	adminRouter.HandleFunc("/push-outbox/replay", handlers.ReplayPushOutboxHandler).Methods("POST")
+	adminRouter.HandleFunc("/push-outbox/{id:[0-9]+}", handlers.GetPushDeliveryHandler).Methods("GET")
+	adminRouter.HandleFunc("/push-outbox/{id:[0-9]+}/replay", handlers.ReplayPushDeliveryHandler).Methods("POST")
+
+	// Protected routes (authentication required)
+	// Apply auth middleware to all protected routes
+	protectedRouter := router.PathPrefix("/").Subrouter()
+	protectedRouter.Use(middleware.AuthMiddleware)
+
+	// Property routes
+	protectedRouter.HandleFunc("/properties", handlers.GetUserPropertiesHandler).Methods("GET")
+	protectedRouter.HandleFunc("/properties/tenant", handlers.GetUserTenantPropertiesHandler).Methods("GET")
+	protectedRouter.HandleFunc("/property/{id:[0-9]+}", handlers.GetPropertyByIDHandler).Methods("GET")
+	protectedRouter.HandleFunc("/property/{id:[0-9]+}/manager", handlers.CheckUserManagerHandler).Methods("GET")
+	protectedRouter.HandleFunc("/property", handlers.AddPropertyHandler).Methods("POST")
+
+	// Manager-only routes (require manager privileges)
+	managerRouter := protectedRouter.PathPrefix("/property/{id:[0-9]+}").Subrouter()
+	managerRouter.Use(middleware.ManagerMiddleware)
+
+	managerRouter.HandleFunc("/photo", handlers.SetPropertyPhotoHandler).Methods("PUT")
+	managerRouter.HandleFunc("/two-factor", handlers.SetPropertyTwoFactorHandler).Methods("PUT")
+	managerRouter.HandleFunc("/broadcast", handlers.BroadcastPropertyHandler).Methods("POST")
+	managerRouter.HandleFunc("/floor", handlers.GetFloorsHandler).Methods("GET")
+	managerRouter.HandleFunc("/floor", handlers.AddFloorHandler).Methods("POST")
+	managerRouter.HandleFunc("/floor/{floor_id:[0-9]+}", handlers.GetFloorByIDHandler).Methods("GET")
+	managerRouter.HandleFunc("/floor/{floor_id:[0-9]+}", handlers.UpdateFloorHandler).Methods("PUT")
+	managerRouter.HandleFunc("/floor/{floor_id:[0-9]+}/request", handlers.SendTenantRequestHandler).Methods("POST")
+	managerRouter.HandleFunc("/floor/{floor_id:[0-9]+}/tenant", handlers.AddTenantToFloorHandler).Methods("POST")
+	managerRouter.HandleFunc("/floor/{floor_id:[0-9]+}/tenant", handlers.RemoveTenantHandler).Methods("DELETE")
+	managerRouter.HandleFunc("/floor/{floor_id:[0-9]+}/payment", handlers.CreatePaymentHandler).Methods("POST")
+	protectedRouter.HandleFunc("/floor/{floor_id:[0-9]+}/payment-history", handlers.GetPaymentHistoryHandler).Methods("GET")
+	protectedRouter.HandleFunc("/floor/{floor_id:[0-9]+}/payment", handlers.GetPaymentDetailsHandler).Methods("GET")
+	managerRouter.HandleFunc("/floor/{floor_id:[0-9]+}/advance-payment", handlers.CreateAdvancePaymentRequestHandler).Methods("POST")
+
+	// Advance payment check and cancel routes
+	protectedRouter.HandleFunc("/floor/{floor_id:[0-9]+}/advance-payment/check", handlers.CheckPendingAdvancePaymentHandler).Methods("GET")
+	protectedRouter.HandleFunc("/floor/{floor_id:[0-9]+}/advance-payment", handlers.CancelAdvancePaymentHandler).Methods("DELETE")
+
+	// Advance details route
+	protectedRouter.HandleFunc("/floor/{floor_id:[0-9]+}/advance-details", handlers.GetAdvanceDetailsHandler).Methods("GET")
+
+	// User routes
+	protectedRouter.HandleFunc("/users/phones", handlers.GetUserPhonesHandler).Methods("GET")
+	protectedRouter.HandleFunc("/users/phones/{phone}", handlers.GetUserIDByPhoneHandler).Methods("GET")
+
+	// Notification routes
+	protectedRouter.HandleFunc("/notifications", handlers.GetUserNotificationsHandler).Methods("GET")
+	protectedRouter.HandleFunc("/notifications/unread-count", handlers.GetUnreadNotificationCountHandler).Methods("GET")
+	protectedRouter.HandleFunc("/notifications/mark-read", handlers.MarkNotificationsAsReadHandler).Methods("POST")
+	protectedRouter.HandleFunc("/notifications/delete/{id}", handlers.DeleteNotificationHandler).Methods("DELETE")
+	protectedRouter.HandleFunc("/notifications/action", handlers.HandleTenantRequestAction).Methods("POST")
+	protectedRouter.HandleFunc("/notifications/send-comment", handlers.SendCommentHandler).Methods("POST")
+	protectedRouter.HandleFunc("/notifications/conversation", handlers.GetConversationHistoryHandler).Methods("GET")
+
+	// Message threads between a floor's tenant and the property's managers;
+	// /notifications/send-comment and /notifications/conversation are the
+	// older comment-based conversations
+	protectedRouter.HandleFunc("/threads", handlers.GetThreadsHandler).Methods("GET")
+	protectedRouter.HandleFunc("/threads", handlers.OpenThreadHandler).Methods("POST")
+	protectedRouter.HandleFunc("/threads/unread-count", handlers.GetUnreadMessageCountHandler).Methods("GET")
+	protectedRouter.HandleFunc("/threads/{id:[0-9]+}/messages", handlers.GetThreadMessagesHandler).Methods("GET")
+	protectedRouter.HandleFunc("/threads/{id:[0-9]+}/messages", handlers.SendMessageHandler).Methods("POST")
+	protectedRouter.HandleFunc("/threads/{id:[0-9]+}/read", handlers.MarkThreadReadHandler).Methods("POST")
+
+	// File uploads for property photos and message attachments
+	protectedRouter.HandleFunc("/uploads", handlers.UploadFileHandler).Methods("POST")
+	protectedRouter.HandleFunc("/uploads/{id:[0-9]+}", handlers.GetUploadHandler).Methods("GET")
+
+	// Tenant-risk chatbot, answering about the caller's own tenants
+	protectedRouter.HandleFunc("/chat", handlers.ChatHandler).Methods("POST")
+
+	// Live notification and conversation events (Server-Sent Events)
+	protectedRouter.HandleFunc("/events", handlers.EventStreamHandler).Methods("GET")
+
+	// Push device routes; /user/fcm-token is the older way to register one
+	protectedRouter.HandleFunc("/user/devices", handlers.RegisterDeviceHandler).Methods("POST")
+	protectedRouter.HandleFunc("/user/devices", handlers.GetDevicesHandler).Methods("GET")
+	protectedRouter.HandleFunc("/user/devices/{id:[0-9]+}", handlers.UnregisterDeviceHandler).Methods("DELETE")
+	protectedRouter.HandleFunc("/user/fcm-token", handlers.UpdateFCMTokenHandler).Methods("POST")
+
+	// Notification channel settings and preferences
+	protectedRouter.HandleFunc("/user/notification-channels", handlers.GetNotificationChannelsHandler).Methods("GET")
+	protectedRouter.HandleFunc("/user/notification-channels", handlers.UpdateNotificationChannelsHandler).Methods("PUT")
+	protectedRouter.HandleFunc("/user/notification-preferences", handlers.GetNotificationPreferencesHandler).Methods("GET")
+	protectedRouter.HandleFunc("/user/notification-preferences", handlers.UpdateNotificationPreferencesHandler).Methods("PUT")
+
+	// Two-factor authentication routes
+	protectedRouter.HandleFunc("/user/2fa", handlers.GetTwoFactorStatusHandler).Methods("GET")
+	protectedRouter.HandleFunc("/user/2fa/setup", handlers.SetupTwoFactorHandler).Methods("POST")
+	protectedRouter.HandleFunc("/user/2fa/enable", handlers.EnableTwoFactorHandler).Methods("POST")
+	protectedRouter.HandleFunc("/user/2fa/disable", handlers.DisableTwoFactorHandler).Methods("POST")
+	protectedRouter.HandleFunc("/user/2fa/recovery-codes", handlers.RegenerateRecoveryCodesHandler).Methods("POST")
+
+	// Personal API token routes
+	protectedRouter.HandleFunc("/user/api-tokens", handlers.CreateAPITokenHandler).Methods("POST")
+	protectedRouter.HandleFunc("/user/api-tokens", handlers.GetAPITokensHandler).Methods("GET")
+	protectedRouter.HandleFunc("/user/api-tokens/{id:[0-9]+}", handlers.RevokeAPITokenHandler).Methods("DELETE")
+
+	// Public test endpoints
+	router.HandleFunc("/test/fcm-connection-public", handlers.TestFCMConnectionPublicHandler).Methods("GET")
+
+	// Test endpoints
+	protectedRouter.HandleFunc("/test/fcm-connection", handlers.TestFCMConnectionHandler).Methods("GET")
+	protectedRouter.HandleFunc("/test/push-notification", handlers.TestPushNotificationHandler).Methods("POST")
+
+	// New payment notification route
+	protectedRouter.HandleFunc("/property/{id:[0-9]+}/floor/{floor_id:[0-9]+}/payment-notification", handlers.SendPaymentNotificationHandler).Methods("POST")
+
+	// Get pending payment notifications for a floor
+	protectedRouter.HandleFunc("/property/{id:[0-9]+}/floor/{floor_id:[0-9]+}/pending-payments", handlers.GetPendingPaymentNotificationsHandler).Methods("GET")
+
+	// Test endpoint to manually trigger notifications
+	protectedRouter.HandleFunc("/test/notifications", handlers.TestSendNotificationsHandler).Methods("POST")
+
+	return router
+}
@@ -0,0 +1,59 @@
+package scheduler
+
+import (
+	"context"
+	"go-rent/config"
+	"go-rent/handlers"
+	"go-rent/logger"
+	"sync/atomic"
+	"time"
+)
+
+// eventPruneInterval is how often events past the retention period are
+// deleted
+const eventPruneInterval = time.Hour
+
+var (
+	eventDispatcherRunning atomic.Bool
+	eventDispatcherDone    chan struct{}
+)
+
+// runEventDispatcher hands newly recorded events to this instance's open
+// event streams until ctx is cancelled. Like the push dispatcher it polls
+// every PollInterval, straight away when an event is recorded here, and
+// without waiting while batches come back full.
+func runEventDispatcher(ctx context.Context, done chan<- struct{}) {
+	eventDispatcherRunning.Store(true)
+	defer close(done)
+	defer eventDispatcherRunning.Store(false)
+
+	poll := time.NewTicker(config.App.Events.PollInterval)
+	defer poll.Stop()
+
+	var lastPrune time.Time
+	for {
+		read, err := handlers.DispatchEvents(ctx)
+		if err != nil && ctx.Err() == nil {
+			logger.Error(ctx, "Event dispatch failed", "error", err)
+		}
+
+		if time.Since(lastPrune) >= eventPruneInterval {
+			if pruned, err := handlers.PruneEvents(ctx); err != nil {
+				logger.Error(ctx, "Event prune failed", "error", err)
+			} else if pruned > 0 {
+				logger.Info(ctx, "Pruned old events", "count", pruned)
+			}
+			lastPrune = time.Now()
+		}
+
+		if err == nil && read == handlers.EventBatchSize && ctx.Err() == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+		case <-handlers.EventDispatcherWakeups():
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package scheduler
+
+import (
+	"context"
+	"go-rent/config"
+	"go-rent/handlers"
+	"go-rent/logger"
+	"sync/atomic"
+	"time"
+)
+
+// pushPruneInterval is how often delivered pushes past the retention period
+// are deleted
+const pushPruneInterval = time.Hour
+
+var (
+	dispatcherRunning atomic.Bool
+	dispatcherDone    chan struct{}
+)
+
+// runPushDispatcher sends queued push notifications until ctx is cancelled.
+// It polls the outbox every PollInterval and straight away when a push is
+// queued, and keeps going without waiting while batches come back full.
+func runPushDispatcher(ctx context.Context, done chan<- struct{}) {
+	dispatcherRunning.Store(true)
+	defer close(done)
+	defer dispatcherRunning.Store(false)
+
+	poll := time.NewTicker(config.App.Outbox.PollInterval)
+	defer poll.Stop()
+
+	var lastPrune time.Time
+	for {
+		claimed, err := handlers.DispatchPushOutbox(ctx)
+		if err != nil {
+			logger.Error(ctx, "Push dispatch failed", "error", err)
+		}
+
+		if time.Since(lastPrune) >= pushPruneInterval {
+			if pruned, err := handlers.PrunePushOutbox(ctx); err != nil {
+				logger.Error(ctx, "Push outbox prune failed", "error", err)
+			} else if pruned > 0 {
+				logger.Info(ctx, "Pruned delivered pushes", "count", pruned)
+			}
+			lastPrune = time.Now()
+		}
+
+		if err == nil && claimed == config.App.Outbox.BatchSize && ctx.Err() == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+		case <-handlers.PushDispatcherWakeups():
+		}
+	}
+}
@@ -1,21 +1,138 @@
 package scheduler
 
 import (
+	"context"
+	"fmt"
+	"go-rent/config"
 	"go-rent/handlers"
+	"sync/atomic"
 	"time"
+
+	"github.com/robfig/cron/v3"
 )
 
-// StartScheduler starts all scheduled tasks
-func StartScheduler() {
+// cronInterval is how often the cron schedule fires. The scheduler is
+// reported as stalled when several intervals pass without a run.
+const cronInterval = time.Minute
+
+// Liveness state read by Status
+var (
+	startedAt   atomic.Int64
+	lastCronRun atomic.Int64
+	loopRunning atomic.Bool
+)
+
+var (
+	cronRunner *cron.Cron
+	loopDone   chan struct{}
+)
+
+// StartScheduler starts all scheduled tasks. They run until ctx is cancelled
+// and Stop is called.
+func StartScheduler(ctx context.Context) {
+	startedAt.Store(time.Now().Unix())
+
 	// Start monthly notification scheduler
-	go scheduleMonthlyNotifications()
+	loopDone = make(chan struct{})
+	go scheduleMonthlyNotifications(ctx, loopDone)
+
+	// Deliver queued push notifications
+	dispatcherDone = make(chan struct{})
+	go runPushDispatcher(ctx, dispatcherDone)
+
+	// Fan recorded events out to this instance's event streams
+	eventDispatcherDone = make(chan struct{})
+	go runEventDispatcher(ctx, eventDispatcherDone)
+
+	cronRunner = cron.New()
+	// Run every minute for immediate test
+	cronRunner.AddFunc("* * * * *", func() {
+		lastCronRun.Store(time.Now().Unix())
+		handlers.SendMonthlyNotifications()
+	})
+	// Send the daily digests that are due
+	cronRunner.AddFunc("* * * * *", handlers.SendNotificationDigests)
+	// Subscribe tenant devices that missed their property topics
+	cronRunner.AddFunc("30 3 * * *", handlers.SyncPropertyTopics)
+	// Delete uploads that nothing refers to
+	cronRunner.AddFunc("15 * * * *", handlers.PruneOrphanUploads)
+	cronRunner.Start()
+}
+
+// Stop stops scheduling new cron runs and waits for running jobs, the
+// monthly loop and the push and event dispatchers to finish. The context
+// passed to StartScheduler must already be cancelled, or the loop keeps
+// sleeping until ctx expires.
+func Stop(ctx context.Context) error {
+	if cronRunner == nil {
+		return nil
+	}
+
+	cronDone := cronRunner.Stop().Done()
+	select {
+	case <-cronDone:
+	case <-ctx.Done():
+		return fmt.Errorf("cron jobs still running: %v", ctx.Err())
+	}
+
+	select {
+	case <-loopDone:
+	case <-ctx.Done():
+		return fmt.Errorf("monthly notification run still in progress: %v", ctx.Err())
+	}
+
+	select {
+	case <-dispatcherDone:
+	case <-ctx.Done():
+		return fmt.Errorf("push dispatcher still sending: %v", ctx.Err())
+	}
+
+	select {
+	case <-eventDispatcherDone:
+	case <-ctx.Done():
+		return fmt.Errorf("event dispatcher still reading: %v", ctx.Err())
+	}
+	return nil
 }
 
-// scheduleMonthlyNotifications schedules the monthly notification task
-func scheduleMonthlyNotifications() {
+// Status returns an error when the scheduler is not started, its monthly
+// loop or one of its dispatchers has exited, or cron has stopped firing
+func Status() error {
+	started := startedAt.Load()
+	if started == 0 {
+		return fmt.Errorf("scheduler not started")
+	}
+	if !loopRunning.Load() {
+		return fmt.Errorf("monthly notification loop is not running")
+	}
+	if !dispatcherRunning.Load() {
+		return fmt.Errorf("push dispatcher is not running")
+	}
+	if !eventDispatcherRunning.Load() {
+		return fmt.Errorf("event dispatcher is not running")
+	}
+
+	last := lastCronRun.Load()
+	if last == 0 {
+		last = started
+	}
+	if since := time.Since(time.Unix(last, 0)); since > 3*cronInterval {
+		return fmt.Errorf("cron has not run for %s", since.Round(time.Second))
+	}
+	return nil
+}
+
+// scheduleMonthlyNotifications schedules the monthly notification task. A
+// run that has started is allowed to finish; cancelling ctx only cuts the
+// sleep before the next one short.
+func scheduleMonthlyNotifications(ctx context.Context, done chan<- struct{}) {
+	loopRunning.Store(true)
+	defer close(done)
+	defer loopRunning.Store(false)
+
 	for {
-		// Get current time in Bangladesh timezone
-		now := time.Now().In(time.FixedZone("BDT", 6*60*60))
+		// Get current time in the configured timezone
+		now := time.Now().In(config.App.Location)
 		
 		// Calculate time until next 5th of the month
 		nextRun := time.Date(now.Year(), now.Month(), 5, 0, 0, 0, 0, now.Location())
@@ -27,10 +144,16 @@ func scheduleMonthlyNotifications() {
 		// Calculate duration until next run
 		duration := nextRun.Sub(now)
 		
-		// Sleep until next run
-		time.Sleep(duration)
+		// Sleep until next run, or until shutdown
+		timer := time.NewTimer(duration)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
 		
 		// Send notifications
 		handlers.SendMonthlyNotifications()
 	}
-} 
\ No newline at end of file
+}
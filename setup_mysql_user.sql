@@ -4,14 +4,15 @@
 -- Create database if it doesn't exist
 CREATE DATABASE IF NOT EXISTS rent;
 
--- Create or update the user 'suma' with the password from config
-CREATE USER IF NOT EXISTS 'suma'@'localhost' IDENTIFIED BY 'tMyc6mApj]wgzHl7';
+-- Create or update the user 'suma'. Replace 'change-me' with the DB_PASSWORD
+-- from your .env file before running.
+CREATE USER IF NOT EXISTS 'suma'@'localhost' IDENTIFIED BY 'change-me';
 
 -- Grant all privileges on the rent database
 GRANT ALL PRIVILEGES ON rent.* TO 'suma'@'localhost';
 
 -- If user already exists, update the password
-ALTER USER 'suma'@'localhost' IDENTIFIED BY 'tMyc6mApj]wgzHl7';
+ALTER USER 'suma'@'localhost' IDENTIFIED BY 'change-me';
 
 -- Flush privileges to apply changes
 FLUSH PRIVILEGES;
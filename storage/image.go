@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"strings"
+
+	// Decoders for the image formats that get thumbnails
+	_ "image/gif"
+	_ "image/png"
+)
+
+// MaxImagePixels bounds the decoded size of an image, so that a small file
+// that decompresses to a huge bitmap cannot exhaust memory
+const MaxImagePixels = 40_000_000
+
+// Image errors
+var (
+	ErrImageTooLarge   = errors.New("storage: image has too many pixels")
+	ErrImageUnreadable = errors.New("storage: image could not be decoded")
+)
+
+// thumbnailQuality is the JPEG quality of thumbnails
+const thumbnailQuality = 80
+
+// thumbnailSamples is how many source pixels are averaged along each side
+// of a thumbnail pixel, at most
+const thumbnailSamples = 4
+
+// HasThumbnail reports whether Thumbnail can decode contentType. WebP needs
+// a decoder the standard library lacks, so WebP images are stored without
+// a thumbnail.
+func HasThumbnail(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// Thumbnail scales the image in data to fit in a size×size square and
+// encodes it as JPEG, flattening transparency onto white. It also returns
+// the image's width and height as displayed, following the EXIF
+// orientation that phone cameras set instead of rotating the pixels.
+func Thumbnail(data []byte, size int) (thumb []byte, width, height int, err error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, 0, ErrImageUnreadable
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, 0, 0, ErrImageUnreadable
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > MaxImagePixels {
+		return nil, 0, 0, ErrImageTooLarge
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, 0, ErrImageUnreadable
+	}
+
+	orientation := jpegOrientation(data)
+	bounds := src.Bounds()
+	width, height = bounds.Dx(), bounds.Dy()
+	if orientation >= 5 {
+		width, height = height, width
+	}
+
+	scale := float64(size) / float64(max(width, height))
+	if scale > 1 {
+		scale = 1
+	}
+	tw, th := max(1, int(float64(width)*scale+0.5)), max(1, int(float64(height)*scale+0.5))
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := y*height/th, (y+1)*height/th
+		for x := 0; x < tw; x++ {
+			x0, x1 := x*width/tw, (x+1)*width/tw
+			dst.SetRGBA(x, y, averageBox(src, orientation, width, height, x0, y0, max(x1, x0+1), max(y1, y0+1)))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to encode thumbnail: %v", err)
+	}
+	return buf.Bytes(), width, height, nil
+}
+
+// averageBox averages up to thumbnailSamples² pixels spread over the box
+// [x0,x1)×[y0,y1) of the displayed image, which is width×height after
+// orientation, over a white background
+func averageBox(src image.Image, orientation, width, height, x0, y0, x1, y1 int) color.RGBA {
+	stepX, stepY := max(1, (x1-x0)/thumbnailSamples), max(1, (y1-y0)/thumbnailSamples)
+	var r, g, b, n uint64
+	for y := y0 + stepY/2; y < y1; y += stepY {
+		for x := x0 + stepX/2; x < x1; x += stepX {
+			sx, sy := orientedSource(orientation, width, height, x, y)
+			pr, pg, pb, pa := src.At(src.Bounds().Min.X+sx, src.Bounds().Min.Y+sy).RGBA()
+			white := 0xffff - pa
+			r, g, b = r+uint64(pr+white), g+uint64(pg+white), b+uint64(pb+white)
+			n++
+		}
+	}
+	return color.RGBA{R: uint8(r / n >> 8), G: uint8(g / n >> 8), B: uint8(b / n >> 8), A: 0xff}
+}
+
+// orientedSource maps pixel (x, y) of the displayed image, which is
+// width×height, to the stored pixel for an EXIF orientation
+func orientedSource(orientation, width, height, x, y int) (int, int) {
+	switch orientation {
+	case 2:
+		return width - 1 - x, y
+	case 3:
+		return width - 1 - x, height - 1 - y
+	case 4:
+		return x, height - 1 - y
+	case 5:
+		return y, x
+	case 6:
+		return y, width - 1 - x
+	case 7:
+		return height - 1 - y, width - 1 - x
+	case 8:
+		return height - 1 - y, x
+	}
+	return x, y
+}
+
+// jpegOrientation returns the EXIF orientation (1 to 8) of a JPEG, or 1
+// when it has none or data is not a JPEG
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			// Metadata comes before the image data
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return 1
+		}
+		if marker == 0xE1 {
+			if orientation := exifOrientation(data[i+4 : i+2+length]); orientation != 0 {
+				return orientation
+			}
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// exifOrientation reads the orientation tag from the first IFD of an APP1
+// Exif segment, returning 0 when there is none
+func exifOrientation(segment []byte) int {
+	if len(segment) < 14 || !strings.HasPrefix(string(segment[:6]), "Exif\x00\x00") {
+		return 0
+	}
+	tiff := segment[6:]
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for e := 0; e < entries; e++ {
+		offset := ifd + 2 + 12*e
+		if offset+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[offset:]) == 0x0112 {
+			if orientation := int(order.Uint16(tiff[offset+8:])); orientation >= 1 && orientation <= 8 {
+				return orientation
+			}
+			return 0
+		}
+	}
+	return 0
+}
@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FilesPath is the route that serves Local files; URL appends the key
+const FilesPath = "/files/"
+
+// Errors from Local.Verify
+var (
+	ErrURLExpired   = errors.New("storage: URL has expired")
+	ErrBadSignature = errors.New("storage: URL signature does not match")
+)
+
+// Local keeps files in a directory. The API serves them itself at
+// FilesPath, checking the expiry and HMAC signature that URL adds to the
+// query string, so Dir need not be reachable any other way.
+type Local struct {
+	Dir string
+	// BaseURL is put before FilesPath in URLs, such as
+	// "https://api.example.com". URLs are relative to the API when it is
+	// empty.
+	BaseURL string
+	Secret  []byte
+}
+
+// NewLocal returns a store in dir, creating the directory if needed
+func NewLocal(dir, baseURL string, secret []byte) (*Local, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %v", err)
+	}
+	return &Local{Dir: dir, BaseURL: strings.TrimSuffix(baseURL, "/"), Secret: secret}, nil
+}
+
+func (l *Local) Backend() string { return BackendLocal }
+
+func (l *Local) path(key string) string {
+	return filepath.Join(l.Dir, filepath.FromSlash(key))
+}
+
+// Put writes to a temporary file beside the destination and renames it, so
+// a file is never served half written
+func (l *Local) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	dest := l.path(key)
+	if err := os.MkdirAll(filepath.Dir(dest), 0o750); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+	if size >= 0 && written != size {
+		return fmt.Errorf("wrote %d bytes of %d", written, size)
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return fmt.Errorf("failed to save file: %v", err)
+	}
+	return nil
+}
+
+// Open returns the file as an *os.File, which callers may seek. The content
+// type comes from the key's extension.
+func (l *Local) Open(ctx context.Context, key string) (io.ReadCloser, Object, error) {
+	if err := checkKey(key); err != nil {
+		return nil, Object{}, err
+	}
+	f, err := os.Open(l.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, Object{}, ErrNotFound
+	}
+	if err != nil {
+		return nil, Object{}, fmt.Errorf("failed to open file: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, Object{}, fmt.Errorf("failed to read file: %v", err)
+	}
+	if info.IsDir() {
+		f.Close()
+		return nil, Object{}, ErrNotFound
+	}
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return f, Object{Key: key, ContentType: contentType, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	if err := os.Remove(l.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete file: %v", err)
+	}
+	return nil
+}
+
+// URL returns BaseURL + FilesPath + key with expires (Unix seconds) and
+// signature query parameters
+func (l *Local) URL(key string, expires time.Time) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	unix := strconv.FormatInt(expires.Unix(), 10)
+	return l.BaseURL + FilesPath + key + "?expires=" + unix + "&signature=" + l.sign(key, unix), nil
+}
+
+// Verify checks the expires and signature parameters of a URL for key
+func (l *Local) Verify(key, expires, signature string, now time.Time) error {
+	if !hmac.Equal([]byte(signature), []byte(l.sign(key, expires))) {
+		return ErrBadSignature
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrBadSignature
+	}
+	if now.Unix() > unix {
+		return ErrURLExpired
+	}
+	return nil
+}
+
+// sign returns the hex HMAC-SHA256 of "<key>\n<expires>"
+func (l *Local) sign(key, expires string) string {
+	mac := hmac.New(sha256.New, l.Secret)
+	mac.Write([]byte(key + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Check creates and removes a file in Dir
+func (l *Local) Check(ctx context.Context) error {
+	f, err := os.CreateTemp(l.Dir, ".check-*")
+	if err != nil {
+		return fmt.Errorf("storage directory is not writable: %v", err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
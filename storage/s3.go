@@ -0,0 +1,340 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SigV4 constants. Request bodies are not hashed; S3 and MinIO accept
+// UNSIGNED-PAYLOAD for requests signed in headers and presigned URLs.
+const (
+	sigV4Algorithm     = "AWS4-HMAC-SHA256"
+	sigV4UnsignedBody  = "UNSIGNED-PAYLOAD"
+	sigV4TimeFormat    = "20060102T150405Z"
+	maxPresignDuration = 7 * 24 * time.Hour
+)
+
+// S3 keeps files in a bucket on Amazon S3 or a compatible service such as
+// MinIO, signing requests with AWS Signature Version 4. URLs are presigned
+// GET URLs, so clients download straight from the bucket.
+type S3 struct {
+	Endpoint *url.URL
+	// PublicEndpoint is used in presigned URLs instead of Endpoint when the
+	// API reaches the service at an address clients cannot, such as a
+	// Docker network name
+	PublicEndpoint  *url.URL
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// PathStyle puts the bucket in the path (http://host/bucket/key), which
+	// MinIO needs, rather than in the host name (http://bucket.host/key)
+	PathStyle bool
+	Client    *http.Client
+}
+
+// NewS3 returns a store for bucket. publicEndpoint may be empty.
+func NewS3(endpoint, publicEndpoint, region, bucket, accessKeyID, secretAccessKey string, pathStyle bool) (*S3, error) {
+	s := &S3{
+		Region:          region,
+		Bucket:          bucket,
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+		PathStyle:       pathStyle,
+		Client:          &http.Client{Timeout: 2 * time.Minute},
+	}
+	var err error
+	if s.Endpoint, err = parseEndpoint(endpoint); err != nil {
+		return nil, err
+	}
+	s.PublicEndpoint = s.Endpoint
+	if publicEndpoint != "" {
+		if s.PublicEndpoint, err = parseEndpoint(publicEndpoint); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func parseEndpoint(raw string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSuffix(raw, "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("S3 endpoint must be an http(s) URL, got %q", raw)
+	}
+	return u, nil
+}
+
+func (s *S3) Backend() string { return BackendS3 }
+
+func (s *S3) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	if size < 0 {
+		return fmt.Errorf("S3 uploads need the size in advance")
+	}
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(ctx, http.MethodPut, key, body, size, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError("upload", resp)
+	}
+	return nil
+}
+
+func (s *S3) Open(ctx context.Context, key string) (io.ReadCloser, Object, error) {
+	if err := checkKey(key); err != nil {
+		return nil, Object{}, err
+	}
+	resp, err := s.do(ctx, http.MethodGet, key, nil, 0, nil)
+	if err != nil {
+		return nil, Object{}, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, Object{}, ErrNotFound
+	default:
+		defer resp.Body.Close()
+		return nil, Object{}, responseError("download", resp)
+	}
+	object := Object{Key: key, ContentType: resp.Header.Get("Content-Type"), Size: resp.ContentLength}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		object.ModTime = modified
+	}
+	return resp.Body, object, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return responseError("delete", resp)
+	}
+	return nil
+}
+
+// URL presigns a GET of key on PublicEndpoint. S3 accepts at most seven
+// days, so later expiry times are cut short.
+func (s *S3) URL(key string, expires time.Time) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	seconds := int64(expires.Sub(now).Round(time.Second) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	if max := int64(maxPresignDuration / time.Second); seconds > max {
+		seconds = max
+	}
+
+	u := s.objectURL(s.PublicEndpoint, key)
+	amzDate := now.Format(sigV4TimeFormat)
+	scope := s.scope(amzDate)
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", sigV4Algorithm)
+	query.Set("X-Amz-Credential", s.AccessKeyID+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.FormatInt(seconds, 10))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	canonical := strings.Join([]string{
+		http.MethodGet,
+		canonicalPath(u),
+		canonicalQuery(query),
+		"host:" + u.Host + "\n",
+		"host",
+		sigV4UnsignedBody,
+	}, "\n")
+	query.Set("X-Amz-Signature", s.signature(amzDate, scope, canonical))
+	u.RawQuery = canonicalQuery(query)
+	return u.String(), nil
+}
+
+// Check asks for the bucket's metadata
+func (s *S3) Check(ctx context.Context) error {
+	resp, err := s.do(ctx, http.MethodHead, "", nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bucket %s returned status %d", s.Bucket, resp.StatusCode)
+	}
+	return nil
+}
+
+// do sends a signed request for key, or for the bucket when key is empty
+func (s *S3) do(ctx context.Context, method, key string, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.objectURL(s.Endpoint, key).String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	s.sign(req, time.Now().UTC())
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("S3 request failed: %v", err)
+	}
+	return resp, nil
+}
+
+// objectURL returns the URL of key in the bucket on endpoint
+func (s *S3) objectURL(endpoint *url.URL, key string) *url.URL {
+	u := *endpoint
+	base := strings.TrimSuffix(endpoint.Path, "/")
+	if s.PathStyle {
+		u.Path = base + "/" + s.Bucket
+		if key != "" {
+			u.Path += "/" + key
+		}
+	} else {
+		u.Host = s.Bucket + "." + endpoint.Host
+		u.Path = base + "/" + key
+	}
+	u.RawPath = ""
+	u.RawQuery = ""
+	return &u
+}
+
+// sign adds the SigV4 Authorization header to req
+func (s *S3) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format(sigV4TimeFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", sigV4UnsignedBody)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		canonicalPath(req.URL),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		sigV4UnsignedBody,
+	}, "\n")
+	scope := s.scope(amzDate)
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, s.AccessKeyID, scope, signedHeaders, s.signature(amzDate, scope, canonical)))
+}
+
+// scope is the credential scope for a request made at amzDate
+func (s *S3) scope(amzDate string) string {
+	return amzDate[:8] + "/" + s.Region + "/s3/aws4_request"
+}
+
+// signature signs a canonical request with the key derived for its day
+func (s *S3) signature(amzDate, scope, canonical string) string {
+	hash := sha256.Sum256([]byte(canonical))
+	stringToSign := sigV4Algorithm + "\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), amzDate[:8])
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalPath is the URI-encoded path, keeping the slashes
+func canonicalPath(u *url.URL) string {
+	if u.Path == "" {
+		return "/"
+	}
+	segments := strings.Split(u.Path, "/")
+	for i, segment := range segments {
+		segments[i] = uriEncode(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// canonicalQuery encodes query sorted by name and then value
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var pairs []string
+	for _, name := range names {
+		values := append([]string(nil), query[name]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(name)+"="+uriEncode(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent-encodes everything but the RFC 3986 unreserved
+// characters, as SigV4 requires
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// responseError describes a failed S3 response, including the start of its
+// XML error body
+func responseError(action string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("S3 %s returned status %d: %s", action, resp.StatusCode, strings.TrimSpace(string(body)))
+}
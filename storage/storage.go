@@ -0,0 +1,78 @@
+// Package storage keeps uploaded files. Every backend implements Store:
+// Local writes them to a directory on disk and S3 to a bucket on any
+// S3-compatible service, such as MinIO. Files are never public; clients
+// get them through signed URLs from Store.URL that stop working at a
+// given time. Thumbnail makes the small previews stored beside images.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Backends
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+// ErrNotFound is returned for a key that holds no file
+var ErrNotFound = errors.New("storage: file not found")
+
+// Object describes a stored file
+type Object struct {
+	Key         string
+	ContentType string
+	Size        int64
+	ModTime     time.Time
+}
+
+// Store keeps files under keys such as "uploads/2025/01/3f9c….jpg". Keys
+// are made of lower case letters, digits and "/._-"; see ValidKey.
+type Store interface {
+	// Backend names the implementation, BackendLocal or BackendS3
+	Backend() string
+	// Put stores size bytes from body under key, replacing any file there
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	// Open returns the file under key, or ErrNotFound. The caller closes it.
+	Open(ctx context.Context, key string) (io.ReadCloser, Object, error)
+	// Delete removes the file under key. Deleting a missing file is not an
+	// error.
+	Delete(ctx context.Context, key string) error
+	// URL returns a URL that serves the file under key until expires
+	URL(key string, expires time.Time) (string, error)
+	// Check reports whether the store can be reached, for readiness probes
+	Check(ctx context.Context) error
+}
+
+var keyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9/._-]*$`)
+
+// maxKeyLength leaves room under the upload table's VARCHAR(255)
+const maxKeyLength = 200
+
+// ValidKey reports whether key can be stored. Keys are relative, have no
+// empty, "." or ".." segments and need no escaping in a URL path or file
+// name.
+func ValidKey(key string) bool {
+	if len(key) > maxKeyLength || !keyPattern.MatchString(key) {
+		return false
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+func checkKey(key string) error {
+	if !ValidKey(key) {
+		return fmt.Errorf("storage: invalid key %q", key)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestGenerateCSRFToken(t *testing.T) {
+	a, err := GenerateCSRFToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := GenerateCSRFToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Error("two tokens are equal")
+	}
+	raw, err := base64.StdEncoding.DecodeString(a)
+	if err != nil || len(raw) != 32 {
+		t.Errorf("token %q is not 32 bytes of base64 (err %v)", a, err)
+	}
+}
+
+func TestValidateCSRFToken(t *testing.T) {
+	tests := []struct {
+		name               string
+		provided, expected string
+		want               bool
+	}{
+		{"match", "abc123", "abc123", true},
+		{"mismatch", "abc123", "abc124", false},
+		{"prefix", "abc", "abc123", false},
+		{"case differs", "ABC123", "abc123", false},
+		{"empty provided", "", "abc123", false},
+		{"empty expected", "abc123", "", false},
+		{"both empty", "", "", false},
+	}
+	for _, tt := range tests {
+		if got := ValidateCSRFToken(tt.provided, tt.expected); got != tt.want {
+			t.Errorf("%s: ValidateCSRFToken(%q, %q) = %v, want %v", tt.name, tt.provided, tt.expected, got, tt.want)
+		}
+	}
+}
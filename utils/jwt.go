@@ -3,18 +3,24 @@ package utils
 import (
 	"errors"
 	"fmt"
+	"go-rent/config"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtKey = []byte("your-secret-key") // In production, use environment variable
-
 type Claims struct {
 	UserID int64 `json:"user_id"`
+	// Purpose marks restricted tokens such as the two-factor login challenge.
+	// Session tokens leave it empty.
+	Purpose string `json:"purpose,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// TwoFactorChallengePurpose marks tokens that only allow completing a
+// two-factor login
+const TwoFactorChallengePurpose = "2fa_challenge"
+
 // GenerateToken creates a new JWT token for the given user ID
 func GenerateToken(userID int64) (string, error) {
 	// Set expiration time to 24 hours from now
@@ -33,7 +39,7 @@ func GenerateToken(userID int64) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
 	// Generate encoded token
-	tokenString, err := token.SignedString(jwtKey)
+	tokenString, err := token.SignedString(config.App.JWT.Secret)
 	if err != nil {
 		return "", err
 	}
@@ -43,6 +49,49 @@ func GenerateToken(userID int64) (string, error) {
 
 // ValidateToken validates the JWT token and returns the user ID
 func ValidateToken(tokenString string) (int64, error) {
+	claims, err := parseClaims(tokenString)
+	if err != nil {
+		return 0, err
+	}
+
+	if claims.Purpose != "" {
+		return 0, errors.New("token is not a session token")
+	}
+
+	return claims.UserID, nil
+}
+
+// GenerateTwoFactorChallenge creates a short-lived token that proves the
+// password step of login succeeded. It cannot be used as a session token.
+func GenerateTwoFactorChallenge(userID int64) (string, error) {
+	claims := &Claims{
+		UserID:  userID,
+		Purpose: TwoFactorChallengePurpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(5 * time.Minute)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(config.App.JWT.Secret)
+}
+
+// ValidateTwoFactorChallenge validates a login challenge token and returns the user ID
+func ValidateTwoFactorChallenge(tokenString string) (int64, error) {
+	claims, err := parseClaims(tokenString)
+	if err != nil {
+		return 0, err
+	}
+
+	if claims.Purpose != TwoFactorChallengePurpose {
+		return 0, errors.New("token is not a two-factor challenge")
+	}
+
+	return claims.UserID, nil
+}
+
+// parseClaims verifies the token signature and expiry and returns its claims
+func parseClaims(tokenString string) (*Claims, error) {
 	claims := &Claims{}
 
 	// Parse the token
@@ -50,16 +99,26 @@ func ValidateToken(tokenString string) (int64, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return jwtKey, nil
+		return verificationKeys(), nil
 	})
 
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
 	if !token.Valid {
-		return 0, errors.New("invalid token")
+		return nil, errors.New("invalid token")
 	}
 
-	return claims.UserID, nil
-} 
\ No newline at end of file
+	return claims, nil
+} 
+
+// verificationKeys returns the current signing key followed by the previous
+// keys that are still accepted during a key rotation
+func verificationKeys() jwt.VerificationKeySet {
+	keys := jwt.VerificationKeySet{Keys: []jwt.VerificationKey{config.App.JWT.Secret}}
+	for _, key := range config.App.JWT.PreviousSecrets {
+		keys.Keys = append(keys.Keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors,
+// "12345678901234567890", in base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// The RFC's 8-digit SHA-1 codes, cut to the last 6 digits
+var rfc6238Vectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	key, err := totpEncoding.DecodeString(rfc6238Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range rfc6238Vectors {
+		if got := totpCode(key, v.unix/totpPeriod); got != v.code {
+			t.Errorf("T=%d: code %s, want %s", v.unix, got, v.code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	for _, v := range rfc6238Vectors {
+		at := time.Unix(v.unix, 0)
+		step, ok := ValidateTOTP(rfc6238Secret, v.code, at)
+		if !ok || step != v.unix/totpPeriod {
+			t.Errorf("T=%d: ValidateTOTP = %d, %v, want %d, true", v.unix, step, ok, v.unix/totpPeriod)
+		}
+	}
+
+	// 1111111111 is in step 37037037; its code is accepted one step either side
+	base := time.Unix(1111111111, 0)
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		at     time.Time
+		ok     bool
+	}{
+		{"same step", rfc6238Secret, "050471", base, true},
+		{"one step later", rfc6238Secret, "050471", base.Add(totpPeriod * time.Second), true},
+		{"one step earlier", rfc6238Secret, "050471", base.Add(-totpPeriod * time.Second), true},
+		{"two steps later", rfc6238Secret, "050471", base.Add(2 * totpPeriod * time.Second), false},
+		{"surrounding spaces", rfc6238Secret, " 050471 ", base, true},
+		{"lower case secret", strings.ToLower(rfc6238Secret), "050471", base, true},
+		{"wrong code", rfc6238Secret, "050472", base, false},
+		{"short code", rfc6238Secret, "05047", base, false},
+		{"8-digit code", rfc6238Secret, "14050471", base, false},
+		{"invalid secret", "not base32!", "050471", base, false},
+	}
+	for _, tt := range tests {
+		if _, ok := ValidateTOTP(tt.secret, tt.code, tt.at); ok != tt.ok {
+			t.Errorf("%s: ValidateTOTP ok = %v, want %v", tt.name, ok, tt.ok)
+		}
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(key) != 20 {
+		t.Errorf("secret %q does not decode to 20 bytes (err %v)", secret, err)
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	got := TOTPProvisioningURI("ABC", "+8801712345678")
+	want := "otpauth://totp/GoRent:%2B8801712345678?algorithm=SHA1&digits=6&issuer=GoRent&period=30&secret=ABC"
+	if got != want {
+		t.Errorf("URI = %s, want %s", got, want)
+	}
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' || strings.ContainsAny(code, "ilo1") {
+			t.Errorf("malformed recovery code %q", code)
+		}
+		if seen[code] {
+			t.Errorf("duplicate recovery code %q", code)
+		}
+		seen[code] = true
+	}
+}